@@ -2,7 +2,11 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
 	"log"
+	"os"
+	"path/filepath"
 	"time"
 
 	"x-ui-bot/internal/bot"
@@ -13,24 +17,52 @@ import (
 	"x-ui-bot/pkg/client"
 )
 
+// defaultDataDir is where the database lives in the container image
+const defaultDataDir = "/root/data"
+
 func main() {
+	configPath := flag.String("config", envOr("XUIBOT_CONFIG", config.DefaultPath), "path to the config file")
+	dataDir := flag.String("data-dir", envOr("XUIBOT_DATA_DIR", defaultDataDir), "directory of the bot database")
+	logLevel := flag.String("log-level", envOr("XUIBOT_LOG_LEVEL", "info"), "log level: debug, info, warn, error")
+	checkConfig := flag.Bool("check-config", false, "validate the config file and exit, non-zero on problems")
+	importSQLite := flag.String("import-sqlite", "", "copy the data of a SQLite bot.db into the configured storage and exit")
+	flag.Parse()
+
+	if *checkConfig {
+		os.Exit(runConfigCheck(*configPath))
+	}
+
+	logger.Init(*logLevel, false) // false = JSON format
+
+	if *importSQLite != "" {
+		os.Exit(runImport(*configPath, *dataDir, *importSQLite))
+	}
+
 	// Load configuration
-	cfg, err := config.Load()
+	cfg, err := config.LoadFile(*configPath)
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
+	configStore := config.NewStore(*configPath, cfg)
 
-	// Create API client
-	apiClient := client.NewAPIClient(cfg.Panel.URL, cfg.Panel.Username, cfg.Panel.Password)
+	// Create API clients for all configured panels
+	panels := client.NewRegistry()
+	for _, p := range cfg.Panels {
+		api := client.NewAPIClient(p.URL, p.Username, p.Password)
+		api.SetInboundCacheTTL(p.InboundCacheTTL())
+		if err := panels.Add(p.Name, api); err != nil {
+			log.Fatalf("Failed to register panel: %v", err)
+		}
+	}
 
 	// Create storage
-	store, err := storage.NewSQLiteStorage("/root/data/bot.db")
+	store, err := openStorage(cfg.Storage, *dataDir)
 	if err != nil {
 		log.Fatalf("Failed to create storage: %v", err)
 	}
 
 	// Create and start bot
-	tgBot, err := bot.NewBot(cfg, apiClient, store)
+	tgBot, err := bot.NewBot(configStore, panels, store)
 	if err != nil {
 		log.Fatalf("Failed to create bot: %v", err)
 	}
@@ -62,3 +94,83 @@ func main() {
 
 	log.Println("Bot stopped gracefully")
 }
+
+// runConfigCheck validates the config file, printing every problem, and returns the exit code
+func runConfigCheck(path string) int {
+	cfg, err := config.LoadFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		return 1
+	}
+	fmt.Printf("%s: OK (%d panels, %d admins)\n", path, len(cfg.Panels), len(cfg.Telegram.AdminIDs))
+	return 0
+}
+
+// openStorage opens the database selected by the config, bot.db in dataDir for SQLite
+func openStorage(cfg config.StorageConfig, dataDir string) (*storage.SQLStorage, error) {
+	if cfg.Driver == config.StoragePostgres {
+		return storage.NewPostgresStorage(cfg.DSN)
+	}
+
+	if err := os.MkdirAll(dataDir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+	return storage.NewSQLiteStorage(filepath.Join(dataDir, "bot.db"))
+}
+
+// runImport copies a SQLite database into the storage selected by the config and returns the exit code
+func runImport(configPath, dataDir, from string) int {
+	cfg, err := config.LoadFile(configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", configPath, err)
+		return 1
+	}
+	if cfg.Storage.Driver == config.StorageSQLite {
+		fmt.Fprintln(os.Stderr, "storage.driver is sqlite, set it to the database to import into")
+		return 1
+	}
+	if _, err := os.Stat(from); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	dst, err := openStorage(cfg.Storage, dataDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", cfg.Storage.Driver, err)
+		return 1
+	}
+	defer func() { _ = dst.Close() }()
+
+	// The source is only read: the import migrates a copy of it in a temporary directory
+	tmpDir, err := os.MkdirTemp("", "x-ui-bot-import-")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	src, err := storage.OpenSQLiteCopy(from, filepath.Join(tmpDir, "bot.db"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", from, err)
+		return 1
+	}
+	defer func() { _ = src.Close() }()
+
+	err = storage.CopyData(dst, src, func(table string, rows int) {
+		fmt.Printf("%-24s %d rows\n", table, rows)
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "import failed, nothing was written: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Imported %s into %s\n", from, cfg.Storage.Driver)
+	return 0
+}
+
+// envOr returns the value of an environment variable, or def when it is not set
+func envOr(name, def string) string {
+	if value, ok := os.LookupEnv(name); ok && value != "" {
+		return value
+	}
+	return def
+}
@@ -37,16 +37,12 @@ func main() {
 
 	// Test traffic for each inbound
 	for _, inbound := range inbounds {
-		inboundID := int(inbound["id"].(float64))
-		remark := ""
-		if r, ok := inbound["remark"].(string); ok {
-			remark = r
-		}
+		inboundID := inbound.ID
 
-		fmt.Printf("=== Inbound %d (%s) ===\n", inboundID, remark)
+		fmt.Printf("=== Inbound %d (%s) ===\n", inboundID, inbound.Remark)
 
 		// Check if clientStats exists in inbound
-		if clientStats, ok := inbound["clientStats"].([]interface{}); ok && len(clientStats) > 0 {
+		if clientStats := inbound.ClientStats; len(clientStats) > 0 {
 			fmt.Printf("Found %d clientStats entries\n", len(clientStats))
 
 			// Print first 3 entries from clientStats
@@ -54,18 +50,12 @@ func main() {
 				if i >= 3 {
 					break
 				}
-				statMap := stat.(map[string]interface{})
-				email := statMap["email"]
-				up := statMap["up"]
-				down := statMap["down"]
-				fmt.Printf("  [%d] email=%v, up=%v, down=%v\n", i+1, email, up, down)
+				fmt.Printf("  [%d] email=%v, up=%v, down=%v\n", i+1, stat.Email, stat.Up, stat.Down)
 			}
 
 			// Pretty print first entry
-			if len(clientStats) > 0 {
-				jsonData, _ := json.MarshalIndent(clientStats[0], "", "  ")
-				fmt.Printf("\nFirst clientStats entry:\n%s\n", string(jsonData))
-			}
+			jsonData, _ := json.MarshalIndent(clientStats[0], "", "  ")
+			fmt.Printf("\nFirst clientStats entry:\n%s\n", string(jsonData))
 		} else {
 			fmt.Println("No clientStats in inbound")
 		}
@@ -2,9 +2,8 @@ package bot
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"strconv"
+	"html"
 	"strings"
 	"sync"
 	"time"
@@ -12,8 +11,10 @@ import (
 	"x-ui-bot/internal/bot/middleware"
 	"x-ui-bot/internal/bot/services"
 	"x-ui-bot/internal/config"
+	"x-ui-bot/internal/i18n"
 	"x-ui-bot/internal/logger"
 	"x-ui-bot/internal/storage"
+	"x-ui-bot/internal/templates"
 	"x-ui-bot/pkg/client"
 
 	"math/rand"
@@ -40,8 +41,8 @@ type BroadcastState = storage.BroadcastState
 
 // Bot represents the Telegram bot
 type Bot struct {
-	config    *config.Config
-	apiClient *client.APIClient
+	config    *config.Store    // Reloaded when config.yaml changes, read through cfg()
+	panels    *client.Registry // API clients of all configured 3x-ui panels
 	bot       *telego.Bot
 	handler   *th.BotHandler
 	cancel    context.CancelFunc
@@ -49,74 +50,118 @@ type Bot struct {
 	isRunning bool
 	storage   Storage // Storage interface for persistence
 	logger    *logger.Logger
+	username  string         // Bot username for t.me links, empty until Start
+	i18n      *i18n.Catalog  // Message catalogs of all supported languages
+	templates *templates.Set // Notification templates, overridable from notifications.templates_dir
+	languages sync.Map       // Language of each user seen since start: tgID -> language
 
 	// Services
 	clientService       *services.ClientService
 	subscriptionService *services.SubscriptionService
 	backupService       *services.BackupService
 	broadcastService    *services.BroadcastService
-	forecastService     *services.ForecastService
+	broadcastScheduler  *services.BroadcastScheduler
+	forecastServices    []*services.ForecastService // One per panel, in registry order
 	expiryNotifier      *services.ExpiryNotifierService
-	inboundSyncService  *services.InboundSyncService
+	inboundSyncServices []*services.InboundSyncService // One per panel, in registry order
 	trafficSyncService  *services.TrafficSyncService
+	userRegistry        *services.UserRegistryService
+	promoService        *services.PromoService
 
 	// Middleware
 	authMiddleware *middleware.AuthMiddleware
 	rateLimiter    *middleware.RateLimiter
 
-	clientCache     sync.Map           // Cache for client data: "inboundID_index" -> client map
-	cacheMutex      sync.RWMutex       // Protects concurrent access to clientCache
-	stopBackup      chan struct{}      // Signal to stop backup scheduler
-	broadcastCancel context.CancelFunc // Cancel function for active broadcast
-	broadcastMutex  sync.Mutex
+	auditFilters    sync.Map      // Audit log filter each admin last opened: tgID -> storage.AuditFilter
+	clientViews     sync.Map      // Client list each admin last opened: tgID -> clientListView
+	tgUsernames     sync.Map      // Telegram username stored for users seen since start: tgID -> username without @
+	broadcastAlbums sync.Map      // Album an admin is sending as a broadcast: tgID -> *broadcastAlbum
+	scheduleEdits   sync.Map      // Broadcast schedule field an admin is editing: tgID -> scheduleEdit
+	stopBackup      chan struct{} // Signal to stop backup scheduler
 }
 
 // Storage interface for bot data persistence
 type Storage = storage.Storage
 
 // NewBot creates a new Bot instance
-func NewBot(cfg *config.Config, apiClient *client.APIClient, store Storage) (*Bot, error) {
+func NewBot(configStore *config.Store, panels *client.Registry, store Storage) (*Bot, error) {
+	cfg := configStore.Get()
+	if panels.Len() == 0 {
+		return nil, fmt.Errorf("no panels configured")
+	}
+
 	bot, err := createTelegoBot(cfg.Telegram.Token, cfg.Telegram.Proxy, cfg.Telegram.APIServer)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create telegram bot: %w", err)
 	}
+	return newBot(configStore, panels, store, bot)
+}
+
+// newBot wires the services and middleware of a Bot around a Telegram client
+func newBot(configStore *config.Store, panels *client.Registry, store Storage, bot *telego.Bot) (*Bot, error) {
+	cfg := configStore.Get()
 
-	// Initialize logger
-	logger.Init("info", false) // false = JSON format
+	// Logger is initialized by the caller
 	log := logger.GetLogger()
 
-	// Initialize services
-	clientService := services.NewClientService(apiClient, log)
-	subscriptionService := services.NewSubscriptionService(log)
-	backupService := services.NewBackupService(apiClient, bot, cfg, log)
-	broadcastService := services.NewBroadcastService(apiClient, bot, log)
-	forecastService := services.NewForecastService(apiClient, store, bot, cfg, log)
-	expiryNotifier := services.NewExpiryNotifierService(bot, store, log, cfg.Notifications.ExpiryWarningDays)
-	inboundSyncService := services.NewInboundSyncService(apiClient, log, cfg.Panel.MultiInboundSync)
-	trafficSyncService := services.NewTrafficSyncService(apiClient, clientService, store, log, cfg.Panel.TrafficSyncHours)
+	catalog, err := i18n.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load message catalogs: %w", err)
+	}
+	tmpl, err := templates.Load(cfg.Notifications.TemplatesDir, catalog.Languages())
+	if err != nil {
+		return nil, fmt.Errorf("failed to load message templates: %w", err)
+	}
+
+	b := &Bot{
+		config:     configStore,
+		panels:     panels,
+		bot:        bot,
+		storage:    store,
+		logger:     log,
+		i18n:       catalog,
+		templates:  tmpl,
+		stopBackup: make(chan struct{}),
+	}
+
+	// Initialize services, messages to users are rendered in their language
+	b.clientService = services.NewClientService(panels, log)
+	b.subscriptionService = services.NewSubscriptionService(log)
+	b.backupService = services.NewBackupService(panels.Default(), bot, configStore, log, b.tr)
+	b.broadcastService = services.NewBroadcastService(bot, store, log, b.tr, b.showBroadcastProgress)
+	b.broadcastScheduler = services.NewBroadcastScheduler(store, log, b.runScheduledBroadcast)
+	b.expiryNotifier = services.NewExpiryNotifierService(bot, store, log, cfg.Notifications.ExpiryWarningDays, b.tr, b.renderer)
+	b.trafficSyncService = services.NewTrafficSyncService(panels, b.clientService, store, log, cfg.Panel.TrafficSyncHours)
+	b.userRegistry = services.NewUserRegistryService(panels, store, log)
+	b.promoService = services.NewPromoService(store, log)
+
+	// Forecast and inbound sync work on the inbounds of a single panel
+	for _, apiClient := range panels.All() {
+		panelCfg, ok := cfg.PanelByName(apiClient.Name())
+		if !ok {
+			panelCfg = cfg.Panel
+		}
+		b.forecastServices = append(b.forecastServices, services.NewForecastService(apiClient, store, bot, configStore, log, b.tr, b.renderer))
+		b.inboundSyncServices = append(b.inboundSyncServices, services.NewInboundSyncService(apiClient, log, panelCfg.MultiInboundSync))
+	}
 
 	// Initialize middleware
-	authMiddleware := middleware.NewAuthMiddleware(cfg)
-	rateLimiter := middleware.NewRateLimiter(cfg.RateLimit.MaxRequestsPerMinute, cfg.RateLimit.WindowSeconds)
-
-	return &Bot{
-		config:              cfg,
-		apiClient:           apiClient,
-		bot:                 bot,
-		storage:             store,
-		logger:              log,
-		clientService:       clientService,
-		subscriptionService: subscriptionService,
-		backupService:       backupService,
-		broadcastService:    broadcastService,
-		forecastService:     forecastService,
-		expiryNotifier:      expiryNotifier,
-		inboundSyncService:  inboundSyncService,
-		trafficSyncService:  trafficSyncService,
-		authMiddleware:      authMiddleware,
-		rateLimiter:         rateLimiter,
-		stopBackup:          make(chan struct{}),
-	}, nil
+	b.authMiddleware = middleware.NewAuthMiddleware(configStore)
+	b.rateLimiter = middleware.NewRateLimiter(cfg.RateLimit, b.authMiddleware, store, log)
+
+	// Components that copied settings at start follow reloads of config.yaml
+	configStore.OnReload(func(cfg *config.Config) error {
+		b.expiryNotifier.SetWarningDays(cfg.Notifications.ExpiryWarningDays)
+		b.rateLimiter.Reconfigure(cfg.RateLimit)
+		return nil
+	})
+
+	return b, nil
+}
+
+// cfg returns the configuration in use, it changes when config.yaml is reloaded
+func (b *Bot) cfg() *config.Config {
+	return b.config.Get()
 }
 
 // createTelegoBot creates a telego bot with optional proxy settings
@@ -130,66 +175,93 @@ func createTelegoBot(token, proxy, apiServer string) (*telego.Bot, error) {
 
 // Start starts the bot
 func (b *Bot) Start() error {
-	// Login to API
-	if err := b.apiClient.Login(context.Background()); err != nil {
-		return fmt.Errorf("failed to login to panel: %w", err)
-	}
-
-	// Set bot commands
-	err := b.bot.SetMyCommands(context.Background(), &telego.SetMyCommandsParams{
-		Commands: []telego.BotCommand{
-			{Command: "start", Description: "Start the bot"},
-			{Command: "help", Description: "Show help message"},
-			{Command: "status", Description: "Show server status"},
-			{Command: "id", Description: "Get your Telegram ID"},
-			{Command: "usage", Description: "Get client usage statistics"},
-			{Command: "forecast", Description: "Show total traffic forecast"},
-		},
-	})
-	if err != nil {
-		b.logger.Warnf("Failed to set bot commands: %v", err)
+	// Login to every panel, an unreachable node must not keep the bot down
+	loggedIn := 0
+	for _, apiClient := range b.panels.All() {
+		if err := apiClient.Login(context.Background()); err != nil {
+			b.logger.Errorf("Failed to login to panel %s: %v", apiClient.Name(), err)
+			continue
+		}
+		loggedIn++
+	}
+	if loggedIn == 0 {
+		return fmt.Errorf("failed to login to any panel")
 	}
 
+	// Referral links point to the bot by username
+	if me, err := b.bot.GetMe(context.Background()); err != nil {
+		b.logger.Warnf("Failed to get bot info: %v", err)
+	} else {
+		b.username = me.Username
+	}
+
+	// Set bot commands, the default language list is shown to users whose language has no catalog
+	b.setCommands("")
+	for _, lang := range b.i18n.Languages() {
+		b.setCommands(lang)
+	}
+
+	// One context for message handling and every service, Stop cancels it and waits
+	// for the goroutines started with it before the storage is closed
+	ctx, cancel := context.WithCancel(context.Background())
+	b.cancel = cancel
+
 	// Start message handling
 	if !b.isRunning {
-		go b.receiveMessages()
+		b.receiveMessages(ctx)
 		b.isRunning = true
 	}
 
 	// Start backup scheduler if enabled
-	if b.config.Panel.BackupDays > 0 {
+	if b.cfg().Panel.BackupDays > 0 {
 		go b.backupScheduler()
 	}
 
-	// Create shared context for all services
-	ctx, cancel := context.WithCancel(context.Background())
-	b.cancel = cancel
+	// Start expiry notifier, it runs without warning days too as they can be set by a config reload
+	b.goTracked(func() { b.expiryNotifier.Start(ctx) })
+	b.goTracked(func() { b.subscriptionSyncScheduler(ctx) })
+	b.logger.Info("Started expiry notifier and sync service")
 
-	// Start expiry notifier
-	if len(b.config.Notifications.ExpiryWarningDays) > 0 {
-		go b.expiryNotifier.Start(ctx)
-		go b.subscriptionSyncScheduler(ctx)
-		b.logger.Info("Started expiry notifier and sync service")
-	}
+	// Apply config.yaml changes on SIGHUP or when the file is saved
+	b.goTracked(func() { b.config.Watch(ctx, config.DefaultWatchInterval, b.reportConfigReload) })
 
-	// Start inbound sync scheduler if enabled
-	if b.config.Panel.MultiInboundSync {
-		syncHours := b.config.Panel.MultiInboundSyncHours
-		if syncHours <= 0 {
-			syncHours = 24 // Default to 24 hours
+	// Start inbound sync schedulers for panels that enable it
+	for i, apiClient := range b.panels.All() {
+		panelCfg := b.panelConfig(apiClient)
+		if !panelCfg.MultiInboundSync {
+			continue
 		}
-		go b.inboundSyncService.Start(ctx, syncHours)
-		b.logger.Infof("Started multi-inbound sync service (interval: %d hours)", syncHours)
+		syncHours := panelCfg.MultiInboundSyncHours
+		inboundSync := b.inboundSyncServices[i]
+		b.goTracked(func() { inboundSync.Start(ctx, syncHours) })
+		b.logger.Infof("Started multi-inbound sync service for panel %s (interval: %d hours)", apiClient.Name(), syncHours)
 	}
 
+	// Send queued broadcasts, resuming those interrupted by a restart
+	b.goTracked(func() { b.broadcastService.Start(ctx) })
+	// Queue scheduled and recurring broadcasts when they are due
+	b.goTracked(func() { b.broadcastScheduler.Start(ctx) })
+
+	// Keep the local users table in line with the panels
+	b.goTracked(func() { b.userRegistry.Start(ctx, 1*time.Hour) })
+
 	// Start traffic sync scheduler if enabled
-	if b.config.Panel.TrafficSyncHours > 0 {
-		go b.trafficSyncService.StartSync(ctx)
+	if b.cfg().Panel.TrafficSyncHours > 0 {
+		b.goTracked(func() { b.trafficSyncService.StartSync(ctx) })
 	}
 
 	return nil
 }
 
+// goTracked runs fn in a goroutine that Stop waits for before closing the storage
+func (b *Bot) goTracked(fn func()) {
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		fn()
+	}()
+}
+
 // Stop stops the bot
 func (b *Bot) Stop() {
 	if b.cancel != nil {
@@ -205,8 +277,8 @@ func (b *Bot) Stop() {
 		close(b.stopBackup)
 	}
 
-	if b.forecastService != nil {
-		b.forecastService.Stop()
+	for _, forecastService := range b.forecastServices {
+		forecastService.Stop()
 	}
 
 	// Close storage
@@ -219,22 +291,20 @@ func (b *Bot) Stop() {
 	b.isRunning = false
 }
 
-// receiveMessages starts receiving and handling messages
-func (b *Bot) receiveMessages() {
-	ctx, cancel := context.WithCancel(context.Background())
-	b.cancel = cancel
-
+// receiveMessages starts receiving and handling messages until ctx is cancelled
+func (b *Bot) receiveMessages(ctx context.Context) {
 	updates, _ := b.bot.UpdatesViaLongPolling(ctx, &telego.GetUpdatesParams{
 		Timeout: 30,
 	})
 
-	b.wg.Add(1)
-	go func() {
-		defer b.wg.Done()
-
+	b.goTracked(func() {
 		handler, _ := th.NewBotHandler(b.bot, updates)
 		b.handler = handler
 
+		// Handle Telegram Payments
+		handler.HandlePreCheckoutQuery(b.handlePreCheckoutQuery, th.AnyPreCheckoutQuery())
+		handler.HandleMessage(b.handleSuccessfulPayment, th.SuccessPayment())
+
 		// Handle commands
 		handler.HandleMessage(b.handleCommand, th.AnyCommand())
 
@@ -245,26 +315,48 @@ func (b *Bot) receiveMessages() {
 		handler.HandleCallbackQuery(b.handleCallback, th.AnyCallbackQueryWithMessage())
 
 		handler.Start() //nolint:errcheck // handler.Start() doesn't return error
-	}()
+	})
 
-	// Start traffic forecast scheduler (uses same ctx so it stops when ctx cancelled)
-	if b.forecastService != nil {
-		b.wg.Add(1)
-		go func() {
-			defer b.wg.Done()
-			b.forecastService.StartScheduler(ctx)
-		}()
+	// Start traffic forecast schedulers (use same ctx so they stop when ctx cancelled)
+	for _, forecastService := range b.forecastServices {
+		b.goTracked(func() { forecastService.StartScheduler(ctx) })
 	}
 
 	// Start cleanup goroutine for expired states (24h TTL)
-	b.wg.Add(1)
-	go func() {
-		defer b.wg.Done()
-		b.cleanupExpiredStates(ctx)
-	}()
+	b.goTracked(func() { b.cleanupExpiredStates(ctx) })
+}
+
+// reportConfigReload logs a config reload and tells the admins what changed or why it failed
+func (b *Bot) reportConfigReload(result *config.ReloadResult, err error) {
+	if err != nil {
+		b.logger.Errorf("Config reload failed, keeping the current config: %v", err)
+		for _, adminID := range b.cfg().Telegram.AdminIDs {
+			b.sendMessage(adminID, b.t(adminID, "config.reload_failed", html.EscapeString(err.Error())))
+		}
+		return
+	}
+	if len(result.Changed) == 0 && len(result.RestartRequired) == 0 {
+		return
+	}
+
+	b.logger.WithFields(map[string]interface{}{
+		"changed":          result.Changed,
+		"restart_required": result.RestartRequired,
+	}).Info("Config reloaded")
+
+	for _, adminID := range b.cfg().Telegram.AdminIDs {
+		t := b.tr(adminID)
+		msg := t("config.reloaded")
+		if len(result.Changed) > 0 {
+			msg += t("config.changed", html.EscapeString(strings.Join(result.Changed, ", ")))
+		}
+		if len(result.RestartRequired) > 0 {
+			msg += t("config.restart_required", html.EscapeString(strings.Join(result.RestartRequired, ", ")))
+		}
+		b.sendMessage(adminID, msg)
+	}
 }
 
-// isAdmin checks if a user is an admin
 // cleanupExpiredStates removes expired user states (TTL: 24 hours)
 func (b *Bot) cleanupExpiredStates(ctx context.Context) {
 	ticker := time.NewTicker(1 * time.Hour)
@@ -282,16 +374,21 @@ func (b *Bot) cleanupExpiredStates(ctx context.Context) {
 
 			// Cleanup rate limits from middleware
 			b.rateLimiter.Cleanup()
+			if err := b.storage.CleanupRateLimitPenalties(24 * time.Hour); err != nil {
+				b.logger.Errorf("Failed to cleanup rate limit penalties: %v", err)
+			}
 
 			b.logger.Info("Completed periodic state cleanup")
 		}
 	}
 }
 
-// createClientForRequest creates a new client based on registration request
-func (b *Bot) createClientForRequest(req *RegistrationRequest) error {
+// createClientForRequest creates a new client based on registration request on the given panel
+func (b *Bot) createClientForRequest(req *RegistrationRequest, apiClient *client.APIClient) error {
+	panelCfg := b.panelConfig(apiClient)
+
 	// Get first inbound to add client to
-	inbounds, err := b.apiClient.GetInbounds(context.Background())
+	inbounds, err := apiClient.GetInbounds(context.Background())
 	if err != nil {
 		return fmt.Errorf("failed to get inbounds: %w", err)
 	}
@@ -302,49 +399,31 @@ func (b *Bot) createClientForRequest(req *RegistrationRequest) error {
 
 	// Use first inbound
 	firstInbound := inbounds[0]
-	inboundID := int(firstInbound["id"].(float64))
-
-	// Get protocol
-	protocol := ""
-	if p, ok := firstInbound["protocol"].(string); ok {
-		protocol = p
-	}
+	inboundID := firstInbound.ID
 
 	// Calculate expiry time
-	expiryTime := time.Now().Add(time.Duration(req.Duration) * 24 * time.Hour).UnixMilli()
+	expiryTime := time.Now().Add(time.Duration(req.Duration+req.BonusDays) * 24 * time.Hour).UnixMilli()
 
 	// Generate subscription ID (16 lowercase alphanumeric characters)
 	subID := generateRandomString(16)
 
 	// If user already exists in any inbound, reuse their subId to avoid duplicates
-	if b.config.Panel.MultiInboundNewUsers {
+	if panelCfg.MultiInboundNewUsers {
 		for _, inbound := range inbounds {
-			settingsStr := ""
-			if settings, ok := inbound["settings"].(string); ok {
-				settingsStr = settings
-			}
-
-			clients, err := b.clientService.ParseClients(settingsStr)
+			clients, err := inbound.Clients()
 			if err != nil {
-				b.logger.Errorf("Failed to parse clients for inbound %v: %v", inbound["id"], err)
-				continue
+				b.logger.Errorf("Failed to parse clients for inbound %d: %v", inbound.ID, err)
 			}
 
 			for _, c := range clients {
-				email := c["email"]
-				if email == "" || email != req.Email {
+				if c.Email == "" || c.Email != req.Email {
 					continue
 				}
 
-				// Parse raw JSON to extract subId
-				rawJSON := c["_raw_json"]
-				var clientData map[string]interface{}
-				if err := json.Unmarshal([]byte(rawJSON), &clientData); err == nil {
-					if existingSub, ok := clientData["subId"].(string); ok && existingSub != "" {
-						subID = existingSub
-						b.logger.Infof("Reusing existing subId for user %s from inbound %v", req.Email, inbound["id"])
-						break
-					}
+				if c.SubID != "" {
+					subID = c.SubID
+					b.logger.Infof("Reusing existing subId for user %s from inbound %d", req.Email, inbound.ID)
+					break
 				}
 			}
 		}
@@ -352,37 +431,24 @@ func (b *Bot) createClientForRequest(req *RegistrationRequest) error {
 
 	// Calculate traffic limit in bytes
 	trafficLimitBytes := int64(0)
-	if b.config.Panel.TrafficLimitGB > 0 {
-		trafficLimitBytes = int64(b.config.Panel.TrafficLimitGB) * 1024 * 1024 * 1024
+	if panelCfg.TrafficLimitGB > 0 {
+		trafficLimitBytes = int64(panelCfg.TrafficLimitGB) * 1024 * 1024 * 1024
 	}
 
 	// Check if multi-inbound mode is enabled for new users
-	if b.config.Panel.MultiInboundNewUsers {
+	if panelCfg.MultiInboundNewUsers {
 		// Create client in ALL inbounds
-		b.logger.Infof("Creating client %s in all inbounds (multi-inbound mode)", req.Email)
+		b.logger.Infof("Creating client %s in all inbounds of panel %s (multi-inbound mode)", req.Email, apiClient.Name())
 
 		createdCount := 0
 		existedCount := 0
 
 		for _, inbound := range inbounds {
-			inboundID := int(inbound["id"].(float64))
-			protocol := ""
-			if p, ok := inbound["protocol"].(string); ok {
-				protocol = p
-			}
-
-			// Get inbound remark (name)
-			inboundRemark := ""
-			if remark, ok := inbound["remark"].(string); ok {
-				inboundRemark = remark
-			}
-			if inboundRemark == "" {
-				inboundRemark = fmt.Sprintf("inbound%d", inboundID)
-			}
+			inboundID := inbound.ID
 
 			// Add inbound name suffix to email: email__remarkName
 			// This allows multiple clients with same base email across inbounds
-			emailForInbound := fmt.Sprintf("%s__%s", req.Email, inboundRemark)
+			emailForInbound := fmt.Sprintf("%s__%s", req.Email, inbound.EmailSuffix())
 
 			// Create client data with SAME subId for all inbounds
 			clientData := map[string]interface{}{
@@ -392,16 +458,16 @@ func (b *Bot) createClientForRequest(req *RegistrationRequest) error {
 				"totalGB":    trafficLimitBytes,
 				"tgId":       req.UserID,
 				"subId":      subID, // Same subId for unified subscription
-				"limitIp":    b.config.Panel.LimitIP,
+				"limitIp":    panelCfg.LimitIP,
 				"comment":    "",
 				"reset":      0,
 			}
 
 			// Add protocol-specific fields
-			b.addProtocolFields(clientData, protocol, inbound)
+			b.addProtocolFields(clientData, inbound)
 
 			// Add client to this inbound
-			if err := b.apiClient.AddClient(context.Background(), inboundID, clientData); err != nil {
+			if err := apiClient.AddClient(context.Background(), inboundID, clientData); err != nil {
 				b.logger.Errorf("Failed to create client %s in inbound %d: %v", emailForInbound, inboundID, err)
 			} else {
 				b.logger.Infof("Created client %s in inbound %d", emailForInbound, inboundID)
@@ -428,16 +494,16 @@ func (b *Bot) createClientForRequest(req *RegistrationRequest) error {
 		"totalGB":    trafficLimitBytes,
 		"tgId":       req.UserID,
 		"subId":      subID,
-		"limitIp":    b.config.Panel.LimitIP,
+		"limitIp":    panelCfg.LimitIP,
 		"comment":    "",
 		"reset":      0,
 	}
 
 	// Add protocol-specific fields
-	b.addProtocolFields(clientData, protocol, firstInbound)
+	b.addProtocolFields(clientData, firstInbound)
 
 	// Add client via API
-	return b.apiClient.AddClient(context.Background(), inboundID, clientData)
+	return apiClient.AddClient(context.Background(), inboundID, clientData)
 }
 
 // stripInboundSuffix removes the __remarkName suffix from email if present
@@ -475,56 +541,37 @@ func (b *Bot) subscriptionSyncScheduler(ctx context.Context) {
 	}
 }
 
-// syncSubscriptionExpiry syncs subscription expiry data to local database
+// syncSubscriptionExpiry syncs subscription expiry data from all panels to local database
 func (b *Bot) syncSubscriptionExpiry() error {
-	inbounds, err := b.apiClient.GetInbounds(context.Background())
-	if err != nil {
-		return fmt.Errorf("failed to get inbounds: %w", err)
+	var inbounds []client.Inbound
+	for _, apiClient := range b.panels.All() {
+		panelInbounds, err := apiClient.GetInbounds(context.Background())
+		if err != nil {
+			return fmt.Errorf("failed to get inbounds of panel %s: %w", apiClient.Name(), err)
+		}
+		inbounds = append(inbounds, panelInbounds...)
 	}
 
 	for _, inbound := range inbounds {
-		settingsStr := ""
-		if settings, ok := inbound["settings"].(string); ok {
-			settingsStr = settings
-		}
-
-		clients, err := b.clientService.ParseClients(settingsStr)
+		clients, err := inbound.Clients()
 		if err != nil {
-			b.logger.Errorf("Failed to parse clients for inbound: %v", err)
-			continue
+			b.logger.Errorf("Failed to parse clients for inbound %d: %v", inbound.ID, err)
 		}
 
-		for _, client := range clients {
-			// Parse tgId
-			var tgID int64
-			if tgIDStr, ok := client["tgId"]; ok && tgIDStr != "" {
-				if val, err := strconv.ParseInt(tgIDStr, 10, 64); err == nil {
-					tgID = val
-				}
-			}
-
+		for _, c := range clients {
 			// Skip clients without telegram ID
-			if tgID == 0 {
+			if !c.HasTgID() {
 				continue
 			}
 
-			// Parse expiry time
-			var expiryTime int64
-			if expiryStr, ok := client["expiryTime"]; ok && expiryStr != "" {
-				if val, err := strconv.ParseInt(expiryStr, 10, 64); err == nil {
-					expiryTime = val
-				}
-			}
-
 			// Skip clients without expiry or expired
-			if expiryTime == 0 || expiryTime < time.Now().UnixMilli() {
+			if c.ExpiryTime == 0 || c.ExpiryTime < time.Now().UnixMilli() {
 				continue
 			}
 
 			// Upsert to database
-			email := client["email"]
-			if err := b.storage.UpsertSubscriptionExpiry(email, tgID, expiryTime); err != nil {
-				b.logger.Errorf("Failed to upsert subscription expiry for %s: %v", email, err)
+			if err := b.storage.UpsertSubscriptionExpiry(c.Email, c.TgID, c.ExpiryTime); err != nil {
+				b.logger.Errorf("Failed to upsert subscription expiry for %s: %v", c.Email, err)
 			}
 		}
 	}
@@ -534,9 +581,9 @@ func (b *Bot) syncSubscriptionExpiry() error {
 
 // backupScheduler periodically sends database backups to admins
 func (b *Bot) backupScheduler() {
-	b.logger.Infof("Backup scheduler started (interval: %d days)", b.config.Panel.BackupDays)
+	b.logger.Infof("Backup scheduler started (interval: %d days)", b.cfg().Panel.BackupDays)
 
-	ticker := time.NewTicker(time.Duration(b.config.Panel.BackupDays) * 24 * time.Hour)
+	ticker := time.NewTicker(time.Duration(b.cfg().Panel.BackupDays) * 24 * time.Hour)
 	defer ticker.Stop()
 
 	// Send initial backup on start
@@ -553,3 +600,21 @@ func (b *Bot) backupScheduler() {
 		}
 	}
 }
+
+// setCommands sets the command menu of a language, an empty language sets the default menu
+// (the catalog falls back to the default language for it)
+func (b *Bot) setCommands(lang string) {
+	t := b.i18n.Translator(lang)
+
+	var commands []telego.BotCommand
+	for _, command := range []string{"start", "help", "status", "id", "usage", "forecast"} {
+		commands = append(commands, telego.BotCommand{Command: command, Description: t("menu." + command)})
+	}
+
+	if err := b.bot.SetMyCommands(context.Background(), &telego.SetMyCommandsParams{
+		Commands:     commands,
+		LanguageCode: lang,
+	}); err != nil {
+		b.logger.Warnf("Failed to set bot commands for language %q: %v", lang, err)
+	}
+}
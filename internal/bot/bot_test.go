@@ -0,0 +1,169 @@
+package bot
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+
+	"x-ui-bot/internal/config"
+	"x-ui-bot/internal/storage"
+	"x-ui-bot/pkg/client"
+
+	"github.com/mymmrac/telego"
+)
+
+const (
+	testToken   = "123456:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"
+	testAdminID = 1
+)
+
+// telegramRequest is a Bot API call made by a test bot
+type telegramRequest struct {
+	Method string
+	Params map[string]interface{}
+}
+
+// ChatID returns the chat the request was sent to, 0 when it has none
+func (r telegramRequest) ChatID() int64 {
+	id, _ := r.Params["chat_id"].(float64)
+	return int64(id)
+}
+
+// Text returns the text or caption of a sent message
+func (r telegramRequest) Text() string {
+	if text, ok := r.Params["text"].(string); ok {
+		return text
+	}
+	caption, _ := r.Params["caption"].(string)
+	return caption
+}
+
+// fakeTelegram is a Bot API server that records every call and answers it with a plausible result
+type fakeTelegram struct {
+	mu       sync.Mutex
+	requests []telegramRequest
+}
+
+func (f *fakeTelegram) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	req := telegramRequest{Method: path.Base(r.URL.Path), Params: map[string]interface{}{}}
+	_ = json.NewDecoder(r.Body).Decode(&req.Params)
+
+	f.mu.Lock()
+	f.requests = append(f.requests, req)
+	f.mu.Unlock()
+
+	var result interface{}
+	switch req.Method {
+	case "getChat":
+		result = map[string]interface{}{
+			"id":                 req.ChatID(),
+			"type":               "private",
+			"first_name":         "Test",
+			"accent_color_id":    0,
+			"max_reaction_count": 0,
+		}
+	case "getMe":
+		result = map[string]interface{}{"id": 123456, "is_bot": true, "first_name": "Bot", "username": "test_bot"}
+	case "answerCallbackQuery", "answerPreCheckoutQuery", "deleteMessage", "setMyCommands":
+		result = true
+	default:
+		result = map[string]interface{}{
+			"message_id": 1,
+			"date":       0,
+			"chat":       map[string]interface{}{"id": req.ChatID(), "type": "private"},
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "result": result})
+}
+
+// sent returns the calls of a method, to one chat when chatID is not 0
+func (f *fakeTelegram) sent(method string, chatID int64) []telegramRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var found []telegramRequest
+	for _, req := range f.requests {
+		if req.Method == method && (chatID == 0 || req.ChatID() == chatID) {
+			found = append(found, req)
+		}
+	}
+	return found
+}
+
+// testConfig is the configuration of test bots: one admin and one panel at panelURL
+func testConfig(t *testing.T, panelURL string, extra string) *config.Config {
+	t.Helper()
+
+	yaml := `
+telegram:
+  token: "` + testToken + `"
+  admin_ids: [1]
+panel:
+  url: "` + panelURL + `"
+  username: admin
+  password: admin
+payment:
+  bank: Test Bank
+  phone_number: "+10000000000"
+  prices:
+    one_month: 300
+    three_month: 800
+    six_month: 1500
+    one_year: 2800
+` + extra
+	cfg, err := config.Parse([]byte(strings.TrimSpace(yaml)))
+	if err != nil {
+		t.Fatalf("config.Parse: %v", err)
+	}
+	return cfg
+}
+
+// newTestBot creates a bot on the in-memory storage that talks to a fake Bot API server.
+// The panel is not contacted unless a test serves one at cfg.Panel.URL
+func newTestBot(t *testing.T, cfg *config.Config) (*Bot, *fakeTelegram, storage.Storage) {
+	t.Helper()
+
+	tg := &fakeTelegram{}
+	server := httptest.NewServer(tg)
+	t.Cleanup(server.Close)
+
+	tgBot, err := telego.NewBot(testToken, telego.WithAPIServer(server.URL), telego.WithDiscardLogger())
+	if err != nil {
+		t.Fatalf("telego.NewBot: %v", err)
+	}
+
+	panels := client.NewRegistry()
+	api := client.NewAPIClient(cfg.Panel.URL, cfg.Panel.Username, cfg.Panel.Password)
+	api.SetInboundCacheTTL(0)
+	if err := panels.Add(cfg.Panel.Name, api); err != nil {
+		t.Fatalf("panels.Add: %v", err)
+	}
+
+	store := storage.NewMemoryStorage()
+	t.Cleanup(func() { _ = store.Close() })
+
+	b, err := newBot(config.NewStore("", cfg), panels, store, tgBot)
+	if err != nil {
+		t.Fatalf("newBot: %v", err)
+	}
+	return b, tg, store
+}
+
+// callbackQuery builds a callback query of a button pressed by a user in their private chat
+func callbackQuery(userID int64, data string) telego.CallbackQuery {
+	return telego.CallbackQuery{
+		ID:   "query",
+		From: telego.User{ID: userID, FirstName: "Test"},
+		Data: data,
+		Message: &telego.Message{
+			MessageID: 10,
+			Chat:      telego.Chat{ID: userID, Type: telego.ChatTypePrivate},
+		},
+	}
+}
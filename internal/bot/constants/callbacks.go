@@ -9,14 +9,36 @@ const (
 	CmdUsage    = "usage"
 	CmdClients  = "clients"
 	CmdForecast = "forecast"
+
+	// Promo codes (admin)
+	CmdPromoAdd    = "promo_add"
+	CmdPromoList   = "promos"
+	CmdPromoDelete = "promo_del"
+
+	// Client search (admin)
+	CmdFind = "find"
+
+	// Audit log (admin)
+	CmdAudit       = "audit"
+	CmdAuditExport = "audit_csv"
+
+	// Broadcast schedules (admin)
+	CmdScheduled = "scheduled"
+
+	// Notification templates (admin)
+	CmdTemplates = "templates"
 )
 
 // Callback Prefixes and Data
 const (
 	// Forecast
-	CbForecastTotal         = "forecast_total"
+	CbForecastTotalPrefix   = "forecast_total_"
 	CbForecastInboundPrefix = "forecast_inbound_"
 
+	// Server pickers (multi-panel setups)
+	CbStatusPanelPrefix  = "status_panel_"
+	CbClientsPanelPrefix = "clients_panel_"
+
 	// Terms
 	CbTermsAccept  = "terms_accept"
 	CbTermsDecline = "terms_decline"
@@ -41,6 +63,19 @@ const (
 	CbApproveExtPrefix = "approve_ext_"
 	CbRejectExtPrefix  = "reject_ext_"
 
+	// Promo codes
+	CbPromoRegistration = "promo_reg"
+	CbPromoExtension    = "promo_ext"
+
+	// Receipts
+	CbExtNoReceiptPrefix = "ext_noreceipt_"
+
+	// Invoice payloads (Telegram Payments)
+	InvoiceExtendPrefix = "ext_"
+
+	// Deep-link payloads of /start
+	StartReferralPrefix = "ref_"
+
 	// Client Management
 	CbClientPrefix        = "client_"
 	CbBackToClients       = "back_to_clients"
@@ -48,13 +83,47 @@ const (
 	CbConfirmDeletePrefix = "confirm_delete_"
 	CbCancelDeletePrefix  = "cancel_delete_"
 
+	// Client list filters and pages
+	CbClientListPrefix       = "cl_"
+	CbClientListFilterPrefix = "cl_f_"
+	CbClientListPagePrefix   = "cl_p_"
+	CbClientListBackPrefix   = "cl_back_"
+	CbClientListClearSearch  = "cl_clear"
+
 	// General
 	CbContactAdmin = "contact_admin"
 	CbReplyPrefix  = "reply_"
 
+	// Language picker
+	CbLanguagePrefix = "lang_"
+
+	// Audit log
+	CbAuditPagePrefix = "audit_page_"
+	CbAuditExport     = "audit_export"
+
 	// Broadcast
-	CbBroadcastConfirm = "broadcast_confirm"
-	CbBroadcastCancel  = "broadcast_cancel"
+	CbBroadcastCancel         = "broadcast_cancel"
+	CbBroadcastButtons        = "bc_buttons"
+	CbBroadcastSchedule       = "bc_schedule"
+	CbBroadcastRepeat         = "bc_repeat"
+	CbBroadcastPin            = "bc_pin"
+	CbBroadcastPreview        = "bc_preview"
+	CbBroadcastNext           = "bc_next"
+	CbBroadcastAudiencePrefix = "bc_aud_"
+	CbBroadcastSendPrefix     = "bc_send_"
+	CbBroadcastPausePrefix    = "bc_pause_"
+	CbBroadcastResumePrefix   = "bc_resume_"
+	CbBroadcastStopPrefix     = "bc_stop_"
+	CbBroadcastRetryPrefix    = "bc_retry_"
+
+	// Broadcast schedules
+	CbScheduleList          = "sch_list"
+	CbScheduleOpenPrefix    = "sch_open_"
+	CbScheduleTimePrefix    = "sch_time_"
+	CbScheduleCronPrefix    = "sch_cron_"
+	CbScheduleTextPrefix    = "sch_text_"
+	CbSchedulePreviewPrefix = "sch_prev_"
+	CbScheduleDeletePrefix  = "sch_del_"
 )
 
 // User States
@@ -65,21 +134,50 @@ const (
 	StateAwaitingDuration         = "awaiting_duration"
 	StateAwaitingNewEmail         = "awaiting_new_email"
 	StateAwaitingBroadcastMessage = "awaiting_broadcast_message"
+	StateAwaitingBroadcastButtons = "awaiting_broadcast_buttons"
+	StateAwaitingBroadcastSendAt  = "awaiting_broadcast_send_at"
+	StateAwaitingBroadcastCron    = "awaiting_broadcast_cron"
+	StateAwaitingScheduleEdit     = "awaiting_schedule_edit"
+	StateAwaitingReceipt          = "awaiting_receipt"
+	StateAwaitingRegPromo         = "awaiting_reg_promo"
+	StateAwaitingExtPromo         = "awaiting_ext_promo"
 )
 
-// Button Texts
+// Button Texts, catalog keys of the reply keyboard buttons.
+// Pressed buttons arrive as text in the user's language, match them with i18n.Catalog.Matches
 const (
-	BtnServerStatus       = "📊 Статус сервера"
-	BtnTrafficForecast    = "📊 Прогноз трафика"
-	BtnClientList         = "👥 Список клиентов"
-	BtnBroadcast          = "📢 Сделать объявление"
-	BtnBackupDB           = "💾 Бэкап БД"
-	BtnTerms              = "📜 Ознакомиться с условиями"
-	BtnMySubscription     = "📱 Моя подписка и инструкции"
-	BtnInstructions       = "инструкции" // Keep for backward compatibility or partial match if needed
-	BtnExtendSubscription = "⏰ Продлить подписку"
-	BtnSettings           = "⚙️ Настройки"
-	BtnUpdateUsername     = "🔄 Обновить username"
-	BtnBack               = "◀️ Назад"
-	BtnContactAdmin       = "💬 Связь с админом"
+	BtnServerStatus       = "button.server_status"
+	BtnTrafficForecast    = "button.traffic_forecast"
+	BtnClientList         = "button.client_list"
+	BtnBroadcast          = "button.broadcast"
+	BtnBackupDB           = "button.backup_db"
+	BtnTerms              = "button.terms"
+	BtnMySubscription     = "button.my_subscription"
+	BtnInstructions       = "button.instructions" // Keep for backward compatibility or partial match if needed
+	BtnExtendSubscription = "button.extend_subscription"
+	BtnSettings           = "button.settings"
+	BtnUpdateUsername     = "button.update_username"
+	BtnLanguage           = "button.language"
+	BtnReferral           = "button.referral"
+	BtnBack               = "button.back"
+	BtnContactAdmin       = "button.contact_admin"
 )
+
+// ReplyKeyboardButtons lists every reply keyboard button, text matching one is a pressed button rather than input
+var ReplyKeyboardButtons = []string{
+	BtnServerStatus,
+	BtnTrafficForecast,
+	BtnClientList,
+	BtnBroadcast,
+	BtnBackupDB,
+	BtnTerms,
+	BtnMySubscription,
+	BtnInstructions,
+	BtnExtendSubscription,
+	BtnSettings,
+	BtnUpdateUsername,
+	BtnLanguage,
+	BtnReferral,
+	BtnBack,
+	BtnContactAdmin,
+}
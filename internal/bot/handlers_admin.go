@@ -2,7 +2,6 @@ package bot
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"html"
 	"os"
@@ -12,6 +11,10 @@ import (
 
 	"x-ui-bot/internal/bot/constants"
 	kbd "x-ui-bot/internal/bot/keyboard"
+	"x-ui-bot/internal/bot/services"
+	"x-ui-bot/internal/storage"
+	"x-ui-bot/internal/templates"
+	"x-ui-bot/pkg/client"
 
 	"github.com/mymmrac/telego"
 	tu "github.com/mymmrac/telego/telegoutil"
@@ -21,9 +24,10 @@ import (
 
 // handleAdminMessageSend handles sending message from admin to client
 func (b *Bot) handleAdminMessageSend(adminChatID int64, messageText string) {
+	t := b.tr(adminChatID)
 	state, exists := b.getAdminMessageState(adminChatID)
 	if !exists {
-		b.sendMessage(adminChatID, "❌ Ошибка: состояние не найдено")
+		b.sendMessage(adminChatID, t("common.error_no_state"))
 		if err := b.deleteUserState(adminChatID); err != nil {
 			b.logger.Errorf("Failed to delete user state: %v", err)
 		}
@@ -33,7 +37,7 @@ func (b *Bot) handleAdminMessageSend(adminChatID int64, messageText string) {
 	// Parse client Telegram ID
 	clientTgID, err := strconv.ParseInt(state.ClientTgID, 10, 64)
 	if err != nil {
-		b.sendMessage(adminChatID, "❌ Ошибка: неверный Telegram ID клиента")
+		b.sendMessage(adminChatID, t("contact.error_client_id"))
 		if err := b.deleteUserState(adminChatID); err != nil {
 			b.logger.Errorf("Failed to delete user state: %v", err)
 		}
@@ -44,7 +48,8 @@ func (b *Bot) handleAdminMessageSend(adminChatID int64, messageText string) {
 	}
 
 	// Create reply button for user
-	replyButton := tu.InlineKeyboardButton("💬 Ответить").
+	ct := b.tr(clientTgID)
+	replyButton := tu.InlineKeyboardButton(ct("button.reply")).
 		WithCallbackData(constants.CbContactAdmin)
 
 	replyKB := &telego.InlineKeyboardMarkup{
@@ -56,16 +61,17 @@ func (b *Bot) handleAdminMessageSend(adminChatID int64, messageText string) {
 	// Send message to client with reply button
 	_, err = b.bot.SendMessage(context.Background(), &telego.SendMessageParams{
 		ChatID:      tu.ID(clientTgID),
-		Text:        fmt.Sprintf("📨 <b>Сообщение от администратора:</b>\n\n%s", messageText),
+		Text:        ct("contact.from_admin") + "\n\n" + messageText,
 		ParseMode:   "HTML",
 		ReplyMarkup: replyKB,
 	})
 
 	cleanEmail := stripInboundSuffix(state.ClientEmail)
 	if err != nil {
-		b.sendMessage(adminChatID, fmt.Sprintf("❌ Не удалось отправить сообщение клиенту %s: %v", cleanEmail, err))
+		b.sendMessage(adminChatID, t("contact.send_failed", cleanEmail, err))
 	} else {
-		b.sendMessage(adminChatID, fmt.Sprintf("✅ Сообщение отправлено клиенту %s", cleanEmail))
+		b.sendMessage(adminChatID, t("contact.sent", cleanEmail))
+		b.recordAudit(adminChatID, storage.AuditActionMessage, cleanEmail, clientTgID, "", messageText)
 	}
 
 	// Clear state
@@ -79,9 +85,10 @@ func (b *Bot) handleAdminMessageSend(adminChatID int64, messageText string) {
 
 // handleAdminMediaSend handles sending media from admin to client
 func (b *Bot) handleAdminMediaSend(adminChatID int64, message *telego.Message) {
+	t := b.tr(adminChatID)
 	state, exists := b.getAdminMessageState(adminChatID)
 	if !exists {
-		b.sendMessage(adminChatID, "❌ Ошибка: состояние не найдено")
+		b.sendMessage(adminChatID, t("common.error_no_state"))
 		if err := b.deleteUserState(adminChatID); err != nil {
 			b.logger.Errorf("Failed to delete user state: %v", err)
 		}
@@ -91,7 +98,7 @@ func (b *Bot) handleAdminMediaSend(adminChatID int64, message *telego.Message) {
 	// Parse client Telegram ID
 	clientTgID, err := strconv.ParseInt(state.ClientTgID, 10, 64)
 	if err != nil {
-		b.sendMessage(adminChatID, "❌ Ошибка: неверный Telegram ID клиента")
+		b.sendMessage(adminChatID, t("contact.error_client_id"))
 		if err := b.deleteUserState(adminChatID); err != nil {
 			b.logger.Errorf("Failed to delete user state: %v", err)
 		}
@@ -101,13 +108,14 @@ func (b *Bot) handleAdminMediaSend(adminChatID int64, message *telego.Message) {
 		return
 	}
 
-	caption := "📨 <b>Сообщение от администратора:</b>"
+	ct := b.tr(clientTgID)
+	caption := ct("contact.from_admin")
 	if message.Caption != "" {
 		caption += fmt.Sprintf("\n\n%s", message.Caption)
 	}
 
 	// Create reply button for user
-	replyButton := tu.InlineKeyboardButton("💬 Ответить").
+	replyButton := tu.InlineKeyboardButton(ct("button.reply")).
 		WithCallbackData(constants.CbContactAdmin)
 
 	replyKB := &telego.InlineKeyboardMarkup{
@@ -117,6 +125,7 @@ func (b *Bot) handleAdminMediaSend(adminChatID int64, message *telego.Message) {
 	}
 
 	// Send media to client
+	sent := false
 	if len(message.Photo) > 0 {
 		// Get the largest photo
 		photo := message.Photo[len(message.Photo)-1]
@@ -127,9 +136,10 @@ func (b *Bot) handleAdminMediaSend(adminChatID int64, message *telego.Message) {
 			ParseMode:   telego.ModeHTML,
 			ReplyMarkup: replyKB,
 		}); err != nil {
-			b.sendMessage(adminChatID, fmt.Sprintf("❌ Не удалось отправить сообщение клиенту %s: %v", state.ClientEmail, err))
+			b.sendMessage(adminChatID, t("contact.send_failed", state.ClientEmail, err))
 		} else {
-			b.sendMessage(adminChatID, fmt.Sprintf("✅ Сообщение отправлено клиенту %s", state.ClientEmail))
+			b.sendMessage(adminChatID, t("contact.sent", state.ClientEmail))
+			sent = true
 		}
 	} else if message.Video != nil {
 		if _, err := b.bot.SendVideo(context.Background(), &telego.SendVideoParams{
@@ -139,9 +149,10 @@ func (b *Bot) handleAdminMediaSend(adminChatID int64, message *telego.Message) {
 			ParseMode:   telego.ModeHTML,
 			ReplyMarkup: replyKB,
 		}); err != nil {
-			b.sendMessage(adminChatID, fmt.Sprintf("❌ Не удалось отправить сообщение клиенту %s: %v", state.ClientEmail, err))
+			b.sendMessage(adminChatID, t("contact.send_failed", state.ClientEmail, err))
 		} else {
-			b.sendMessage(adminChatID, fmt.Sprintf("✅ Сообщение отправлено клиенту %s", state.ClientEmail))
+			b.sendMessage(adminChatID, t("contact.sent", state.ClientEmail))
+			sent = true
 		}
 	} else if message.Document != nil {
 		if _, err := b.bot.SendDocument(context.Background(), &telego.SendDocumentParams{
@@ -151,9 +162,10 @@ func (b *Bot) handleAdminMediaSend(adminChatID int64, message *telego.Message) {
 			ParseMode:   telego.ModeHTML,
 			ReplyMarkup: replyKB,
 		}); err != nil {
-			b.sendMessage(adminChatID, fmt.Sprintf("❌ Не удалось отправить сообщение клиенту %s: %v", state.ClientEmail, err))
+			b.sendMessage(adminChatID, t("contact.send_failed", state.ClientEmail, err))
 		} else {
-			b.sendMessage(adminChatID, fmt.Sprintf("✅ Сообщение отправлено клиенту %s", state.ClientEmail))
+			b.sendMessage(adminChatID, t("contact.sent", state.ClientEmail))
+			sent = true
 		}
 	} else if message.Audio != nil {
 		if _, err := b.bot.SendAudio(context.Background(), &telego.SendAudioParams{
@@ -163,9 +175,10 @@ func (b *Bot) handleAdminMediaSend(adminChatID int64, message *telego.Message) {
 			ParseMode:   telego.ModeHTML,
 			ReplyMarkup: replyKB,
 		}); err != nil {
-			b.sendMessage(adminChatID, fmt.Sprintf("❌ Не удалось отправить сообщение клиенту %s: %v", state.ClientEmail, err))
+			b.sendMessage(adminChatID, t("contact.send_failed", state.ClientEmail, err))
 		} else {
-			b.sendMessage(adminChatID, fmt.Sprintf("✅ Сообщение отправлено клиенту %s", state.ClientEmail))
+			b.sendMessage(adminChatID, t("contact.sent", state.ClientEmail))
+			sent = true
 		}
 	} else if message.Voice != nil {
 		if _, err := b.bot.SendVoice(context.Background(), &telego.SendVoiceParams{
@@ -175,12 +188,17 @@ func (b *Bot) handleAdminMediaSend(adminChatID int64, message *telego.Message) {
 			ParseMode:   telego.ModeHTML,
 			ReplyMarkup: replyKB,
 		}); err != nil {
-			b.sendMessage(adminChatID, fmt.Sprintf("❌ Не удалось отправить сообщение клиенту %s: %v", state.ClientEmail, err))
+			b.sendMessage(adminChatID, t("contact.send_failed", state.ClientEmail, err))
 		} else {
-			b.sendMessage(adminChatID, fmt.Sprintf("✅ Сообщение отправлено клиенту %s", state.ClientEmail))
+			b.sendMessage(adminChatID, t("contact.sent", state.ClientEmail))
+			sent = true
 		}
 	}
 
+	if sent {
+		b.recordAudit(adminChatID, storage.AuditActionMessage, state.ClientEmail, clientTgID, "", "[media] "+message.Caption)
+	}
+
 	// Clear state
 	if err := b.deleteUserState(adminChatID); err != nil {
 		b.logger.Errorf("Failed to delete user state: %v", err)
@@ -193,17 +211,16 @@ func (b *Bot) handleAdminMediaSend(adminChatID int64, message *telego.Message) {
 // handleContactAdmin initiates user messaging admin
 func (b *Bot) handleContactAdmin(chatID int64, userID int64) {
 	b.logger.Infof("User %d wants to contact admin", userID)
+	t := b.tr(userID)
 
 	// Get user info from Telegram
 	tgUsername := ""
 	userName := ""
 
 	// Try to get from API (if registered)
-	clientInfo, err := b.apiClient.GetClientByTgID(context.Background(), userID)
+	_, clientInfo, err := b.findClientByTgID(userID)
 	if err == nil && clientInfo != nil {
-		if email, ok := clientInfo["email"].(string); ok {
-			userName = stripInboundSuffix(email)
-		}
+		userName = stripInboundSuffix(clientInfo.Email)
 	}
 
 	// Store state
@@ -213,22 +230,23 @@ func (b *Bot) handleContactAdmin(chatID int64, userID int64) {
 		TgUsername: tgUsername,
 		Timestamp:  time.Now(),
 	}); err != nil {
-		b.sendMessage(chatID, "❌ Ошибка сохранения состояния")
+		b.sendMessage(chatID, t("common.error_state"))
 		return
 	}
 	if err := b.setUserState(chatID, constants.StateAwaitingUserMessage); err != nil {
-		b.sendMessage(chatID, "❌ Ошибка сохранения состояния")
+		b.sendMessage(chatID, t("common.error_state"))
 		return
 	}
 
-	b.sendMessage(chatID, "💬 Напишите ваше сообщение администратору:")
+	b.sendMessage(chatID, t("contact.prompt"))
 }
 
 // handleUserMessageSend handles sending message from user to admins
 func (b *Bot) handleUserMessageSend(chatID int64, userID int64, messageText string, from *telego.User) {
+	t := b.tr(userID)
 	state, exists := b.getUserMessageState(chatID)
 	if !exists {
-		b.sendMessage(chatID, "❌ Ошибка: состояние не найдено")
+		b.sendMessage(chatID, t("common.error_no_state"))
 		if err := b.deleteUserState(chatID); err != nil {
 			b.logger.Errorf("Failed to delete user state: %v", err)
 		}
@@ -246,19 +264,12 @@ func (b *Bot) handleUserMessageSend(chatID int64, userID int64, messageText stri
 	}
 
 	// Send message to all admins with reply button
-	for _, adminID := range b.config.Telegram.AdminIDs {
-		msg := fmt.Sprintf(
-			"📨 <b>Сообщение от пользователя:</b>\n\n"+
-				"👤 %s %s\n"+
-				"🆔 ID: %d\n\n"+
-				"💬 <i>%s</i>",
-			userName,
-			tgUsername,
-			userID,
-			html.EscapeString(messageText),
-		)
-
-		kb := kbd.BuildReplyInlineKeyboard(userID)
+	for _, adminID := range b.cfg().Telegram.AdminIDs {
+		at := b.tr(adminID)
+		msg := at("contact.from_user", userName, tgUsername, userID) +
+			at("contact.from_user_text", html.EscapeString(messageText))
+
+		kb := kbd.BuildReplyInlineKeyboard(at, userID)
 
 		if _, err := b.bot.SendMessage(context.Background(), tu.Message(tu.ID(adminID), msg).
 			WithReplyMarkup(kb).
@@ -267,7 +278,7 @@ func (b *Bot) handleUserMessageSend(chatID int64, userID int64, messageText stri
 		}
 	}
 
-	b.sendMessage(chatID, "✅ Ваше сообщение отправлено администратору")
+	b.sendMessage(chatID, t("contact.sent_to_admin"))
 
 	// Clear state
 	if err := b.deleteUserState(chatID); err != nil {
@@ -280,25 +291,31 @@ func (b *Bot) handleUserMessageSend(chatID int64, userID int64, messageText stri
 
 // handleUsage handles the /usage command
 func (b *Bot) handleUsage(chatID int64, email string) {
-	traffic, err := b.apiClient.GetClientTraffics(context.Background(), email)
-	if err != nil {
-		b.sendMessage(chatID, fmt.Sprintf("❌ Failed to get client traffic: %v", err))
+	t := b.tr(chatID)
+	// Email is unique per panel, take the first panel that knows it
+	var traffic *client.ClientStat
+	var err error
+	for _, apiClient := range b.panels.All() {
+		traffic, err = apiClient.GetClientTraffics(context.Background(), email)
+		if err == nil && traffic != nil {
+			break
+		}
+	}
+	if err != nil || traffic == nil {
+		if err == nil {
+			err = fmt.Errorf("client not found")
+		}
+		b.sendMessage(chatID, t("usage.error", err))
 		return
 	}
 
 	// Format usage message
 	cleanEmail := stripInboundSuffix(email)
-	msg := fmt.Sprintf("📈 Usage for %s:\n\n", cleanEmail)
+	msg := t("usage.title", cleanEmail)
 
-	if up, ok := traffic["up"].(float64); ok {
-		msg += fmt.Sprintf("⬆️ Upload: %.2f GB\n", up/1024/1024/1024)
-	}
-	if down, ok := traffic["down"].(float64); ok {
-		msg += fmt.Sprintf("⬇️ Download: %.2f GB\n", down/1024/1024/1024)
-	}
-	if total, ok := traffic["total"].(float64); ok {
-		msg += fmt.Sprintf("📊 Total: %.2f GB\n", total/1024/1024/1024)
-	}
+	msg += t("usage.upload", float64(traffic.Up)/1024/1024/1024)
+	msg += t("usage.download", float64(traffic.Down)/1024/1024/1024)
+	msg += t("usage.total", float64(traffic.Total)/1024/1024/1024)
 
 	b.sendMessage(chatID, msg)
 }
@@ -306,31 +323,26 @@ func (b *Bot) handleUsage(chatID int64, email string) {
 // handleShowTerms shows terms and conditions
 func (b *Bot) handleShowTerms(chatID int64, userID int64) {
 	b.logger.Infof("Showing terms to user %d", userID)
+	t := b.tr(userID)
 
 	// Read terms from file
 	terms, err := os.ReadFile("terms.txt")
 	if err != nil {
 		b.logger.Errorf("Failed to read terms.txt: %v", err)
-		b.sendMessage(chatID, "❌ Ошибка загрузки условий. Обратитесь к администратору.")
+		b.sendMessage(chatID, t("terms.error_load"))
 		return
 	}
 
 	// Check if user is already registered
-	clientInfo, err := b.apiClient.GetClientByTgID(context.Background(), chatID)
-	isRegistered := err == nil && clientInfo != nil
+	_, isRegistered := b.getUser(chatID)
 
 	var keyboard *telego.InlineKeyboardMarkup
 	text := string(terms)
 
 	if isRegistered {
-		text += "\n\n✅ Вы уже приняли эти условия."
+		text += t("terms.already_accepted")
 	} else {
-		keyboard = tu.InlineKeyboard(
-			tu.InlineKeyboardRow(
-				tu.InlineKeyboardButton("✅ Принять").WithCallbackData("terms_accept"),
-				tu.InlineKeyboardButton("❌ Отклонить").WithCallbackData("terms_decline"),
-			),
-		)
+		keyboard = kbd.BuildTermsKeyboard(t)
 	}
 
 	if _, err := b.bot.SendMessage(context.Background(), &telego.SendMessageParams{
@@ -346,10 +358,10 @@ func (b *Bot) handleShowTerms(chatID int64, userID int64) {
 // handleTermsAccept handles terms acceptance
 func (b *Bot) handleTermsAccept(chatID int64, userID int64, messageID int, from *telego.User) {
 	// Check if user is already registered
-	clientInfo, err := b.apiClient.GetClientByTgID(context.Background(), chatID)
+	_, clientInfo, err := b.findClientByTgID(chatID)
 	if err == nil && clientInfo != nil {
 		b.logger.Infof("User %d tried to accept terms but is already registered", userID)
-		b.sendMessage(chatID, "✅ Вы уже зарегистрированы.")
+		b.sendMessage(chatID, b.t(userID, "terms.already_registered"))
 
 		// Remove buttons from the message
 		_, _ = b.bot.EditMessageReplyMarkup(context.Background(), &telego.EditMessageReplyMarkupParams{
@@ -366,7 +378,7 @@ func (b *Bot) handleTermsAccept(chatID int64, userID int64, messageID int, from
 	if _, err := b.bot.EditMessageText(context.Background(), &telego.EditMessageTextParams{
 		ChatID:    tu.ID(chatID),
 		MessageID: messageID,
-		Text:      "✅ Вы приняли условия использования.\n\nТеперь можете приступить к регистрации.",
+		Text:      b.t(userID, "terms.accepted_full"),
 	}); err != nil {
 		b.logger.Errorf("Failed to edit terms message for user %d: %v", chatID, err)
 	}
@@ -382,7 +394,7 @@ func (b *Bot) handleTermsAccept(chatID int64, userID int64, messageID int, from
 	tgUsername := from.Username
 
 	// Start registration process
-	b.handleRegistrationStart(chatID, userID, userName, tgUsername)
+	b.handleRegistrationStart(chatID, userID, userName, tgUsername, b.userLanguage(userID))
 }
 
 // handleTermsDecline handles terms decline
@@ -393,50 +405,38 @@ func (b *Bot) handleTermsDecline(chatID int64, messageID int) {
 	if _, err := b.bot.EditMessageText(context.Background(), &telego.EditMessageTextParams{
 		ChatID:    tu.ID(chatID),
 		MessageID: messageID,
-		Text:      "❌ Вы отклонили условия использования.\n\nБез принятия условий регистрация невозможна.\n\nВы можете ознакомиться с условиями заново в любое время.",
+		Text:      b.t(chatID, "terms.declined_full"),
 	}); err != nil {
 		b.logger.Errorf("Failed to edit terms decline message for user %d: %v", chatID, err)
 	}
 }
 
-// handleExtendSubscription handles subscription extension request
-func (b *Bot) handleExtendSubscription(chatID int64, userID int64) {
+// handleExtendSubscription handles subscription extension request, promo is the entered promo code or nil
+func (b *Bot) handleExtendSubscription(chatID int64, userID int64, promo *storage.PromoCode) {
 	b.logger.Infof("User %d requested subscription extension", userID)
+	t := b.tr(userID)
 
 	// Get client info
-	clientInfo, err := b.apiClient.GetClientByTgID(context.Background(), userID)
+	_, clientInfo, err := b.findClientByTgID(userID)
 	if err != nil {
-		b.sendMessage(chatID, "❌ У вас нет активной подписки.\n\nДля получения VPN используйте кнопку '📱 Получить VPN'")
+		b.sendMessage(chatID, t("extension.no_subscription"))
 		return
 	}
 
-	email := ""
-	if e, ok := clientInfo["email"].(string); ok {
-		email = e
-	}
+	email := clientInfo.Email
 
 	// Check if user has unlimited subscription (expiryTime = 0)
-	expiryTime := int64(0)
-	if et, ok := clientInfo["expiryTime"].(float64); ok {
-		expiryTime = int64(et)
-	}
-
-	if expiryTime == 0 {
-		b.sendMessage(chatID, "✅ У вас безлимитная подписка!\n\n∞ Срок действия: бессрочно\n\nПродление не требуется.")
+	if clientInfo.ExpiryTime == 0 {
+		b.sendMessage(chatID, t("extension.unlimited"))
 		b.logger.Infof("User %d has unlimited subscription, extension denied", userID)
 		return
 	}
 
 	// Show duration selection keyboard with prices (no trial for renewals)
-	keyboard := b.createDurationKeyboard(fmt.Sprintf("extend_%d", userID), false)
+	keyboard := b.createDurationKeyboard(t, fmt.Sprintf("extend_%d", userID), false, promo)
 
 	cleanEmail := stripInboundSuffix(email)
-	msg := fmt.Sprintf(
-		"🔄 <b>Продление подписки</b>\n\n"+
-			"👤 Аккаунт: %s\n\n"+
-			"Выберите срок продления:",
-		html.EscapeString(cleanEmail),
-	)
+	msg := t("extension.choose_duration", html.EscapeString(cleanEmail))
 
 	if _, err := b.bot.SendMessage(context.Background(), tu.Message(tu.ID(chatID), msg).
 		WithReplyMarkup(keyboard).
@@ -445,112 +445,105 @@ func (b *Bot) handleExtendSubscription(chatID int64, userID int64) {
 	}
 }
 
-// handleExtensionRequest processes subscription extension request
-func (b *Bot) handleExtensionRequest(userID int64, chatID int64, messageID int, duration int, tgUsername string) {
+// handleExtensionRequest processes subscription extension request, promoCode is empty when no promo code was entered
+func (b *Bot) handleExtensionRequest(userID int64, chatID int64, messageID int, duration int, promoCode string) {
+	t := b.tr(userID)
+
 	// Get client info
-	clientInfo, err := b.apiClient.GetClientByTgID(context.Background(), userID)
+	_, clientInfo, err := b.findClientByTgID(userID)
 	if err != nil {
-		b.sendMessage(chatID, "❌ Ошибка: клиент не найден")
+		b.sendMessage(chatID, t("extension.error_client_not_found"))
 		return
 	}
 
-	email := ""
-	userName := ""
-	if e, ok := clientInfo["email"].(string); ok {
-		email = e
+	email := clientInfo.Email
+
+	// The promo code is checked again as it may have run out since it was entered
+	quote, err := b.priceQuote(userID, duration, promoCode)
+	if err != nil {
+		b.editMessageText(chatID, messageID, promoErrorText(t, err)+t("extension.reopen"))
+		return
 	}
 
-	// Use Telegram username if available, otherwise use email or fallback
-	if tgUsername != "" {
-		userName = tgUsername
-	} else if email != "" {
-		userName = email
-	} else {
-		userName = fmt.Sprintf("User_%d", userID)
+	// A period made free by a promo code is applied right away
+	if quote.Code != "" && quote.Price <= 0 {
+		b.applyFreeExtension(chatID, messageID, userID, quote)
+		return
 	}
 
-	// Format Telegram username for display
-	tgUsernameStr := ""
-	if tgUsername != "" {
-		tgUsernameStr = fmt.Sprintf("\n💬 Telegram: @%s", tgUsername)
-	}
-
-	// Send request to all admins
-	for _, adminID := range b.config.Telegram.AdminIDs {
-		keyboard := tu.InlineKeyboard(
-			tu.InlineKeyboardRow(
-				tu.InlineKeyboardButton("✅ Одобрить").WithCallbackData(fmt.Sprintf("approve_ext_%d_%d", userID, duration)),
-				tu.InlineKeyboardButton("❌ Отклонить").WithCallbackData(fmt.Sprintf("reject_ext_%d", userID)),
-			),
-		)
-
-		cleanEmail := stripInboundSuffix(email)
-		adminMsg := fmt.Sprintf(
-			"🔄 Запрос на продление подписки\n\n"+
-				"👤 Пользователь: %s (ID: %d)%s\n"+
-				"👤 Username: %s\n"+
-				"📅 Продлить на: %d дней",
-			userName,
-			userID,
-			tgUsernameStr,
-			cleanEmail,
-			duration,
-		)
-
-		if _, err := b.bot.SendMessage(context.Background(), tu.Message(tu.ID(adminID), adminMsg).
-			WithReplyMarkup(keyboard)); err != nil {
-			b.logger.Errorf("Failed to send extension request to admin %d: %v", adminID, err)
-		} else {
-			b.logger.Infof("Sent extension request to admin %d", adminID)
+	// Paid extensions are applied automatically, admins only see the result
+	if b.cfg().Payment.InvoicesEnabled() {
+		b.sendExtensionInvoice(chatID, messageID, userID, quote, email)
+		return
+	}
+
+	// A newer request replaces the one that never got a receipt
+	if open, err := b.storage.GetOpenExtensionRequest(userID); err == nil {
+		if err := b.storage.SetExtensionRequestStatus(open.ID, storage.ExtensionStatusCancelled); err != nil {
+			b.logger.Errorf("Failed to cancel extension request %d: %v", open.ID, err)
 		}
 	}
 
-	// Determine price based on duration
-	var price int
-	switch duration {
-	case 30:
-		price = b.config.Payment.Prices.OneMonth
-	case 90:
-		price = b.config.Payment.Prices.ThreeMonth
-	case 180:
-		price = b.config.Payment.Prices.SixMonth
-	case 365:
-		price = b.config.Payment.Prices.OneYear
+	req := &storage.ExtensionRequest{
+		UserID:    userID,
+		Duration:  duration,
+		Price:     quote.Price,
+		PromoCode: quote.Code,
+		BonusDays: quote.BonusDays,
+		Status:    storage.ExtensionStatusAwaitingReceipt,
+	}
+	if err := b.storage.CreateExtensionRequest(req); err != nil {
+		b.logger.Errorf("Failed to save extension request of user %d: %v", userID, err)
+		b.sendMessage(chatID, t("extension.error_save"))
+		return
+	}
+
+	if err := b.setUserState(chatID, constants.StateAwaitingReceipt); err != nil {
+		b.sendMessage(chatID, t("common.error_state"))
+		return
 	}
 
+	keyboard := tu.InlineKeyboard(
+		tu.InlineKeyboardRow(
+			tu.InlineKeyboardButton(t("button.send_without_receipt")).WithCallbackData(fmt.Sprintf("%s%d", constants.CbExtNoReceiptPrefix, req.ID)),
+		),
+	)
+
 	// Update user's message with payment info
 	cleanEmail := stripInboundSuffix(email)
-	b.editMessageText(chatID, messageID, fmt.Sprintf(
-		"✅ Запрос на продление отправлен администраторам!\n\n"+
-			"👤 Аккаунт: %s\n"+
-			"📅 Срок: %d дней\n\n"+
-			"💳 <b>Реквизиты для оплаты:</b>\n"+
-			"🏦 Банк: %s\n"+
-			"📱 Номер: %s\n"+
-			"💰 Сумма: %d₽\n\n"+
-			"✍️ В комментарии укажите свой username.\n\n"+
-			"⏳ После оплаты дождитесь одобрения администратора...",
+	b.editMessage(chatID, messageID, t("extension.payment_details",
 		html.EscapeString(cleanEmail),
 		duration,
-		html.EscapeString(b.config.Payment.Bank),
-		b.config.Payment.PhoneNumber,
-		price,
-	))
+		html.EscapeString(b.cfg().Payment.Bank),
+		b.cfg().Payment.PhoneNumber,
+		quote.Price,
+		b.cfg().Payment.Currency,
+		b.promoQuoteLines(t, quote),
+	), keyboard)
+
+	b.logger.Infof("Extension request %d created for user %d, email: %s, duration: %d days", req.ID, userID, email, duration)
+}
 
-	b.logger.Infof("Extension request sent for user %d, email: %s, duration: %d days", userID, email, duration)
+// extensionResult describes a subscription extended by extendSubscription
+type extensionResult struct {
+	Email     string // Base email without inbound suffix
+	OldExpiry int64
+	NewExpiry int64
 }
 
-// handleExtensionApproval processes admin approval for subscription extension
-func (b *Bot) handleExtensionApproval(userID int64, adminChatID int64, messageID int, duration int) {
-	// Get user info from Telegram
-	userName, tgUsername := b.getUserInfo(userID)
+// extendSubscription adds duration days to every client of the user and sends them the new subscription details.
+// It is the shared path of admin approvals and paid invoices.
+func (b *Bot) extendSubscription(userID int64, duration int) (*extensionResult, error) {
+	// Find the panel the user's subscription lives on
+	apiClient, _, err := b.findClientByTgID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("client not found")
+	}
 
 	// Get all inbounds
-	inbounds, err := b.apiClient.GetInbounds(context.Background())
+	inbounds, err := apiClient.GetInbounds(context.Background())
 	if err != nil {
-		b.sendMessage(adminChatID, fmt.Sprintf("❌ Ошибка получения списка инбаундов: %v", err))
-		b.logger.Errorf("Failed to get inbounds: %v", err)
-		return
+		return nil, fmt.Errorf("failed to get inbounds: %w", err)
 	}
 
 	// Find first client to get current expiry and calculate new expiry
@@ -559,29 +552,16 @@ func (b *Bot) handleExtensionApproval(userID int64, adminChatID int64, messageID
 	var foundFirstClient bool
 
 	for _, inbound := range inbounds {
-		settingsStr := ""
-		if settings, ok := inbound["settings"].(string); ok {
-			settingsStr = settings
-		}
-
-		clients, err := b.clientService.ParseClients(settingsStr)
+		clients, err := inbound.Clients()
 		if err != nil {
-			continue
+			b.logger.Warnf("Skipping invalid clients of inbound %d: %v", inbound.ID, err)
 		}
 
 		// Find client with matching tgId
-		for _, client := range clients {
-			if client["tgId"] == fmt.Sprintf("%d", userID) {
-				rawJSON := client["_raw_json"]
-				var clientData map[string]interface{}
-				if err := json.Unmarshal([]byte(rawJSON), &clientData); err != nil {
-					continue
-				}
-
-				if et, ok := clientData["expiryTime"].(float64); ok {
-					currentExpiry = int64(et)
-				}
-				cleanEmail = stripInboundSuffix(client["email"])
+		for _, c := range clients {
+			if c.TgID == userID {
+				currentExpiry = c.ExpiryTime
+				cleanEmail = stripInboundSuffix(c.Email)
 				foundFirstClient = true
 				break
 			}
@@ -592,9 +572,7 @@ func (b *Bot) handleExtensionApproval(userID int64, adminChatID int64, messageID
 	}
 
 	if !foundFirstClient {
-		b.sendMessage(adminChatID, "❌ Ошибка: клиент не найден")
-		b.logger.Errorf("Client with tgID %d not found", userID)
-		return
+		return nil, fmt.Errorf("client not found")
 	}
 
 	// Calculate new expiry time: add extension to CURRENT expiry (or to now if expired)
@@ -614,90 +592,65 @@ func (b *Bot) handleExtensionApproval(userID int64, adminChatID int64, messageID
 
 	// Update all clients with this tgId across all inbounds
 	updatedCount := 0
+	var limitIP int
 	for _, inbound := range inbounds {
-		inboundID := int(inbound["id"].(float64))
-		settingsStr := ""
-		if settings, ok := inbound["settings"].(string); ok {
-			settingsStr = settings
-		}
+		inboundID := inbound.ID
 
-		clients, err := b.clientService.ParseClients(settingsStr)
+		clients, err := inbound.Clients()
 		if err != nil {
 			b.logger.Errorf("Failed to parse clients for inbound %d: %v", inboundID, err)
-			continue
 		}
 
 		// Find client with matching tgId
-		for _, client := range clients {
-			if client["tgId"] == fmt.Sprintf("%d", userID) {
-				rawJSON := client["_raw_json"]
-				var clientData map[string]interface{}
-				if err := json.Unmarshal([]byte(rawJSON), &clientData); err != nil {
-					b.logger.Errorf("Failed to parse client JSON: %v", err)
-					continue
-				}
-
+		for _, c := range clients {
+			if c.TgID == userID {
 				// Update expiryTime
-				clientData["expiryTime"] = newExpiry
-
-				// Fix numeric fields for proper type conversion
-				b.clientService.FixNumericFields(clientData)
+				c.ExpiryTime = newExpiry
 
 				// Update client via API
-				emailWithSuffix := client["email"]
-				err = b.apiClient.UpdateClient(context.Background(), inboundID, emailWithSuffix, clientData)
+				emailWithSuffix := c.Email
+				err = apiClient.UpdateClient(context.Background(), inboundID, emailWithSuffix, c.Map())
 				if err != nil {
 					b.logger.Errorf("Failed to update client in inbound %d: %v", inboundID, err)
 				} else {
 					b.logger.Infof("Updated expiry in inbound %d for %s", inboundID, emailWithSuffix)
 					updatedCount++
+					if limitIP == 0 {
+						limitIP = c.LimitIP
+					}
 				}
 			}
 		}
 	}
 
 	if updatedCount == 0 {
-		b.sendMessage(adminChatID, "❌ Ошибка: не удалось обновить ни один инбаунд")
-		return
+		return nil, fmt.Errorf("failed to update any inbound")
 	}
 
 	b.logger.Infof("Successfully extended subscription in %d inbounds", updatedCount)
+	b.syncUserRecord(userID, "")
+	t := b.tr(userID)
 
 	// Get subscription link
-	subLink, err := b.apiClient.GetClientLink(context.Background(), cleanEmail)
+	subLink, err := apiClient.GetClientLink(context.Background(), cleanEmail)
 	if err != nil {
 		b.logger.Warnf("Failed to get subscription link: %v", err)
-		subLink = "Не удалось получить ссылку"
+		subLink = t("subscription.link_unavailable")
 	}
 
 	// Calculate time remaining (days and hours)
 	daysUntilExpiry, hoursUntilExpiry := b.calculateTimeRemaining(newExpiry)
 
-	oldExpiry := time.UnixMilli(currentExpiry).Format("02.01.2006 15:04")
-	newExpiryFormatted := time.UnixMilli(newExpiry).Format("02.01.2006 15:04")
-
 	// Notify user
-
-	// Get client info for device limit
-	clientInfo, err := b.apiClient.GetClientByTgID(context.Background(), userID)
 	limitDevicesText := ""
-	if err == nil {
-		if limitIP, ok := clientInfo["limitIp"].(float64); ok && int(limitIP) > 0 {
-			limitDevicesText = fmt.Sprintf("\n📱 Лимит устройств: %d", int(limitIP))
-		}
+	if limitIP > 0 {
+		limitDevicesText = t("extension.device_limit", limitIP)
 	}
 
-	userMsg := fmt.Sprintf(
-		"✅ <b>Ваша подписка продлена!</b>\n\n"+
-			"👤 Аккаунт: %s\n"+
-			"📅 Продлено на: %d дней\n"+
-			"⏰ Истекает: %s\n"+
-			"📅 Осталось: %d дней %d часов%s\n\n"+
-			"🔗 <b>Ваша VPN конфигурация:</b>\n"+
-			"<blockquote expandable>%s</blockquote>",
+	userMsg := t("extension.extended",
 		html.EscapeString(cleanEmail),
 		duration,
-		newExpiryFormatted,
+		time.UnixMilli(newExpiry).Format("02.01.2006 15:04"),
 		daysUntilExpiry,
 		hoursUntilExpiry,
 		limitDevicesText,
@@ -705,50 +658,100 @@ func (b *Bot) handleExtensionApproval(userID int64, adminChatID int64, messageID
 	)
 	b.sendMessage(userID, userMsg)
 
-	// Update admin message
-	tgUsernameStr := ""
-	if tgUsername != "" {
-		tgUsernameStr = fmt.Sprintf(" (%s)", tgUsername)
+	b.logger.Infof("Subscription extended for user %d, email: %s, added: %d days, expires: %s",
+		userID, cleanEmail, duration, time.UnixMilli(newExpiry).Format("02.01.2006 15:04"))
+
+	return &extensionResult{
+		Email:     cleanEmail,
+		OldExpiry: currentExpiry,
+		NewExpiry: newExpiry,
+	}, nil
+}
+
+// handleExtensionApproval processes admin approval for subscription extension.
+// requestID is 0 for cards sent before requests were stored
+func (b *Bot) handleExtensionApproval(userID int64, adminChatID int64, messageID int, duration int, requestID int64) {
+	if !b.claimExtensionRequest(adminChatID, requestID, storage.ExtensionStatusApproved) {
+		return
 	}
 
-	adminMsg := fmt.Sprintf(
-		"✅ <b>Продление ОДОБРЕНО</b>\n\n"+
-			"👤 Пользователь: %s%s\n"+
-			"👤 Username: %s\n"+
-			"⏰ Было до: %s\n"+
-			"📅 Продлено: +%d дней\n"+
-			"⏰ Теперь до: %s",
-		html.EscapeString(userName),
-		tgUsernameStr,
-		html.EscapeString(cleanEmail),
-		oldExpiry,
-		duration,
-		newExpiryFormatted,
+	// Get user info from Telegram
+	userName, tgUsername := b.getUserInfo(userID)
+
+	// Stored requests carry the promo code bonus days
+	quote := services.Quote{Duration: duration}
+	if requestID != 0 {
+		if req, err := b.storage.GetExtensionRequest(requestID); err == nil {
+			quote = b.extensionQuote(req)
+		}
+	}
+
+	t := b.tr(adminChatID)
+	result, err := b.extendSubscription(userID, quote.TotalDays())
+	if err != nil {
+		b.releaseExtensionRequest(requestID)
+		b.sendMessage(adminChatID, t("extension.error_extend", err))
+		b.logger.Errorf("Failed to extend subscription for user %d: %v", userID, err)
+		return
+	}
+	if quote.Code != "" {
+		b.redeemPromo(userID, storage.PromoPurposeExtension, quote)
+	}
+
+	// Update admin message
+	adminMsg, err := b.renderer(adminChatID)(templates.ExtensionApproved, templates.ExtensionApprovedData{
+		User:      html.EscapeString(userName),
+		Username:  html.EscapeString(tgUsername),
+		Email:     html.EscapeString(result.Email),
+		OldExpiry: time.UnixMilli(result.OldExpiry).Format("02.01.2006 15:04"),
+		Days:      quote.TotalDays(),
+		Promo:     b.promoQuoteLines(t, quote),
+		NewExpiry: time.UnixMilli(result.NewExpiry).Format("02.01.2006 15:04"),
+	})
+	if err != nil {
+		// The subscription is already extended, the card still has to leave the pending state
+		b.logger.Errorf("Failed to render extension approval for user %d: %v", userID, err)
+		adminMsg = t("common.error", html.EscapeString(err.Error()))
+	}
+	b.recordAudit(adminChatID, storage.AuditActionExtend, result.Email, userID,
+		time.UnixMilli(result.OldExpiry).Format("02.01.2006 15:04"),
+		time.UnixMilli(result.NewExpiry).Format("02.01.2006 15:04"),
 	)
-	b.editMessageText(adminChatID, messageID, adminMsg)
+	b.editRequestCard(adminChatID, messageID, requestID, adminMsg)
 
-	b.logger.Infof("Subscription extended for user %d, email: %s, added: %d days, expires: %s",
-		userID, cleanEmail, duration, newExpiryFormatted)
+	b.setUserTrial(userID, false)
+	b.rewardReferrer(userID)
+}
+
+// extensionQuote rebuilds the quote an extension request was priced with
+func (b *Bot) extensionQuote(req *storage.ExtensionRequest) services.Quote {
+	return services.Quote{
+		Duration:  req.Duration,
+		BasePrice: b.cfg().Payment.Prices.ForDuration(req.Duration),
+		Price:     req.Price,
+		BonusDays: req.BonusDays,
+		Code:      req.PromoCode,
+	}
 }
 
 // handleExtensionRejection processes admin rejection for subscription extension
-func (b *Bot) handleExtensionRejection(userID int64, adminChatID int64, messageID int) {
+func (b *Bot) handleExtensionRejection(userID int64, adminChatID int64, messageID int, requestID int64) {
+	if !b.claimExtensionRequest(adminChatID, requestID, storage.ExtensionStatusRejected) {
+		return
+	}
+
 	// Get user info from Telegram
 	userName, tgUsername := b.getUserInfo(userID)
 
 	// Get client info for logging
-	clientInfo, err := b.apiClient.GetClientByTgID(context.Background(), userID)
+	_, clientInfo, err := b.findClientByTgID(userID)
 	email := ""
 	if err == nil {
-		if e, ok := clientInfo["email"].(string); ok {
-			email = e
-		}
+		email = clientInfo.Email
 	}
 
 	// Notify user
-	userMsg := "❌ К сожалению, ваш запрос на продление подписки был отклонен администратором.\n\n" +
-		"Пожалуйста, обратитесь к администратору для уточнения деталей."
-	b.sendMessage(userID, userMsg)
+	b.sendMessage(userID, b.t(userID, "extension.rejected_user"))
 
 	// Update admin message
 	tgUsernameStr := ""
@@ -756,15 +759,13 @@ func (b *Bot) handleExtensionRejection(userID int64, adminChatID int64, messageI
 		tgUsernameStr = fmt.Sprintf(" (%s)", tgUsername)
 	}
 
-	adminMsg := fmt.Sprintf(
-		"❌ <b>Продление ОТКЛОНЕНО</b>\n\n"+
-			"👤 Пользователь: %s%s\n"+
-			"👤 Username: %s",
+	adminMsg := b.t(adminChatID, "extension.rejected",
 		html.EscapeString(userName),
 		tgUsernameStr,
 		html.EscapeString(email),
 	)
-	b.editMessageText(adminChatID, messageID, adminMsg)
+	b.recordAudit(adminChatID, storage.AuditActionRejectExtension, email, userID, "", "")
+	b.editRequestCard(adminChatID, messageID, requestID, adminMsg)
 
 	b.logger.Infof("Extension rejected for user %d, email: %s", userID, email)
 }
@@ -773,89 +774,59 @@ func (b *Bot) handleExtensionRejection(userID int64, adminChatID int64, messageI
 func (b *Bot) handleBackupRequest(chatID int64) {
 	b.logger.Infof("Manual backup requested by admin %d", chatID)
 
-	b.sendMessage(chatID, "⏳ Создаю бэкап базы данных...")
+	t := b.tr(chatID)
+	b.sendMessage(chatID, t("backup.creating"))
 
-	// Download backup from panel
-	backup, err := b.apiClient.GetDatabaseBackup(context.Background())
-	if err != nil {
-		b.logger.Errorf("Failed to download backup: %v", err)
-		b.sendMessage(chatID, fmt.Sprintf("❌ Ошибка создания бэкапа: %v", err))
-		return
-	}
+	for _, apiClient := range b.panels.All() {
+		// Download backup from panel
+		backup, err := apiClient.GetDatabaseBackup(context.Background())
+		if err != nil {
+			b.logger.Errorf("Failed to download backup from panel %s: %v", apiClient.Name(), err)
+			b.sendMessage(chatID, b.panelTitle(apiClient)+t("backup.error_create", err))
+			continue
+		}
 
-	// Send backup to requesting admin
-	filename := fmt.Sprintf("x-ui_%s.db", time.Now().Format("2006-01-02_15-04"))
-	reader := &namedBytesReader{
-		Reader: strings.NewReader(string(backup)),
-		name:   filename,
-	}
+		// Send backup to requesting admin
+		reader := &namedBytesReader{
+			Reader: strings.NewReader(string(backup)),
+			name:   b.backupFilename(apiClient),
+		}
 
-	_, err = b.bot.SendDocument(context.Background(), &telego.SendDocumentParams{
-		ChatID: tu.ID(chatID),
-		Document: telego.InputFile{
-			File: reader,
-		},
-		Caption:   fmt.Sprintf("📦 <b>Database Backup</b>\n\n🕐 Time: %s\n💾 Size: %.2f MB", time.Now().Format("2006-01-02 15:04:05"), float64(len(backup))/1024/1024),
-		ParseMode: "HTML",
-	})
+		_, err = b.bot.SendDocument(context.Background(), &telego.SendDocumentParams{
+			ChatID: tu.ID(chatID),
+			Document: telego.InputFile{
+				File: reader,
+			},
+			Caption:   b.panelTitle(apiClient) + t("backup.caption_size", time.Now().Format("2006-01-02 15:04:05"), float64(len(backup))/1024/1024),
+			ParseMode: "HTML",
+		})
 
-	if err != nil {
-		b.logger.Errorf("Failed to send backup to admin %d: %v", chatID, err)
-		b.sendMessage(chatID, fmt.Sprintf("❌ Ошибка отправки бэкапа: %v", err))
-	} else {
-		b.logger.Infof("Manual backup sent to admin %d", chatID)
+		if err != nil {
+			b.logger.Errorf("Failed to send backup to admin %d: %v", chatID, err)
+			b.sendMessage(chatID, t("backup.error_send", err))
+		} else {
+			b.logger.Infof("Manual backup of panel %s sent to admin %d", apiClient.Name(), chatID)
+		}
 	}
 }
 
 // handleTrafficForecast handles admin request to view traffic forecast - shows inbound selection
 func (b *Bot) handleTrafficForecast(chatID int64) {
-	if b.forecastService == nil {
-		b.sendMessage(chatID, "❌ Forecast service is not initialized")
-		return
-	}
-
-	forecast, err := b.forecastService.CalculateTotalForecast()
-	if err != nil {
-		b.sendMessage(chatID, fmt.Sprintf("❌ Ошибка расчета прогноза: %v", err))
-		return
-	}
-
-	message := "🌐 <b>ОБЩИЙ ПРОГНОЗ ТРАФИКА</b>\n\n" + b.forecastService.FormatForecastMessage(forecast)
-
-	// Build keyboard with inbounds
-	inbounds, err := b.apiClient.GetInbounds(context.Background())
-	var keyboard *telego.InlineKeyboardMarkup
-	if err == nil {
-		var rows [][]telego.InlineKeyboardButton
-		for _, inbound := range inbounds {
-			id := 0
-			if v, ok := inbound["id"].(float64); ok {
-				id = int(v)
-			}
-			remark := fmt.Sprintf("Inbound %d", id)
-			if r, ok := inbound["remark"].(string); ok && r != "" {
-				remark = r
-			}
-
-			btn := tu.InlineKeyboardButton(fmt.Sprintf("📊 %s", remark)).
-				WithCallbackData(fmt.Sprintf("%s%d", constants.CbForecastInboundPrefix, id))
-			rows = append(rows, []telego.InlineKeyboardButton{btn})
-		}
-		// Add refresh button
-		rows = append(rows, []telego.InlineKeyboardButton{
-			tu.InlineKeyboardButton("🔄 Обновить").WithCallbackData(constants.CbForecastTotal),
-		})
-		keyboard = &telego.InlineKeyboardMarkup{InlineKeyboard: rows}
-	}
-
-	b.sendMessageWithInlineKeyboard(chatID, message, keyboard)
+	b.sendForecastMenu(chatID)
 }
 
 // handleUserMediaSend handles sending media from user to admins
 func (b *Bot) handleUserMediaSend(chatID int64, userID int64, message *telego.Message, from *telego.User) {
+	// Media sent after an extension request is the payment receipt
+	if state, _ := b.getUserState(chatID); state == constants.StateAwaitingReceipt {
+		b.handleReceiptUpload(chatID, userID, message)
+		return
+	}
+
+	t := b.tr(userID)
 	state, exists := b.getUserMessageState(chatID)
 	if !exists {
-		b.sendMessage(chatID, "❌ Ошибка: состояние не найдено")
+		b.sendMessage(chatID, t("common.error_no_state"))
 		if err := b.deleteUserState(chatID); err != nil {
 			b.logger.Errorf("Failed to delete user state: %v", err)
 		}
@@ -872,24 +843,18 @@ func (b *Bot) handleUserMediaSend(chatID int64, userID int64, message *telego.Me
 		userName = from.FirstName
 	}
 
-	caption := fmt.Sprintf(
-		"📨 <b>Сообщение от пользователя:</b>\n\n"+
-			"👤 %s %s\n"+
-			"🆔 ID: %d",
-		userName,
-		tgUsername,
-		userID,
-	)
+	// Send media to all admins
+	for _, adminID := range b.cfg().Telegram.AdminIDs {
+		at := b.tr(adminID)
+		caption := at("contact.from_user", userName, tgUsername, userID)
 
-	// Add message text if present
-	if message.Caption != "" {
-		caption += fmt.Sprintf("\n\n💬 <i>%s</i>", html.EscapeString(message.Caption))
-	}
+		// Add message text if present
+		if message.Caption != "" {
+			caption += at("contact.from_user_text", html.EscapeString(message.Caption))
+		}
 
-	kb := kbd.BuildReplyInlineKeyboard(userID)
+		kb := kbd.BuildReplyInlineKeyboard(at, userID)
 
-	// Send media to all admins
-	for _, adminID := range b.config.Telegram.AdminIDs {
 		// Forward or send the media with caption
 		if len(message.Photo) > 0 {
 			// Get the largest photo
@@ -946,7 +911,7 @@ func (b *Bot) handleUserMediaSend(chatID int64, userID int64, message *telego.Me
 		}
 	}
 
-	b.sendMessage(chatID, "✅ Ваше сообщение отправлено администратору")
+	b.sendMessage(chatID, t("contact.sent_to_admin"))
 
 	// Clear state
 	if err := b.deleteUserState(chatID); err != nil {
@@ -0,0 +1,267 @@
+package bot
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"x-ui-bot/internal/bot/constants"
+	"x-ui-bot/internal/i18n"
+	"x-ui-bot/internal/storage"
+
+	"github.com/mymmrac/telego"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+// Audit log handlers: recording admin actions, paging through the log and CSV export
+
+// auditPageSize is the number of entries on one page of /audit
+const auditPageSize = 10
+
+// maxAuditValueLength limits the stored values, messages sent to clients can be long
+const maxAuditValueLength = 500
+
+// recordAudit appends an action to the audit log, failures are only logged so that the action itself is not undone
+func (b *Bot) recordAudit(actorID int64, action string, targetEmail string, targetTgID int64, before, after string) {
+	entry := &storage.AuditEntry{
+		ActorID:     actorID,
+		Action:      action,
+		TargetEmail: stripInboundSuffix(targetEmail),
+		TargetTgID:  targetTgID,
+		Before:      truncateRunes(before, maxAuditValueLength),
+		After:       truncateRunes(after, maxAuditValueLength),
+	}
+	if err := b.storage.AddAuditEntry(entry); err != nil {
+		b.logger.Errorf("Failed to record audit entry %s by %d: %v", action, actorID, err)
+	}
+}
+
+// handleAuditLog shows the first page of the audit log: /audit [action=...] [actor=...] [target=...]
+func (b *Bot) handleAuditLog(chatID int64, args []string) {
+	filter, err := parseAuditFilter(args)
+	if err != nil {
+		b.sendMessage(chatID, b.t(chatID, "audit.invalid", html.EscapeString(err.Error()))+b.t(chatID, "audit.usage"))
+		return
+	}
+
+	b.auditFilters.Store(chatID, filter)
+	text, keyboard := b.buildAuditPage(chatID, filter, 0)
+	if keyboard == nil {
+		b.sendMessage(chatID, text)
+		return
+	}
+	b.sendMessageWithInlineKeyboard(chatID, text, keyboard)
+}
+
+// handleAuditPage shows another page of the audit log with the filter the admin last opened
+func (b *Bot) handleAuditPage(chatID int64, messageID int, page int) {
+	filter := storage.AuditFilter{}
+	if stored, ok := b.auditFilters.Load(chatID); ok {
+		filter = stored.(storage.AuditFilter)
+	}
+
+	text, keyboard := b.buildAuditPage(chatID, filter, page)
+	if keyboard == nil {
+		b.editMessageText(chatID, messageID, text)
+		return
+	}
+	b.editMessage(chatID, messageID, text, keyboard)
+}
+
+// buildAuditPage renders one page of the audit log with navigation and export buttons, the keyboard is nil when there is nothing to show
+func (b *Bot) buildAuditPage(chatID int64, filter storage.AuditFilter, page int) (string, *telego.InlineKeyboardMarkup) {
+	t := b.tr(chatID)
+
+	total, err := b.storage.CountAuditEntries(filter)
+	if err != nil {
+		b.logger.Errorf("Failed to count audit entries: %v", err)
+		return t("audit.error_load"), nil
+	}
+	if total == 0 {
+		return t("audit.empty"), nil
+	}
+
+	pages := (total + auditPageSize - 1) / auditPageSize
+	if page < 0 || page >= pages {
+		page = 0
+	}
+
+	filter.Limit = auditPageSize
+	filter.Offset = page * auditPageSize
+	entries, err := b.storage.GetAuditEntries(filter)
+	if err != nil {
+		b.logger.Errorf("Failed to get audit entries: %v", err)
+		return t("audit.error_load"), nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString(t("audit.title", page+1, pages, total))
+	for _, entry := range entries {
+		sb.WriteString("\n")
+		sb.WriteString(formatAuditEntry(t, entry))
+	}
+
+	var nav []telego.InlineKeyboardButton
+	if page > 0 {
+		nav = append(nav, tu.InlineKeyboardButton(t("button.prev_page")).WithCallbackData(fmt.Sprintf("%s%d", constants.CbAuditPagePrefix, page-1)))
+	}
+	if page < pages-1 {
+		nav = append(nav, tu.InlineKeyboardButton(t("button.next_page")).WithCallbackData(fmt.Sprintf("%s%d", constants.CbAuditPagePrefix, page+1)))
+	}
+
+	var rows [][]telego.InlineKeyboardButton
+	if len(nav) > 0 {
+		rows = append(rows, nav)
+	}
+	rows = append(rows, tu.InlineKeyboardRow(
+		tu.InlineKeyboardButton(t("button.export_csv")).WithCallbackData(constants.CbAuditExport),
+	))
+
+	return sb.String(), tu.InlineKeyboard(rows...)
+}
+
+// handleAuditExport sends the audit entries matching the filter as a CSV file.
+// Without args the filter the admin last opened in /audit is used
+func (b *Bot) handleAuditExport(chatID int64, args []string) {
+	t := b.tr(chatID)
+
+	filter := storage.AuditFilter{}
+	if len(args) > 0 {
+		var err error
+		if filter, err = parseAuditFilter(args); err != nil {
+			b.sendMessage(chatID, t("audit.invalid", html.EscapeString(err.Error()))+t("audit.usage"))
+			return
+		}
+	} else if stored, ok := b.auditFilters.Load(chatID); ok {
+		filter = stored.(storage.AuditFilter)
+	}
+
+	entries, err := b.storage.GetAuditEntries(filter)
+	if err != nil {
+		b.logger.Errorf("Failed to get audit entries for export: %v", err)
+		b.sendMessage(chatID, t("audit.error_load"))
+		return
+	}
+	if len(entries) == 0 {
+		b.sendMessage(chatID, t("audit.empty"))
+		return
+	}
+
+	data, err := auditCSV(entries)
+	if err != nil {
+		b.logger.Errorf("Failed to build audit CSV: %v", err)
+		b.sendMessage(chatID, t("audit.error_export"))
+		return
+	}
+
+	reader := &namedBytesReader{
+		Reader: strings.NewReader(data),
+		name:   fmt.Sprintf("audit_%s.csv", time.Now().Format("2006-01-02_15-04-05")),
+	}
+	if _, err := b.bot.SendDocument(context.Background(), &telego.SendDocumentParams{
+		ChatID:    tu.ID(chatID),
+		Document:  telego.InputFile{File: reader},
+		Caption:   t("audit.export_caption", len(entries)),
+		ParseMode: telego.ModeHTML,
+	}); err != nil {
+		b.logger.Errorf("Failed to send audit export to admin %d: %v", chatID, err)
+		b.sendMessage(chatID, t("audit.error_export"))
+	}
+}
+
+// formatAuditEntry formats an audit entry for admins
+func formatAuditEntry(t i18n.Translator, entry *storage.AuditEntry) string {
+	target := html.EscapeString(entry.TargetEmail)
+	if entry.TargetTgID != 0 {
+		if target != "" {
+			target += " "
+		}
+		target += fmt.Sprintf("(tg %d)", entry.TargetTgID)
+	}
+	if target == "" {
+		target = "—"
+	}
+
+	line := t("audit.entry",
+		entry.ID,
+		entry.CreatedAt.Format("02.01.2006 15:04"),
+		entry.ActorID,
+		entry.Action,
+		target,
+	)
+	if entry.Before != "" || entry.After != "" {
+		line += t("audit.entry_change",
+			html.EscapeString(truncateRunes(entry.Before, 80)),
+			html.EscapeString(truncateRunes(entry.After, 80)),
+		)
+	}
+	return line
+}
+
+// auditCSV writes audit entries as CSV with a header row
+func auditCSV(entries []*storage.AuditEntry) (string, error) {
+	var sb strings.Builder
+	w := csv.NewWriter(&sb)
+
+	if err := w.Write([]string{"id", "time", "actor_id", "action", "target_email", "target_tg_id", "before", "after"}); err != nil {
+		return "", err
+	}
+	for _, e := range entries {
+		if err := w.Write([]string{
+			strconv.FormatInt(e.ID, 10),
+			e.CreatedAt.Format(time.RFC3339),
+			strconv.FormatInt(e.ActorID, 10),
+			e.Action,
+			e.TargetEmail,
+			strconv.FormatInt(e.TargetTgID, 10),
+			e.Before,
+			e.After,
+		}); err != nil {
+			return "", err
+		}
+	}
+
+	w.Flush()
+	return sb.String(), w.Error()
+}
+
+// parseAuditFilter parses the key=value arguments of /audit and /audit_csv
+func parseAuditFilter(args []string) (storage.AuditFilter, error) {
+	var filter storage.AuditFilter
+	for _, arg := range args {
+		key, val, ok := strings.Cut(arg, "=")
+		if !ok || val == "" {
+			return filter, fmt.Errorf("unexpected argument %q", arg)
+		}
+
+		switch strings.ToLower(key) {
+		case "action":
+			filter.Action = strings.ToLower(val)
+		case "actor":
+			id, err := strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				return filter, fmt.Errorf("actor must be a Telegram ID")
+			}
+			filter.ActorID = id
+		case "target":
+			filter.Target = val
+		default:
+			return filter, fmt.Errorf("unknown option %q", key)
+		}
+	}
+	return filter, nil
+}
+
+// truncateRunes shortens s to at most max characters, marking the cut with an ellipsis
+func truncateRunes(s string, max int) string {
+	if utf8.RuneCountInString(s) <= max {
+		return s
+	}
+	runes := []rune(s)
+	return string(runes[:max-1]) + "…"
+}
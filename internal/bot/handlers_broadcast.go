@@ -2,12 +2,20 @@ package bot
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"html"
+	"net/url"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	"x-ui-bot/internal/bot/constants"
+	"x-ui-bot/internal/bot/services"
+	"x-ui-bot/internal/i18n"
+	"x-ui-bot/internal/storage"
 
 	"github.com/mymmrac/telego"
 	tu "github.com/mymmrac/telego/telegoutil"
@@ -18,188 +26,564 @@ import (
 // handleBroadcastStart initiates broadcast message creation
 func (b *Bot) handleBroadcastStart(chatID int64) {
 	b.logger.Infof("Admin %d started broadcast creation", chatID)
+	t := b.tr(chatID)
 
 	if err := b.setUserState(chatID, "awaiting_broadcast_message"); err != nil {
-		b.sendMessage(chatID, "❌ Ошибка сохранения состояния")
+		b.sendMessage(chatID, t("common.error_state"))
 		return
 	}
 	if err := b.setBroadcastState(chatID, &BroadcastState{
 		Timestamp: time.Now(),
 	}); err != nil {
-		b.sendMessage(chatID, "❌ Ошибка сохранения состояния")
+		b.sendMessage(chatID, t("common.error_state"))
 		return
 	}
 
-	msg := "📢 <b>Создание объявления</b>\n\n" +
-		"Отправьте текст объявления, которое будет разослано всем зарегистрированным пользователям.\n\n" +
-		"<i>Можно использовать HTML форматирование: &lt;b&gt;жирный&lt;/b&gt;, &lt;i&gt;курсив&lt;/i&gt;</i>"
+	b.sendMessage(chatID, t("broadcast.prompt"))
+}
+
+// Broadcast composer limits
+const (
+	// broadcastCaptionLimit is the caption length Telegram accepts under media
+	broadcastCaptionLimit = 1024
+	// broadcastButtonsLimit is the number of URL buttons a broadcast may have
+	broadcastButtonsLimit = 10
+	// broadcastAlbumWait is how long the parts of an album are collected, Telegram delivers them as separate messages
+	broadcastAlbumWait = 1500 * time.Millisecond
+	// sendAtLayout is how send times are entered and shown, in the time zone of the bot
+	sendAtLayout = "2006-01-02 15:04"
+)
 
-	b.sendMessage(chatID, msg)
+// broadcastAlbum collects the parts of an album an admin sends as a broadcast
+type broadcastAlbum struct {
+	mu      sync.Mutex
+	groupID string
+	parts   map[int]storage.BroadcastMedia // Message ID -> media, the order they were sent in
+	caption string
+	timer   *time.Timer
 }
 
-// handleBroadcastMessage handles broadcast message text input
-func (b *Bot) handleBroadcastMessage(chatID int64, message string) {
+// handleBroadcastMessage takes the content of a broadcast: a text, a photo, video or document with
+// its caption, or the parts of an album. The media, buttons, pin and send time are kept in the draft
+func (b *Bot) handleBroadcastMessage(chatID int64, message *telego.Message) {
 	state, exists := b.getBroadcastState(chatID)
 	if !exists {
 		return
 	}
 
-	state.Message = message
+	media, ok := broadcastMediaOf(message)
+	if !ok {
+		b.sendMessage(chatID, b.t(chatID, "broadcast.error_media"))
+		return
+	}
+	if message.MediaGroupID != "" {
+		b.collectBroadcastAlbum(chatID, message, *media)
+		return
+	}
 
-	// Show confirmation with preview
-	msg := fmt.Sprintf(
-		"📢 <b>Подтверждение рассылки</b>\n\n"+
-			"<b>Предпросмотр:</b>\n"+
-			"──────────────\n"+
-			"%s\n"+
-			"──────────────\n\n"+
-			"Разослать это объявление всем пользователям?",
-		message,
-	)
+	content := state.BroadcastContent
+	content.Message, content.Media = message.Text, nil
+	if media != nil {
+		content.Message, content.Media = message.Caption, []storage.BroadcastMedia{*media}
+	}
+	b.updateBroadcastDraft(chatID, state, content)
+}
+
+// broadcastMediaOf returns the media of a message, nil for a text, false for media broadcasts cannot carry
+func broadcastMediaOf(message *telego.Message) (*storage.BroadcastMedia, bool) {
+	switch {
+	case len(message.Photo) > 0:
+		// The largest size
+		return &storage.BroadcastMedia{Type: storage.BroadcastMediaPhoto, FileID: message.Photo[len(message.Photo)-1].FileID}, true
+	case message.Video != nil:
+		return &storage.BroadcastMedia{Type: storage.BroadcastMediaVideo, FileID: message.Video.FileID}, true
+	case message.Document != nil:
+		return &storage.BroadcastMedia{Type: storage.BroadcastMediaDocument, FileID: message.Document.FileID}, true
+	case message.Text != "":
+		return nil, true
+	}
+	return nil, false
+}
+
+// collectBroadcastAlbum adds a part of an album to the draft once no more parts arrive
+func (b *Bot) collectBroadcastAlbum(chatID int64, message *telego.Message, media storage.BroadcastMedia) {
+	value, _ := b.broadcastAlbums.LoadOrStore(chatID, &broadcastAlbum{groupID: message.MediaGroupID})
+	album := value.(*broadcastAlbum)
+
+	album.mu.Lock()
+	defer album.mu.Unlock()
+	if album.groupID != message.MediaGroupID {
+		// A new album replaces one still being collected
+		album.groupID, album.parts, album.caption = message.MediaGroupID, nil, ""
+	}
+	if album.parts == nil {
+		album.parts = make(map[int]storage.BroadcastMedia)
+	}
+	album.parts[message.MessageID] = media
+	if message.Caption != "" {
+		album.caption = message.Caption
+	}
 
+	if album.timer != nil {
+		album.timer.Stop()
+	}
+	album.timer = time.AfterFunc(broadcastAlbumWait, func() {
+		b.finishBroadcastAlbum(chatID, album)
+	})
+}
+
+// finishBroadcastAlbum puts a collected album into the draft
+func (b *Bot) finishBroadcastAlbum(chatID int64, album *broadcastAlbum) {
+	album.mu.Lock()
+	b.broadcastAlbums.CompareAndDelete(chatID, album)
+	ids := make([]int, 0, len(album.parts))
+	for id := range album.parts {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	media := make([]storage.BroadcastMedia, 0, len(ids))
+	for _, id := range ids {
+		media = append(media, album.parts[id])
+	}
+	caption := album.caption
+	album.mu.Unlock()
+
+	state, exists := b.getBroadcastState(chatID)
+	if !exists {
+		return
+	}
+	content := state.BroadcastContent
+	content.Message, content.Media = caption, media
+	b.updateBroadcastDraft(chatID, state, content)
+}
+
+// updateBroadcastDraft saves new content into the draft and shows the composer
+func (b *Bot) updateBroadcastDraft(chatID int64, state *BroadcastState, content storage.BroadcastContent) {
+	t := b.tr(chatID)
+	if !b.captionFits(content) {
+		b.sendMessage(chatID, t("broadcast.error_caption", broadcastCaptionLimit))
+		return
+	}
+
+	state.BroadcastContent = content
+	state.Timestamp = time.Now()
+	if err := b.setBroadcastState(chatID, state); err != nil {
+		b.sendMessage(chatID, t("common.error_state"))
+		return
+	}
+	if err := b.setUserState(chatID, constants.StateAwaitingBroadcastMessage); err != nil {
+		b.logger.Errorf("Failed to set user state: %v", err)
+	}
+
+	b.showBroadcastComposer(chatID, state)
+}
+
+// captionFits reports whether the text of a media broadcast fits in a caption. The caption
+// carries the announcement header, which differs between languages
+func (b *Bot) captionFits(content storage.BroadcastContent) bool {
+	if len(content.Media) == 0 {
+		return true
+	}
+	for _, lang := range b.i18n.Languages() {
+		if utf8.RuneCountInString(b.i18n.T(lang, "broadcast.announcement", content.Message)) > broadcastCaptionLimit {
+			return false
+		}
+	}
+	return true
+}
+
+// showBroadcastComposer shows the draft with the buttons to add links, schedule, repeat, pin, preview and
+// choose the recipients. Sending another message replaces the text and media of the draft
+func (b *Bot) showBroadcastComposer(chatID int64, state *BroadcastState, messageID ...int) {
+	t := b.tr(chatID)
+	msg := t("broadcast.composer", b.broadcastSummary(t, state.BroadcastContent, state.Cron))
+
+	pin := t("button.broadcast_pin_off")
+	if state.Pin {
+		pin = t("button.broadcast_pin_on")
+	}
 	keyboard := tu.InlineKeyboard(
 		tu.InlineKeyboardRow(
-			tu.InlineKeyboardButton("✅ Отправить").WithCallbackData(constants.CbBroadcastConfirm),
-			tu.InlineKeyboardButton("❌ Отменить").WithCallbackData(constants.CbBroadcastCancel),
+			tu.InlineKeyboardButton(t("button.broadcast_buttons")).WithCallbackData(constants.CbBroadcastButtons),
+			tu.InlineKeyboardButton(t("button.broadcast_schedule")).WithCallbackData(constants.CbBroadcastSchedule),
+		),
+		tu.InlineKeyboardRow(
+			tu.InlineKeyboardButton(t("button.broadcast_repeat")).WithCallbackData(constants.CbBroadcastRepeat),
+			tu.InlineKeyboardButton(pin).WithCallbackData(constants.CbBroadcastPin),
+		),
+		tu.InlineKeyboardRow(
+			tu.InlineKeyboardButton(t("button.broadcast_preview")).WithCallbackData(constants.CbBroadcastPreview),
+			tu.InlineKeyboardButton(t("button.broadcast_next")).WithCallbackData(constants.CbBroadcastNext),
+		),
+		tu.InlineKeyboardRow(
+			tu.InlineKeyboardButton(t("button.cancel")).WithCallbackData(constants.CbBroadcastCancel),
 		),
 	)
 
+	if len(messageID) > 0 {
+		b.editMessage(chatID, messageID[0], msg, keyboard)
+		return
+	}
 	b.sendMessageWithInlineKeyboard(chatID, msg, keyboard)
 }
 
-// handleBroadcastConfirm sends broadcast to all users
-func (b *Bot) handleBroadcastConfirm(chatID int64, messageID int) {
+// broadcastSummary describes a draft or schedule: what it sends, its buttons, when it is sent, how it
+// repeats and whether it is pinned. A recurring draft without a send time starts when its cron spec fires
+func (b *Bot) broadcastSummary(t i18n.Translator, content storage.BroadcastContent, cron string) string {
+	kind := t("broadcast.kind_text")
+	switch {
+	case len(content.Media) > 1:
+		kind = t("broadcast.kind_album", len(content.Media))
+	case len(content.Media) == 1:
+		kind = t("broadcast.kind_" + content.Media[0].Type)
+	}
+
+	summary := t("broadcast.summary_kind", kind)
+	if len(content.Buttons) > 0 {
+		summary += t("broadcast.summary_buttons", len(content.Buttons))
+	}
+	sendAt := content.SendAt
+	if sendAt.IsZero() && cron != "" {
+		sendAt, _ = services.NextRun(cron, time.Now())
+	}
+	if sendAt.IsZero() {
+		summary += t("broadcast.summary_send_now")
+	} else {
+		summary += t("broadcast.summary_send_at", sendAt.Format(sendAtLayout))
+	}
+	if cron != "" {
+		summary += t("broadcast.summary_repeat", html.EscapeString(cron))
+	}
+	if content.Pin {
+		summary += t("broadcast.summary_pin")
+	}
+	return summary
+}
+
+// handleBroadcastComposer handles the composer buttons and returns the text of the callback answer
+func (b *Bot) handleBroadcastComposer(chatID int64, messageID int, data string) string {
+	t := b.tr(chatID)
 	state, exists := b.getBroadcastState(chatID)
-	if !exists {
-		if err := b.bot.AnswerCallbackQuery(context.Background(), &telego.AnswerCallbackQueryParams{
-			CallbackQueryID: fmt.Sprintf("%d", messageID),
-			Text:            "Ошибка: состояние рассылки не найдено",
-			ShowAlert:       true,
-		}); err != nil {
-			b.logger.Errorf("Failed to answer callback query: %v", err)
+	if !exists || (state.Message == "" && len(state.Media) == 0) {
+		return t("broadcast.error_no_state")
+	}
+
+	switch data {
+	case constants.CbBroadcastButtons:
+		if err := b.setUserState(chatID, constants.StateAwaitingBroadcastButtons); err != nil {
+			return t("common.error_state")
+		}
+		b.sendMessage(chatID, t("broadcast.buttons_prompt", broadcastButtonsLimit))
+
+	case constants.CbBroadcastSchedule:
+		if err := b.setUserState(chatID, constants.StateAwaitingBroadcastSendAt); err != nil {
+			return t("common.error_state")
+		}
+		b.sendMessage(chatID, sendAtPrompt(t))
+
+	case constants.CbBroadcastRepeat:
+		if err := b.setUserState(chatID, constants.StateAwaitingBroadcastCron); err != nil {
+			return t("common.error_state")
 		}
+		b.sendMessage(chatID, t("broadcast.cron_prompt", time.Now().Format(sendAtLayout)))
+
+	case constants.CbBroadcastPin:
+		state.Pin = !state.Pin
+		if err := b.setBroadcastState(chatID, state); err != nil {
+			return t("common.error_state")
+		}
+		b.showBroadcastComposer(chatID, state, messageID)
+
+	case constants.CbBroadcastPreview:
+		if err := b.broadcastService.Preview(context.Background(), chatID, state.BroadcastContent); err != nil {
+			b.logger.Warnf("Failed to send broadcast preview to admin %d: %v", chatID, err)
+			b.sendMessage(chatID, t("broadcast.error_preview", html.EscapeString(err.Error())))
+		}
+
+	case constants.CbBroadcastNext:
+		// The admin sees the announcement as recipients will before choosing them
+		if err := b.broadcastService.Preview(context.Background(), chatID, state.BroadcastContent); err != nil {
+			b.logger.Warnf("Failed to send broadcast preview to admin %d: %v", chatID, err)
+			b.sendMessage(chatID, t("broadcast.error_preview", html.EscapeString(err.Error())))
+			return ""
+		}
+		b.editMessageText(chatID, messageID, t("broadcast.composer", b.broadcastSummary(t, state.BroadcastContent, state.Cron)))
+		b.sendMessageWithInlineKeyboard(chatID, t("broadcast.audience_prompt"), broadcastAudienceKeyboard(t))
+	}
+	return ""
+}
+
+// handleBroadcastButtonsInput sets the URL buttons of the draft, one "Text | URL" per line or "-" for none
+func (b *Bot) handleBroadcastButtonsInput(chatID int64, input string) {
+	t := b.tr(chatID)
+	state, exists := b.getBroadcastState(chatID)
+	if !exists {
+		b.sendMessage(chatID, t("broadcast.error_no_state"))
 		return
 	}
 
-	// Update message to show it's processing
-	b.editMessageText(chatID, messageID, "⏳ Отправка объявления...")
+	var buttons []storage.BroadcastButton
+	if strings.TrimSpace(input) != "-" {
+		var line int
+		if buttons, line = parseBroadcastButtons(input); line > 0 {
+			b.sendMessage(chatID, t("broadcast.error_buttons", line))
+			return
+		}
+		if len(buttons) > broadcastButtonsLimit {
+			b.sendMessage(chatID, t("broadcast.error_buttons_limit", broadcastButtonsLimit))
+			return
+		}
+	}
 
-	// Get all registered users
-	inbounds, err := b.apiClient.GetInbounds(context.Background())
-	if err != nil {
-		b.logger.Errorf("Failed to get inbounds for broadcast: %v", err)
-		b.editMessageText(chatID, messageID, "❌ Ошибка при получении списка пользователей")
-		if err := b.deleteBroadcastState(chatID); err != nil {
-			b.logger.Errorf("Failed to delete broadcast state: %v", err)
+	content := state.BroadcastContent
+	content.Buttons = buttons
+	b.updateBroadcastDraft(chatID, state, content)
+}
+
+// parseBroadcastButtons reads one "Text | URL" button per line. The number of the first
+// invalid line is returned with them, 0 when all are valid
+func parseBroadcastButtons(input string) ([]storage.BroadcastButton, int) {
+	var buttons []storage.BroadcastButton
+	for i, line := range strings.Split(input, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
 		}
-		if err := b.deleteUserState(chatID); err != nil {
-			b.logger.Errorf("Failed to delete user state: %v", err)
+		text, link, found := strings.Cut(line, "|")
+		text, link = strings.TrimSpace(text), strings.TrimSpace(link)
+		if !found || text == "" {
+			return nil, i + 1
+		}
+		u, err := url.Parse(link)
+		if err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http" && u.Scheme != "tg") {
+			return nil, i + 1
 		}
+		buttons = append(buttons, storage.BroadcastButton{Text: text, URL: link})
+	}
+	if len(buttons) == 0 {
+		return nil, 1
+	}
+	return buttons, 0
+}
+
+// handleBroadcastSendAtInput sets when the draft is sent: a time in sendAtLayout, a delay such as 2h, or "-" for right away
+func (b *Bot) handleBroadcastSendAtInput(chatID int64, input string) {
+	t := b.tr(chatID)
+	state, exists := b.getBroadcastState(chatID)
+	if !exists {
+		b.sendMessage(chatID, t("broadcast.error_no_state"))
 		return
-	} // Collect unique Telegram IDs
-	userIDs := make(map[int64]bool)
-	for _, inbound := range inbounds {
-		settings, ok := inbound["settings"].(string)
-		if !ok {
-			continue
+	}
+
+	var sendAt time.Time
+	if strings.TrimSpace(input) != "-" {
+		var errText string
+		if sendAt, errText = parseSendAt(t, input, time.Now()); errText != "" {
+			b.sendMessage(chatID, errText)
+			return
 		}
+	}
 
-		var settingsData map[string]interface{}
-		if err := json.Unmarshal([]byte(settings), &settingsData); err != nil {
-			continue
+	content := state.BroadcastContent
+	content.SendAt = sendAt
+	b.updateBroadcastDraft(chatID, state, content)
+}
+
+// sendAtPrompt asks for a send time, with the current server time and an example
+func sendAtPrompt(t i18n.Translator) string {
+	now := time.Now()
+	return t("broadcast.send_at_prompt", now.Format(sendAtLayout), now.Add(24*time.Hour).Format(sendAtLayout))
+}
+
+// parseSendAt reads a send time in sendAtLayout or a delay such as 2h. The text of the error
+// to show the admin is returned for an unreadable or past time
+func parseSendAt(t i18n.Translator, input string, now time.Time) (time.Time, string) {
+	input = strings.TrimSpace(input)
+	var sendAt time.Time
+	if delay, err := time.ParseDuration(input); err == nil {
+		sendAt = now.Add(delay)
+	} else if at, err := time.ParseInLocation(sendAtLayout, input, time.Local); err == nil {
+		sendAt = at
+	} else {
+		return time.Time{}, t("broadcast.error_send_at", now.Format(sendAtLayout))
+	}
+	if !sendAt.After(now) {
+		return time.Time{}, t("broadcast.error_send_at_past")
+	}
+	return sendAt, ""
+}
+
+// handleBroadcastCronInput makes the draft repeat on a cron spec, or "-" to send it once
+func (b *Bot) handleBroadcastCronInput(chatID int64, input string) {
+	t := b.tr(chatID)
+	state, exists := b.getBroadcastState(chatID)
+	if !exists {
+		b.sendMessage(chatID, t("broadcast.error_no_state"))
+		return
+	}
+
+	spec := ""
+	if strings.TrimSpace(input) != "-" {
+		var errText string
+		if spec, errText = parseCronInput(t, input); errText != "" {
+			b.sendMessage(chatID, errText)
+			return
 		}
+	}
 
-		clients, ok := settingsData["clients"].([]interface{})
-		if !ok {
-			continue
+	state.Cron = spec
+	b.updateBroadcastDraft(chatID, state, state.BroadcastContent)
+}
+
+// parseCronInput checks a cron spec fires at all. The text of the error to show the admin is returned for one that does not
+func parseCronInput(t i18n.Translator, input string) (string, string) {
+	spec := strings.Join(strings.Fields(input), " ")
+	if _, err := services.NextRun(spec, time.Now()); err != nil {
+		return "", t("broadcast.error_cron", html.EscapeString(err.Error()))
+	}
+	return spec, ""
+}
+
+// handleBroadcastAudience handles the audience picker: the picker itself, the inbound list,
+// or the preview of a chosen audience with its recipient count
+func (b *Bot) handleBroadcastAudience(chatID int64, messageID int, code string) {
+	t := b.tr(chatID)
+	state, exists := b.getBroadcastState(chatID)
+	if !exists {
+		b.editMessageText(chatID, messageID, t("broadcast.error_no_state"))
+		return
+	}
+
+	switch code {
+	case audiencePick:
+		b.editMessage(chatID, messageID, t("broadcast.audience_prompt"), broadcastAudienceKeyboard(t))
+		return
+	case audienceInbounds:
+		b.showBroadcastInbounds(chatID, messageID)
+		return
+	}
+
+	aud, ok := parseAudience(code)
+	if !ok {
+		b.logger.Warnf("Unknown broadcast audience %q from admin %d", code, chatID)
+		b.editMessage(chatID, messageID, t("broadcast.audience_prompt"), broadcastAudienceKeyboard(t))
+		return
+	}
+
+	recipients, err := b.audienceRecipients(aud)
+	if err != nil {
+		b.logger.Errorf("Failed to get recipients of broadcast audience %s: %v", aud.code(), err)
+		b.editMessageText(chatID, messageID, t("broadcast.error_users"))
+		return
+	}
+
+	msg := t("broadcast.confirm", b.broadcastSummary(t, state.BroadcastContent, state.Cron), b.audienceLabel(t, aud), len(recipients))
+	var rows [][]telego.InlineKeyboardButton
+	if len(recipients) > 0 {
+		send := t("button.send")
+		if isScheduled(state) {
+			send = t("button.broadcast_schedule_confirm")
 		}
+		rows = append(rows, tu.InlineKeyboardRow(
+			tu.InlineKeyboardButton(send).WithCallbackData(constants.CbBroadcastSendPrefix+aud.code()),
+		))
+	} else {
+		msg += t("broadcast.audience_empty")
+	}
+	rows = append(rows, tu.InlineKeyboardRow(
+		tu.InlineKeyboardButton(t("button.audience_change")).WithCallbackData(constants.CbBroadcastAudiencePrefix+audiencePick),
+		tu.InlineKeyboardButton(t("button.cancel")).WithCallbackData(constants.CbBroadcastCancel),
+	))
 
-		for _, clientInterface := range clients {
-			client, ok := clientInterface.(map[string]interface{})
-			if !ok {
-				continue
-			}
+	b.editMessage(chatID, messageID, msg, tu.InlineKeyboard(rows...))
+}
+
+// showBroadcastInbounds lists the inbounds of every panel as audiences
+func (b *Bot) showBroadcastInbounds(chatID int64, messageID int) {
+	t := b.tr(chatID)
 
-			if tgID, ok := client["tgId"].(float64); ok && tgID > 0 {
-				userIDs[int64(tgID)] = true
+	var rows [][]telego.InlineKeyboardButton
+	for i, apiClient := range b.panels.All() {
+		inbounds, err := apiClient.GetInbounds(context.Background())
+		if err != nil {
+			b.logger.Errorf("Failed to get inbounds of panel %s for broadcast: %v", apiClient.Name(), err)
+			b.editMessageText(chatID, messageID, t("common.error_inbounds"))
+			return
+		}
+		for _, inbound := range inbounds {
+			label := fmt.Sprintf("🌐 #%d %s", inbound.ID, inbound.Remark)
+			if b.hasMultiplePanels() {
+				label = fmt.Sprintf("🖥 %s · #%d %s", apiClient.Name(), inbound.ID, inbound.Remark)
 			}
+			aud := broadcastAudience{Segment: audienceInbound, PanelIndex: i, InboundID: inbound.ID}
+			rows = append(rows, tu.InlineKeyboardRow(
+				tu.InlineKeyboardButton(strings.TrimSpace(label)).WithCallbackData(constants.CbBroadcastAudiencePrefix+aud.code()),
+			))
 		}
 	}
+	rows = append(rows, tu.InlineKeyboardRow(
+		tu.InlineKeyboardButton(t("button.back")).WithCallbackData(constants.CbBroadcastAudiencePrefix+audiencePick),
+	))
 
-	// Send broadcast in a cancellable goroutine to avoid blocking and allow cancellation
-	ctx, cancel := context.WithCancel(context.Background())
-	b.broadcastMutex.Lock()
-	if b.broadcastCancel != nil {
-		// There's an existing broadcast; cancel it first
-		b.broadcastCancel()
+	b.editMessage(chatID, messageID, t("broadcast.audience_inbound_prompt"), tu.InlineKeyboard(rows...))
+}
+
+// isScheduled reports whether a draft is sent later or repeatedly rather than right away
+func isScheduled(state *BroadcastState) bool {
+	return !state.SendAt.IsZero() || state.Cron != ""
+}
+
+// handleBroadcastConfirm queues the broadcast for the users of the audience, or schedules it when it
+// has a send time or repeats. The text of the callback answer is returned
+func (b *Bot) handleBroadcastConfirm(chatID int64, messageID int, aud broadcastAudience) string {
+	t := b.tr(chatID)
+	state, exists := b.getBroadcastState(chatID)
+	if !exists {
+		b.editMessageText(chatID, messageID, t("broadcast.error_no_state"))
+		return ""
+	}
+	if isScheduled(state) {
+		return b.scheduleBroadcast(chatID, messageID, state, aud)
 	}
-	b.broadcastCancel = cancel
-	b.broadcastMutex.Unlock()
 
-	// Start goroutine to perform send loop
-	b.wg.Add(1)
-	go func(ctx context.Context) {
-		defer b.wg.Done()
-		defer func() {
-			if r := recover(); r != nil {
-				b.logger.Errorf("Panic in broadcast goroutine: %v", r)
-			}
-		}()
-		successCount := 0
-		failCount := 0
-		broadcastMsg := fmt.Sprintf("📢 <b>Объявление</b>\n\n%s", state.Message)
-
-	BCAST_LOOP:
-		for userID := range userIDs {
-			select {
-			case <-ctx.Done():
-				b.logger.Infof("Broadcast cancelled by admin %d", chatID)
-				break BCAST_LOOP
-			default:
-			}
+	// Update message to show it's processing
+	b.editMessageText(chatID, messageID, t("broadcast.in_progress"))
 
-			// Try to send message
-			_, err := b.bot.SendMessage(ctx, &telego.SendMessageParams{
-				ChatID:    tu.ID(userID),
-				Text:      broadcastMsg,
-				ParseMode: telego.ModeHTML,
-			})
-			if err != nil {
-				b.logger.Warnf("Failed to send broadcast to user %d: %v", userID, err)
-				failCount++
-			} else {
-				successCount++
-			}
-			time.Sleep(50 * time.Millisecond) // Rate limiting
-		}
-
-		// Update admin with results
-		resultMsg := fmt.Sprintf(
-			"✅ <b>Рассылка завершена</b>\n\n"+
-				"📊 Отправлено: %d\n"+
-				"❌ Ошибок: %d\n"+
-				"👥 Всего пользователей: %d",
-			successCount,
-			failCount,
-			len(userIDs),
-		)
-		b.editMessageText(chatID, messageID, resultMsg)
-
-		// Clean up state
+	// Recipients are collected again, the panels may have changed since the preview
+	recipients, err := b.audienceRecipients(aud)
+	if err != nil {
+		b.logger.Errorf("Failed to get recipients for broadcast: %v", err)
+		b.editMessageText(chatID, messageID, t("broadcast.error_users"))
 		if err := b.deleteBroadcastState(chatID); err != nil {
 			b.logger.Errorf("Failed to delete broadcast state: %v", err)
 		}
 		if err := b.deleteUserState(chatID); err != nil {
 			b.logger.Errorf("Failed to delete user state: %v", err)
 		}
-		b.logger.Infof("Broadcast completed by admin %d: %d sent, %d failed", chatID, successCount, failCount)
+		return ""
+	}
 
-		// Reset cancellation function
-		b.broadcastMutex.Lock()
-		b.broadcastCancel = nil
-		b.broadcastMutex.Unlock()
-	}(ctx)
-	// The results and cleanup are handled asynchronously inside the goroutine above.
+	// The job is kept in storage and sent by the broadcast worker, this message shows its progress
+	job := &storage.BroadcastJob{
+		CreatedBy:        chatID,
+		BroadcastContent: state.BroadcastContent,
+		ChatID:           chatID,
+		MessageID:        messageID,
+	}
+	if err := b.broadcastService.Enqueue(job, recipients); err != nil {
+		b.logger.Errorf("Failed to queue broadcast: %v", err)
+		b.editMessageText(chatID, messageID, t("broadcast.error_queue"))
+		return ""
+	}
+	b.logger.Infof("Broadcast job %d targets audience %s", job.ID, aud.code())
+
+	if err := b.deleteBroadcastState(chatID); err != nil {
+		b.logger.Errorf("Failed to delete broadcast state: %v", err)
+	}
+	if err := b.deleteUserState(chatID); err != nil {
+		b.logger.Errorf("Failed to delete user state: %v", err)
+	}
+	b.showBroadcastProgress(job)
+	return t("broadcast.sending")
 }
 
 // handleBroadcastCancel cancels broadcast creation
@@ -211,16 +595,312 @@ func (b *Bot) handleBroadcastCancel(chatID int64, messageID int) {
 		b.logger.Errorf("Failed to delete user state: %v", err)
 	}
 
-	// Cancel active broadcast if any
-	b.broadcastMutex.Lock()
-	if b.broadcastCancel != nil {
-		b.broadcastCancel()
-		b.broadcastCancel = nil
+	b.editMessageText(chatID, messageID, b.t(chatID, "broadcast.cancelled"))
+	b.logger.Infof("Broadcast cancelled by admin %d", chatID)
+}
+
+// showBroadcastProgress edits the progress message of a job to its current counts and the buttons its status allows
+func (b *Bot) showBroadcastProgress(job *storage.BroadcastJob) {
+	if job.MessageID == 0 {
+		return
+	}
+
+	progress, err := b.storage.GetBroadcastProgress(job.ID)
+	if err != nil {
+		b.logger.Errorf("Failed to get progress of broadcast job %d: %v", job.ID, err)
+		return
 	}
-	b.broadcastMutex.Unlock()
 
-	b.editMessageText(chatID, messageID, "❌ Рассылка отменена")
-	b.logger.Infof("Broadcast cancelled by admin %d", chatID)
+	t := b.tr(job.ChatID)
+	total := progress.Pending + progress.Sent + progress.Failed
+	text := t("broadcast.progress", job.ID, t("broadcast.status_"+job.Status),
+		progress.Sent, progress.Failed, progress.Pending, total)
+	if job.Status == storage.BroadcastStatusQueued && job.SendAt.After(time.Now()) {
+		text += t("broadcast.progress_scheduled", job.SendAt.Format(sendAtLayout))
+	}
+
+	var rows [][]telego.InlineKeyboardButton
+	switch job.Status {
+	case storage.BroadcastStatusQueued, storage.BroadcastStatusRunning:
+		rows = append(rows, tu.InlineKeyboardRow(
+			tu.InlineKeyboardButton(t("button.broadcast_pause")).WithCallbackData(fmt.Sprintf("%s%d", constants.CbBroadcastPausePrefix, job.ID)),
+			tu.InlineKeyboardButton(t("button.broadcast_stop")).WithCallbackData(fmt.Sprintf("%s%d", constants.CbBroadcastStopPrefix, job.ID)),
+		))
+	case storage.BroadcastStatusPaused:
+		rows = append(rows, tu.InlineKeyboardRow(
+			tu.InlineKeyboardButton(t("button.broadcast_resume")).WithCallbackData(fmt.Sprintf("%s%d", constants.CbBroadcastResumePrefix, job.ID)),
+			tu.InlineKeyboardButton(t("button.broadcast_stop")).WithCallbackData(fmt.Sprintf("%s%d", constants.CbBroadcastStopPrefix, job.ID)),
+		))
+	case storage.BroadcastStatusDone:
+		if progress.Failed > 0 {
+			rows = append(rows, tu.InlineKeyboardRow(
+				tu.InlineKeyboardButton(t("button.broadcast_retry", progress.Failed)).WithCallbackData(fmt.Sprintf("%s%d", constants.CbBroadcastRetryPrefix, job.ID)),
+			))
+		}
+	}
+
+	if len(rows) == 0 {
+		b.editMessageText(job.ChatID, job.MessageID, text)
+		return
+	}
+	b.editMessage(job.ChatID, job.MessageID, text, tu.InlineKeyboard(rows...))
+}
+
+// handleBroadcastControl pauses, resumes, cancels or retries a broadcast job from its progress message
+// and returns the text of the callback answer
+func (b *Bot) handleBroadcastControl(chatID int64, prefix string, jobID int64) string {
+	t := b.tr(chatID)
+
+	var job *storage.BroadcastJob
+	var err error
+	answer := ""
+	switch prefix {
+	case constants.CbBroadcastPausePrefix:
+		job, err = b.broadcastService.Pause(jobID)
+		answer = t("broadcast.paused_short")
+	case constants.CbBroadcastResumePrefix:
+		job, err = b.broadcastService.Resume(jobID)
+		answer = t("broadcast.resumed_short")
+	case constants.CbBroadcastStopPrefix:
+		job, err = b.broadcastService.Cancel(jobID)
+		answer = t("broadcast.cancelled_short")
+	case constants.CbBroadcastRetryPrefix:
+		var n int
+		job, n, err = b.broadcastService.RetryFailed(jobID)
+		answer = t("broadcast.retry_short", n)
+		if err == nil && n == 0 {
+			answer = t("broadcast.retry_none")
+		}
+	}
+
+	if job == nil {
+		b.logger.Errorf("Failed to change broadcast job %d: %v", jobID, err)
+		return t("common.error", err)
+	}
+	// A stale button shows the status the job reached meanwhile
+	b.showBroadcastProgress(job)
+	if err != nil {
+		b.logger.Warnf("Broadcast job %d not changed by admin %d: %v", jobID, chatID, err)
+		return t("broadcast.not_changed", t("broadcast.status_"+job.Status))
+	}
+
+	b.logger.Infof("Admin %d changed broadcast job %d: %s", chatID, jobID, job.Status)
+	return answer
+}
+
+// Broadcast audiences, a user matches when any of their subscriptions on any panel does
+const (
+	audienceAll      = "all"
+	audienceActive   = "active"   // Enabled and not expired
+	audienceExpired  = "expired"  // Expiry date passed
+	audienceExpiring = "expiring" // Active and ending within broadcastAudience.Days
+	audienceBlocked  = "blocked"  // Disabled on the panel
+	audienceTrial    = "trial"    // Registered with the trial plan and not paid since
+	audienceTraffic  = "traffic"  // Used at least broadcastAudience.Percent of the traffic limit
+	audienceInbound  = "inbound"  // Has a client in broadcastAudience.InboundID of the panel
+
+	// Picker pages, not audiences
+	audiencePick     = "pick"
+	audienceInbounds = "inbounds"
+)
+
+// trafficAudiencePercents are the traffic thresholds offered in the audience picker
+var trafficAudiencePercents = []int{80, 90, 100}
+
+// broadcastAudience is the segment of users a broadcast is sent to, carried in callback data by its code
+type broadcastAudience struct {
+	Segment    string
+	Days       int
+	Percent    int
+	PanelIndex int
+	InboundID  int
+}
+
+// code encodes the audience for callback data, parseAudience reads it back
+func (a broadcastAudience) code() string {
+	switch a.Segment {
+	case audienceExpiring:
+		return fmt.Sprintf("%s_%d", a.Segment, a.Days)
+	case audienceTraffic:
+		return fmt.Sprintf("%s_%d", a.Segment, a.Percent)
+	case audienceInbound:
+		return fmt.Sprintf("%s_%d_%d", a.Segment, a.PanelIndex, a.InboundID)
+	}
+	return a.Segment
+}
+
+// parseAudience decodes an audience code
+func parseAudience(code string) (broadcastAudience, bool) {
+	parts := strings.Split(code, "_")
+	aud := broadcastAudience{Segment: parts[0]}
+
+	nums := make([]int, 0, len(parts)-1)
+	for _, part := range parts[1:] {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return aud, false
+		}
+		nums = append(nums, n)
+	}
+
+	switch aud.Segment {
+	case audienceAll, audienceActive, audienceExpired, audienceBlocked, audienceTrial:
+		return aud, len(nums) == 0
+	case audienceExpiring:
+		if len(nums) != 1 || nums[0] == 0 {
+			return aud, false
+		}
+		aud.Days = nums[0]
+	case audienceTraffic:
+		if len(nums) != 1 || nums[0] == 0 {
+			return aud, false
+		}
+		aud.Percent = nums[0]
+	case audienceInbound:
+		if len(nums) != 2 {
+			return aud, false
+		}
+		aud.PanelIndex, aud.InboundID = nums[0], nums[1]
+	default:
+		return aud, false
+	}
+	return aud, true
+}
+
+// matches reports whether a client list entry belongs to the audience
+func (a broadcastAudience) matches(entry *clientListEntry, now time.Time) bool {
+	switch a.Segment {
+	case audienceActive:
+		return entry.Enable && !entry.expired(now)
+	case audienceExpired:
+		return entry.expired(now)
+	case audienceExpiring:
+		return entry.expiresWithin(now, a.Days)
+	case audienceBlocked:
+		return !entry.Enable
+	case audienceTraffic:
+		return entry.LimitBytes > 0 && float64(entry.TotalTraffic) >= entry.LimitBytes*float64(a.Percent)/100
+	}
+	return true
+}
+
+// broadcastAudienceKeyboard builds the audience picker
+func broadcastAudienceKeyboard(t i18n.Translator) *telego.InlineKeyboardMarkup {
+	button := func(label string, aud broadcastAudience) telego.InlineKeyboardButton {
+		return tu.InlineKeyboardButton(label).WithCallbackData(constants.CbBroadcastAudiencePrefix + aud.code())
+	}
+
+	expiring := make([]telego.InlineKeyboardButton, 0, len(expiringFilterDays))
+	for _, days := range expiringFilterDays {
+		expiring = append(expiring, button(t("clients.filter_expiring", days), broadcastAudience{Segment: audienceExpiring, Days: days}))
+	}
+	traffic := make([]telego.InlineKeyboardButton, 0, len(trafficAudiencePercents))
+	for _, percent := range trafficAudiencePercents {
+		traffic = append(traffic, button(t("button.audience_traffic", percent), broadcastAudience{Segment: audienceTraffic, Percent: percent}))
+	}
+
+	return tu.InlineKeyboard(
+		tu.InlineKeyboardRow(
+			button(t("broadcast.audience_all"), broadcastAudience{Segment: audienceAll}),
+			button(t("broadcast.audience_active"), broadcastAudience{Segment: audienceActive}),
+		),
+		tu.InlineKeyboardRow(
+			button(t("broadcast.audience_expired"), broadcastAudience{Segment: audienceExpired}),
+			button(t("broadcast.audience_blocked"), broadcastAudience{Segment: audienceBlocked}),
+			button(t("broadcast.audience_trial"), broadcastAudience{Segment: audienceTrial}),
+		),
+		expiring,
+		traffic,
+		tu.InlineKeyboardRow(
+			tu.InlineKeyboardButton(t("button.audience_inbounds")).WithCallbackData(constants.CbBroadcastAudiencePrefix+audienceInbounds),
+		),
+		tu.InlineKeyboardRow(
+			tu.InlineKeyboardButton(t("button.cancel")).WithCallbackData(constants.CbBroadcastCancel),
+		),
+	)
+}
+
+// audienceLabel names an audience in the broadcast preview
+func (b *Bot) audienceLabel(t i18n.Translator, aud broadcastAudience) string {
+	switch aud.Segment {
+	case audienceExpiring:
+		return t("broadcast.audience_expiring", aud.Days)
+	case audienceTraffic:
+		return t("broadcast.audience_traffic", aud.Percent)
+	case audienceInbound:
+		label := t("broadcast.audience_inbound", aud.InboundID)
+		if apiClient, ok := b.panelAt(aud.PanelIndex); ok && b.hasMultiplePanels() {
+			label += " · " + html.EscapeString(apiClient.Name())
+		}
+		return label
+	}
+	return t("broadcast.audience_" + aud.Segment)
+}
+
+// audienceRecipients returns the Telegram IDs of the users in an audience, each once.
+// Clients without a Telegram ID cannot be messaged and are left out
+func (b *Bot) audienceRecipients(aud broadcastAudience) ([]int64, error) {
+	seen := make(map[int64]bool)
+	var recipients []int64
+	add := func(tgID int64) {
+		if tgID > 0 && !seen[tgID] {
+			seen[tgID] = true
+			recipients = append(recipients, tgID)
+		}
+	}
+
+	switch aud.Segment {
+	case audienceTrial:
+		users, err := b.storage.GetAllUsers()
+		if err != nil {
+			return nil, err
+		}
+		for _, user := range users {
+			if user.Trial {
+				add(user.TgID)
+			}
+		}
+		return recipients, nil
+
+	case audienceInbound:
+		apiClient, ok := b.panelAt(aud.PanelIndex)
+		if !ok {
+			return nil, fmt.Errorf("panel %d not found", aud.PanelIndex)
+		}
+		inbounds, err := apiClient.GetInbounds(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		for _, inbound := range inbounds {
+			if inbound.ID != aud.InboundID {
+				continue
+			}
+			clients, err := inbound.Clients()
+			if err != nil {
+				b.logger.Warnf("Skipping clients of inbound %d in broadcast audience: %v", inbound.ID, err)
+			}
+			for _, c := range clients {
+				if c.HasTgID() {
+					add(c.TgID)
+				}
+			}
+		}
+		return recipients, nil
+	}
+
+	now := time.Now()
+	for _, apiClient := range b.panels.All() {
+		entries, err := b.collectClientEntries(apiClient)
+		if err != nil {
+			return nil, fmt.Errorf("panel %s: %w", apiClient.Name(), err)
+		}
+		for _, entry := range entries {
+			if aud.matches(entry, now) {
+				add(entry.TgID)
+			}
+		}
+	}
+	return recipients, nil
 }
 
 // namedBytesReader wraps bytes data to implement NamedReader interface
@@ -233,41 +913,43 @@ func (r *namedBytesReader) Name() string {
 	return r.name
 }
 
-// sendBackupToAdmins sends database backup to all admins
+// sendBackupToAdmins sends database backups of all panels to all admins
 func (b *Bot) sendBackupToAdmins() {
 	b.logger.Info("Starting database backup...")
 
-	// Download backup from panel
-	backup, err := b.apiClient.GetDatabaseBackup(context.Background())
-	if err != nil {
-		b.logger.Errorf("Failed to download backup: %v", err)
-		for _, adminID := range b.config.Telegram.AdminIDs {
-			b.sendMessage(adminID, fmt.Sprintf("❌ Ошибка создания бэкапа: %v", err))
+	for _, apiClient := range b.panels.All() {
+		// Download backup from panel
+		backup, err := apiClient.GetDatabaseBackup(context.Background())
+		if err != nil {
+			b.logger.Errorf("Failed to download backup from panel %s: %v", apiClient.Name(), err)
+			for _, adminID := range b.cfg().Telegram.AdminIDs {
+				b.sendMessage(adminID, b.panelTitle(apiClient)+b.t(adminID, "backup.error_create", err))
+			}
+			continue
 		}
-		return
-	}
 
-	// Send to all admins
-	filename := fmt.Sprintf("x-ui_%s.db", time.Now().Format("2006-01-02_15-04"))
-	for _, adminID := range b.config.Telegram.AdminIDs {
-		reader := &namedBytesReader{
-			Reader: strings.NewReader(string(backup)),
-			name:   filename,
-		}
+		// Send to all admins
+		filename := b.backupFilename(apiClient)
+		for _, adminID := range b.cfg().Telegram.AdminIDs {
+			reader := &namedBytesReader{
+				Reader: strings.NewReader(string(backup)),
+				name:   filename,
+			}
 
-		_, err := b.bot.SendDocument(context.Background(), &telego.SendDocumentParams{
-			ChatID: tu.ID(adminID),
-			Document: telego.InputFile{
-				File: reader,
-			},
-			Caption:   fmt.Sprintf("📦 <b>Backup Database</b>\n\n🕐 Time: %s\n💾 Size: %.2f MB", time.Now().Format("2006-01-02 15:04:05"), float64(len(backup))/1024/1024),
-			ParseMode: "HTML",
-		})
+			_, err := b.bot.SendDocument(context.Background(), &telego.SendDocumentParams{
+				ChatID: tu.ID(adminID),
+				Document: telego.InputFile{
+					File: reader,
+				},
+				Caption:   b.panelTitle(apiClient) + b.t(adminID, "backup.caption_size", time.Now().Format("2006-01-02 15:04:05"), float64(len(backup))/1024/1024),
+				ParseMode: "HTML",
+			})
 
-		if err != nil {
-			b.logger.Errorf("Failed to send backup to admin %d: %v", adminID, err)
-		} else {
-			b.logger.Infof("Backup sent to admin %d", adminID)
+			if err != nil {
+				b.logger.Errorf("Failed to send backup to admin %d: %v", adminID, err)
+			} else {
+				b.logger.Infof("Backup of panel %s sent to admin %d", apiClient.Name(), adminID)
+			}
 		}
 	}
 }
@@ -0,0 +1,521 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"x-ui-bot/internal/bot/constants"
+	"x-ui-bot/internal/i18n"
+	"x-ui-bot/pkg/client"
+
+	"github.com/mymmrac/telego"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+// Client list handlers: /clients, /find, filter chips and pages
+
+// clientsPageSize is the number of clients on one page of the list
+const clientsPageSize = 20
+
+// Client list filters
+const (
+	clientFilterAll       = "all"
+	clientFilterExpired   = "expired"
+	clientFilterBlocked   = "blocked"
+	clientFilterOverQuota = "over_quota"
+	clientFilterExpiring  = "expiring" // Expires within clientListView.Days
+)
+
+// expiringFilterDays are the windows offered as filter chips
+var expiringFilterDays = []int{3, 7, 30}
+
+// clientListView is the client list an admin has open, kept across page and filter edits
+type clientListView struct {
+	PanelIndex int    // -1 lists the clients of every panel
+	Filter     string // One of the clientFilter* values
+	Days       int    // Window of clientFilterExpiring
+	Query      string // Search text of /find
+	Page       int
+}
+
+// clientListEntry is a user as shown in the list: all clients of a Telegram ID on a panel grouped together
+type clientListEntry struct {
+	Panel        *client.APIClient
+	First        client.Client // Instance the client button refers to
+	TgID         int64
+	Email        string // Clean email without suffix
+	Enable       bool   // true if enabled in ANY inbound
+	ExpiryTime   int64
+	TotalTraffic int64
+	LimitBytes   float64
+	InboundCount int
+}
+
+// expired reports whether the subscription has ended
+func (e *clientListEntry) expired(now time.Time) bool {
+	return e.ExpiryTime > 0 && e.ExpiryTime < now.UnixMilli()
+}
+
+// unlimited reports whether the subscription has no end date
+func (e *clientListEntry) unlimited() bool {
+	return e.ExpiryTime == 0
+}
+
+// overQuota reports whether the traffic limit is used up
+func (e *clientListEntry) overQuota() bool {
+	return e.LimitBytes > 0 && float64(e.TotalTraffic) >= e.LimitBytes
+}
+
+// expiresWithin reports whether an active subscription ends within the given days
+func (e *clientListEntry) expiresWithin(now time.Time, days int) bool {
+	return !e.expired(now) && e.ExpiryTime > 0 && e.ExpiryTime <= now.AddDate(0, 0, days).UnixMilli()
+}
+
+// handleClients handles the /clients command - shows all clients with traffic stats,
+// or a server picker when several panels are configured
+func (b *Bot) handleClients(chatID int64, isAdmin bool, messageID ...int) {
+	t := b.tr(chatID)
+	if !isAdmin {
+		b.sendMessage(chatID, t("command.admin_only"))
+		return
+	}
+
+	if b.hasMultiplePanels() {
+		msg := t("panel.choose")
+		keyboard := b.buildPanelPicker(constants.CbClientsPanelPrefix)
+		if len(messageID) > 0 {
+			b.editMessage(chatID, messageID[0], msg, keyboard)
+		} else {
+			b.sendMessageWithInlineKeyboard(chatID, msg, keyboard)
+		}
+		return
+	}
+
+	b.handlePanelClients(chatID, 0, messageID...)
+}
+
+// handlePanelClients opens the unfiltered client list of one panel
+func (b *Bot) handlePanelClients(chatID int64, panelIndex int, messageID ...int) {
+	if _, ok := b.panelAt(panelIndex); !ok {
+		b.sendMessage(chatID, b.t(chatID, "panel.not_found"))
+		return
+	}
+
+	view := clientListView{PanelIndex: panelIndex, Filter: clientFilterAll}
+	b.clientViews.Store(chatID, view)
+	if len(messageID) == 0 {
+		b.sendMessage(chatID, b.t(chatID, "clients.loading"))
+	}
+	b.showClientList(chatID, view, messageID...)
+}
+
+// handleFind searches clients of every panel by email, Telegram username or ID: /find <text>
+func (b *Bot) handleFind(chatID int64, args []string) {
+	query := strings.TrimSpace(strings.Join(args, " "))
+	if query == "" {
+		b.sendMessage(chatID, b.t(chatID, "clients.find_usage"))
+		return
+	}
+
+	view := clientListView{PanelIndex: -1, Filter: clientFilterAll, Query: query}
+	b.clientViews.Store(chatID, view)
+	b.sendMessage(chatID, b.t(chatID, "clients.loading"))
+	b.showClientList(chatID, view)
+}
+
+// refreshClientList shows the list the admin last opened again, after a change or from the client menu
+func (b *Bot) refreshClientList(chatID int64, panelIndex int, messageID ...int) {
+	view, ok := b.clientView(chatID)
+	if !ok || (view.PanelIndex >= 0 && view.PanelIndex != panelIndex) {
+		b.handlePanelClients(chatID, panelIndex, messageID...)
+		return
+	}
+	b.showClientList(chatID, view, messageID...)
+}
+
+// handleClientListCallback applies a filter chip or page button to the list the admin has open
+func (b *Bot) handleClientListCallback(chatID int64, messageID int, data string) {
+	view, ok := b.clientView(chatID)
+	if !ok {
+		b.editMessageText(chatID, messageID, b.t(chatID, "clients.list_expired"))
+		return
+	}
+
+	switch {
+	case strings.HasPrefix(data, constants.CbClientListPagePrefix):
+		page, err := strconv.Atoi(strings.TrimPrefix(data, constants.CbClientListPagePrefix))
+		if err != nil {
+			return
+		}
+		view.Page = page
+	case strings.HasPrefix(data, constants.CbClientListFilterPrefix):
+		filter := strings.TrimPrefix(data, constants.CbClientListFilterPrefix)
+		view.Days = 0
+		if name, days, found := strings.Cut(filter, "_"); found && name == clientFilterExpiring {
+			if n, err := strconv.Atoi(days); err == nil {
+				filter, view.Days = clientFilterExpiring, n
+			}
+		}
+		view.Filter = filter
+		view.Page = 0
+	case data == constants.CbClientListClearSearch:
+		view.Query = ""
+		view.Page = 0
+		if view.PanelIndex < 0 && !b.hasMultiplePanels() {
+			view.PanelIndex = 0
+		}
+	}
+
+	b.clientViews.Store(chatID, view)
+	b.showClientList(chatID, view, messageID)
+}
+
+// clientView returns the client list view the admin last opened
+func (b *Bot) clientView(chatID int64) (clientListView, bool) {
+	v, ok := b.clientViews.Load(chatID)
+	if !ok {
+		return clientListView{}, false
+	}
+	return v.(clientListView), true
+}
+
+// showClientList renders one page of the view, editing the message when its ID is given
+func (b *Bot) showClientList(chatID int64, view clientListView, messageID ...int) {
+	t := b.tr(chatID)
+
+	panels := b.panels.All()
+	if view.PanelIndex >= 0 {
+		apiClient, ok := b.panelAt(view.PanelIndex)
+		if !ok {
+			b.sendMessage(chatID, t("panel.not_found"))
+			return
+		}
+		panels = []*client.APIClient{apiClient}
+	}
+
+	var entries []*clientListEntry
+	for _, apiClient := range panels {
+		panelEntries, err := b.collectClientEntries(apiClient)
+		if err != nil {
+			b.logger.Errorf("Failed to get clients of panel %s: %v", apiClient.Name(), err)
+			b.sendMessage(chatID, b.panelTitle(apiClient)+t("clients.error_list", err))
+			return
+		}
+		entries = append(entries, panelEntries...)
+	}
+
+	entries = b.filterClientEntries(entries, view)
+	total := len(entries)
+	pages := (total + clientsPageSize - 1) / clientsPageSize
+	if view.Page >= pages {
+		view.Page = pages - 1
+	}
+	if view.Page < 0 {
+		view.Page = 0
+	}
+
+	start := view.Page * clientsPageSize
+	end := min(start+clientsPageSize, total)
+
+	var buttons [][]telego.InlineKeyboardButton
+	for _, entry := range entries[start:end] {
+		refID, err := b.clientRef(entry.Panel, entry.First)
+		if err != nil {
+			b.logger.Errorf("Failed to get ref of client %s: %v", entry.First.Email, err)
+			continue
+		}
+		buttons = append(buttons, tu.InlineKeyboardRow(
+			tu.InlineKeyboardButton(b.clientButtonText(entry, view.PanelIndex < 0)).
+				WithCallbackData(clientCallback(constants.CbClientPrefix, refID)),
+		))
+	}
+
+	var nav []telego.InlineKeyboardButton
+	if view.Page > 0 {
+		nav = append(nav, tu.InlineKeyboardButton(t("button.prev_page")).WithCallbackData(fmt.Sprintf("%s%d", constants.CbClientListPagePrefix, view.Page-1)))
+	}
+	if view.Page < pages-1 {
+		nav = append(nav, tu.InlineKeyboardButton(t("button.next_page")).WithCallbackData(fmt.Sprintf("%s%d", constants.CbClientListPagePrefix, view.Page+1)))
+	}
+	if len(nav) > 0 {
+		buttons = append(buttons, nav)
+	}
+	buttons = append(buttons, clientFilterChips(t, view)...)
+
+	if view.Query != "" {
+		buttons = append(buttons, tu.InlineKeyboardRow(
+			tu.InlineKeyboardButton(t("button.clear_search", truncateRunes(view.Query, 24))).WithCallbackData(constants.CbClientListClearSearch),
+		))
+	}
+	if b.hasMultiplePanels() {
+		buttons = append(buttons, tu.InlineKeyboardRow(
+			tu.InlineKeyboardButton(t("button.back_to_servers")).WithCallbackData(constants.CbBackToClients),
+		))
+	}
+
+	title := t("clients.list")
+	if view.PanelIndex >= 0 {
+		title = b.panelTitle(panels[0]) + title
+	}
+	msg := title + b.clientListSummary(t, view, total, pages)
+	if total == 0 {
+		msg = title + t("clients.empty_filtered")
+		if view.Filter == clientFilterAll && view.Query == "" {
+			msg = t("clients.empty")
+		}
+	}
+
+	keyboard := tu.InlineKeyboard(buttons...)
+	if len(messageID) > 0 {
+		b.editMessage(chatID, messageID[0], msg, keyboard)
+	} else {
+		b.sendMessageWithInlineKeyboard(chatID, msg, keyboard)
+	}
+
+	b.logger.Infof("Sent page %d of %d clients to user ID: %d", view.Page+1, total, chatID)
+}
+
+// clientListSummary describes the filter, the search and the page shown
+func (b *Bot) clientListSummary(t i18n.Translator, view clientListView, total, pages int) string {
+	summary := t("clients.summary", view.Page+1, pages, total)
+	if view.Filter != clientFilterAll {
+		summary += t("clients.summary_filter", clientFilterLabel(t, view.Filter, view.Days))
+	}
+	if view.Query != "" {
+		summary += t("clients.summary_search", html.EscapeString(view.Query))
+	}
+	return summary
+}
+
+// collectClientEntries groups the clients of a panel by Telegram ID, or by clean email when there is none
+func (b *Bot) collectClientEntries(apiClient *client.APIClient) ([]*clientListEntry, error) {
+	inbounds, err := apiClient.GetInbounds(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	grouped := make(map[string]*clientListEntry) // key: tgId or clean email
+	var entries []*clientListEntry
+
+	for _, inbound := range inbounds {
+		clients, err := inbound.Clients()
+		if err != nil {
+			b.logger.WithFields(map[string]interface{}{
+				"error":      err,
+				"inbound_id": inbound.ID,
+			}).Error("Failed to parse clients")
+		}
+
+		for _, c := range clients {
+			cleanEmail := stripInboundSuffix(c.Email)
+
+			groupKey := "email_" + cleanEmail
+			if c.HasTgID() {
+				groupKey = strconv.FormatInt(c.TgID, 10)
+			}
+
+			entry, exists := grouped[groupKey]
+			if !exists {
+				entry = &clientListEntry{
+					Panel:      apiClient,
+					First:      c,
+					TgID:       c.TgID,
+					Email:      cleanEmail,
+					ExpiryTime: c.ExpiryTime,
+					LimitBytes: float64(c.TotalGB),
+				}
+				grouped[groupKey] = entry
+				entries = append(entries, entry)
+			}
+			entry.InboundCount++
+			// If enabled in ANY inbound, show as enabled
+			if c.Enable {
+				entry.Enable = true
+			}
+
+			// Use max traffic instead of sum, as traffic is synced across inbounds
+			if stat, ok := inbound.StatByEmail(c.Email); ok && stat.Used() > entry.TotalTraffic {
+				entry.TotalTraffic = stat.Used()
+			}
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return strings.ToLower(entries[i].Email) < strings.ToLower(entries[j].Email)
+	})
+	return entries, nil
+}
+
+// filterClientEntries keeps the entries matching the filter and the search text of the view
+func (b *Bot) filterClientEntries(entries []*clientListEntry, view clientListView) []*clientListEntry {
+	now := time.Now()
+	query := strings.ToLower(strings.TrimPrefix(view.Query, "@"))
+
+	// Usernames are matched against the local users table, Telegram is not asked for every client
+	var usernames map[int64]string
+	if query != "" {
+		usernames = b.storedUsernames()
+	}
+
+	var result []*clientListEntry
+	for _, entry := range entries {
+		switch view.Filter {
+		case clientFilterExpired:
+			if !entry.expired(now) {
+				continue
+			}
+		case clientFilterBlocked:
+			if entry.Enable {
+				continue
+			}
+		case clientFilterOverQuota:
+			if !entry.overQuota() {
+				continue
+			}
+		case clientFilterExpiring:
+			if !entry.expiresWithin(now, view.Days) {
+				continue
+			}
+		}
+
+		if query != "" && !clientMatches(entry, query, usernames) {
+			continue
+		}
+		result = append(result, entry)
+	}
+	return result
+}
+
+// clientMatches reports whether the email, the Telegram ID or the stored Telegram username contains the lower-case query
+func clientMatches(entry *clientListEntry, query string, usernames map[int64]string) bool {
+	if strings.Contains(strings.ToLower(entry.Email), query) {
+		return true
+	}
+	if !entry.First.HasTgID() {
+		return false
+	}
+	if strings.Contains(strconv.FormatInt(entry.TgID, 10), query) {
+		return true
+	}
+	username := usernames[entry.TgID]
+	return username != "" && strings.Contains(strings.ToLower(username), query)
+}
+
+// storedUsernames returns the Telegram usernames of the local users table by Telegram ID
+func (b *Bot) storedUsernames() map[int64]string {
+	users, err := b.storage.GetAllUsers()
+	if err != nil {
+		b.logger.Errorf("Failed to load users for the client search: %v", err)
+		return nil
+	}
+
+	usernames := make(map[int64]string, len(users))
+	for _, user := range users {
+		if user.TgUsername != "" {
+			usernames[user.TgID] = user.TgUsername
+		}
+	}
+	return usernames
+}
+
+// telegramUsername returns the stored @username of a user, empty when the user has not written to the bot
+func (b *Bot) telegramUsername(tgID int64) string {
+	user, ok := b.getUser(tgID)
+	if !ok || user.TgUsername == "" {
+		return ""
+	}
+	return "@" + user.TgUsername
+}
+
+// clientButtonText formats a list entry: status + email + username + inbound count + traffic
+func (b *Bot) clientButtonText(entry *clientListEntry, withPanel bool) string {
+	var statusEmoji string
+	switch {
+	case entry.expired(time.Now()):
+		statusEmoji = "⛔"
+	case !entry.Enable:
+		statusEmoji = "🔴"
+	case entry.unlimited():
+		statusEmoji = "💎"
+	default:
+		statusEmoji = "🟢"
+	}
+
+	trafficStr := " ∞"
+	if entry.LimitBytes > 0 {
+		limitGB := entry.LimitBytes / (1024 * 1024 * 1024)
+		usedGB := float64(entry.TotalTraffic) / (1024 * 1024 * 1024)
+		percentage := int(math.Ceil((float64(entry.TotalTraffic) / entry.LimitBytes) * 100))
+		trafficStr = fmt.Sprintf(" %.1fGB/%.0fGB (%d%%)", usedGB, limitGB, percentage)
+	}
+
+	tgUsernameStr := ""
+	if entry.First.HasTgID() {
+		if username := b.telegramUsername(entry.TgID); username != "" {
+			tgUsernameStr = " " + username
+		}
+	}
+
+	inboundIndicator := ""
+	if entry.InboundCount > 1 {
+		inboundIndicator = fmt.Sprintf(" [%d🌐]", entry.InboundCount)
+	}
+
+	panelStr := ""
+	if withPanel && b.hasMultiplePanels() {
+		panelStr = fmt.Sprintf("[%s] ", entry.Panel.Name())
+	}
+
+	return fmt.Sprintf("%s %s%s%s%s%s", statusEmoji, panelStr, entry.Email, tgUsernameStr, inboundIndicator, trafficStr)
+}
+
+// clientFilterChips builds the filter buttons, the active filter is marked
+func clientFilterChips(t i18n.Translator, view clientListView) [][]telego.InlineKeyboardButton {
+	chip := func(filter string, days int) telego.InlineKeyboardButton {
+		label := clientFilterLabel(t, filter, days)
+		data := constants.CbClientListFilterPrefix + filter
+		if filter == clientFilterExpiring {
+			data = fmt.Sprintf("%s%s_%d", constants.CbClientListFilterPrefix, filter, days)
+		}
+		if view.Filter == filter && (filter != clientFilterExpiring || view.Days == days) {
+			label = "• " + label
+		}
+		return tu.InlineKeyboardButton(label).WithCallbackData(data)
+	}
+
+	expiring := make([]telego.InlineKeyboardButton, 0, len(expiringFilterDays))
+	for _, days := range expiringFilterDays {
+		expiring = append(expiring, chip(clientFilterExpiring, days))
+	}
+
+	return [][]telego.InlineKeyboardButton{
+		tu.InlineKeyboardRow(
+			chip(clientFilterAll, 0),
+			chip(clientFilterExpired, 0),
+			chip(clientFilterBlocked, 0),
+			chip(clientFilterOverQuota, 0),
+		),
+		expiring,
+	}
+}
+
+// clientFilterLabel names a filter for chips and the list summary
+func clientFilterLabel(t i18n.Translator, filter string, days int) string {
+	switch filter {
+	case clientFilterExpired:
+		return t("clients.filter_expired")
+	case clientFilterBlocked:
+		return t("clients.filter_blocked")
+	case clientFilterOverQuota:
+		return t("clients.filter_over_quota")
+	case clientFilterExpiring:
+		return t("clients.filter_expiring", days)
+	}
+	return t("clients.filter_all")
+}
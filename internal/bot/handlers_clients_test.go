@@ -0,0 +1,58 @@
+package bot
+
+import (
+	"testing"
+
+	"x-ui-bot/internal/storage"
+	"x-ui-bot/pkg/client"
+)
+
+func TestFindMatchesStoredUsernames(t *testing.T) {
+	b, tg, store := newTestBot(t, testConfig(t, "http://127.0.0.1:1", ""))
+
+	for _, user := range []*storage.User{
+		{TgID: 42, Email: "alice", Status: storage.UserStatusActive},
+		{TgID: 43, Email: "bob", Status: storage.UserStatusActive},
+	} {
+		if err := store.UpsertUser(user); err != nil {
+			t.Fatalf("UpsertUser: %v", err)
+		}
+	}
+	if err := store.SetUserTgUsername(42, "Wonderland"); err != nil {
+		t.Fatalf("SetUserTgUsername: %v", err)
+	}
+
+	var entries []*clientListEntry
+	for _, c := range []client.Client{
+		{Email: "alice", TgID: 42},
+		{Email: "bob", TgID: 43},
+		{Email: "carol", TgID: 44},
+	} {
+		entries = append(entries, &clientListEntry{First: c, TgID: int64(c.TgID), Email: c.Email})
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"@wonder", []string{"alice"}},
+		{"WONDERLAND", []string{"alice"}},
+		{"bo", []string{"bob"}},
+		{"44", []string{"carol"}},
+		{"nobody", nil},
+	}
+	for _, tt := range tests {
+		got := b.filterClientEntries(entries, clientListView{Query: tt.query})
+		var emails []string
+		for _, entry := range got {
+			emails = append(emails, entry.Email)
+		}
+		if len(emails) != len(tt.want) || (len(emails) > 0 && emails[0] != tt.want[0]) {
+			t.Errorf("query %q matched %v, want %v", tt.query, emails, tt.want)
+		}
+	}
+
+	if calls := tg.sent("getChat", 0); len(calls) != 0 {
+		t.Errorf("search asked Telegram for %d chats, want none", len(calls))
+	}
+}
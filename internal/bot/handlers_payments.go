@@ -0,0 +1,285 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+	"time"
+
+	"x-ui-bot/internal/bot/constants"
+	"x-ui-bot/internal/bot/services"
+	"x-ui-bot/internal/config"
+	"x-ui-bot/internal/storage"
+
+	"github.com/mymmrac/telego"
+	th "github.com/mymmrac/telego/telegohandler"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+// Telegram Payments handlers for paid subscription extensions
+
+// extensionInvoicePayload builds the invoice payload of an extension: "ext_<userID>_<days>[_<promo code>]"
+func extensionInvoicePayload(userID int64, duration int, promoCode string) string {
+	payload := fmt.Sprintf("%s%d_%d", constants.InvoiceExtendPrefix, userID, duration)
+	if promoCode != "" {
+		payload += "_" + promoCode
+	}
+	return payload
+}
+
+// parseExtensionInvoicePayload parses the payload built by extensionInvoicePayload.
+// The promo code is the rest of the payload, so it may contain "_"
+func parseExtensionInvoicePayload(payload string) (userID int64, duration int, promoCode string, ok bool) {
+	if !strings.HasPrefix(payload, constants.InvoiceExtendPrefix) {
+		return 0, 0, "", false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(payload, constants.InvoiceExtendPrefix), "_", 3)
+	if len(parts) < 2 || (len(parts) == 3 && parts[2] == "") {
+		return 0, 0, "", false
+	}
+
+	userID, err1 := strconv.ParseInt(parts[0], 10, 64)
+	duration, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil {
+		return 0, 0, "", false
+	}
+	if len(parts) == 3 {
+		promoCode = parts[2]
+	}
+	return userID, duration, promoCode, true
+}
+
+// invoiceAmount returns the invoice amount of a quote in the smallest units of the invoice currency
+func (b *Bot) invoiceAmount(quote services.Quote) int {
+	return quote.Price * b.cfg().Payment.MinorUnits()
+}
+
+// formatAmount formats an amount in the smallest units of a currency, such as 30000 RUB as "300.00 RUB"
+func formatAmount(amount int, currency string) string {
+	exp := config.CurrencyExponent(currency)
+	if exp == 0 {
+		return fmt.Sprintf("%d %s", amount, currency)
+	}
+	units := config.CurrencyMinorUnits(currency)
+	return fmt.Sprintf("%d.%0*d %s", amount/units, exp, amount%units, currency)
+}
+
+// sendExtensionInvoice replaces the duration picker with an invoice for the quoted period
+func (b *Bot) sendExtensionInvoice(chatID int64, messageID int, userID int64, quote services.Quote, email string) {
+	t := b.tr(userID)
+	duration := quote.Duration
+	price := quote.Price
+	if price <= 0 {
+		b.sendMessage(chatID, t("payment.no_price"))
+		return
+	}
+
+	cleanEmail := stripInboundSuffix(email)
+	b.editMessageText(chatID, messageID, t("payment.invoice_message",
+		html.EscapeString(cleanEmail),
+		duration,
+		price,
+		b.cfg().Payment.Currency,
+		b.promoQuoteLines(t, quote),
+	))
+
+	invoice := tu.Invoice(
+		tu.ID(chatID),
+		t("payment.invoice_title"),
+		t("payment.invoice_description", cleanEmail, quote.TotalDays()),
+		extensionInvoicePayload(userID, duration, quote.Code),
+		b.cfg().Payment.ProviderToken,
+		b.cfg().Payment.Currency,
+		tu.LabeledPrice(t("common.days", quote.TotalDays()), b.invoiceAmount(quote)),
+	)
+	if _, err := b.bot.SendInvoice(context.Background(), invoice); err != nil {
+		b.logger.Errorf("Failed to send invoice to user %d: %v", userID, err)
+		b.sendMessage(chatID, t("payment.error_invoice"))
+		return
+	}
+
+	b.logger.Infof("Sent extension invoice to user %d, duration: %d days, amount: %d %s", userID, duration, price, b.cfg().Payment.Currency)
+}
+
+// handlePreCheckoutQuery validates an invoice right before Telegram charges the user
+func (b *Bot) handlePreCheckoutQuery(_ *th.Context, query telego.PreCheckoutQuery) error {
+	errorMessage := b.validatePreCheckout(query)
+	if errorMessage != "" {
+		b.logger.Warnf("Rejected pre-checkout %s from user %d: %s", query.InvoicePayload, query.From.ID, errorMessage)
+	}
+
+	if err := b.bot.AnswerPreCheckoutQuery(context.Background(), &telego.AnswerPreCheckoutQueryParams{
+		PreCheckoutQueryID: query.ID,
+		Ok:                 errorMessage == "",
+		ErrorMessage:       errorMessage,
+	}); err != nil {
+		b.logger.Errorf("Failed to answer pre-checkout query: %v", err)
+	}
+	return nil
+}
+
+// validatePreCheckout returns the reason to reject a pre-checkout query, empty when it can be charged
+func (b *Bot) validatePreCheckout(query telego.PreCheckoutQuery) string {
+	t := b.tr(query.From.ID)
+	if !b.cfg().Payment.InvoicesEnabled() {
+		return t("payment.unavailable")
+	}
+
+	userID, duration, promoCode, ok := parseExtensionInvoicePayload(query.InvoicePayload)
+	if !ok || userID != query.From.ID {
+		return t("payment.invalid_invoice")
+	}
+
+	// The promo code must still be valid when the user pays
+	quote, err := b.priceQuote(userID, duration, promoCode)
+	if err != nil {
+		return t("payment.promo_invalid")
+	}
+
+	if query.Currency != b.cfg().Payment.Currency || query.TotalAmount != b.invoiceAmount(quote) || query.TotalAmount <= 0 {
+		return t("payment.price_changed")
+	}
+
+	if _, exists := b.getUser(userID); !exists {
+		return t("payment.subscription_not_found")
+	}
+
+	return ""
+}
+
+// handleSuccessfulPayment records a charge in the ledger and extends the subscription
+func (b *Bot) handleSuccessfulPayment(_ *th.Context, message telego.Message) error {
+	payment := message.SuccessfulPayment
+	userID := message.From.ID
+
+	// The payload was checked at pre-checkout, a bad one here means the user paid for nothing known
+	var quote services.Quote
+	var payloadErr error
+	payloadUserID, duration, promoCode, ok := parseExtensionInvoicePayload(payment.InvoicePayload)
+	switch {
+	case !ok || duration <= 0:
+		payloadErr = fmt.Errorf("invalid invoice payload %q", payment.InvoicePayload)
+	case payloadUserID != userID:
+		payloadErr = fmt.Errorf("invoice of user %d paid by user %d", payloadUserID, userID)
+	default:
+		quote = b.paidQuote(duration, promoCode, payment.TotalAmount)
+	}
+
+	record := &storage.Payment{
+		UserID:           userID,
+		TelegramChargeID: payment.TelegramPaymentChargeID,
+		ProviderChargeID: payment.ProviderPaymentChargeID,
+		Payload:          payment.InvoicePayload,
+		Duration:         quote.TotalDays(),
+		Amount:           payment.TotalAmount,
+		Currency:         payment.Currency,
+		Status:           storage.PaymentStatusPaid,
+	}
+	if payloadErr != nil {
+		record.Status = storage.PaymentStatusFailed
+	}
+
+	// The ledger entry is what applies a charge only once, Telegram may deliver the same update twice
+	if err := b.storage.AddPayment(record); err != nil {
+		if errors.Is(err, storage.ErrPaymentExists) {
+			b.logger.Warnf("Payment %s already recorded, skipping", payment.TelegramPaymentChargeID)
+			return nil
+		}
+		// Extending without a ledger entry could apply the charge twice, admins apply it by hand
+		b.logger.Errorf("Failed to record payment %s of user %d: %v", payment.TelegramPaymentChargeID, userID, err)
+		b.sendMessage(message.Chat.ID, b.t(userID, "payment.extend_failed"))
+		b.notifyAdminsAboutPayment(message.From, quote, payment, nil, fmt.Errorf("failed to record the payment: %w", err))
+		return nil
+	}
+
+	b.logger.Infof("Payment received from user %d: %d %s, charge %s", userID, payment.TotalAmount, payment.Currency, payment.TelegramPaymentChargeID)
+
+	if payloadErr != nil {
+		b.logger.Errorf("Payment %s not applied: %v", payment.TelegramPaymentChargeID, payloadErr)
+		b.sendMessage(message.Chat.ID, b.t(userID, "payment.extend_failed"))
+		b.notifyAdminsAboutPayment(message.From, quote, payment, nil, payloadErr)
+		return nil
+	}
+
+	b.redeemPromo(userID, storage.PromoPurposeExtension, quote)
+
+	status := storage.PaymentStatusApplied
+	result, err := b.extendSubscription(userID, quote.TotalDays())
+	if err != nil {
+		status = storage.PaymentStatusFailed
+		b.logger.Errorf("Failed to extend subscription after payment %s: %v", payment.TelegramPaymentChargeID, err)
+		b.sendMessage(message.Chat.ID, b.t(userID, "payment.extend_failed"))
+	}
+
+	if record.ID != 0 {
+		if err := b.storage.SetPaymentStatus(record.ID, status); err != nil {
+			b.logger.Errorf("Failed to update payment %d status: %v", record.ID, err)
+		}
+	}
+
+	b.notifyAdminsAboutPayment(message.From, quote, payment, result, err)
+	if err == nil {
+		b.setUserTrial(userID, false)
+		b.rewardReferrer(userID)
+	}
+	return nil
+}
+
+// paidQuote rebuilds the quote of a paid invoice. The code was validated at pre-checkout,
+// so it is applied even if it has run out since then
+func (b *Bot) paidQuote(duration int, promoCode string, amount int) services.Quote {
+	basePrice := b.cfg().Payment.Prices.ForDuration(duration)
+	if promoCode == "" {
+		return services.ApplyPromo(nil, duration, basePrice)
+	}
+
+	promo, err := b.storage.GetPromoCode(promoCode)
+	if err != nil {
+		b.logger.Warnf("Promo code %s of a paid invoice not found: %v", promoCode, err)
+		quote := services.ApplyPromo(nil, duration, basePrice)
+		quote.Price = amount / b.cfg().Payment.MinorUnits()
+		return quote
+	}
+	return services.ApplyPromo(promo, duration, basePrice)
+}
+
+// notifyAdminsAboutPayment tells admins about a paid extension and whether it was applied
+func (b *Bot) notifyAdminsAboutPayment(from *telego.User, quote services.Quote, payment *telego.SuccessfulPayment, result *extensionResult, extendErr error) {
+	tgUsernameStr := ""
+	if from.Username != "" {
+		tgUsernameStr = fmt.Sprintf(" (@%s)", from.Username)
+	}
+	amount := formatAmount(payment.TotalAmount, payment.Currency)
+
+	for _, adminID := range b.cfg().Telegram.AdminIDs {
+		t := b.tr(adminID)
+
+		var adminMsg string
+		if extendErr != nil {
+			adminMsg = t("payment.admin_extend_failed",
+				html.EscapeString(from.FirstName),
+				tgUsernameStr,
+				from.ID,
+				quote.TotalDays(),
+				amount,
+				b.promoQuoteLines(t, quote),
+				html.EscapeString(payment.TelegramPaymentChargeID),
+				html.EscapeString(extendErr.Error()),
+			)
+		} else {
+			adminMsg = t("payment.admin_paid",
+				html.EscapeString(from.FirstName),
+				tgUsernameStr,
+				html.EscapeString(result.Email),
+				quote.TotalDays(),
+				amount,
+				b.promoQuoteLines(t, quote),
+				time.UnixMilli(result.NewExpiry).Format("02.01.2006 15:04"),
+			)
+		}
+		b.sendMessage(adminID, adminMsg)
+	}
+}
@@ -0,0 +1,251 @@
+package bot
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"x-ui-bot/internal/storage"
+
+	"github.com/mymmrac/telego"
+)
+
+// paymentsConfig enables invoices on top of the test config
+const paymentsConfig = `  provider_token: "test-provider"
+`
+
+// fakePanel is a 3x-ui panel with one vless inbound that applies updateClient calls
+type fakePanel struct {
+	mu      sync.Mutex
+	clients []map[string]interface{}
+	updates int
+}
+
+func (p *fakePanel) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var obj interface{}
+	switch {
+	case r.URL.Path == "/login":
+		http.SetCookie(w, &http.Cookie{Name: "3x-ui", Value: "session"})
+	case r.URL.Path == "/panel/api/inbounds/list":
+		settings, _ := json.Marshal(map[string]interface{}{"clients": p.clients})
+		obj = []map[string]interface{}{{
+			"id":       1,
+			"protocol": "vless",
+			"remark":   "main",
+			"enable":   true,
+			"settings": string(settings),
+		}}
+	case strings.HasPrefix(r.URL.Path, "/panel/api/inbounds/updateClient/"):
+		var body struct {
+			Settings string `json:"settings"`
+		}
+		var settings struct {
+			Clients []map[string]interface{} `json:"clients"`
+		}
+		if json.NewDecoder(r.Body).Decode(&body) != nil || json.Unmarshal([]byte(body.Settings), &settings) != nil || len(settings.Clients) != 1 {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		for i, c := range p.clients {
+			if c["email"] == settings.Clients[0]["email"] {
+				p.clients[i] = settings.Clients[0]
+			}
+		}
+		p.updates++
+	case strings.HasPrefix(r.URL.Path, "/panel/api/inbounds/getClientLink/"):
+		obj = "https://sub.example.com/sub"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "obj": obj})
+}
+
+// expiry returns the expiry time of a client in milliseconds
+func (p *fakePanel) expiry(email string) int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, c := range p.clients {
+		if c["email"] == email {
+			v, _ := c["expiryTime"].(float64)
+			return int64(v)
+		}
+	}
+	return 0
+}
+
+// newPaymentsBot creates a bot taking invoice payments, with user 42 registered as alice on a fake panel
+func newPaymentsBot(t *testing.T) (*Bot, *fakeTelegram, storage.Storage, *fakePanel) {
+	t.Helper()
+
+	panel := &fakePanel{clients: []map[string]interface{}{{
+		"id":         "uuid-42",
+		"email":      "alice",
+		"enable":     true,
+		"tgId":       42,
+		"expiryTime": float64(time.Now().Add(10 * 24 * time.Hour).UnixMilli()),
+	}}}
+	server := httptest.NewServer(panel)
+	t.Cleanup(server.Close)
+
+	b, tg, store := newTestBot(t, testConfig(t, server.URL, paymentsConfig))
+	if err := store.UpsertUser(&storage.User{TgID: 42, Email: "alice", Status: storage.UserStatusActive}); err != nil {
+		t.Fatalf("UpsertUser: %v", err)
+	}
+	return b, tg, store, panel
+}
+
+func TestParseExtensionInvoicePayload(t *testing.T) {
+	tests := []struct {
+		payload      string
+		wantUserID   int64
+		wantDuration int
+		wantCode     string
+		wantOK       bool
+	}{
+		{payload: "ext_42_30", wantUserID: 42, wantDuration: 30, wantOK: true},
+		{payload: "ext_42_90_SPRING", wantUserID: 42, wantDuration: 90, wantCode: "SPRING", wantOK: true},
+		{payload: "ext_42_30_SUMMER_SALE", wantUserID: 42, wantDuration: 30, wantCode: "SUMMER_SALE", wantOK: true},
+		{payload: ""},
+		{payload: "ext_"},
+		{payload: "ext_42"},
+		{payload: "ext_42_"},
+		{payload: "ext_42_30_"},
+		{payload: "ext_x_30"},
+		{payload: "ext_42_month"},
+		{payload: "ext__30"},
+		{payload: "reg_42_30"},
+		{payload: "42_30"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.payload, func(t *testing.T) {
+			userID, duration, code, ok := parseExtensionInvoicePayload(tt.payload)
+			if ok != tt.wantOK || userID != tt.wantUserID || duration != tt.wantDuration || code != tt.wantCode {
+				t.Errorf("got %d, %d, %q, %v, want %d, %d, %q, %v",
+					userID, duration, code, ok, tt.wantUserID, tt.wantDuration, tt.wantCode, tt.wantOK)
+			}
+		})
+	}
+
+	// Payloads built by extensionInvoicePayload parse back
+	for _, code := range []string{"", "SPRING", "SUMMER_SALE"} {
+		userID, duration, got, ok := parseExtensionInvoicePayload(extensionInvoicePayload(42, 180, code))
+		if !ok || userID != 42 || duration != 180 || got != code {
+			t.Errorf("round trip of code %q: got %d, %d, %q, %v", code, userID, duration, got, ok)
+		}
+	}
+}
+
+func TestValidatePreCheckout(t *testing.T) {
+	b, _, _, _ := newPaymentsBot(t)
+
+	tests := []struct {
+		name     string
+		from     int64
+		payload  string
+		currency string
+		amount   int
+		wantKey  string // Message key of the rejection, empty when the charge is accepted
+	}{
+		{name: "valid", from: 42, payload: "ext_42_30", currency: "RUB", amount: 30000},
+		{name: "valid year", from: 42, payload: "ext_42_365", currency: "RUB", amount: 280000},
+		{name: "currency mismatch", from: 42, payload: "ext_42_30", currency: "USD", amount: 30000, wantKey: "payment.price_changed"},
+		{name: "amount mismatch", from: 42, payload: "ext_42_30", currency: "RUB", amount: 29900, wantKey: "payment.price_changed"},
+		{name: "amount of another period", from: 42, payload: "ext_42_90", currency: "RUB", amount: 30000, wantKey: "payment.price_changed"},
+		{name: "price in whole units", from: 42, payload: "ext_42_30", currency: "RUB", amount: 300, wantKey: "payment.price_changed"},
+		{name: "mismatched uid", from: 42, payload: "ext_43_30", currency: "RUB", amount: 30000, wantKey: "payment.invalid_invoice"},
+		{name: "malformed payload", from: 42, payload: "ext_42", currency: "RUB", amount: 30000, wantKey: "payment.invalid_invoice"},
+		{name: "unknown promo code", from: 42, payload: "ext_42_30_NOPE", currency: "RUB", amount: 30000, wantKey: "payment.promo_invalid"},
+		{name: "promo code with underscore", from: 42, payload: "ext_42_30_SUMMER_SALE", currency: "RUB", amount: 30000, wantKey: "payment.promo_invalid"},
+		{name: "unknown user", from: 44, payload: "ext_44_30", currency: "RUB", amount: 30000, wantKey: "payment.subscription_not_found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := b.validatePreCheckout(telego.PreCheckoutQuery{
+				ID:             "query",
+				From:           telego.User{ID: tt.from, FirstName: "Test"},
+				Currency:       tt.currency,
+				TotalAmount:    tt.amount,
+				InvoicePayload: tt.payload,
+			})
+
+			want := ""
+			if tt.wantKey != "" {
+				want = b.t(tt.from, tt.wantKey)
+			}
+			if got != want {
+				t.Errorf("validatePreCheckout = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestValidatePreCheckoutInvoicesDisabled(t *testing.T) {
+	b, _, _ := newTestBot(t, testConfig(t, "http://127.0.0.1:1", ""))
+
+	got := b.validatePreCheckout(telego.PreCheckoutQuery{
+		From:           telego.User{ID: 42},
+		Currency:       "RUB",
+		TotalAmount:    30000,
+		InvoicePayload: "ext_42_30",
+	})
+	if want := b.t(42, "payment.unavailable"); got != want {
+		t.Errorf("validatePreCheckout = %q, want %q", got, want)
+	}
+}
+
+func TestDuplicateSuccessfulPaymentExtendsOnce(t *testing.T) {
+	b, tg, store, panel := newPaymentsBot(t)
+	before := panel.expiry("alice")
+
+	message := telego.Message{
+		MessageID: 20,
+		From:      &telego.User{ID: 42, FirstName: "Alice"},
+		Chat:      telego.Chat{ID: 42, Type: telego.ChatTypePrivate},
+		SuccessfulPayment: &telego.SuccessfulPayment{
+			Currency:                "RUB",
+			TotalAmount:             30000,
+			InvoicePayload:          extensionInvoicePayload(42, 30, ""),
+			TelegramPaymentChargeID: "charge-1",
+			ProviderPaymentChargeID: "provider-1",
+		},
+	}
+
+	// Telegram may deliver the same update twice
+	for i := 0; i < 2; i++ {
+		if err := b.handleSuccessfulPayment(nil, message); err != nil {
+			t.Fatalf("handleSuccessfulPayment %d: %v", i+1, err)
+		}
+	}
+
+	if panel.updates != 1 {
+		t.Errorf("panel got %d client updates, want 1", panel.updates)
+	}
+	if got, want := panel.expiry("alice"), before+30*24*time.Hour.Milliseconds(); got != want {
+		t.Errorf("expiry moved by %s, want 30 days", time.Duration(got-before)*time.Millisecond)
+	}
+
+	payment, err := store.GetPaymentByChargeID("charge-1")
+	if err != nil {
+		t.Fatalf("GetPaymentByChargeID: %v", err)
+	}
+	if payment.Status != storage.PaymentStatusApplied || payment.Duration != 30 || payment.Amount != 30000 {
+		t.Errorf("payment = %+v, want an applied 30 day charge of 30000", payment)
+	}
+
+	if got := len(tg.sent("sendMessage", 42)); got != 1 {
+		t.Errorf("user got %d messages, want one about the extension", got)
+	}
+	if got := len(tg.sent("sendMessage", testAdminID)); got != 1 {
+		t.Errorf("admin got %d messages, want one about the payment", got)
+	}
+}
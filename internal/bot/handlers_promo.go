@@ -0,0 +1,357 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+	"time"
+
+	"x-ui-bot/internal/bot/constants"
+	"x-ui-bot/internal/bot/services"
+	"x-ui-bot/internal/i18n"
+	"x-ui-bot/internal/storage"
+)
+
+// Promo code handlers: admin management and entering codes during registration and extension
+
+// handlePromoAdd creates a promo code: /promo_add <CODE> <type> <value> [uses=N] [per_user=N] [until=DD.MM.YYYY]
+func (b *Bot) handlePromoAdd(chatID int64, adminID int64, args []string) {
+	t := b.tr(adminID)
+	promo, err := parsePromoArgs(args)
+	if err != nil {
+		b.sendMessage(chatID, t("promo.add_invalid", html.EscapeString(err.Error()))+t("promo.add_usage"))
+		return
+	}
+	promo.CreatedBy = adminID
+
+	if _, err := b.storage.GetPromoCode(promo.Code); err == nil {
+		b.sendMessage(chatID, t("promo.exists", promo.Code))
+		return
+	}
+
+	if err := b.storage.CreatePromoCode(promo); err != nil {
+		b.logger.Errorf("Failed to create promo code %s: %v", promo.Code, err)
+		b.sendMessage(chatID, t("promo.error_save"))
+		return
+	}
+
+	b.sendMessage(chatID, t("promo.created")+b.formatPromoCode(t, promo))
+	b.recordAudit(adminID, storage.AuditActionPromoAdd, "", 0, "", promoAuditValue(promo))
+	b.logger.Infof("Admin %d created promo code %s (%s %d)", adminID, promo.Code, promo.Type, promo.Value)
+}
+
+// handlePromoList shows all promo codes with their usage
+func (b *Bot) handlePromoList(chatID int64) {
+	t := b.tr(chatID)
+	promos, err := b.storage.GetAllPromoCodes()
+	if err != nil {
+		b.logger.Errorf("Failed to get promo codes: %v", err)
+		b.sendMessage(chatID, t("promo.error_list"))
+		return
+	}
+
+	if len(promos) == 0 {
+		b.sendMessage(chatID, t("promo.list_empty"))
+		return
+	}
+
+	var sb strings.Builder
+	sb.WriteString(t("promo.list_title"))
+	for _, promo := range promos {
+		sb.WriteString("\n")
+		sb.WriteString(b.formatPromoCode(t, promo))
+		sb.WriteString("\n")
+	}
+	sb.WriteString(t("promo.list_delete_hint"))
+	b.sendMessage(chatID, sb.String())
+}
+
+// handlePromoDelete deletes a promo code: /promo_del <CODE>
+func (b *Bot) handlePromoDelete(chatID int64, adminID int64, args []string) {
+	t := b.tr(adminID)
+	if len(args) != 1 {
+		b.sendMessage(chatID, t("promo.delete_usage"))
+		return
+	}
+
+	code := services.NormalizePromoCode(args[0])
+	before := code
+	if promo, err := b.storage.GetPromoCode(code); err == nil {
+		before = promoAuditValue(promo)
+	}
+	if err := b.storage.DeletePromoCode(code); err != nil {
+		b.sendMessage(chatID, t("promo.code_not_found", html.EscapeString(code)))
+		return
+	}
+
+	b.sendMessage(chatID, t("promo.deleted", code))
+	b.recordAudit(adminID, storage.AuditActionPromoDelete, "", 0, before, "")
+	b.logger.Infof("Promo code %s deleted by admin %d", code, adminID)
+}
+
+// promoAuditValue describes a promo code for the audit log
+func promoAuditValue(promo *storage.PromoCode) string {
+	return fmt.Sprintf("%s %s=%d max=%d per_user=%d", promo.Code, promo.Type, promo.Value, promo.MaxUses, promo.PerUserLimit)
+}
+
+// handlePromoPrompt asks the user for a promo code during registration or extension
+func (b *Bot) handlePromoPrompt(chatID int64, userID int64, state string) {
+	t := b.tr(userID)
+	if state == constants.StateAwaitingRegPromo {
+		if req, exists := b.getRegistrationRequest(userID); !exists || req.Status != "input_duration" {
+			b.sendMessage(chatID, t("registration.not_found_restart"))
+			return
+		}
+	}
+
+	if err := b.setUserState(chatID, state); err != nil {
+		b.sendMessage(chatID, t("common.error_state"))
+		return
+	}
+	b.sendMessage(chatID, t("promo.enter"))
+}
+
+// handlePromoInput checks the entered promo code and shows the plans again, with the discount if the code is valid
+func (b *Bot) handlePromoInput(chatID int64, userID int64, text string, state string) {
+	t := b.tr(userID)
+	promo, err := b.promoService.Validate(text, userID)
+	if err != nil {
+		b.sendMessage(chatID, promoErrorText(t, err))
+		promo = nil
+	} else {
+		b.sendMessage(chatID, t("promo.applied", promo.Code, b.promoEffectText(t, promo)))
+	}
+
+	if state == constants.StateAwaitingRegPromo {
+		req, exists := b.getRegistrationRequest(userID)
+		if !exists {
+			b.sendMessage(chatID, t("registration.not_found_restart"))
+			if err := b.deleteUserState(chatID); err != nil {
+				b.logger.Errorf("Failed to delete user state: %v", err)
+			}
+			return
+		}
+		if err := b.setUserState(chatID, constants.StateAwaitingDuration); err != nil {
+			b.sendMessage(chatID, t("common.error_state"))
+			return
+		}
+		b.sendRegistrationDurations(chatID, userID, req.Email, promo)
+		return
+	}
+
+	if err := b.deleteUserState(chatID); err != nil {
+		b.logger.Errorf("Failed to delete user state: %v", err)
+	}
+	b.handleExtendSubscription(chatID, userID, promo)
+}
+
+// priceQuote prices a period for the user, applying the promo code when code is not empty
+func (b *Bot) priceQuote(userID int64, duration int, code string) (services.Quote, error) {
+	price := b.cfg().Payment.Prices.ForDuration(duration)
+	if code == "" {
+		return services.ApplyPromo(nil, duration, price), nil
+	}
+
+	promo, err := b.promoService.Validate(code, userID)
+	if err != nil {
+		return services.Quote{}, err
+	}
+	return services.ApplyPromo(promo, duration, price), nil
+}
+
+// redeemPromo counts a paid period against its promo code.
+// The user was charged the quoted price already, so a code used up in the meantime is only logged
+func (b *Bot) redeemPromo(userID int64, purpose string, quote services.Quote) {
+	if err := b.promoService.Redeem(userID, purpose, quote); err != nil {
+		b.logger.Warnf("Failed to redeem promo code %s for user %d: %v", quote.Code, userID, err)
+	}
+}
+
+// applyFreeExtension extends a subscription made free by a promo code: there is nothing to pay
+// or to check, so neither an invoice nor an admin request is needed. The code is redeemed first,
+// which is what keeps a repeated tap from extending twice
+func (b *Bot) applyFreeExtension(chatID int64, messageID int, userID int64, quote services.Quote) {
+	t := b.tr(userID)
+	if err := b.promoService.Redeem(userID, storage.PromoPurposeExtension, quote); err != nil {
+		b.logger.Warnf("Failed to redeem promo code %s for user %d: %v", quote.Code, userID, err)
+		b.editMessageText(chatID, messageID, t("promo.error_check")+t("extension.reopen"))
+		return
+	}
+
+	b.editMessageText(chatID, messageID, t("promo.free_extension", quote.TotalDays(), b.promoQuoteLines(t, quote)))
+	result, err := b.extendSubscription(userID, quote.TotalDays())
+	if err != nil {
+		b.logger.Errorf("Failed to apply free extension of user %d: %v", userID, err)
+		b.sendMessage(chatID, t("promo.free_extension_failed"))
+	} else {
+		b.recordAudit(userID, storage.AuditActionExtend, result.Email, userID,
+			time.UnixMilli(result.OldExpiry).Format("02.01.2006 15:04"),
+			time.UnixMilli(result.NewExpiry).Format("02.01.2006 15:04"),
+		)
+		b.setUserTrial(userID, false)
+	}
+
+	userName, tgUsername := b.getUserInfo(userID)
+	tgUsernameStr := ""
+	if tgUsername != "" {
+		tgUsernameStr = " (" + tgUsername + ")"
+	}
+	for _, adminID := range b.cfg().Telegram.AdminIDs {
+		at := b.tr(adminID)
+		if err != nil {
+			b.sendMessage(adminID, at("promo.admin_free_extension_failed",
+				html.EscapeString(userName), html.EscapeString(tgUsernameStr), userID,
+				quote.TotalDays(), b.promoQuoteLines(at, quote), html.EscapeString(err.Error())))
+			continue
+		}
+		b.sendMessage(adminID, at("promo.admin_free_extension",
+			html.EscapeString(userName), html.EscapeString(tgUsernameStr), html.EscapeString(result.Email),
+			quote.TotalDays(), b.promoQuoteLines(at, quote), time.UnixMilli(result.NewExpiry).Format("02.01.2006 15:04")))
+	}
+}
+
+// promoErrorText returns the user message for a promo code validation error
+func promoErrorText(t i18n.Translator, err error) string {
+	switch {
+	case errors.Is(err, services.ErrPromoNotFound):
+		return t("promo.error_not_found")
+	case errors.Is(err, services.ErrPromoExpired):
+		return t("promo.error_expired")
+	case errors.Is(err, services.ErrPromoUsedUp):
+		return t("promo.error_used_up")
+	case errors.Is(err, services.ErrPromoUserLimit):
+		return t("promo.error_user_limit")
+	default:
+		return t("promo.error_check")
+	}
+}
+
+// promoEffectText describes what a promo code gives: "-20%", "-100 RUB" or "+7 days"
+func (b *Bot) promoEffectText(t i18n.Translator, promo *storage.PromoCode) string {
+	switch promo.Type {
+	case storage.PromoTypePercent:
+		return t("promo.effect_percent", promo.Value)
+	case storage.PromoTypeFixed:
+		return t("promo.effect_fixed", promo.Value, b.cfg().Payment.Currency)
+	case storage.PromoTypeDays:
+		return "+" + t("common.days", promo.Value)
+	}
+	return promo.Type
+}
+
+// promoQuoteLines returns the message lines describing the promo code of a quote, empty without one
+func (b *Bot) promoQuoteLines(t i18n.Translator, quote services.Quote) string {
+	if quote.Code == "" {
+		return ""
+	}
+
+	lines := t("promo.quote_code", quote.Code)
+	if quote.Discounted() {
+		lines += t("promo.quote_base_price", quote.BasePrice, b.cfg().Payment.Currency)
+	}
+	if quote.BonusDays > 0 {
+		lines += t("promo.quote_bonus", quote.BonusDays)
+	}
+	return lines
+}
+
+// formatPromoCode formats a promo code for admins
+func (b *Bot) formatPromoCode(t i18n.Translator, promo *storage.PromoCode) string {
+	uses := fmt.Sprintf("%d", promo.Uses)
+	if promo.MaxUses > 0 {
+		uses = fmt.Sprintf("%d/%d", promo.Uses, promo.MaxUses)
+	}
+
+	perUser := t("promo.no_limit")
+	if promo.PerUserLimit > 0 {
+		perUser = strconv.Itoa(promo.PerUserLimit)
+	}
+
+	expires := t("promo.no_expiry")
+	if !promo.ExpiresAt.IsZero() {
+		expires = t("promo.expires", promo.ExpiresAt.Format("02.01.2006"))
+		if time.Now().After(promo.ExpiresAt) {
+			expires += t("promo.expired_mark")
+		}
+	}
+
+	return t("promo.line",
+		promo.Code,
+		b.promoEffectText(t, promo),
+		uses,
+		perUser,
+		expires,
+	)
+}
+
+// parsePromoArgs parses the arguments of /promo_add
+func parsePromoArgs(args []string) (*storage.PromoCode, error) {
+	if len(args) < 3 {
+		return nil, fmt.Errorf("not enough arguments")
+	}
+
+	code := services.NormalizePromoCode(args[0])
+	if !services.ValidPromoCode(code) {
+		return nil, fmt.Errorf("code must be 3-20 Latin letters and digits")
+	}
+
+	promo := &storage.PromoCode{
+		Code:         code,
+		Type:         strings.ToLower(args[1]),
+		PerUserLimit: 1,
+	}
+
+	value, err := strconv.Atoi(args[2])
+	if err != nil || value <= 0 {
+		return nil, fmt.Errorf("value must be a positive number")
+	}
+	promo.Value = value
+
+	switch promo.Type {
+	case storage.PromoTypePercent:
+		if value > 100 {
+			return nil, fmt.Errorf("percent discount cannot exceed 100")
+		}
+	case storage.PromoTypeFixed, storage.PromoTypeDays:
+	default:
+		return nil, fmt.Errorf("unknown type %q", args[1])
+	}
+
+	for _, arg := range args[3:] {
+		key, val, ok := strings.Cut(arg, "=")
+		if !ok {
+			return nil, fmt.Errorf("unexpected argument %q", arg)
+		}
+
+		switch strings.ToLower(key) {
+		case "uses":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("uses must be a non-negative number")
+			}
+			promo.MaxUses = n
+		case "per_user":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("per_user must be a non-negative number")
+			}
+			promo.PerUserLimit = n
+		case "until":
+			day, err := time.ParseInLocation("02.01.2006", val, time.Local)
+			if err != nil {
+				return nil, fmt.Errorf("until must be a date like 31.12.2026")
+			}
+			// The code stays valid through the whole last day
+			promo.ExpiresAt = day.Add(24*time.Hour - time.Second)
+			if time.Now().After(promo.ExpiresAt) {
+				return nil, fmt.Errorf("until is in the past")
+			}
+		default:
+			return nil, fmt.Errorf("unknown option %q", key)
+		}
+	}
+
+	return promo, nil
+}
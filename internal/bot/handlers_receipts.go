@@ -0,0 +1,217 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+
+	"x-ui-bot/internal/bot/constants"
+	"x-ui-bot/internal/storage"
+
+	"github.com/mymmrac/telego"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+// Receipt handlers for manually paid extension requests
+
+// handleReceiptUpload attaches a screenshot or PDF receipt to the user's open extension request
+// and sends the request to admins
+func (b *Bot) handleReceiptUpload(chatID int64, userID int64, message *telego.Message) {
+	t := b.tr(userID)
+	req, err := b.storage.GetOpenExtensionRequest(userID)
+	if err != nil {
+		b.sendMessage(chatID, t("receipt.request_not_found"))
+		if err := b.deleteUserState(chatID); err != nil {
+			b.logger.Errorf("Failed to delete user state: %v", err)
+		}
+		return
+	}
+
+	receipt := &storage.Receipt{
+		RequestID: req.ID,
+		UserID:    userID,
+	}
+	switch {
+	case len(message.Photo) > 0:
+		// Get the largest photo
+		receipt.FileID = message.Photo[len(message.Photo)-1].FileID
+		receipt.FileType = storage.ReceiptTypePhoto
+	case message.Document != nil && isReceiptDocument(message.Document):
+		receipt.FileID = message.Document.FileID
+		receipt.FileType = storage.ReceiptTypeDocument
+		receipt.FileName = message.Document.FileName
+		receipt.MimeType = message.Document.MimeType
+	default:
+		b.sendMessage(chatID, t("receipt.wrong_file"))
+		return
+	}
+
+	if err := b.storage.AddReceipt(receipt); err != nil {
+		b.logger.Errorf("Failed to save receipt for extension request %d: %v", req.ID, err)
+		b.sendMessage(chatID, t("receipt.error_save"))
+		return
+	}
+
+	if err := b.storage.SetExtensionRequestStatus(req.ID, storage.ExtensionStatusPending); err != nil {
+		b.logger.Errorf("Failed to update extension request %d: %v", req.ID, err)
+	}
+	b.sendExtensionRequestToAdmins(req, receipt)
+
+	if err := b.deleteUserState(chatID); err != nil {
+		b.logger.Errorf("Failed to delete user state: %v", err)
+	}
+
+	b.sendMessage(chatID, t("receipt.received"))
+	b.logger.Infof("Receipt %d attached to extension request %d of user %d", receipt.ID, req.ID, userID)
+}
+
+// handleExtensionWithoutReceipt sends an open extension request to admins without a receipt
+func (b *Bot) handleExtensionWithoutReceipt(chatID int64, userID int64, messageID int, requestID int64) {
+	req, err := b.storage.GetExtensionRequest(requestID)
+	if err != nil || req.UserID != userID || req.Status != storage.ExtensionStatusAwaitingReceipt {
+		b.editMessageText(chatID, messageID, b.t(userID, "receipt.request_sent_or_missing"))
+		return
+	}
+
+	if err := b.storage.SetExtensionRequestStatus(req.ID, storage.ExtensionStatusPending); err != nil {
+		b.logger.Errorf("Failed to update extension request %d: %v", req.ID, err)
+	}
+	b.sendExtensionRequestToAdmins(req, nil)
+
+	if err := b.deleteUserState(chatID); err != nil {
+		b.logger.Errorf("Failed to delete user state: %v", err)
+	}
+
+	b.editMessageText(chatID, messageID, b.t(userID, "receipt.sent_without_receipt", req.Duration))
+}
+
+// sendExtensionRequestToAdmins sends the approval card of an extension request to all admins,
+// the receipt (if any) is sent as the card itself
+func (b *Bot) sendExtensionRequestToAdmins(req *storage.ExtensionRequest, receipt *storage.Receipt) {
+	userName, tgUsername := b.getUserInfo(req.UserID)
+
+	email := ""
+	if user, ok := b.getUser(req.UserID); ok {
+		email = user.Email
+	}
+
+	tgUsernameStr := ""
+	if tgUsername != "" {
+		tgUsernameStr = fmt.Sprintf("\n💬 Telegram: %s", html.EscapeString(tgUsername))
+	}
+
+	for _, adminID := range b.cfg().Telegram.AdminIDs {
+		t := b.tr(adminID)
+
+		receiptStr := t("receipt.missing")
+		if receipt != nil {
+			receiptStr = t("receipt.attached")
+		}
+
+		caption := t("receipt.request",
+			html.EscapeString(userName),
+			req.UserID,
+			tgUsernameStr,
+			html.EscapeString(email),
+			req.Duration,
+			req.Price,
+			b.cfg().Payment.Currency,
+			b.promoQuoteLines(t, b.extensionQuote(req)),
+			receiptStr,
+		)
+
+		keyboard := tu.InlineKeyboard(
+			tu.InlineKeyboardRow(
+				tu.InlineKeyboardButton(t("button.approve")).WithCallbackData(fmt.Sprintf("%s%d_%d_%d", constants.CbApproveExtPrefix, req.UserID, req.Duration, req.ID)),
+				tu.InlineKeyboardButton(t("button.decline")).WithCallbackData(fmt.Sprintf("%s%d_%d", constants.CbRejectExtPrefix, req.UserID, req.ID)),
+			),
+		)
+
+		var err error
+		switch {
+		case receipt == nil:
+			_, err = b.bot.SendMessage(context.Background(), tu.Message(tu.ID(adminID), caption).
+				WithReplyMarkup(keyboard).
+				WithParseMode(telego.ModeHTML))
+		case receipt.FileType == storage.ReceiptTypePhoto:
+			_, err = b.bot.SendPhoto(context.Background(), &telego.SendPhotoParams{
+				ChatID:      tu.ID(adminID),
+				Photo:       tu.FileFromID(receipt.FileID),
+				Caption:     caption,
+				ParseMode:   telego.ModeHTML,
+				ReplyMarkup: keyboard,
+			})
+		default:
+			_, err = b.bot.SendDocument(context.Background(), &telego.SendDocumentParams{
+				ChatID:      tu.ID(adminID),
+				Document:    tu.FileFromID(receipt.FileID),
+				Caption:     caption,
+				ParseMode:   telego.ModeHTML,
+				ReplyMarkup: keyboard,
+			})
+		}
+
+		if err != nil {
+			b.logger.Errorf("Failed to send extension request to admin %d: %v", adminID, err)
+		} else {
+			b.logger.Infof("Sent extension request %d to admin %d", req.ID, adminID)
+		}
+	}
+}
+
+// claimExtensionRequest records the admin decision on an extension request before it is carried out, so that
+// of two admins deciding at the same time only one acts on it. The other one is told the request is already decided
+func (b *Bot) claimExtensionRequest(adminChatID int64, requestID int64, status string) bool {
+	if requestID == 0 {
+		return true
+	}
+
+	claimed, err := b.storage.DecideExtensionRequest(requestID, status, adminChatID)
+	if err != nil {
+		b.logger.Errorf("Failed to record decision on extension request %d: %v", requestID, err)
+		b.sendMessage(adminChatID, b.t(adminChatID, "common.error", html.EscapeString(err.Error())))
+		return false
+	}
+	if claimed {
+		return true
+	}
+
+	key := "receipt.already_closed"
+	if req, err := b.storage.GetExtensionRequest(requestID); err == nil {
+		switch req.Status {
+		case storage.ExtensionStatusApproved:
+			key = "receipt.already_approved"
+		case storage.ExtensionStatusRejected:
+			key = "receipt.already_rejected"
+		}
+	}
+	b.sendMessage(adminChatID, b.t(adminChatID, key))
+	return false
+}
+
+// releaseExtensionRequest reopens a request claimed by an approval that could not be carried out
+func (b *Bot) releaseExtensionRequest(requestID int64) {
+	if requestID == 0 {
+		return
+	}
+	if err := b.storage.SetExtensionRequestStatus(requestID, storage.ExtensionStatusPending); err != nil {
+		b.logger.Errorf("Failed to reopen extension request %d: %v", requestID, err)
+	}
+}
+
+// editRequestCard replaces the admin card of an extension request, cards with a receipt are media captions
+func (b *Bot) editRequestCard(chatID int64, messageID int, requestID int64, text string) {
+	if requestID != 0 {
+		if receipts, err := b.storage.GetReceipts(requestID); err == nil && len(receipts) > 0 {
+			b.editMessageCaption(chatID, messageID, text)
+			return
+		}
+	}
+	b.editMessageText(chatID, messageID, text)
+}
+
+// isReceiptDocument reports whether a document looks like a receipt: a PDF or an image
+func isReceiptDocument(doc *telego.Document) bool {
+	return doc.MimeType == "application/pdf" || strings.HasPrefix(doc.MimeType, "image/")
+}
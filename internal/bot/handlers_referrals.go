@@ -0,0 +1,129 @@
+package bot
+
+import (
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+
+	"x-ui-bot/internal/bot/constants"
+	"x-ui-bot/internal/storage"
+)
+
+// Referral program handlers: deep-link tracking, referrer bonuses and referral stats
+
+// recordReferral stores the referrer from a /start ref_<tgid> payload.
+// Only users without a subscription can be referred, and only by registered users
+func (b *Bot) recordReferral(userID int64, payload string) {
+	if !b.cfg().Referral.Enabled() || !strings.HasPrefix(payload, constants.StartReferralPrefix) {
+		return
+	}
+
+	referrerID, err := strconv.ParseInt(strings.TrimPrefix(payload, constants.StartReferralPrefix), 10, 64)
+	if err != nil || referrerID == userID {
+		return
+	}
+
+	if _, registered := b.getUser(userID); registered {
+		return
+	}
+	if _, exists := b.getUser(referrerID); !exists {
+		b.logger.Warnf("User %d started with referral link of unknown user %d", userID, referrerID)
+		return
+	}
+
+	if err := b.storage.AddReferral(&storage.Referral{
+		ReferredID: userID,
+		ReferrerID: referrerID,
+	}); err != nil {
+		b.logger.Errorf("Failed to record referral of user %d by %d: %v", userID, referrerID, err)
+		return
+	}
+
+	b.logger.Infof("User %d started the bot with referral link of user %d", userID, referrerID)
+}
+
+// rewardReferrer credits the referrer of the user with bonus days, once, on the user's first paid subscription
+func (b *Bot) rewardReferrer(userID int64) {
+	bonusDays := b.cfg().Referral.BonusDays
+	if bonusDays <= 0 {
+		return
+	}
+
+	referral, err := b.storage.GetReferral(userID)
+	if err != nil || !referral.RewardedAt.IsZero() {
+		return
+	}
+
+	// Unlimited subscriptions have no expiry to move
+	_, referrer, err := b.findClientByTgID(referral.ReferrerID)
+	if err != nil {
+		b.logger.Warnf("Referrer %d of user %d has no subscription, bonus not credited", referral.ReferrerID, userID)
+		return
+	}
+	if referrer.ExpiryTime == 0 {
+		b.logger.Infof("Referrer %d of user %d has an unlimited subscription, bonus not credited", referral.ReferrerID, userID)
+		return
+	}
+
+	// Claim the reward first so a concurrent approval cannot credit it twice
+	claimed, err := b.storage.MarkReferralRewarded(userID, bonusDays)
+	if err != nil {
+		b.logger.Errorf("Failed to mark referral of user %d as rewarded: %v", userID, err)
+		return
+	}
+	if !claimed {
+		return
+	}
+
+	if _, err := b.extendSubscription(referral.ReferrerID, bonusDays); err != nil {
+		b.logger.Errorf("Failed to credit referral bonus to user %d: %v", referral.ReferrerID, err)
+		for _, adminID := range b.cfg().Telegram.AdminIDs {
+			b.sendMessage(adminID, b.t(adminID, "referral.bonus_failed",
+				bonusDays,
+				referral.ReferrerID,
+				userID,
+				html.EscapeString(err.Error()),
+			))
+		}
+		return
+	}
+
+	b.sendMessage(referral.ReferrerID, b.t(referral.ReferrerID, "referral.bonus_credited", bonusDays))
+	b.logger.Infof("Referral bonus of %d days credited to user %d for user %d", bonusDays, referral.ReferrerID, userID)
+}
+
+// handleReferralInfo shows the user's referral link and stats
+func (b *Bot) handleReferralInfo(chatID int64, userID int64) {
+	t := b.tr(userID)
+	if !b.cfg().Referral.Enabled() {
+		b.sendMessage(chatID, t("referral.disabled"))
+		return
+	}
+
+	if _, registered := b.getUser(userID); !registered {
+		b.sendMessage(chatID, t("common.not_registered"))
+		return
+	}
+
+	if b.username == "" {
+		b.sendMessage(chatID, t("referral.link_unavailable"))
+		return
+	}
+
+	stats, err := b.storage.GetReferralStats(userID)
+	if err != nil {
+		b.logger.Errorf("Failed to get referral stats of user %d: %v", userID, err)
+		b.sendMessage(chatID, t("referral.error_stats"))
+		return
+	}
+
+	link := fmt.Sprintf("https://t.me/%s?start=%s%d", b.username, constants.StartReferralPrefix, userID)
+	b.sendMessage(chatID, t("referral.info",
+		b.cfg().Referral.BonusDays,
+		link,
+		stats.Invited,
+		stats.Rewarded,
+		stats.BonusDays,
+	))
+}
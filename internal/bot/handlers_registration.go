@@ -9,19 +9,30 @@ import (
 	"unicode/utf8"
 
 	"x-ui-bot/internal/bot/constants"
+	"x-ui-bot/internal/bot/middleware"
+	"x-ui-bot/internal/bot/services"
+	"x-ui-bot/internal/i18n"
+	"x-ui-bot/internal/storage"
 
+	"github.com/mymmrac/telego"
 	tu "github.com/mymmrac/telego/telegoutil"
 )
 
 // Registration handlers for user registration process
 
 // handleRegistrationStart initiates the registration process
-func (b *Bot) handleRegistrationStart(chatID int64, userID int64, userName string, tgUsername string) {
+// language is the user's language, saved with the user record once registered
+func (b *Bot) handleRegistrationStart(chatID int64, userID int64, userName string, tgUsername string, language string) {
 	b.logger.Infof("Registration started by user %d", userID)
+	t := b.tr(userID)
+
+	if !b.checkRateLimit(chatID, userID, middleware.ActionRegistration) {
+		return
+	}
 
 	// Check if user already has pending request
 	if req, exists := b.getRegistrationRequest(userID); exists && req.Status == "pending" {
-		b.sendMessage(chatID, "⏳ У вас уже есть активная заявка на регистрацию. Дождитесь ответа администратора.")
+		b.sendMessage(chatID, t("registration.already_pending"))
 		return
 	}
 
@@ -31,91 +42,114 @@ func (b *Bot) handleRegistrationStart(chatID int64, userID int64, userName strin
 		Username:   userName,
 		TgUsername: tgUsername,
 		Status:     "input_email",
+		Language:   language,
 		Timestamp:  time.Now(),
 	}); err != nil {
-		b.sendMessage(chatID, "❌ Ошибка сохранения заявки")
+		b.sendMessage(chatID, t("registration.error_save"))
 		return
 	}
 
 	if err := b.setUserState(chatID, constants.StateAwaitingEmail); err != nil {
-		b.sendMessage(chatID, "❌ Ошибка сохранения состояния")
+		b.sendMessage(chatID, t("common.error_state"))
 		return
 	}
-	b.sendMessage(chatID, "📝 Регистрация нового клиента\n\n🔹 Шаг 1/2: Введите желаемый username:")
+	b.sendMessage(chatID, t("registration.step_username"))
 }
 
 // handleRegistrationEmail processes email input
 func (b *Bot) handleRegistrationEmail(chatID int64, userID int64, email string) {
+	t := b.tr(userID)
 	req, exists := b.getRegistrationRequest(userID)
 
 	if !exists {
-		b.sendMessage(chatID, "❌ Ошибка: регистрация не найдена. Начните заново.")
+		b.sendMessage(chatID, t("registration.not_found_restart"))
 		return
 	}
 
-	// Validate username - check if not empty and length
+	// Validate username - check if not empty and length. A menu button pressed instead of typing is not a username
 	email = strings.TrimSpace(email)
-	if email == "" || strings.Contains(strings.ToLower(email), "зарегистрироваться") {
-		b.sendMessage(chatID, "❌ Username не может быть пустым.\n\nВведите корректный username:")
+	if email == "" || b.isKeyboardButton(email) {
+		b.sendMessage(chatID, t("registration.username_empty"))
 		return
 	}
 
 	// Check for ## suffix - forbidden for user input
 	if strings.Contains(email, "##") {
-		b.sendMessage(chatID, "❌ Username не может содержать символы ##\n\nЭто служебные символы системы.\n\nВведите другой username:")
+		b.sendMessage(chatID, t("registration.username_reserved"))
 		return
 	}
 
 	// Validate username length (3-32 characters, count actual characters not bytes)
 	usernameLength := utf8.RuneCountInString(email)
 	if usernameLength < 3 {
-		b.sendMessage(chatID, "❌ Username слишком короткий. Минимум 3 символа.\n\nВведите другой username:")
+		b.sendMessage(chatID, t("registration.username_too_short"))
 		return
 	}
 	if usernameLength > 32 {
-		b.sendMessage(chatID, "❌ Username слишком длинный. Максимум 32 символа.\n\nВведите другой username:")
+		b.sendMessage(chatID, t("registration.username_too_long"))
 		return
 	}
 
 	req.Email = email
 	req.Status = "input_duration"
 	if err := b.setRegistrationRequest(userID, req); err != nil {
-		b.sendMessage(chatID, "❌ Ошибка сохранения заявки")
+		b.sendMessage(chatID, t("registration.error_save"))
 		return
 	}
 	if err := b.setUserState(chatID, constants.StateAwaitingDuration); err != nil {
-		b.sendMessage(chatID, "❌ Ошибка сохранения состояния")
+		b.sendMessage(chatID, t("common.error_state"))
 		return
 	}
 
+	b.sendRegistrationDurations(chatID, userID, email, nil)
+}
+
+// sendRegistrationDurations shows the plans of registration step 2, with the discount of promo if not nil
+func (b *Bot) sendRegistrationDurations(chatID int64, userID int64, email string, promo *storage.PromoCode) {
 	// Check if user has had previous subscriptions - trial only for first purchase
-	isFirstPurchase := true
-	_, err := b.apiClient.GetClientByTgID(context.Background(), userID)
-	if err == nil {
-		// User already exists - not first purchase
-		isFirstPurchase = false
-	}
+	// User already exists - not first purchase
+	_, registered := b.getUser(userID)
+	isFirstPurchase := !registered
 
-	keyboard := b.createDurationKeyboard(constants.CbRegDurationBase, isFirstPurchase)
+	t := b.tr(userID)
+	keyboard := b.createDurationKeyboard(t, constants.CbRegDurationBase, isFirstPurchase, promo)
 
-	msg := fmt.Sprintf("✅ Username: %s\n\n🔹 Шаг 2/2: Выберите срок действия:", email)
+	msg := t("registration.step_duration", email)
 	if _, err := b.bot.SendMessage(context.Background(), tu.Message(tu.ID(chatID), msg).WithReplyMarkup(keyboard)); err != nil {
 		b.logger.Errorf("Failed to send duration selection to user %d: %v", chatID, err)
 	}
 }
 
-// handleRegistrationDuration processes duration selection
-func (b *Bot) handleRegistrationDuration(userID int64, chatID int64, duration int) {
+// handleRegistrationDuration processes duration selection, promoCode is empty when no promo code was entered
+func (b *Bot) handleRegistrationDuration(userID int64, chatID int64, duration int, promoCode string) {
+	t := b.tr(userID)
 	req, exists := b.getRegistrationRequest(userID)
 	if !exists {
-		b.sendMessage(chatID, "❌ Ошибка: регистрация не найдена")
+		b.sendMessage(chatID, t("registration.not_found"))
 		return
 	}
 
+	isTrial := (duration == b.cfg().Payment.TrialDays && b.cfg().Payment.TrialDays > 0)
+
+	// Promo codes apply to paid plans only, the code is checked again as it may have run out since it was entered
+	quote := services.Quote{Duration: duration}
+	if !isTrial {
+		var err error
+		quote, err = b.priceQuote(userID, duration, promoCode)
+		if err != nil {
+			b.sendMessage(chatID, promoErrorText(t, err)+t("registration.choose_again"))
+			b.sendRegistrationDurations(chatID, userID, req.Email, nil)
+			return
+		}
+	}
+
 	req.Duration = duration
+	req.Price = quote.Price
+	req.PromoCode = quote.Code
+	req.BonusDays = quote.BonusDays
 	req.Status = "pending"
 	if err := b.setRegistrationRequest(userID, req); err != nil {
-		b.sendMessage(chatID, "❌ Ошибка сохранения заявки")
+		b.sendMessage(chatID, t("registration.error_save"))
 		return
 	}
 
@@ -123,75 +157,31 @@ func (b *Bot) handleRegistrationDuration(userID int64, chatID int64, duration in
 		b.logger.Errorf("Failed to delete user state: %v", err)
 	}
 
-	// Determine price based on duration
-	var price int
-	isTrial := (duration == b.config.Payment.TrialDays && b.config.Payment.TrialDays > 0)
-
 	// Check if trial auto-approval is enabled
-	if isTrial && b.config.Payment.AutoApproveTrial {
+	if isTrial && b.cfg().Payment.AutoApproveTrial {
 		// Auto-approve trial subscription
 		b.logger.Infof("Auto-approving trial subscription for user %d", userID)
 		go b.autoApproveRegistration(req)
 
 		// Show pending message to user
-		trialText := b.config.Payment.TrialText
-		if trialText == "" {
-			trialText = fmt.Sprintf("%d дня", duration)
-		}
-		b.sendMessage(chatID, fmt.Sprintf(
-			"✅ Заявка на пробный период принята!\n\n"+
-				"🎁 <b>Пробный период: %s БЕСПЛАТНО</b>\n\n"+
-				"⏳ Настройка аккаунта... Вы получите данные для подключения через несколько секунд.",
-			trialText,
-		))
+		b.sendMessage(chatID, t("registration.trial_accepted", b.trialText(t, duration)))
 		return
 	}
 
 	// Send request to admins
 	b.sendRegistrationRequestToAdmins(req)
 
-	if isTrial {
-		price = 0
-	} else {
-		switch duration {
-		case 30:
-			price = b.config.Payment.Prices.OneMonth
-		case 90:
-			price = b.config.Payment.Prices.ThreeMonth
-		case 180:
-			price = b.config.Payment.Prices.SixMonth
-		case 365:
-			price = b.config.Payment.Prices.OneYear
-		}
-	}
-
 	var paymentMsg string
 	if isTrial {
-		trialText := b.config.Payment.TrialText
-		if trialText == "" {
-			trialText = fmt.Sprintf("%d дня", duration)
-		}
-		paymentMsg = fmt.Sprintf(
-			"✅ Заявка на пробный период отправлена!\n\n"+
-				"🎁 <b>Пробный период: %s БЕСПЛАТНО</b>\n\n"+
-				"⏳ Ожидайте подтверждения от администратора.\n\n"+
-				"<i>Оплата не требуется. После активации вы получите доступ к VPN на %s.</i>",
-			trialText,
-			trialText,
-		)
+		trialText := b.trialText(t, duration)
+		paymentMsg = t("registration.trial_sent", trialText, trialText)
 	} else {
-		paymentMsg = fmt.Sprintf(
-			"✅ Заявка отправлена!\n\n"+
-				"⏳ Ожидайте подтверждения от администратора.\n\n"+
-				"💳 <b>Реквизиты для оплаты:</b>\n"+
-				"🏦 Банк: %s\n"+
-				"📱 Номер: %s\n"+
-				"💰 Сумма: %d₽\n\n"+
-				"✍️ В комментарии укажите свой username.\n\n"+
-				"<i>После оплаты дождитесь подтверждения от администратора.</i>",
-			html.EscapeString(b.config.Payment.Bank),
-			b.config.Payment.PhoneNumber,
-			price,
+		paymentMsg = t("registration.sent",
+			html.EscapeString(b.cfg().Payment.Bank),
+			b.cfg().Payment.PhoneNumber,
+			quote.Price,
+			b.cfg().Payment.Currency,
+			b.promoQuoteLines(t, quote),
 		)
 	}
 
@@ -202,48 +192,64 @@ func (b *Bot) handleRegistrationDuration(userID int64, chatID int64, duration in
 func (b *Bot) sendRegistrationRequestToAdmins(req *RegistrationRequest) {
 	b.logger.Debugf("Sending registration to admins - UserID: %d, TgUsername: '%s'", req.UserID, req.TgUsername)
 
-	// Format Telegram username
-	tgUsernameStr := ""
-	if req.TgUsername != "" {
-		tgUsernameStr = fmt.Sprintf("\n💬 Telegram: @%s", req.TgUsername)
-	}
-
 	// Check if this is a trial subscription
-	isTrial := (req.Duration == b.config.Payment.TrialDays && b.config.Payment.TrialDays > 0)
-	trialTag := ""
-	if isTrial {
-		trialTag = " 🎁 ПРОБНЫЙ ПЕРИОД"
-	}
-
-	// Determine correct plural form
-	durationText := fmt.Sprintf("%d дней", req.Duration)
-	if req.Duration <= 4 {
-		durationText = fmt.Sprintf("%d дня", req.Duration)
-	}
-
-	msg := fmt.Sprintf(
-		"📝 Новая заявка на регистрацию%s\n\n"+
-			"👤 Пользователь: %s (ID: %d)%s\n"+
-			"👤 Username: %s\n"+
-			"📅 Срок: %s\n"+
-			"🕐 Время: %s",
-		trialTag,
-		req.Username,
-		req.UserID,
-		tgUsernameStr,
-		req.Email,
-		durationText,
-		req.Timestamp.Format("02.01.2006 15:04"),
-	)
-
-	keyboard := tu.InlineKeyboard(
-		tu.InlineKeyboardRow(
-			tu.InlineKeyboardButton("✅ Одобрить").WithCallbackData(fmt.Sprintf("approve_reg_%d", req.UserID)),
-			tu.InlineKeyboardButton("❌ Отклонить").WithCallbackData(fmt.Sprintf("reject_reg_%d", req.UserID)),
-		),
-	)
-
-	for _, adminID := range b.config.Telegram.AdminIDs {
+	isTrial := (req.Duration == b.cfg().Payment.TrialDays && b.cfg().Payment.TrialDays > 0)
+
+	for _, adminID := range b.cfg().Telegram.AdminIDs {
+		t := b.tr(adminID)
+
+		// Format Telegram username
+		tgUsernameStr := ""
+		if req.TgUsername != "" {
+			tgUsernameStr = fmt.Sprintf("\n💬 Telegram: @%s", req.TgUsername)
+		}
+
+		trialTag := ""
+		if isTrial {
+			trialTag = t("registration.trial_tag")
+		}
+
+		// Determine correct plural form
+		durationText := t("common.days", req.Duration)
+		if req.Duration <= 4 {
+			durationText = t("common.days_few", req.Duration)
+		}
+
+		// Paid requests show the price, including the promo code discount and bonus days
+		priceStr := ""
+		if !isTrial {
+			quote := b.registrationQuote(req)
+			priceStr = t("registration.price", quote.Price, b.cfg().Payment.Currency, b.promoQuoteLines(t, quote))
+		}
+
+		msg := t("registration.request",
+			trialTag,
+			req.Username,
+			req.UserID,
+			tgUsernameStr,
+			req.Email,
+			durationText,
+			priceStr,
+			req.Timestamp.Format("02.01.2006 15:04"),
+		)
+
+		rows := [][]telego.InlineKeyboardButton{
+			tu.InlineKeyboardRow(
+				tu.InlineKeyboardButton(t("button.approve")).WithCallbackData(fmt.Sprintf("%s%d", constants.CbApproveRegPrefix, req.UserID)),
+				tu.InlineKeyboardButton(t("button.decline")).WithCallbackData(fmt.Sprintf("%s%d", constants.CbRejectRegPrefix, req.UserID)),
+			),
+		}
+
+		// Plain approval places the user on the least loaded panel, these buttons pick one explicitly
+		if b.hasMultiplePanels() {
+			for i, name := range b.panels.Names() {
+				rows = append(rows, tu.InlineKeyboardRow(
+					tu.InlineKeyboardButton(t("button.approve_to_panel", name)).WithCallbackData(fmt.Sprintf("%s%d_%d", constants.CbApproveRegPrefix, req.UserID, i)),
+				))
+			}
+		}
+		keyboard := tu.InlineKeyboard(rows...)
+
 		if _, err := b.bot.SendMessage(context.Background(), tu.Message(tu.ID(adminID), msg).WithReplyMarkup(keyboard)); err != nil {
 			b.logger.Errorf("Failed to send registration request to admin %d: %v", adminID, err)
 		} else {
@@ -257,25 +263,31 @@ func (b *Bot) autoApproveRegistration(req *RegistrationRequest) {
 	// Small delay to ensure state is saved
 	time.Sleep(500 * time.Millisecond)
 
-	// Create client via API
-	err := b.createClientForRequest(req)
+	// Create client via API on the least loaded panel
+	apiClient, err := b.panelForRegistration(-1)
+	if err == nil {
+		err = b.createClientForRequest(req, apiClient)
+	}
 	if err != nil {
-		b.sendMessage(req.UserID, fmt.Sprintf("❌ Ошибка при создании аккаунта: %v\n\nОбратитесь к администратору.", err))
+		b.sendMessage(req.UserID, b.t(req.UserID, "registration.error_create_account", err))
 		b.logger.Errorf("Failed to auto-create client for request: %v", err)
 
 		// Notify admins about the error
-		for _, adminID := range b.config.Telegram.AdminIDs {
-			b.sendMessage(adminID, fmt.Sprintf("⚠️ Ошибка автоматического создания пробного аккаунта для пользователя %s (ID: %d): %v", req.Username, req.UserID, err))
+		for _, adminID := range b.cfg().Telegram.AdminIDs {
+			b.sendMessage(adminID, b.t(adminID, "registration.error_auto_create", req.Username, req.UserID, err))
 		}
 		return
 	}
 
 	req.Status = "approved"
+	b.syncUserRecord(req.UserID, req.Language)
+	b.setUserTgUsername(req.UserID, req.TgUsername)
+	b.setUserTrial(req.UserID, true)
 
 	// Send subscription info with QR code
-	if err := b.sendSubscriptionInfo(req.UserID, req.UserID, req.Email, "✅ <b>Ваш пробный аккаунт активирован!</b>"); err != nil {
+	if err := b.sendSubscriptionInfo(req.UserID, req.UserID, req.Email, b.t(req.UserID, "registration.trial_activated")); err != nil {
 		b.logger.Errorf("Failed to send subscription info: %v", err)
-		b.sendMessage(req.UserID, fmt.Sprintf("✅ Аккаунт активирован!\n\n❌ Не удалось отправить данные подписки: %v\n\nОбратитесь к администратору.", err))
+		b.sendMessage(req.UserID, b.t(req.UserID, "registration.activated_no_info", err))
 	}
 
 	// Show main menu to the user after successful registration
@@ -283,29 +295,20 @@ func (b *Bot) autoApproveRegistration(req *RegistrationRequest) {
 	b.handleStart(req.UserID, req.Username, false)
 
 	// Notify admins about auto-approval
-	trialText := b.config.Payment.TrialText
-	if trialText == "" {
-		trialText = fmt.Sprintf("%d дня", req.Duration)
-	}
-
 	tgUsernameStr := ""
 	if req.TgUsername != "" {
 		tgUsernameStr = fmt.Sprintf(" (@%s)", req.TgUsername)
 	}
 
-	adminMsg := fmt.Sprintf(
-		"✅ <b>Пробный аккаунт автоматически создан</b>\n\n"+
-			"👤 Пользователь: %s%s\n"+
-			"👤 Username: %s\n"+
-			"📅 Срок: %s",
-		html.EscapeString(req.Username),
-		tgUsernameStr,
-		html.EscapeString(req.Email),
-		trialText,
-	)
-
-	for _, adminID := range b.config.Telegram.AdminIDs {
-		b.sendMessage(adminID, adminMsg)
+	for _, adminID := range b.cfg().Telegram.AdminIDs {
+		t := b.tr(adminID)
+		b.sendMessage(adminID, t("registration.trial_auto_created",
+			html.EscapeString(req.Username),
+			tgUsernameStr,
+			html.EscapeString(req.Email),
+			b.trialText(t, req.Duration),
+			b.panelLine(t, apiClient),
+		))
 	}
 
 	// Clean up
@@ -319,30 +322,40 @@ func (b *Bot) autoApproveRegistration(req *RegistrationRequest) {
 	b.logger.Infof("Auto-approved trial registration for user %d, email: %s", req.UserID, req.Email)
 }
 
-// handleRegistrationDecision handles admin's approval or rejection
-func (b *Bot) handleRegistrationDecision(requestUserID int64, adminChatID int64, messageID int, isApprove bool) {
+// handleRegistrationDecision handles admin's approval or rejection.
+// panelIndex selects the panel for an approved user, -1 picks the least loaded one
+func (b *Bot) handleRegistrationDecision(requestUserID int64, adminChatID int64, messageID int, isApprove bool, panelIndex int) {
+	t := b.tr(adminChatID)
 	req, exists := b.getRegistrationRequest(requestUserID)
 
 	if !exists {
-		b.sendMessage(adminChatID, "❌ Заявка не найдена")
+		b.sendMessage(adminChatID, t("registration.request_not_found"))
 		return
 	}
 
 	if isApprove {
 		// Create client via API
-		err := b.createClientForRequest(req)
+		apiClient, err := b.panelForRegistration(panelIndex)
+		if err == nil {
+			err = b.createClientForRequest(req, apiClient)
+		}
 		if err != nil {
-			b.sendMessage(adminChatID, fmt.Sprintf("❌ Ошибка при создании клиента: %v", err))
+			b.sendMessage(adminChatID, t("registration.error_create_client", err))
 			b.logger.Errorf("Failed to create client for request: %v", err)
 			return
 		}
 
 		req.Status = "approved"
+		b.syncUserRecord(req.UserID, req.Language)
+		b.setUserTgUsername(req.UserID, req.TgUsername)
+		if req.PromoCode != "" {
+			b.redeemPromo(req.UserID, storage.PromoPurposeRegistration, b.registrationQuote(req))
+		}
 
 		// Send subscription info with QR code
-		if err := b.sendSubscriptionInfo(req.UserID, requestUserID, req.Email, "✅ <b>Ваша заявка одобрена!</b>"); err != nil {
+		if err := b.sendSubscriptionInfo(req.UserID, requestUserID, req.Email, b.t(req.UserID, "registration.approved_user")); err != nil {
 			b.logger.Errorf("Failed to send subscription info: %v", err)
-			b.sendMessage(req.UserID, fmt.Sprintf("✅ Заявка одобрена!\n\n❌ Не удалось отправить данные подписки: %v\n\nОбратитесь к администратору.", err))
+			b.sendMessage(req.UserID, b.t(req.UserID, "registration.approved_no_info", err))
 		}
 
 		// Show main menu to the user after successful registration
@@ -355,25 +368,31 @@ func (b *Bot) handleRegistrationDecision(requestUserID int64, adminChatID int64,
 			tgUsernameStr = fmt.Sprintf(" (@%s)", req.TgUsername)
 		}
 
-		adminMsg := fmt.Sprintf(
-			"✅ <b>Заявка ОДОБРЕНА</b>\n\n"+
-				"👤 Пользователь: %s%s\n"+
-				"👤 Username: %s\n"+
-				"📅 Срок: %d дней",
+		adminMsg := t("registration.approved",
 			html.EscapeString(req.Username),
 			tgUsernameStr,
 			html.EscapeString(req.Email),
 			req.Duration,
+			b.promoQuoteLines(t, b.registrationQuote(req)),
+			b.panelLine(t, apiClient),
 		)
 		b.editMessageText(adminChatID, messageID, adminMsg)
+		b.recordAudit(adminChatID, storage.AuditActionApproveRegistration, req.Email, req.UserID, "",
+			fmt.Sprintf("%d days, panel %s", req.Duration, apiClient.Name()))
 
-		b.logger.Infof("Registration approved for user %d, email: %s", requestUserID, req.Email)
+		b.logger.Infof("Registration approved for user %d, email: %s, panel: %s", requestUserID, req.Email, apiClient.Name())
+
+		// A paid plan is the first paid subscription of the user
+		if req.Duration != b.cfg().Payment.TrialDays || b.cfg().Payment.TrialDays == 0 {
+			b.rewardReferrer(req.UserID)
+		} else {
+			b.setUserTrial(req.UserID, true)
+		}
 	} else {
 		req.Status = "rejected"
 
 		// Notify user
-		userMsg := "❌ К сожалению, ваша заявка была отклонена администратором."
-		b.sendMessage(req.UserID, userMsg)
+		b.sendMessage(req.UserID, b.t(req.UserID, "registration.rejected_user"))
 
 		// Update admin message
 		tgUsernameStr := ""
@@ -381,17 +400,14 @@ func (b *Bot) handleRegistrationDecision(requestUserID int64, adminChatID int64,
 			tgUsernameStr = fmt.Sprintf(" (@%s)", req.TgUsername)
 		}
 
-		adminMsg := fmt.Sprintf(
-			"❌ <b>Заявка ОТКЛОНЕНА</b>\n\n"+
-				"👤 Пользователь: %s%s\n"+
-				"👤 Username: %s\n"+
-				"📅 Срок: %d дней",
+		adminMsg := t("registration.rejected",
 			html.EscapeString(req.Username),
 			tgUsernameStr,
 			html.EscapeString(req.Email),
 			req.Duration,
 		)
 		b.editMessageText(adminChatID, messageID, adminMsg)
+		b.recordAudit(adminChatID, storage.AuditActionRejectRegistration, req.Email, req.UserID, "", "")
 
 		b.logger.Infof("Registration rejected for user %d, email: %s", requestUserID, req.Email)
 	}
@@ -406,3 +422,28 @@ func (b *Bot) handleRegistrationDecision(requestUserID int64, adminChatID int64,
 		b.logger.Errorf("Failed to delete user state: %v", err)
 	}
 }
+
+// trialText describes the trial period: the configured text, or its length in days
+func (b *Bot) trialText(t i18n.Translator, duration int) string {
+	if b.cfg().Payment.TrialText != "" {
+		return b.cfg().Payment.TrialText
+	}
+	return t("plan.trial_days", duration)
+}
+
+// registrationQuote rebuilds the quote a registration request was priced with
+func (b *Bot) registrationQuote(req *RegistrationRequest) services.Quote {
+	basePrice := b.cfg().Payment.Prices.ForDuration(req.Duration)
+	quote := services.Quote{
+		Duration:  req.Duration,
+		BasePrice: basePrice,
+		Price:     basePrice,
+		BonusDays: req.BonusDays,
+		Code:      req.PromoCode,
+	}
+	// Requests without a promo code may predate stored prices
+	if req.PromoCode != "" {
+		quote.Price = req.Price
+	}
+	return quote
+}
@@ -0,0 +1,56 @@
+package bot
+
+import (
+	"testing"
+	"time"
+
+	"x-ui-bot/internal/bot/constants"
+)
+
+func TestRegistrationUsernameRejectsMenuButtons(t *testing.T) {
+	const userID = 42
+	b, tg, _ := newTestBot(t, testConfig(t, "http://127.0.0.1:1", ""))
+
+	if err := b.setRegistrationRequest(userID, &RegistrationRequest{
+		UserID:    userID,
+		Status:    "input_email",
+		Language:  "en",
+		Timestamp: time.Now(),
+	}); err != nil {
+		t.Fatalf("setRegistrationRequest: %v", err)
+	}
+
+	tests := []struct {
+		text      string
+		wantEmail string // Empty when the text must be rejected
+	}{
+		{text: b.i18n.T("ru", constants.BtnMySubscription)},
+		{text: b.i18n.T("en", constants.BtnMySubscription)},
+		{text: b.i18n.T("ru", constants.BtnContactAdmin)},
+		{text: b.i18n.T("en", constants.BtnTerms)},
+		{text: "   "},
+		{text: "зарегистрироваться", wantEmail: "зарегистрироваться"},
+		{text: "alice", wantEmail: "alice"},
+	}
+
+	for _, tt := range tests {
+		rejections := len(tg.sent("sendMessage", userID))
+		b.handleRegistrationEmail(userID, userID, tt.text)
+
+		req, ok := b.getRegistrationRequest(userID)
+		if !ok {
+			t.Fatalf("%q: registration request lost", tt.text)
+		}
+		if req.Email != tt.wantEmail {
+			t.Errorf("%q: email = %q, want %q", tt.text, req.Email, tt.wantEmail)
+		}
+		if tt.wantEmail != "" {
+			continue
+		}
+
+		replies := tg.sent("sendMessage", userID)
+		if len(replies) != rejections+1 || replies[len(replies)-1].Text() != b.t(userID, "registration.username_empty") {
+			t.Errorf("%q: no username_empty reply", tt.text)
+		}
+	}
+}
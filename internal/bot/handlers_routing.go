@@ -2,7 +2,6 @@ package bot
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"html"
 	"math"
@@ -12,6 +11,8 @@ import (
 
 	"x-ui-bot/internal/bot/constants"
 	kbd "x-ui-bot/internal/bot/keyboard"
+	"x-ui-bot/internal/bot/middleware"
+	"x-ui-bot/internal/storage"
 
 	"github.com/mymmrac/telego"
 	th "github.com/mymmrac/telego/telegohandler"
@@ -25,26 +26,26 @@ func (b *Bot) handleMediaMessage(_ *th.Context, message telego.Message) error {
 	isAdmin := b.authMiddleware.IsAdmin(userID)
 
 	b.logger.Infof("Media message from user ID: %d", userID)
+	b.rememberLanguage(message.From)
+	b.rememberUsername(message.From)
+	t := b.tr(userID)
 
-	// Check rate limit (admins bypass automatically)
-	if !isAdmin {
-		if err := b.rateLimiter.Check(userID); err != nil {
-			b.logger.Warnf("Rate limit exceeded for user ID: %d", userID)
-			return nil
-		}
+	// Check rate limit (admins are exempt unless configured otherwise)
+	if !b.checkRateLimit(chatID, userID, middleware.ActionMessage) {
+		return nil
 	}
 
 	// Check if client is blocked
 	if !isAdmin {
 		if b.isClientBlocked(userID) {
-			b.sendMessage(chatID, "🔒 Ваш доступ заблокирован")
+			b.sendMessage(chatID, t("common.blocked"))
 			return nil
 		}
 	}
 
 	// Check if user is waiting for message to send to admin
 	if state, exists := b.getUserState(chatID); exists {
-		if state == "awaiting_user_message" {
+		if state == "awaiting_user_message" || state == constants.StateAwaitingReceipt {
 			b.handleUserMediaSend(chatID, userID, &message, message.From)
 			return nil
 		}
@@ -52,6 +53,10 @@ func (b *Bot) handleMediaMessage(_ *th.Context, message telego.Message) error {
 			b.handleAdminMediaSend(chatID, &message)
 			return nil
 		}
+		if state == constants.StateAwaitingBroadcastMessage && isAdmin {
+			b.handleBroadcastMessage(chatID, &message)
+			return nil
+		}
 	}
 
 	return nil
@@ -66,25 +71,29 @@ func (b *Bot) handleCommand(ctx *th.Context, message telego.Message) error {
 	command, _, args := tu.ParseCommand(message.Text)
 
 	b.logger.Infof("Command /%s from user ID: %d", command, userID)
+	b.rememberLanguage(message.From)
+	b.rememberUsername(message.From)
+	t := b.tr(userID)
 
-	// Check rate limit (admins bypass automatically)
-	if !isAdmin {
-		if err := b.rateLimiter.Check(userID); err != nil {
-			b.logger.Warnf("Rate limit exceeded for user ID: %d", userID)
-			return nil // Silently ignore
-		}
+	// Check rate limit (admins are exempt unless configured otherwise)
+	if !b.checkRateLimit(chatID, userID, middleware.ActionCommand) {
+		return nil
 	}
 
 	// Check if client is blocked (except for start, help, id commands and admins)
 	if !isAdmin && command != constants.CmdStart && command != constants.CmdHelp && command != constants.CmdID {
 		if b.isClientBlocked(userID) {
-			b.sendMessage(chatID, "🔒 Ваш доступ заблокирован")
+			b.sendMessage(chatID, t("common.blocked"))
 			return nil
 		}
 	}
 
 	switch command {
 	case constants.CmdStart:
+		// Deep link payload: /start ref_<tgid>
+		if !isAdmin && len(args) > 0 {
+			b.recordReferral(userID, args[0])
+		}
 		b.handleStart(chatID, message.From.FirstName, isAdmin)
 	case constants.CmdHelp:
 		b.handleHelp(chatID)
@@ -94,61 +103,106 @@ func (b *Bot) handleCommand(ctx *th.Context, message telego.Message) error {
 		b.handleID(chatID, message.From.ID)
 	case constants.CmdUsage:
 		if !isAdmin {
-			b.sendMessage(chatID, "⛔ У вас нет прав")
+			b.sendMessage(chatID, t("common.no_rights"))
 			return nil
 		}
 		if len(args) > 1 {
 			email := args[1]
 			b.handleUsage(chatID, email)
 		} else {
-			b.sendMessage(chatID, "❌ Использование: /usage <email>")
+			b.sendMessage(chatID, t("command.usage_usage"))
 		}
 	case constants.CmdClients:
 		b.handleClients(chatID, isAdmin)
+	case constants.CmdFind:
+		if !isAdmin {
+			b.sendMessage(chatID, t("common.no_rights"))
+			return nil
+		}
+		b.handleFind(chatID, args)
 	case constants.CmdForecast:
 		b.handleForecast(chatID, isAdmin)
+	case constants.CmdPromoAdd, constants.CmdPromoList, constants.CmdPromoDelete:
+		if !isAdmin {
+			b.sendMessage(chatID, t("common.no_rights"))
+			return nil
+		}
+		switch command {
+		case constants.CmdPromoAdd:
+			b.handlePromoAdd(chatID, userID, args)
+		case constants.CmdPromoList:
+			b.handlePromoList(chatID)
+		case constants.CmdPromoDelete:
+			b.handlePromoDelete(chatID, userID, args)
+		}
+	case constants.CmdScheduled:
+		if !isAdmin {
+			b.sendMessage(chatID, t("common.no_rights"))
+			return nil
+		}
+		b.showSchedules(chatID)
+	case constants.CmdTemplates:
+		if !isAdmin {
+			b.sendMessage(chatID, t("common.no_rights"))
+			return nil
+		}
+		b.handleTemplates(chatID, args)
+	case constants.CmdAudit, constants.CmdAuditExport:
+		if !isAdmin {
+			b.sendMessage(chatID, t("common.no_rights"))
+			return nil
+		}
+		if command == constants.CmdAudit {
+			b.handleAuditLog(chatID, args)
+		} else {
+			b.handleAuditExport(chatID, args)
+		}
 	default:
-		// Check if it's a client action command: /client_enable_1_0 or /client_disable_1_0
+		// Check if it's a client action command: /client_enable_<ref> or /client_disable_<ref>
 		if strings.HasPrefix(command, constants.CbClientPrefix) && isAdmin {
-			parts := strings.Split(command, "_")
-			if len(parts) == 4 {
+			parts := strings.SplitN(command, "_", 3)
+			if len(parts) == 3 {
 				action := parts[1] // enable or disable
-				inboundID, err1 := strconv.Atoi(parts[2])
-				clientIndex, err2 := strconv.Atoi(parts[3])
-
-				if err1 == nil && err2 == nil {
-					cacheKey := fmt.Sprintf("%d_%d", inboundID, clientIndex)
-					if client, ok := b.getClientFromCacheCopy(cacheKey); ok {
-						email := client["email"]
-						cleanEmail := stripInboundSuffix(email)
-
-						switch action {
-						case "enable":
-							err := b.clientService.EnableClient(inboundID, email, client)
-							if err != nil {
-								b.sendMessage(chatID, fmt.Sprintf("❌ Ошибка: %v", err))
-							} else {
-								b.sendMessage(chatID, fmt.Sprintf("✅ Клиент %s разблокирован", cleanEmail))
-								b.handleClients(chatID, isAdmin)
+				if refID, ok := parseClientCallback(parts[2], ""); ok {
+					panelIndex, client, err := b.resolveClient(refID, true)
+					if err != nil {
+						b.sendMessage(chatID, clientRefErrorText(t, err))
+						return nil
+					}
+					cleanEmail := stripInboundSuffix(client.Email)
+
+					switch action {
+					case "enable":
+						err := b.clientService.EnableClient(client)
+						if err != nil {
+							b.sendMessage(chatID, t("common.error", err))
+						} else {
+							b.sendMessage(chatID, t("clients.enabled", cleanEmail))
+							b.recordAudit(userID, storage.AuditActionUnblock, cleanEmail, client.TgID, "disabled", "enabled")
+							if client.HasTgID() {
+								b.syncUserRecord(client.TgID, "")
 							}
-						case "disable":
-							err := b.clientService.DisableClient(inboundID, email, client)
-							if err != nil {
-								b.sendMessage(chatID, fmt.Sprintf("❌ Ошибка: %v", err))
-							} else {
-								b.sendMessage(chatID, fmt.Sprintf("🔒 Клиент %s заблокирован", cleanEmail))
-								b.handleClients(chatID, isAdmin)
+							b.refreshClientList(chatID, panelIndex)
+						}
+					case "disable":
+						err := b.clientService.DisableClient(client)
+						if err != nil {
+							b.sendMessage(chatID, t("common.error", err))
+						} else {
+							b.sendMessage(chatID, t("clients.disabled", cleanEmail))
+							b.recordAudit(userID, storage.AuditActionBlock, cleanEmail, client.TgID, "enabled", "disabled")
+							if client.HasTgID() {
+								b.syncUserRecord(client.TgID, "")
 							}
+							b.refreshClientList(chatID, panelIndex)
 						}
-					} else {
-						b.sendMessage(chatID, "❌ Клиент не найден. Обновите список: /clients")
 					}
 					return nil
 				}
 			}
 		}
 
-		b.sendMessage(chatID, "❌ Неизвестная команда. Используйте /help для справки.")
+		b.sendMessage(chatID, t("command.unknown"))
 	}
 
 	return nil
@@ -171,25 +225,25 @@ func (b *Bot) handleTextMessage(ctx *th.Context, message telego.Message) error {
 	isAdmin := b.authMiddleware.IsAdmin(userID)
 
 	b.logger.Infof("Text message: '%s' by user ID: %d", message.Text, userID)
+	b.rememberLanguage(message.From)
+	b.rememberUsername(message.From)
+	t := b.tr(userID)
 
-	// Check rate limit (admins bypass automatically)
-	if !isAdmin {
-		if err := b.rateLimiter.Check(userID); err != nil {
-			b.logger.Warnf("Rate limit exceeded for user ID: %d", userID)
-			return nil
-		}
+	// Check rate limit (admins are exempt unless configured otherwise)
+	if !b.checkRateLimit(chatID, userID, middleware.ActionMessage) {
+		return nil
 	}
 
 	// Check message length (max 2000 chars for user messages)
 	if len(message.Text) > 2000 {
-		b.sendMessage(chatID, "❌ Сообщение слишком длинное. Максимум 2000 символов.")
+		b.sendMessage(chatID, t("message.too_long"))
 		return nil
 	}
 
 	// Check if client is blocked — block all non-admin actions (including chat and registration)
 	if !isAdmin {
 		if b.isClientBlocked(userID) {
-			b.sendMessage(chatID, "🔒 Ваш доступ заблокирован")
+			b.sendMessage(chatID, t("common.blocked"))
 			// Clear any pending states for blocked user
 			_ = b.deleteUserState(chatID)
 			return nil
@@ -212,60 +266,82 @@ func (b *Bot) handleTextMessage(ctx *th.Context, message telego.Message) error {
 			b.handleUserMessageSend(chatID, userID, message.Text, message.From)
 			return nil
 		case constants.StateAwaitingBroadcastMessage:
-			b.handleBroadcastMessage(chatID, message.Text)
+			b.handleBroadcastMessage(chatID, &message)
+			return nil
+		case constants.StateAwaitingBroadcastButtons:
+			b.handleBroadcastButtonsInput(chatID, message.Text)
+			return nil
+		case constants.StateAwaitingBroadcastSendAt:
+			b.handleBroadcastSendAtInput(chatID, message.Text)
+			return nil
+		case constants.StateAwaitingBroadcastCron:
+			b.handleBroadcastCronInput(chatID, message.Text)
+			return nil
+		case constants.StateAwaitingScheduleEdit:
+			b.handleScheduleEditInput(chatID, message.Text)
+			return nil
+		case constants.StateAwaitingRegPromo, constants.StateAwaitingExtPromo:
+			b.handlePromoInput(chatID, userID, message.Text, state)
 			return nil
 		}
 	}
 
-	switch message.Text {
-	case constants.BtnServerStatus:
+	// Buttons arrive as text in the language the keyboard was built in
+	button := func(key string) bool {
+		return b.i18n.Matches(message.Text, key)
+	}
+
+	switch {
+	case button(constants.BtnServerStatus):
 		if !isAdmin {
-			b.sendMessage(chatID, "⛔ У вас нет прав")
+			b.sendMessage(chatID, t("common.no_rights"))
 			return nil
 		}
 		b.handleStatus(chatID, isAdmin)
-	case constants.BtnTrafficForecast:
+	case button(constants.BtnTrafficForecast):
 		if !isAdmin {
-			b.sendMessage(chatID, "⛔ У вас нет прав")
+			b.sendMessage(chatID, t("common.no_rights"))
 			return nil
 		}
 		b.handleTrafficForecast(chatID)
-	case constants.BtnClientList:
+	case button(constants.BtnClientList):
 		if !isAdmin {
-			b.sendMessage(chatID, "⛔ У вас нет прав")
+			b.sendMessage(chatID, t("common.no_rights"))
 			return nil
 		}
 		b.handleClients(chatID, isAdmin)
-	case constants.BtnBroadcast:
+	case button(constants.BtnBroadcast):
 		if !isAdmin {
-			b.sendMessage(chatID, "⛔ У вас нет прав")
+			b.sendMessage(chatID, t("common.no_rights"))
 			return nil
 		}
 		b.handleBroadcastStart(chatID)
-	case constants.BtnBackupDB:
+	case button(constants.BtnBackupDB):
 		if !isAdmin {
-			b.sendMessage(chatID, "⛔ У вас нет прав")
+			b.sendMessage(chatID, t("common.no_rights"))
 			return nil
 		}
 		b.handleBackupRequest(chatID)
-	default:
-		// Handle buttons with emoji (encoding issues)
-		if strings.Contains(message.Text, constants.BtnTerms) {
-			b.handleShowTerms(chatID, userID)
-		} else if strings.Contains(message.Text, constants.BtnMySubscription) || strings.Contains(message.Text, constants.BtnInstructions) {
-			b.handleMySubscription(chatID, userID)
-		} else if strings.Contains(message.Text, constants.BtnExtendSubscription) {
-			b.handleExtendSubscription(chatID, userID)
-		} else if strings.Contains(message.Text, constants.BtnSettings) {
-			b.handleSettings(chatID, userID)
-		} else if strings.Contains(message.Text, constants.BtnUpdateUsername) {
-			b.handleUpdateUsername(chatID, userID)
-		} else if strings.Contains(message.Text, constants.BtnBack) {
-			// Return to main menu
-			b.handleStart(chatID, message.From.FirstName, false)
-		} else if strings.Contains(message.Text, constants.BtnContactAdmin) {
-			b.handleContactAdmin(chatID, userID)
-		}
+	// Handle buttons with emoji (encoding issues)
+	case button(constants.BtnTerms):
+		b.handleShowTerms(chatID, userID)
+	case button(constants.BtnMySubscription), button(constants.BtnInstructions):
+		b.handleMySubscription(chatID, userID)
+	case button(constants.BtnExtendSubscription):
+		b.handleExtendSubscription(chatID, userID, nil)
+	case button(constants.BtnSettings):
+		b.handleSettings(chatID, userID)
+	case button(constants.BtnReferral):
+		b.handleReferralInfo(chatID, userID)
+	case button(constants.BtnUpdateUsername):
+		b.handleUpdateUsername(chatID, userID)
+	case button(constants.BtnLanguage):
+		b.handleLanguageMenu(chatID, userID)
+	case button(constants.BtnBack):
+		// Return to main menu
+		b.handleStart(chatID, message.From.FirstName, false)
+	case button(constants.BtnContactAdmin):
+		b.handleContactAdmin(chatID, userID)
 	}
 
 	return nil
@@ -280,6 +356,14 @@ func (b *Bot) handleCallback(ctx *th.Context, query telego.CallbackQuery) error
 	isAdmin := b.authMiddleware.IsAdmin(userID)
 
 	b.logger.Infof("Callback from user %d: %s", userID, data)
+	b.rememberLanguage(&query.From)
+	b.rememberUsername(&query.From)
+	t := b.tr(userID)
+
+	// Check rate limit (admins are exempt unless configured otherwise)
+	if !b.checkCallbackRateLimit(query.ID, userID) {
+		return nil
+	}
 
 	// Handle terms acceptance/decline
 	if data == constants.CbTermsAccept {
@@ -287,7 +371,7 @@ func (b *Bot) handleCallback(ctx *th.Context, query telego.CallbackQuery) error
 		if !isAdmin && b.isClientBlocked(userID) {
 			if err := b.bot.AnswerCallbackQuery(context.Background(), &telego.AnswerCallbackQueryParams{
 				CallbackQueryID: query.ID,
-				Text:            "🔒 Ваш доступ заблокирован",
+				Text:            t("common.blocked"),
 				ShowAlert:       true,
 			}); err != nil {
 				b.logger.Errorf("Failed to answer blocked user callback: %v", err)
@@ -298,7 +382,7 @@ func (b *Bot) handleCallback(ctx *th.Context, query telego.CallbackQuery) error
 		b.handleTermsAccept(chatID, userID, messageID, &query.From)
 		if err := b.bot.AnswerCallbackQuery(context.Background(), &telego.AnswerCallbackQueryParams{
 			CallbackQueryID: query.ID,
-			Text:            "✅ Условия приняты",
+			Text:            t("terms.accepted"),
 		}); err != nil {
 			b.logger.Errorf("Failed to answer terms accept callback: %v", err)
 		}
@@ -309,7 +393,7 @@ func (b *Bot) handleCallback(ctx *th.Context, query telego.CallbackQuery) error
 		b.handleTermsDecline(chatID, messageID)
 		if err := b.bot.AnswerCallbackQuery(context.Background(), &telego.AnswerCallbackQueryParams{
 			CallbackQueryID: query.ID,
-			Text:            "❌ Регистрация отменена",
+			Text:            t("terms.declined"),
 		}); err != nil {
 			b.logger.Errorf("Failed to answer terms decline callback: %v", err)
 		}
@@ -341,20 +425,26 @@ func (b *Bot) handleCallback(ctx *th.Context, query telego.CallbackQuery) error
 
 	// Handle back to subscription (before block check - available to all users)
 	if data == "back_to_subscription" {
+		if !b.checkRateLimit(chatID, userID, middleware.ActionQRCode) {
+			if err := b.bot.AnswerCallbackQuery(context.Background(), &telego.AnswerCallbackQueryParams{
+				CallbackQueryID: query.ID,
+			}); err != nil {
+				b.logger.Errorf("Failed to answer back to subscription callback: %v", err)
+			}
+			return nil
+		}
 		// Re-send subscription info
-		clientInfo, err := b.apiClient.GetClientByTgID(context.Background(), userID)
+		_, clientInfo, err := b.findClientByTgID(userID)
 		if err == nil {
-			if email, ok := clientInfo["email"].(string); ok {
-				// Delete old message and send new one with QR code
-				if err := b.bot.DeleteMessage(context.Background(), &telego.DeleteMessageParams{
-					ChatID:    tu.ID(chatID),
-					MessageID: messageID,
-				}); err != nil {
-					b.logger.Errorf("Failed to delete message: %v", err)
-				}
-				if err := b.sendSubscriptionInfo(chatID, userID, email, "📱 Моя подписка"); err != nil {
-					b.logger.Errorf("Failed to send subscription info: %v", err)
-				}
+			// Delete old message and send new one with QR code
+			if err := b.bot.DeleteMessage(context.Background(), &telego.DeleteMessageParams{
+				ChatID:    tu.ID(chatID),
+				MessageID: messageID,
+			}); err != nil {
+				b.logger.Errorf("Failed to delete message: %v", err)
+			}
+			if err := b.sendSubscriptionInfo(chatID, userID, clientInfo.Email, t("subscription.title")); err != nil {
+				b.logger.Errorf("Failed to send subscription info: %v", err)
 			}
 		}
 		if err := b.bot.AnswerCallbackQuery(context.Background(), &telego.AnswerCallbackQueryParams{
@@ -365,12 +455,23 @@ func (b *Bot) handleCallback(ctx *th.Context, query telego.CallbackQuery) error
 		return nil
 	}
 
+	// Handle language selection (before block check - available to all users)
+	if strings.HasPrefix(data, constants.CbLanguagePrefix) {
+		b.handleLanguageChange(chatID, userID, messageID, strings.TrimPrefix(data, constants.CbLanguagePrefix))
+		if err := b.bot.AnswerCallbackQuery(context.Background(), &telego.AnswerCallbackQueryParams{
+			CallbackQueryID: query.ID,
+		}); err != nil {
+			b.logger.Errorf("Failed to answer language callback: %v", err)
+		}
+		return nil
+	}
+
 	// Check if client is blocked — block all non-admin callbacks
 	if !isAdmin {
 		if b.isClientBlocked(userID) {
 			if err := b.bot.AnswerCallbackQuery(context.Background(), &telego.AnswerCallbackQueryParams{
 				CallbackQueryID: query.ID,
-				Text:            "🔒 Ваш доступ заблокирован",
+				Text:            t("common.blocked"),
 				ShowAlert:       true,
 			}); err != nil {
 				b.logger.Errorf("Failed to answer blocked user callback: %v", err)
@@ -379,16 +480,20 @@ func (b *Bot) handleCallback(ctx *th.Context, query telego.CallbackQuery) error
 		}
 	}
 
-	// Handle registration duration selection (non-admin can use)
+	// Handle registration duration selection (non-admin can use): reg_duration_<days>[_<promo code>]
 	if strings.HasPrefix(data, constants.CbRegDurationPrefix) {
 		parts := strings.Split(data, "_")
-		if len(parts) == 3 {
+		if len(parts) == 3 || len(parts) == 4 {
 			duration, err := strconv.Atoi(parts[2])
 			if err == nil {
-				b.handleRegistrationDuration(userID, chatID, duration)
+				promoCode := ""
+				if len(parts) == 4 {
+					promoCode = parts[3]
+				}
+				b.handleRegistrationDuration(userID, chatID, duration, promoCode)
 				if err := b.bot.AnswerCallbackQuery(context.Background(), &telego.AnswerCallbackQueryParams{
 					CallbackQueryID: query.ID,
-					Text:            fmt.Sprintf("✅ Выбрано: %d дней", duration),
+					Text:            t("registration.duration_selected", duration),
 				}); err != nil {
 					b.logger.Errorf("Failed to answer duration selection callback: %v", err)
 				}
@@ -397,19 +502,25 @@ func (b *Bot) handleCallback(ctx *th.Context, query telego.CallbackQuery) error
 		}
 	}
 
-	// Handle subscription extension (non-admin can use)
+	// Handle subscription extension (non-admin can use): extend_<userID>_<days>[_<promo code>]
 	if strings.HasPrefix(data, constants.CbExtendPrefix) {
 		parts := strings.Split(data, "_")
-		if len(parts) == 3 {
+		if len(parts) == 3 || len(parts) == 4 {
 			requestUserID, err1 := strconv.ParseInt(parts[1], 10, 64)
 			duration, err2 := strconv.Atoi(parts[2])
 			if err1 == nil && err2 == nil && requestUserID == userID {
-				// Get Telegram username from callback query
-				tgUsername := query.From.Username
-				b.handleExtensionRequest(userID, chatID, messageID, duration, tgUsername)
+				promoCode := ""
+				if len(parts) == 4 {
+					promoCode = parts[3]
+				}
+				b.handleExtensionRequest(userID, chatID, messageID, duration, promoCode)
+				answer := t("extension.pay_and_send_receipt", duration)
+				if b.cfg().Payment.InvoicesEnabled() {
+					answer = t("extension.invoice_sent", duration)
+				}
 				if err := b.bot.AnswerCallbackQuery(context.Background(), &telego.AnswerCallbackQueryParams{
 					CallbackQueryID: query.ID,
-					Text:            fmt.Sprintf("✅ Запрос на %d дней отправлен", duration),
+					Text:            answer,
 				}); err != nil {
 					b.logger.Errorf("Failed to answer extension request callback: %v", err)
 				}
@@ -418,6 +529,21 @@ func (b *Bot) handleCallback(ctx *th.Context, query telego.CallbackQuery) error
 		}
 	}
 
+	// Handle promo code entry during registration or extension (non-admin can use)
+	if data == constants.CbPromoRegistration || data == constants.CbPromoExtension {
+		state := constants.StateAwaitingExtPromo
+		if data == constants.CbPromoRegistration {
+			state = constants.StateAwaitingRegPromo
+		}
+		b.handlePromoPrompt(chatID, userID, state)
+		if err := b.bot.AnswerCallbackQuery(context.Background(), &telego.AnswerCallbackQueryParams{
+			CallbackQueryID: query.ID,
+		}); err != nil {
+			b.logger.Errorf("Failed to answer promo code callback: %v", err)
+		}
+		return nil
+	}
+
 	// Handle extend subscription from notification (non-admin can use)
 	if data == "extend_subscription" {
 		b.handleExtensionMenu(chatID, userID, messageID)
@@ -429,12 +555,25 @@ func (b *Bot) handleCallback(ctx *th.Context, query telego.CallbackQuery) error
 		return nil
 	}
 
+	// Handle extension sent without a receipt (non-admin can use)
+	if strings.HasPrefix(data, constants.CbExtNoReceiptPrefix) {
+		if requestID, err := strconv.ParseInt(strings.TrimPrefix(data, constants.CbExtNoReceiptPrefix), 10, 64); err == nil {
+			b.handleExtensionWithoutReceipt(chatID, userID, messageID, requestID)
+			if err := b.bot.AnswerCallbackQuery(context.Background(), &telego.AnswerCallbackQueryParams{
+				CallbackQueryID: query.ID,
+			}); err != nil {
+				b.logger.Errorf("Failed to answer no receipt callback: %v", err)
+			}
+			return nil
+		}
+	}
+
 	// Handle contact admin (non-admin can use)
 	if data == constants.CbContactAdmin {
 		b.handleContactAdmin(chatID, userID)
 		if err := b.bot.AnswerCallbackQuery(context.Background(), &telego.AnswerCallbackQueryParams{
 			CallbackQueryID: query.ID,
-			Text:            "✅ Введите ваше сообщение",
+			Text:            t("contact.enter_message"),
 		}); err != nil {
 			b.logger.Errorf("Failed to answer contact admin callback: %v", err)
 		}
@@ -445,7 +584,7 @@ func (b *Bot) handleCallback(ctx *th.Context, query telego.CallbackQuery) error
 	if !b.authMiddleware.IsAdmin(userID) {
 		if err := b.bot.AnswerCallbackQuery(context.Background(), &telego.AnswerCallbackQueryParams{
 			CallbackQueryID: query.ID,
-			Text:            "⛔ У вас нет прав",
+			Text:            t("common.no_rights"),
 			ShowAlert:       true,
 		}); err != nil {
 			b.logger.Errorf("Failed to answer permission denied callback: %v", err)
@@ -453,14 +592,18 @@ func (b *Bot) handleCallback(ctx *th.Context, query telego.CallbackQuery) error
 		return nil
 	}
 
-	// Handle registration approval/rejection
+	// Handle registration approval/rejection: approve_reg_<userID>[_<panelIndex>]
 	if strings.HasPrefix(data, constants.CbApproveRegPrefix) || strings.HasPrefix(data, constants.CbRejectRegPrefix) {
 		parts := strings.Split(data, "_")
-		if len(parts) == 3 {
+		if len(parts) == 3 || len(parts) == 4 {
 			requestUserID, err := strconv.ParseInt(parts[2], 10, 64)
+			panelIndex := -1
+			if err == nil && len(parts) == 4 {
+				panelIndex, err = strconv.Atoi(parts[3])
+			}
 			if err == nil {
 				isApprove := strings.HasPrefix(data, constants.CbApproveRegPrefix)
-				b.handleRegistrationDecision(requestUserID, chatID, messageID, isApprove)
+				b.handleRegistrationDecision(requestUserID, chatID, messageID, isApprove, panelIndex)
 				return nil
 			}
 		}
@@ -468,35 +611,83 @@ func (b *Bot) handleCallback(ctx *th.Context, query telego.CallbackQuery) error
 
 	// Handle extension approval/rejection
 	if strings.HasPrefix(data, constants.CbApproveExtPrefix) || strings.HasPrefix(data, constants.CbRejectExtPrefix) {
+		// approve_ext_<uid>_<days>[_<requestID>] and reject_ext_<uid>[_<requestID>],
+		// the request ID is missing on cards sent before requests were stored
 		parts := strings.Split(data, "_")
-		if strings.HasPrefix(data, constants.CbApproveExtPrefix) && len(parts) == 4 {
+		if strings.HasPrefix(data, constants.CbApproveExtPrefix) && (len(parts) == 4 || len(parts) == 5) {
 			requestUserID, err1 := strconv.ParseInt(parts[2], 10, 64)
 			duration, err2 := strconv.Atoi(parts[3])
-			if err1 == nil && err2 == nil {
-				b.handleExtensionApproval(requestUserID, chatID, messageID, duration)
+			var requestID int64
+			var err3 error
+			if len(parts) == 5 {
+				requestID, err3 = strconv.ParseInt(parts[4], 10, 64)
+			}
+			if err1 == nil && err2 == nil && err3 == nil {
+				b.handleExtensionApproval(requestUserID, chatID, messageID, duration, requestID)
 				return nil
 			}
-		} else if strings.HasPrefix(data, constants.CbRejectExtPrefix) && len(parts) == 3 {
-			requestUserID, err := strconv.ParseInt(parts[2], 10, 64)
-			if err == nil {
-				b.handleExtensionRejection(requestUserID, chatID, messageID)
+		} else if strings.HasPrefix(data, constants.CbRejectExtPrefix) && (len(parts) == 3 || len(parts) == 4) {
+			requestUserID, err1 := strconv.ParseInt(parts[2], 10, 64)
+			var requestID int64
+			var err2 error
+			if len(parts) == 4 {
+				requestID, err2 = strconv.ParseInt(parts[3], 10, 64)
+			}
+			if err1 == nil && err2 == nil {
+				b.handleExtensionRejection(requestUserID, chatID, messageID, requestID)
 				return nil
 			}
 		}
 	}
 
-	// Handle client_X_Y buttons (show client actions menu)
+	// Handle server pickers
+	if strings.HasPrefix(data, constants.CbClientsPanelPrefix) {
+		if panelIndex, err := strconv.Atoi(strings.TrimPrefix(data, constants.CbClientsPanelPrefix)); err == nil {
+			if err := b.bot.AnswerCallbackQuery(context.Background(), &telego.AnswerCallbackQueryParams{
+				CallbackQueryID: query.ID,
+			}); err != nil {
+				b.logger.Errorf("Failed to answer clients panel callback: %v", err)
+			}
+			b.handlePanelClients(chatID, panelIndex, messageID)
+			return nil
+		}
+	}
+
+	if strings.HasPrefix(data, constants.CbStatusPanelPrefix) {
+		if panelIndex, err := strconv.Atoi(strings.TrimPrefix(data, constants.CbStatusPanelPrefix)); err == nil {
+			if err := b.bot.AnswerCallbackQuery(context.Background(), &telego.AnswerCallbackQueryParams{
+				CallbackQueryID: query.ID,
+			}); err != nil {
+				b.logger.Errorf("Failed to answer status panel callback: %v", err)
+			}
+			b.handlePanelStatus(chatID, messageID, panelIndex)
+			return nil
+		}
+	}
+
+	// Handle client_<ref> buttons (show client actions menu)
 	if strings.HasPrefix(data, constants.CbClientPrefix) {
-		parts := strings.Split(data, "_")
-		if len(parts) == 3 {
-			inboundID, err1 := strconv.Atoi(parts[1])
-			clientIndex, err2 := strconv.Atoi(parts[2])
+		if refID, ok := parseClientCallback(data, constants.CbClientPrefix); ok {
+			b.handleClientMenu(chatID, messageID, refID, query.ID)
+			return nil
+		}
+	}
 
-			if err1 == nil && err2 == nil {
-				b.handleClientMenu(chatID, messageID, inboundID, clientIndex, query.ID)
-				return nil
+	// Handle client list filter chips, pages and the way back from a client menu
+	if strings.HasPrefix(data, constants.CbClientListPrefix) {
+		if err := b.bot.AnswerCallbackQuery(context.Background(), &telego.AnswerCallbackQueryParams{
+			CallbackQueryID: query.ID,
+		}); err != nil {
+			b.logger.Errorf("Failed to answer client list callback: %v", err)
+		}
+		if strings.HasPrefix(data, constants.CbClientListBackPrefix) {
+			if panelIndex, err := strconv.Atoi(strings.TrimPrefix(data, constants.CbClientListBackPrefix)); err == nil {
+				b.refreshClientList(chatID, panelIndex, messageID)
 			}
+			return nil
 		}
+		b.handleClientListCallback(chatID, messageID, data)
+		return nil
 	}
 
 	// Handle back_to_clients button
@@ -510,202 +701,194 @@ func (b *Bot) handleCallback(ctx *th.Context, query telego.CallbackQuery) error
 		return nil
 	}
 
-	// Handle delete_X_Y buttons
+	// Handle delete_<ref> buttons
 	if strings.HasPrefix(data, constants.CbDeletePrefix) {
-		parts := strings.Split(data, "_")
-		if len(parts) == 3 {
-			inboundID, err1 := strconv.Atoi(parts[1])
-			clientIndex, err2 := strconv.Atoi(parts[2])
+		if refID, ok := parseClientCallback(data, constants.CbDeletePrefix); ok {
+			_, client, err := b.resolveClient(refID, false)
+			if err != nil {
+				b.answerClientRefError(query.ID, t, err)
+				return nil
+			}
+			cleanEmail := stripInboundSuffix(client.Email)
 
-			if err1 == nil && err2 == nil {
-				cacheKey := fmt.Sprintf("%d_%d", inboundID, clientIndex)
-				if client, ok := b.getClientFromCacheCopy(cacheKey); ok {
-					email := client["email"]
-					cleanEmail := stripInboundSuffix(email)
-
-					// Show confirmation dialog
-					confirmMsg := fmt.Sprintf("❗ Вы уверены, что хотите удалить клиента?\n\n👤 Email: %s", cleanEmail)
-					keyboard := kbd.BuildConfirmDeleteKeyboard(inboundID, clientIndex)
-
-					if _, err := b.bot.EditMessageText(context.Background(), &telego.EditMessageTextParams{
-						ChatID:      tu.ID(chatID),
-						MessageID:   messageID,
-						Text:        confirmMsg,
-						ReplyMarkup: keyboard,
-					}); err != nil {
-						b.logger.Errorf("Failed to edit delete confirmation message: %v", err)
-					}
+			// Show confirmation dialog
+			confirmMsg := t("clients.delete_confirm", cleanEmail)
+			keyboard := kbd.BuildConfirmDeleteKeyboard(t, refID)
 
-					if err := b.bot.AnswerCallbackQuery(context.Background(), &telego.AnswerCallbackQueryParams{
-						CallbackQueryID: query.ID,
-					}); err != nil {
-						b.logger.Errorf("Failed to answer delete confirmation callback: %v", err)
-					}
-					return nil
-				}
+			if _, err := b.bot.EditMessageText(context.Background(), &telego.EditMessageTextParams{
+				ChatID:      tu.ID(chatID),
+				MessageID:   messageID,
+				Text:        confirmMsg,
+				ReplyMarkup: keyboard,
+			}); err != nil {
+				b.logger.Errorf("Failed to edit delete confirmation message: %v", err)
+			}
+
+			if err := b.bot.AnswerCallbackQuery(context.Background(), &telego.AnswerCallbackQueryParams{
+				CallbackQueryID: query.ID,
+			}); err != nil {
+				b.logger.Errorf("Failed to answer delete confirmation callback: %v", err)
 			}
+			return nil
 		}
 	}
 
+	// Handle confirm_delete_<ref>, the client is verified against fresh panel data before deleting
 	if strings.HasPrefix(data, constants.CbConfirmDeletePrefix) {
-		parts := strings.Split(data, "_")
-		if len(parts) == 4 {
-			inboundID, err1 := strconv.Atoi(parts[2])
-			clientIndex, err2 := strconv.Atoi(parts[3])
-
-			if err1 == nil && err2 == nil {
-				cacheKey := fmt.Sprintf("%d_%d", inboundID, clientIndex)
-				if client, ok := b.getClientFromCacheCopy(cacheKey); ok {
-					tgIDStr := client["tgId"]
-					email := client["email"]
-					cleanEmail := stripInboundSuffix(email)
-
-					// Delete from ALL inbounds where this user exists
-					deletedCount := 0
-					var deleteErrors []string
-
-					// Get all inbounds
-					inbounds, err := b.apiClient.GetInbounds(context.Background())
-					if err != nil {
-						if err := b.bot.AnswerCallbackQuery(context.Background(), &telego.AnswerCallbackQueryParams{
-							CallbackQueryID: query.ID,
-							Text:            fmt.Sprintf("❌ Ошибка получения инбаундов: %v", err),
-							ShowAlert:       true,
-						}); err != nil {
-							b.logger.Errorf("Failed to answer delete error callback: %v", err)
-						}
-						return nil
-					}
+		if refID, ok := parseClientCallback(data, constants.CbConfirmDeletePrefix); ok {
+			panelIndex, client, err := b.resolveClient(refID, true)
+			if err != nil {
+				b.answerClientRefError(query.ID, t, err)
+				return nil
+			}
+			cleanEmail := stripInboundSuffix(client.Email)
 
-					// Find and delete from all inbounds
-					for _, inbound := range inbounds {
-						ibID := int(inbound["id"].(float64))
-						settingsStr := ""
-						if settings, ok := inbound["settings"].(string); ok {
-							settingsStr = settings
-						}
+			// Delete from ALL inbounds of the panel where this user exists
+			deletedCount := 0
+			var deleteErrors []string
 
-						clients, err := b.clientService.ParseClients(settingsStr)
-						if err != nil {
-							continue
-						}
+			// Get all inbounds
+			apiClient, found := b.panelAt(panelIndex)
+			if !found {
+				if err := b.bot.AnswerCallbackQuery(context.Background(), &telego.AnswerCallbackQueryParams{
+					CallbackQueryID: query.ID,
+					Text:            t("panel.not_found"),
+					ShowAlert:       true,
+				}); err != nil {
+					b.logger.Errorf("Failed to answer delete error callback: %v", err)
+				}
+				return nil
+			}
+			inbounds, err := apiClient.GetInbounds(context.Background())
+			if err != nil {
+				if err := b.bot.AnswerCallbackQuery(context.Background(), &telego.AnswerCallbackQueryParams{
+					CallbackQueryID: query.ID,
+					Text:            t("clients.error_inbounds", err),
+					ShowAlert:       true,
+				}); err != nil {
+					b.logger.Errorf("Failed to answer delete error callback: %v", err)
+				}
+				return nil
+			}
 
-						// Find client with matching tgId
-						for _, c := range clients {
-							if c["tgId"] == tgIDStr {
-								clientID := c["id"] // UUID for VMESS/VLESS
-								err := b.apiClient.DeleteClient(context.Background(), ibID, clientID)
-								if err != nil {
-									deleteErrors = append(deleteErrors, fmt.Sprintf("inbound %d: %v", ibID, err))
-									b.logger.Errorf("Failed to delete client from inbound %d: %v", ibID, err)
-								} else {
-									deletedCount++
-									b.logger.Infof("Deleted client %s from inbound %d", c["email"], ibID)
-								}
-								break
-							}
-						}
-					}
+			// Find and delete from all inbounds
+			for _, inbound := range inbounds {
+				ibID := inbound.ID
 
-					// Report result
-					if deletedCount == 0 {
-						if err := b.bot.AnswerCallbackQuery(context.Background(), &telego.AnswerCallbackQueryParams{
-							CallbackQueryID: query.ID,
-							Text:            fmt.Sprintf("❌ Не удалось удалить клиента: %s", strings.Join(deleteErrors, "; ")),
-							ShowAlert:       true,
-						}); err != nil {
-							b.logger.Errorf("Failed to answer delete error callback: %v", err)
-						}
-					} else {
-						resultText := fmt.Sprintf("🗑️ Клиент %s удалён из %d инбаундов", cleanEmail, deletedCount)
-						if len(deleteErrors) > 0 {
-							resultText += fmt.Sprintf("\n\nОшибки: %s", strings.Join(deleteErrors, "; "))
-						}
+				clients, err := inbound.Clients()
+				if err != nil {
+					b.logger.Warnf("Skipping invalid clients of inbound %d: %v", inbound.ID, err)
+				}
 
-						if err := b.bot.AnswerCallbackQuery(context.Background(), &telego.AnswerCallbackQueryParams{
-							CallbackQueryID: query.ID,
-							Text:            resultText,
-						}); err != nil {
-							b.logger.Errorf("Failed to answer delete success callback: %v", err)
+				// Find client with matching tgId
+				for _, c := range clients {
+					if sameClientOwner(c, client) {
+						clientID := c.Key() // UUID for VMESS/VLESS, password for Trojan
+						err := apiClient.DeleteClient(context.Background(), ibID, clientID)
+						if err != nil {
+							deleteErrors = append(deleteErrors, fmt.Sprintf("inbound %d: %v", ibID, err))
+							b.logger.Errorf("Failed to delete client from inbound %d: %v", ibID, err)
+						} else {
+							deletedCount++
+							b.logger.Infof("Deleted client %s from inbound %d", c.Email, ibID)
 						}
-						// Refresh client list
-						b.handleClients(chatID, true, messageID)
+						break
 					}
-					return nil
 				}
 			}
-		}
-	}
 
-	if strings.HasPrefix(data, constants.CbCancelDeletePrefix) {
-		parts := strings.Split(data, "_")
-		if len(parts) == 4 {
-			inboundID, err1 := strconv.Atoi(parts[2])
-			clientIndex, err2 := strconv.Atoi(parts[3])
+			if deletedCount > 0 {
+				b.recordAudit(userID, storage.AuditActionDelete, cleanEmail, client.TgID, fmt.Sprintf("%d inbounds", deletedCount), "")
+				if client.HasTgID() {
+					b.syncUserRecord(client.TgID, "")
+				}
+			}
 
-			if err1 == nil && err2 == nil {
+			// Report result
+			if deletedCount == 0 {
 				if err := b.bot.AnswerCallbackQuery(context.Background(), &telego.AnswerCallbackQueryParams{
 					CallbackQueryID: query.ID,
-					Text:            "❌ Удаление отменено",
+					Text:            t("clients.delete_failed", strings.Join(deleteErrors, "; ")),
+					ShowAlert:       true,
 				}); err != nil {
-					b.logger.Errorf("Failed to answer cancel delete callback: %v", err)
+					b.logger.Errorf("Failed to answer delete error callback: %v", err)
 				}
-				// Return to client menu
-				b.handleClientMenu(chatID, messageID, inboundID, clientIndex, query.ID)
-				return nil
+			} else {
+				resultText := t("clients.deleted", cleanEmail, deletedCount)
+				if len(deleteErrors) > 0 {
+					resultText += t("clients.errors_block", strings.Join(deleteErrors, "; "))
+				}
+
+				if err := b.bot.AnswerCallbackQuery(context.Background(), &telego.AnswerCallbackQueryParams{
+					CallbackQueryID: query.ID,
+					Text:            resultText,
+				}); err != nil {
+					b.logger.Errorf("Failed to answer delete success callback: %v", err)
+				}
+				// Refresh client list, keeping the admin's filter and page
+				b.refreshClientList(chatID, panelIndex, messageID)
 			}
+			return nil
 		}
 	}
 
-	if strings.HasPrefix(data, "msg_") {
-		parts := strings.Split(data, "_")
-		if len(parts) == 3 {
-			inboundID, err1 := strconv.Atoi(parts[1])
-			clientIndex, err2 := strconv.Atoi(parts[2])
-
-			if err1 == nil && err2 == nil {
-				cacheKey := fmt.Sprintf("%d_%d", inboundID, clientIndex)
-				if client, ok := b.getClientFromCacheCopy(cacheKey); ok {
-					email := client["email"]
-					tgId := client["tgId"]
-
-					if tgId != "" && tgId != "0" {
-						// Store admin chat ID and client info for message sending
-						if err := b.setAdminMessageState(chatID, &AdminMessageState{
-							ClientEmail: email,
-							ClientTgID:  tgId,
-							InboundID:   inboundID,
-							ClientIndex: clientIndex,
-							Timestamp:   time.Now(),
-						}); err != nil {
-							b.logger.Errorf("Failed to set admin message state: %v", err)
-							return nil
-						}
-						if err := b.setUserState(chatID, "awaiting_admin_message"); err != nil {
-							b.logger.Errorf("Failed to set user state: %v", err)
-							return nil
-						}
+	if strings.HasPrefix(data, constants.CbCancelDeletePrefix) {
+		if refID, ok := parseClientCallback(data, constants.CbCancelDeletePrefix); ok {
+			if err := b.bot.AnswerCallbackQuery(context.Background(), &telego.AnswerCallbackQueryParams{
+				CallbackQueryID: query.ID,
+				Text:            t("clients.delete_cancelled"),
+			}); err != nil {
+				b.logger.Errorf("Failed to answer cancel delete callback: %v", err)
+			}
+			// Return to client menu
+			b.handleClientMenu(chatID, messageID, refID, query.ID)
+			return nil
+		}
+	}
 
-						if err := b.bot.AnswerCallbackQuery(context.Background(), &telego.AnswerCallbackQueryParams{
-							CallbackQueryID: query.ID,
-						}); err != nil { // Ask admin to type message
-							b.logger.Errorf("Failed to answer message client callback: %v", err)
-						}
-						cleanEmail := stripInboundSuffix(email)
-						msg := fmt.Sprintf("💬 Отправка сообщения клиенту %s\n\nВведите текст сообщения:", cleanEmail)
-						b.sendMessage(chatID, msg)
-					} else {
-						if err := b.bot.AnswerCallbackQuery(context.Background(), &telego.AnswerCallbackQueryParams{
-							CallbackQueryID: query.ID,
-							Text:            "❌ У клиента нет привязанного Telegram ID",
-							ShowAlert:       true,
-						}); err != nil {
-							b.logger.Errorf("Failed to answer no tg id callback: %v", err)
-						}
-					}
+	if strings.HasPrefix(data, "msg_") {
+		if refID, ok := parseClientCallback(data, "msg_"); ok {
+			_, client, err := b.resolveClient(refID, false)
+			if err != nil {
+				b.answerClientRefError(query.ID, t, err)
+				return nil
+			}
+			email := client.Email
+
+			if client.HasTgID() {
+				// Store admin chat ID and client info for message sending
+				if err := b.setAdminMessageState(chatID, &AdminMessageState{
+					ClientEmail: email,
+					ClientTgID:  strconv.FormatInt(client.TgID, 10),
+					InboundID:   client.InboundID,
+					Timestamp:   time.Now(),
+				}); err != nil {
+					b.logger.Errorf("Failed to set admin message state: %v", err)
+					return nil
+				}
+				if err := b.setUserState(chatID, "awaiting_admin_message"); err != nil {
+					b.logger.Errorf("Failed to set user state: %v", err)
 					return nil
 				}
+
+				if err := b.bot.AnswerCallbackQuery(context.Background(), &telego.AnswerCallbackQueryParams{
+					CallbackQueryID: query.ID,
+				}); err != nil { // Ask admin to type message
+					b.logger.Errorf("Failed to answer message client callback: %v", err)
+				}
+				cleanEmail := stripInboundSuffix(email)
+				msg := t("clients.message_prompt", cleanEmail)
+				b.sendMessage(chatID, msg)
+			} else {
+				if err := b.bot.AnswerCallbackQuery(context.Background(), &telego.AnswerCallbackQueryParams{
+					CallbackQueryID: query.ID,
+					Text:            t("clients.no_tg_id"),
+					ShowAlert:       true,
+				}); err != nil {
+					b.logger.Errorf("Failed to answer no tg id callback: %v", err)
+				}
 			}
+			return nil
 		}
 	}
 
@@ -733,173 +916,255 @@ func (b *Bot) handleCallback(ctx *th.Context, query telego.CallbackQuery) error
 				b.logger.Errorf("Failed to answer reply callback: %v", err)
 			}
 
-			b.sendMessage(chatID, fmt.Sprintf("💬 Введите ответ пользователю (ID: %d):", replyToUserID))
+			b.sendMessage(chatID, t("contact.reply_prompt", replyToUserID))
 			return nil
 		}
 	}
 
-	// Handle toggle_X_Y buttons - toggle across ALL inbounds
+	// Handle toggle_<ref> buttons - toggle across ALL inbounds of the panel, verified against fresh panel data
 	if strings.HasPrefix(data, "toggle_") {
-		parts := strings.Split(data, "_")
-		if len(parts) == 3 {
-			inboundID, err1 := strconv.Atoi(parts[1])
-			clientIndex, err2 := strconv.Atoi(parts[2])
-
-			if err1 == nil && err2 == nil {
-				cacheKey := fmt.Sprintf("%d_%d", inboundID, clientIndex)
-				if client, ok := b.getClientFromCacheCopy(cacheKey); ok {
-					tgIDStr := client["tgId"]
-
-					// Determine target state: if ANY inbound is enabled, we'll disable all; otherwise enable all
-					shouldEnable := true
-
-					// Find all clients with same tgId
-					inbounds, err := b.apiClient.GetInbounds(context.Background())
-					if err != nil {
-						if err := b.bot.AnswerCallbackQuery(context.Background(), &telego.AnswerCallbackQueryParams{
-							CallbackQueryID: query.ID,
-							Text:            fmt.Sprintf("❌ Ошибка получения инбаундов: %v", err),
-							ShowAlert:       true,
-						}); err != nil {
-							b.logger.Errorf("Failed to answer toggle error callback: %v", err)
-						}
-						return nil
-					}
+		if refID, ok := parseClientCallback(data, "toggle_"); ok {
+			panelIndex, client, err := b.resolveClient(refID, true)
+			if err != nil {
+				b.answerClientRefError(query.ID, t, err)
+				return nil
+			}
+			// Determine target state: if ANY inbound is enabled, we'll disable all; otherwise enable all
+			shouldEnable := true
 
-					// First pass: check if any is enabled
-					for _, inbound := range inbounds {
-						settingsStr := ""
-						if settings, ok := inbound["settings"].(string); ok {
-							settingsStr = settings
-						}
+			// Find all clients with same tgId on the panel
+			apiClient, found := b.panelAt(panelIndex)
+			if !found {
+				if err := b.bot.AnswerCallbackQuery(context.Background(), &telego.AnswerCallbackQueryParams{
+					CallbackQueryID: query.ID,
+					Text:            t("panel.not_found"),
+					ShowAlert:       true,
+				}); err != nil {
+					b.logger.Errorf("Failed to answer toggle error callback: %v", err)
+				}
+				return nil
+			}
+			inbounds, err := apiClient.GetInbounds(context.Background())
+			if err != nil {
+				if err := b.bot.AnswerCallbackQuery(context.Background(), &telego.AnswerCallbackQueryParams{
+					CallbackQueryID: query.ID,
+					Text:            t("clients.error_inbounds", err),
+					ShowAlert:       true,
+				}); err != nil {
+					b.logger.Errorf("Failed to answer toggle error callback: %v", err)
+				}
+				return nil
+			}
 
-						clients, err := b.clientService.ParseClients(settingsStr)
-						if err != nil {
-							continue
-						}
+			// First pass: check if any is enabled
+			for _, inbound := range inbounds {
+				clients, err := inbound.Clients()
+				if err != nil {
+					b.logger.Warnf("Skipping invalid clients of inbound %d: %v", inbound.ID, err)
+				}
 
-						for _, c := range clients {
-							if c["tgId"] == tgIDStr && tgIDStr != "" && tgIDStr != "0" {
-								if c["enable"] == "true" {
-									shouldEnable = false // Found enabled instance, so we'll disable all
-									break
-								}
-							}
-						}
-						if !shouldEnable {
+				for _, c := range clients {
+					if sameClientOwner(c, client) {
+						if c.Enable {
+							shouldEnable = false // Found enabled instance, so we'll disable all
 							break
 						}
 					}
+				}
+				if !shouldEnable {
+					break
+				}
+			}
 
-					// Second pass: toggle all instances
-					toggledCount := 0
-					var toggleErrors []string
-
-					for _, inbound := range inbounds {
-						ibID := int(inbound["id"].(float64))
-						settingsStr := ""
-						if settings, ok := inbound["settings"].(string); ok {
-							settingsStr = settings
-						}
+			// Second pass: toggle all instances
+			toggledCount := 0
+			var toggleErrors []string
 
-						clients, err := b.clientService.ParseClients(settingsStr)
-						if err != nil {
-							continue
-						}
+			for _, inbound := range inbounds {
+				ibID := inbound.ID
 
-						for idx, c := range clients {
-							if c["tgId"] == tgIDStr && tgIDStr != "" && tgIDStr != "0" {
-								// Parse raw JSON
-								rawJSON := c["_raw_json"]
-								var clientData map[string]interface{}
-								if err := json.Unmarshal([]byte(rawJSON), &clientData); err != nil {
-									toggleErrors = append(toggleErrors, fmt.Sprintf("inbound %d: parse error", ibID))
-									continue
-								}
-
-								var err error
-								if shouldEnable {
-									err = b.clientService.EnableClient(ibID, c["email"], c)
-								} else {
-									err = b.clientService.DisableClient(ibID, c["email"], c)
-								}
-
-								if err != nil {
-									toggleErrors = append(toggleErrors, fmt.Sprintf("inbound %d: %v", ibID, err))
-									b.logger.Errorf("Failed to toggle client in inbound %d: %v", ibID, err)
-								} else {
-									toggledCount++
-									b.logger.Infof("Toggled client %s in inbound %d (enable: %v)", c["email"], ibID, shouldEnable)
-
-									// Update cache
-									ck := fmt.Sprintf("%d_%d", ibID, idx)
-									if shouldEnable {
-										b.updateClientField(ck, "enable", "true")
-									} else {
-										b.updateClientField(ck, "enable", "false")
-									}
-								}
-								break
-							}
-						}
-					}
+				clients, err := inbound.Clients()
+				if err != nil {
+					b.logger.Warnf("Skipping invalid clients of inbound %d: %v", ibID, err)
+				}
 
-					// Report result
-					var resultMsg string
-					if toggledCount == 0 {
-						resultMsg = fmt.Sprintf("❌ Не удалось изменить статус: %s", strings.Join(toggleErrors, "; "))
-					} else {
+				for _, c := range clients {
+					if sameClientOwner(c, client) {
+						var err error
 						if shouldEnable {
-							resultMsg = fmt.Sprintf("✅ Разблокировано в %d инбаундах", toggledCount)
+							err = b.clientService.EnableClient(c)
 						} else {
-							resultMsg = fmt.Sprintf("🔒 Заблокировано в %d инбаундах", toggledCount)
+							err = b.clientService.DisableClient(c)
 						}
-						if len(toggleErrors) > 0 {
-							resultMsg += fmt.Sprintf("\nОшибки: %s", strings.Join(toggleErrors, "; "))
+
+						if err != nil {
+							toggleErrors = append(toggleErrors, fmt.Sprintf("inbound %d: %v", ibID, err))
+							b.logger.Errorf("Failed to toggle client in inbound %d: %v", ibID, err)
+						} else {
+							toggledCount++
+							b.logger.Infof("Toggled client %s in inbound %d (enable: %v)", c.Email, ibID, shouldEnable)
 						}
+						break
 					}
+				}
+			}
 
-					if err := b.bot.AnswerCallbackQuery(context.Background(), &telego.AnswerCallbackQueryParams{
-						CallbackQueryID: query.ID,
-						Text:            resultMsg,
-					}); err != nil {
-						b.logger.Errorf("Failed to answer toggle success callback: %v", err)
-					}
+			if toggledCount > 0 {
+				action, before, after := storage.AuditActionBlock, "enabled", "disabled"
+				if shouldEnable {
+					action, before, after = storage.AuditActionUnblock, "disabled", "enabled"
+				}
+				b.recordAudit(userID, action, client.Email, client.TgID, before, after)
+				if client.HasTgID() {
+					b.syncUserRecord(client.TgID, "")
+				}
+			}
 
-					// Refresh client menu with updated data
-					b.handleClientMenu(chatID, messageID, inboundID, clientIndex, query.ID)
-					return nil
+			// Report result
+			var resultMsg string
+			if toggledCount == 0 {
+				resultMsg = t("clients.toggle_failed", strings.Join(toggleErrors, "; "))
+			} else {
+				if shouldEnable {
+					resultMsg = t("clients.toggle_enabled", toggledCount)
+				} else {
+					resultMsg = t("clients.toggle_disabled", toggledCount)
+				}
+				if len(toggleErrors) > 0 {
+					resultMsg += t("clients.errors_line", strings.Join(toggleErrors, "; "))
 				}
 			}
+
+			if err := b.bot.AnswerCallbackQuery(context.Background(), &telego.AnswerCallbackQueryParams{
+				CallbackQueryID: query.ID,
+				Text:            resultMsg,
+			}); err != nil {
+				b.logger.Errorf("Failed to answer toggle success callback: %v", err)
+			}
+
+			// Refresh client menu with updated data
+			b.handleClientMenu(chatID, messageID, refID, query.ID)
+			return nil
+		}
+	}
+
+	// Handle the broadcast composer, audience, confirmation and cancellation
+	switch data {
+	case constants.CbBroadcastButtons, constants.CbBroadcastSchedule, constants.CbBroadcastRepeat, constants.CbBroadcastPin, constants.CbBroadcastPreview, constants.CbBroadcastNext:
+		answer := b.handleBroadcastComposer(chatID, messageID, data)
+		if err := b.bot.AnswerCallbackQuery(context.Background(), &telego.AnswerCallbackQueryParams{
+			CallbackQueryID: query.ID,
+			Text:            answer,
+		}); err != nil {
+			b.logger.Errorf("Failed to answer callback query for broadcast composer: %v", err)
 		}
+		return nil
 	}
 
-	// Handle broadcast confirmation/cancellation
-	if data == constants.CbBroadcastConfirm {
-		b.handleBroadcastConfirm(chatID, messageID)
+	if strings.HasPrefix(data, constants.CbBroadcastAudiencePrefix) {
 		if err := b.bot.AnswerCallbackQuery(context.Background(), &telego.AnswerCallbackQueryParams{
 			CallbackQueryID: query.ID,
-			Text:            "📢 Отправка рассылки...",
 		}); err != nil {
-			b.logger.Errorf("Failed to answer callback query for broadcast confirm: %v", err)
+			b.logger.Errorf("Failed to answer callback query for broadcast audience: %v", err)
 		}
+		b.handleBroadcastAudience(chatID, messageID, strings.TrimPrefix(data, constants.CbBroadcastAudiencePrefix))
 		return nil
 	}
 
+	if strings.HasPrefix(data, constants.CbBroadcastSendPrefix) {
+		if aud, ok := parseAudience(strings.TrimPrefix(data, constants.CbBroadcastSendPrefix)); ok {
+			answer := b.handleBroadcastConfirm(chatID, messageID, aud)
+			if err := b.bot.AnswerCallbackQuery(context.Background(), &telego.AnswerCallbackQueryParams{
+				CallbackQueryID: query.ID,
+				Text:            answer,
+			}); err != nil {
+				b.logger.Errorf("Failed to answer callback query for broadcast confirm: %v", err)
+			}
+			return nil
+		}
+	}
+
 	if data == constants.CbBroadcastCancel {
 		b.handleBroadcastCancel(chatID, messageID)
 		if err := b.bot.AnswerCallbackQuery(context.Background(), &telego.AnswerCallbackQueryParams{
 			CallbackQueryID: query.ID,
-			Text:            "❌ Отменено",
+			Text:            t("broadcast.cancelled_short"),
 		}); err != nil {
 			b.logger.Errorf("Failed to answer callback query for broadcast cancel: %v", err)
 		}
 		return nil
 	}
 
+	// Handle the buttons of broadcast progress messages
+	for _, prefix := range []string{constants.CbBroadcastPausePrefix, constants.CbBroadcastResumePrefix, constants.CbBroadcastStopPrefix, constants.CbBroadcastRetryPrefix} {
+		if !strings.HasPrefix(data, prefix) {
+			continue
+		}
+		if jobID, err := strconv.ParseInt(strings.TrimPrefix(data, prefix), 10, 64); err == nil {
+			answer := b.handleBroadcastControl(chatID, prefix, jobID)
+			if err := b.bot.AnswerCallbackQuery(context.Background(), &telego.AnswerCallbackQueryParams{
+				CallbackQueryID: query.ID,
+				Text:            answer,
+			}); err != nil {
+				b.logger.Errorf("Failed to answer broadcast control callback: %v", err)
+			}
+			return nil
+		}
+	}
+
+	// Handle the list and cards of broadcast schedules
+	if data == constants.CbScheduleList {
+		if err := b.bot.AnswerCallbackQuery(context.Background(), &telego.AnswerCallbackQueryParams{
+			CallbackQueryID: query.ID,
+		}); err != nil {
+			b.logger.Errorf("Failed to answer schedule list callback: %v", err)
+		}
+		b.showSchedules(chatID, messageID)
+		return nil
+	}
+	for _, prefix := range []string{constants.CbScheduleOpenPrefix, constants.CbScheduleTimePrefix, constants.CbScheduleCronPrefix,
+		constants.CbScheduleTextPrefix, constants.CbSchedulePreviewPrefix, constants.CbScheduleDeletePrefix} {
+		if !strings.HasPrefix(data, prefix) {
+			continue
+		}
+		if id, err := strconv.ParseInt(strings.TrimPrefix(data, prefix), 10, 64); err == nil {
+			answer := b.handleScheduleAction(chatID, messageID, prefix, id)
+			if err := b.bot.AnswerCallbackQuery(context.Background(), &telego.AnswerCallbackQueryParams{
+				CallbackQueryID: query.ID,
+				Text:            answer,
+			}); err != nil {
+				b.logger.Errorf("Failed to answer schedule callback: %v", err)
+			}
+			return nil
+		}
+	}
+
+	// Handle audit log pages and export
+	if strings.HasPrefix(data, constants.CbAuditPagePrefix) {
+		if page, err := strconv.Atoi(strings.TrimPrefix(data, constants.CbAuditPagePrefix)); err == nil {
+			if err := b.bot.AnswerCallbackQuery(context.Background(), &telego.AnswerCallbackQueryParams{
+				CallbackQueryID: query.ID,
+			}); err != nil {
+				b.logger.Errorf("Failed to answer audit page callback: %v", err)
+			}
+			b.handleAuditPage(chatID, messageID, page)
+			return nil
+		}
+	}
+
+	if data == constants.CbAuditExport {
+		if err := b.bot.AnswerCallbackQuery(context.Background(), &telego.AnswerCallbackQueryParams{
+			CallbackQueryID: query.ID,
+		}); err != nil {
+			b.logger.Errorf("Failed to answer audit export callback: %v", err)
+		}
+		b.handleAuditExport(chatID, nil)
+		return nil
+	}
+
 	// Handle forecast callbacks
-	if data == constants.CbForecastTotal {
-		b.handleForecastTotalCallback(chatID, messageID, query.ID)
+	if strings.HasPrefix(data, constants.CbForecastTotalPrefix) {
+		b.handleForecastTotalCallback(chatID, messageID, query.ID, data)
 		return nil
 	}
 	if strings.HasPrefix(data, constants.CbForecastInboundPrefix) {
@@ -910,7 +1175,7 @@ func (b *Bot) handleCallback(ctx *th.Context, query telego.CallbackQuery) error
 	// Default callback response
 	if err := b.bot.AnswerCallbackQuery(context.Background(), &telego.AnswerCallbackQueryParams{
 		CallbackQueryID: query.ID,
-		Text:            "Обработка...",
+		Text:            t("common.processing"),
 	}); err != nil {
 		b.logger.Errorf("Failed to answer callback query: %v", err)
 	}
@@ -919,56 +1184,32 @@ func (b *Bot) handleCallback(ctx *th.Context, query telego.CallbackQuery) error
 }
 
 // handleClientMenu shows actions menu for a specific client
-func (b *Bot) handleClientMenu(chatID int64, messageID int, inboundID int, clientIndex int, queryID string) {
-	cacheKey := fmt.Sprintf("%d_%d", inboundID, clientIndex)
-	client, ok := b.getClientFromCacheCopy(cacheKey)
-
-	// If not in cache, reload from API
-	if !ok {
-		inbounds, err := b.apiClient.GetInbounds(context.Background())
-		if err != nil {
-			if err := b.bot.AnswerCallbackQuery(context.Background(), &telego.AnswerCallbackQueryParams{
-				CallbackQueryID: queryID,
-				Text:            "❌ Ошибка загрузки данных",
-				ShowAlert:       true,
-			}); err != nil {
-				b.logger.Errorf("Failed to answer callback query for data loading error: %v", err)
-			}
-			return
-		}
-
-		// Find the specific inbound and client
-		for _, inbound := range inbounds {
-			if id, ok := inbound["id"].(float64); ok && int(id) == inboundID {
-				c, err := b.extractClientFromInbound(inbound, clientIndex)
-				if err == nil {
-					// Cache it for future use
-					b.storeClientToCache(cacheKey, c)
-					// Ensure the local 'client' variable has the loaded data
-					client = c
-					break
-				}
-			}
-		}
+func (b *Bot) handleClientMenu(chatID int64, messageID int, refID int64, queryID string) {
+	t := b.tr(chatID)
+	panelIndex, client, err := b.resolveClient(refID, false)
+	if err != nil {
+		b.answerClientRefError(queryID, t, err)
+		return
+	}
+	apiClient, _ := b.panelAt(panelIndex)
 
-		if !ok {
-			if err := b.bot.AnswerCallbackQuery(context.Background(), &telego.AnswerCallbackQueryParams{
-				CallbackQueryID: queryID,
-				Text:            "❌ Клиент не найден",
-				ShowAlert:       true,
-			}); err != nil {
-				b.logger.Errorf("Failed to answer callback query for client not found: %v", err)
-			}
-			return
+	// The inbound list is cached by the API client, this reuses the snapshot the client was resolved from
+	inbounds, err := apiClient.GetInbounds(context.Background())
+	if err != nil {
+		if err := b.bot.AnswerCallbackQuery(context.Background(), &telego.AnswerCallbackQueryParams{
+			CallbackQueryID: queryID,
+			Text:            t("clients.error_loading"),
+			ShowAlert:       true,
+		}); err != nil {
+			b.logger.Errorf("Failed to answer callback query for data loading error: %v", err)
 		}
+		return
 	}
 
 	// Get info from clicked client
-	email := client["email"]
-	cleanEmail := stripInboundSuffix(email)
-	tgId := client["tgId"]
-	totalGB := client["totalGB"]
-	expiryTime := client["expiryTime"]
+	cleanEmail := stripInboundSuffix(client.Email)
+	totalGB := client.TotalGB
+	expiryTime := client.ExpiryTime
 
 	// Find ALL clients with same tgId across all inbounds
 	type InboundClientInfo struct {
@@ -982,55 +1223,32 @@ func (b *Bot) handleClientMenu(chatID int64, messageID int, inboundID int, clien
 
 	var allClientInstances []InboundClientInfo
 
-	inbounds, err := b.apiClient.GetInbounds(context.Background())
-	if err == nil {
-		for _, inbound := range inbounds {
-			ibID := int(inbound["id"].(float64))
-			ibName := ""
-			if remark, ok := inbound["remark"].(string); ok && remark != "" {
-				ibName = remark
-			} else {
-				ibName = fmt.Sprintf("Inbound %d", ibID)
-			}
-
-			settingsStr := ""
-			if settings, ok := inbound["settings"].(string); ok {
-				settingsStr = settings
-			}
-
-			clients, err := b.clientService.ParseClients(settingsStr)
-			if err != nil {
-				continue
-			}
+	for _, inbound := range inbounds {
+		ibID := inbound.ID
+		ibName := inbound.Name()
 
-			for idx, c := range clients {
-				if c["tgId"] == tgId && tgId != "" && tgId != "0" {
-					// Found client in this inbound
-					isEnabled := c["enable"] == "true"
-
-					// Get traffic for this specific instance
-					var traffic int64
-					trafficData, err := b.apiClient.GetClientTraffics(context.Background(), c["email"])
-					if err == nil && trafficData != nil {
-						var up, down int64
-						if u, ok := trafficData["up"].(float64); ok {
-							up = int64(u)
-						}
-						if d, ok := trafficData["down"].(float64); ok {
-							down = int64(d)
-						}
-						traffic = up + down
-					}
+		clients, err := inbound.Clients()
+		if err != nil {
+			b.logger.Warnf("Skipping invalid clients of inbound %d: %v", ibID, err)
+		}
 
-					allClientInstances = append(allClientInstances, InboundClientInfo{
-						InboundID:   ibID,
-						InboundName: ibName,
-						ClientIndex: idx,
-						Email:       c["email"],
-						Enable:      isEnabled,
-						Traffic:     traffic,
-					})
+		for idx, c := range clients {
+			if sameClientOwner(c, client) {
+				// Get traffic for this specific instance
+				var traffic int64
+				trafficData, err := apiClient.GetClientTraffics(context.Background(), c.Email)
+				if err == nil && trafficData != nil {
+					traffic = trafficData.Used()
 				}
+
+				allClientInstances = append(allClientInstances, InboundClientInfo{
+					InboundID:   ibID,
+					InboundName: ibName,
+					ClientIndex: idx,
+					Email:       c.Email,
+					Enable:      c.Enable,
+					Traffic:     traffic,
+				})
 			}
 		}
 	}
@@ -1045,13 +1263,10 @@ func (b *Bot) handleClientMenu(chatID int64, messageID int, inboundID int, clien
 
 	// Get Telegram username
 	tgUsernameStr := ""
-	if tgId != "" && tgId != "0" {
-		tgIDInt, err := strconv.ParseInt(tgId, 10, 64)
-		if err == nil && tgIDInt > 0 {
-			_, username := b.getUserInfo(tgIDInt)
-			if username != "" {
-				tgUsernameStr = fmt.Sprintf("\n👤 Telegram: %s", username)
-			}
+	if client.HasTgID() {
+		_, username := b.getUserInfo(client.TgID)
+		if username != "" {
+			tgUsernameStr = t("clients.telegram_line", username)
 		}
 	}
 
@@ -1060,31 +1275,31 @@ func (b *Bot) handleClientMenu(chatID int64, messageID int, inboundID int, clien
 	isUnlimited := false
 	subscriptionStr := ""
 
-	if expiryTime != "" && expiryTime != "0" {
-		timestamp, err := strconv.ParseInt(expiryTime, 10, 64)
-		if err == nil && timestamp > 0 {
+	if expiryTime != 0 {
+		timestamp := expiryTime
+		if timestamp > 0 {
 			now := time.Now().UnixMilli()
 			if timestamp < now {
 				isExpired = true
 				expireDate := time.UnixMilli(timestamp).Format("02.01.2006 15:04")
-				subscriptionStr = fmt.Sprintf("⛔ Истекла: %s", expireDate)
+				subscriptionStr = t("clients.subscription_expired", expireDate)
 			} else {
 				// Calculate remaining time
 				days, hours := b.calculateTimeRemaining(timestamp)
 				expireDate := time.UnixMilli(timestamp).Format("02.01.2006 15:04")
-				subscriptionStr = fmt.Sprintf("✅ До: %s (%d дн. %d ч.)", expireDate, days, hours)
+				subscriptionStr = t("clients.subscription_until", expireDate, days, hours)
 			}
 		}
 	} else {
 		isUnlimited = true
-		subscriptionStr = "💎 Безлимитная (∞)"
+		subscriptionStr = t("clients.subscription_unlimited")
 	}
 
 	// Traffic limit info
 	trafficLimitStr := ""
-	if totalGB != "" && totalGB != "0" {
+	if totalGB != 0 {
 		// totalGB is already in bytes
-		limitBytes, _ := strconv.ParseFloat(totalGB, 64)
+		limitBytes := float64(totalGB)
 		limitGB := limitBytes / (1024 * 1024 * 1024)
 
 		percentage := 0
@@ -1092,13 +1307,13 @@ func (b *Bot) handleClientMenu(chatID int64, messageID int, inboundID int, clien
 			percentage = int(math.Ceil((float64(totalTraffic) / limitBytes) * 100))
 		}
 
-		trafficLimitStr = fmt.Sprintf(" / %.0f ГБ (%d%%)", limitGB, percentage)
+		trafficLimitStr = t("clients.traffic_limit", limitGB, percentage)
 	} else {
 		trafficLimitStr = " (∞)"
 	}
 
 	// Status - based on whether enabled in ANY inbound
-	statusText := "🟢 Активен"
+	statusText := t("clients.status_active")
 	anyEnabled := false
 	for _, instance := range allClientInstances {
 		if instance.Enable {
@@ -1108,17 +1323,17 @@ func (b *Bot) handleClientMenu(chatID int64, messageID int, inboundID int, clien
 	}
 
 	if isExpired {
-		statusText = "⛔ Истекла подписка"
+		statusText = t("clients.status_expired")
 	} else if !anyEnabled {
-		statusText = "🔴 Заблокирован"
+		statusText = t("clients.status_blocked")
 	} else if isUnlimited {
-		statusText = "💎 Безлимитная подписка"
+		statusText = t("clients.status_unlimited")
 	}
 
 	// Build inbounds list
 	inboundsListStr := ""
 	if len(allClientInstances) > 0 {
-		inboundsListStr = "\n\n🌐 <b>Инбаунды:</b>"
+		inboundsListStr = t("clients.inbounds_header")
 		for _, instance := range allClientInstances {
 			statusEmoji := "🟢"
 			if !instance.Enable {
@@ -1132,11 +1347,7 @@ func (b *Bot) handleClientMenu(chatID int64, messageID int, inboundID int, clien
 	}
 
 	// Build message
-	msg := fmt.Sprintf(
-		"👤 <b>%s</b>\n\n"+
-			"📊 Статус: %s%s\n"+
-			"📅 Подписка: %s\n\n"+
-			"📊 Трафик: %s%s%s",
+	msg := b.panelTitle(apiClient) + t("clients.card",
 		html.EscapeString(cleanEmail),
 		statusText,
 		tgUsernameStr,
@@ -1152,29 +1363,29 @@ func (b *Bot) handleClientMenu(chatID int64, messageID int, inboundID int, clien
 	// Toggle block/unblock button - will affect ALL inbounds
 	if anyEnabled {
 		buttons = append(buttons, []telego.InlineKeyboardButton{
-			tu.InlineKeyboardButton("🔒 Заблокировать везде").WithCallbackData(fmt.Sprintf("toggle_%d_%d", inboundID, clientIndex)),
+			tu.InlineKeyboardButton(t("button.block_everywhere")).WithCallbackData(clientCallback("toggle_", refID)),
 		})
 	} else {
 		buttons = append(buttons, []telego.InlineKeyboardButton{
-			tu.InlineKeyboardButton("✅ Разблокировать везде").WithCallbackData(fmt.Sprintf("toggle_%d_%d", inboundID, clientIndex)),
+			tu.InlineKeyboardButton(t("button.unblock_everywhere")).WithCallbackData(clientCallback("toggle_", refID)),
 		})
 	}
 
 	// Message button if tgId exists
-	if tgId != "" && tgId != "0" {
+	if client.HasTgID() {
 		buttons = append(buttons, []telego.InlineKeyboardButton{
-			tu.InlineKeyboardButton("💬 Написать").WithCallbackData(fmt.Sprintf("msg_%d_%d", inboundID, clientIndex)),
+			tu.InlineKeyboardButton(t("button.write")).WithCallbackData(clientCallback("msg_", refID)),
 		})
 	}
 
 	// Delete button
 	buttons = append(buttons, []telego.InlineKeyboardButton{
-		tu.InlineKeyboardButton("🗑️ Удалить").WithCallbackData(fmt.Sprintf("delete_%d_%d", inboundID, clientIndex)),
+		tu.InlineKeyboardButton(t("button.delete")).WithCallbackData(clientCallback(constants.CbDeletePrefix, refID)),
 	})
 
 	// Back button
 	buttons = append(buttons, []telego.InlineKeyboardButton{
-		tu.InlineKeyboardButton("◀️ Назад").WithCallbackData("back_to_clients"),
+		tu.InlineKeyboardButton(t(constants.BtnBack)).WithCallbackData(fmt.Sprintf("%s%d", constants.CbClientListBackPrefix, panelIndex)),
 	})
 
 	keyboard := &telego.InlineKeyboardMarkup{InlineKeyboard: buttons}
@@ -1196,13 +1407,20 @@ func (b *Bot) handleClientMenu(chatID int64, messageID int, inboundID int, clien
 	}
 }
 
-// handleForecastTotalCallback shows total forecast (back from inbound view)
-func (b *Bot) handleForecastTotalCallback(chatID int64, messageID int, callbackID string) {
-	forecast, err := b.forecastService.CalculateTotalForecast()
+// handleForecastTotalCallback handles forecast_total_P callback - shows total forecast of a panel
+func (b *Bot) handleForecastTotalCallback(chatID int64, messageID int, callbackID string, data string) {
+	t := b.tr(chatID)
+	panelIndex, err := strconv.Atoi(strings.TrimPrefix(data, constants.CbForecastTotalPrefix))
+	if err != nil {
+		b.logger.Errorf("Invalid forecast callback data: %s", data)
+		return
+	}
+
+	message, keyboard, err := b.buildTotalForecast(t, panelIndex)
 	if err != nil {
 		if err := b.bot.AnswerCallbackQuery(context.Background(), &telego.AnswerCallbackQueryParams{
 			CallbackQueryID: callbackID,
-			Text:            "❌ Ошибка расчета прогноза",
+			Text:            t("forecast.error"),
 			ShowAlert:       true,
 		}); err != nil {
 			b.logger.Errorf("Failed to answer callback query: %v", err)
@@ -1210,60 +1428,42 @@ func (b *Bot) handleForecastTotalCallback(chatID int64, messageID int, callbackI
 		return
 	}
 
-	message := "🌐 <b>ОБЩИЙ ПРОГНОЗ ТРАФИКА</b>\n\n" + b.forecastService.FormatForecastMessage(forecast)
-
-	// Build keyboard with inbounds
-	inbounds, err := b.apiClient.GetInbounds(context.Background())
-	var keyboard *telego.InlineKeyboardMarkup
-	if err == nil {
-		var rows [][]telego.InlineKeyboardButton
-		for _, inbound := range inbounds {
-			id := 0
-			if v, ok := inbound["id"].(float64); ok {
-				id = int(v)
-			}
-			remark := fmt.Sprintf("Inbound %d", id)
-			if r, ok := inbound["remark"].(string); ok && r != "" {
-				remark = r
-			}
-
-			btn := tu.InlineKeyboardButton(fmt.Sprintf("📊 %s", remark)).
-				WithCallbackData(fmt.Sprintf("%s%d", constants.CbForecastInboundPrefix, id))
-			rows = append(rows, []telego.InlineKeyboardButton{btn})
-		}
-		// Add refresh button
-		rows = append(rows, []telego.InlineKeyboardButton{
-			tu.InlineKeyboardButton("🔄 Обновить").WithCallbackData(constants.CbForecastTotal),
-		})
-		keyboard = &telego.InlineKeyboardMarkup{InlineKeyboard: rows}
-	}
-
 	b.editMessage(chatID, messageID, message, keyboard)
 	if err := b.bot.AnswerCallbackQuery(context.Background(), &telego.AnswerCallbackQueryParams{CallbackQueryID: callbackID}); err != nil {
 		b.logger.Errorf("Failed to answer callback query: %v", err)
 	}
 }
 
-// handleForecastInboundCallback handles forecast_inbound_X callback
+// handleForecastInboundCallback handles forecast_inbound_P_X callback
 func (b *Bot) handleForecastInboundCallback(chatID int64, messageID int, callbackID string, data string) {
-	// Parse inbound ID from callback data: forecast_inbound_X
+	t := b.tr(chatID)
+
+	// Parse panel index and inbound ID from callback data: forecast_inbound_P_X
 	parts := strings.Split(data, "_")
-	if len(parts) != 3 {
+	if len(parts) != 4 {
 		b.logger.Errorf("Invalid forecast callback data: %s", data)
 		return
 	}
 
-	inboundID, err := strconv.Atoi(parts[2])
-	if err != nil {
-		b.logger.Errorf("Failed to parse inbound ID from callback: %v", err)
+	panelIndex, err1 := strconv.Atoi(parts[2])
+	inboundID, err2 := strconv.Atoi(parts[3])
+	if err1 != nil || err2 != nil {
+		b.logger.Errorf("Failed to parse forecast callback: %s", data)
+		return
+	}
+
+	forecastService := b.forecastServiceAt(panelIndex)
+	apiClient, ok := b.panelAt(panelIndex)
+	if forecastService == nil || !ok {
+		b.logger.Errorf("Unknown panel index in forecast callback: %s", data)
 		return
 	}
 
-	forecast, err := b.forecastService.CalculateForecast(inboundID)
+	forecast, err := forecastService.CalculateForecast(inboundID)
 	if err != nil {
 		if err := b.bot.AnswerCallbackQuery(context.Background(), &telego.AnswerCallbackQueryParams{
 			CallbackQueryID: callbackID,
-			Text:            fmt.Sprintf("❌ Ошибка: %v", err),
+			Text:            t("common.error", err),
 			ShowAlert:       true,
 		}); err != nil {
 			b.logger.Errorf("Failed to answer callback query: %v", err)
@@ -1271,12 +1471,12 @@ func (b *Bot) handleForecastInboundCallback(chatID int64, messageID int, callbac
 		return
 	}
 
-	message := fmt.Sprintf("📊 <b>ПРОГНОЗ ДЛЯ INBOUND #%d</b>\n\n%s", inboundID, b.forecastService.FormatForecastMessage(forecast))
+	message := b.panelTitle(apiClient) + t("forecast.inbound_title", inboundID, forecastService.FormatForecastMessage(t, forecast))
 
 	// Back button
 	keyboard := tu.InlineKeyboard(
 		tu.InlineKeyboardRow(
-			tu.InlineKeyboardButton("🔙 Назад к общему").WithCallbackData(constants.CbForecastTotal),
+			tu.InlineKeyboardButton(t("button.back_to_total")).WithCallbackData(fmt.Sprintf("%s%d", constants.CbForecastTotalPrefix, panelIndex)),
 		),
 	)
 
@@ -0,0 +1,52 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"x-ui-bot/internal/bot/constants"
+	"x-ui-bot/internal/storage"
+)
+
+func TestExtensionWithoutReceiptCallbackFromUser(t *testing.T) {
+	const userID = 42
+	b, tg, store := newTestBot(t, testConfig(t, "http://127.0.0.1:1", ""))
+
+	req := &storage.ExtensionRequest{
+		UserID:   userID,
+		Duration: 30,
+		Price:    300,
+		Status:   storage.ExtensionStatusAwaitingReceipt,
+	}
+	if err := store.CreateExtensionRequest(req); err != nil {
+		t.Fatalf("CreateExtensionRequest: %v", err)
+	}
+
+	data := fmt.Sprintf("%s%d", constants.CbExtNoReceiptPrefix, req.ID)
+	if err := b.handleCallback(nil, callbackQuery(userID, data)); err != nil {
+		t.Fatalf("handleCallback: %v", err)
+	}
+
+	for _, answer := range tg.sent("answerCallbackQuery", 0) {
+		if answer.Params["show_alert"] == true {
+			t.Fatalf("callback refused with %q", answer.Params["text"])
+		}
+	}
+
+	got, err := store.GetExtensionRequest(req.ID)
+	if err != nil {
+		t.Fatalf("GetExtensionRequest: %v", err)
+	}
+	if got.Status != storage.ExtensionStatusPending {
+		t.Errorf("request status = %q, want %q", got.Status, storage.ExtensionStatusPending)
+	}
+
+	cards := tg.sent("sendMessage", testAdminID)
+	if len(cards) != 1 {
+		t.Fatalf("admin got %d messages, want the request card", len(cards))
+	}
+	if !strings.Contains(cards[0].Text(), fmt.Sprint(userID)) {
+		t.Errorf("request card does not mention user %d: %q", userID, cards[0].Text())
+	}
+}
@@ -0,0 +1,333 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"x-ui-bot/internal/bot/constants"
+	"x-ui-bot/internal/bot/services"
+	"x-ui-bot/internal/i18n"
+	"x-ui-bot/internal/storage"
+
+	"github.com/mymmrac/telego"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+// Handlers for broadcasts sent later or repeatedly: creating a schedule from the composer,
+// listing, editing and cancelling schedules, and queuing a job for each run
+
+// Fields of a broadcast schedule an admin can edit by sending a message
+const (
+	scheduleFieldTime = "time"
+	scheduleFieldCron = "cron"
+	scheduleFieldText = "text"
+)
+
+// scheduleEdit is the field of a broadcast schedule an admin was asked for
+type scheduleEdit struct {
+	ID    int64
+	Field string
+}
+
+// scheduleBroadcast stores the draft as a schedule for the audience and returns the text of the callback answer.
+// The first run is the send time of the draft, or the next time its cron spec fires
+func (b *Bot) scheduleBroadcast(chatID int64, messageID int, state *BroadcastState, aud broadcastAudience) string {
+	t := b.tr(chatID)
+
+	content := state.BroadcastContent
+	if content.SendAt.IsZero() {
+		next, err := services.NextRun(state.Cron, time.Now())
+		if err != nil {
+			b.logger.Errorf("Broadcast draft of admin %d has an unusable cron spec %q: %v", chatID, state.Cron, err)
+			b.editMessageText(chatID, messageID, t("broadcast.error_cron", html.EscapeString(err.Error())))
+			return ""
+		}
+		content.SendAt = next
+	}
+
+	schedule := &storage.BroadcastSchedule{
+		CreatedBy:        chatID,
+		BroadcastContent: content,
+		Audience:         aud.code(),
+		Cron:             state.Cron,
+	}
+	if err := b.storage.CreateBroadcastSchedule(schedule); err != nil {
+		b.logger.Errorf("Failed to save broadcast schedule: %v", err)
+		b.editMessageText(chatID, messageID, t("schedule.error_save"))
+		return ""
+	}
+	b.broadcastScheduler.Notify()
+	b.logger.Infof("Broadcast schedule %d created by admin %d for audience %s, first run at %s",
+		schedule.ID, chatID, schedule.Audience, schedule.SendAt.Format(time.RFC3339))
+
+	if err := b.deleteBroadcastState(chatID); err != nil {
+		b.logger.Errorf("Failed to delete broadcast state: %v", err)
+	}
+	if err := b.deleteUserState(chatID); err != nil {
+		b.logger.Errorf("Failed to delete user state: %v", err)
+	}
+
+	b.editMessage(chatID, messageID, t("schedule.created", schedule.ID, schedule.SendAt.Format(sendAtLayout)), tu.InlineKeyboard(
+		tu.InlineKeyboardRow(
+			tu.InlineKeyboardButton(t("button.schedule_open")).WithCallbackData(fmt.Sprintf("%s%d", constants.CbScheduleOpenPrefix, schedule.ID)),
+			tu.InlineKeyboardButton(t("button.schedule_list")).WithCallbackData(constants.CbScheduleList),
+		),
+	))
+	return t("schedule.created_short")
+}
+
+// runScheduledBroadcast queues the broadcast job of one run of a schedule. The recipients are
+// collected at the time of the run, the admin who scheduled it gets a progress message
+func (b *Bot) runScheduledBroadcast(schedule *storage.BroadcastSchedule) error {
+	adminID := schedule.CreatedBy
+	t := b.tr(adminID)
+
+	aud, ok := parseAudience(schedule.Audience)
+	if !ok {
+		b.sendMessage(adminID, t("schedule.run_failed", schedule.ID))
+		return fmt.Errorf("unknown audience %q", schedule.Audience)
+	}
+	recipients, err := b.audienceRecipients(aud)
+	if err != nil {
+		b.sendMessage(adminID, t("schedule.run_failed", schedule.ID))
+		return fmt.Errorf("failed to get recipients: %w", err)
+	}
+	if len(recipients) == 0 {
+		b.sendMessage(adminID, t("schedule.run_empty", schedule.ID, b.audienceLabel(t, aud)))
+		return nil
+	}
+
+	b.sendMessage(adminID, t("schedule.run_started", schedule.ID, b.audienceLabel(t, aud)))
+	// This message becomes the progress message of the job
+	msg, err := b.bot.SendMessage(context.Background(), tu.Message(tu.ID(adminID), t("broadcast.in_progress")).WithParseMode(telego.ModeHTML))
+	messageID := 0
+	if err != nil {
+		b.logger.Warnf("Failed to send broadcast progress message to admin %d: %v", adminID, err)
+	} else {
+		messageID = msg.MessageID
+	}
+
+	content := schedule.BroadcastContent
+	content.SendAt = time.Time{}
+	job := &storage.BroadcastJob{
+		CreatedBy:        adminID,
+		BroadcastContent: content,
+		ChatID:           adminID,
+		MessageID:        messageID,
+	}
+	if err := b.broadcastService.Enqueue(job, recipients); err != nil {
+		if messageID != 0 {
+			b.editMessageText(adminID, messageID, t("broadcast.error_queue"))
+		}
+		return err
+	}
+	b.logger.Infof("Broadcast schedule %d queued job %d for audience %s", schedule.ID, job.ID, schedule.Audience)
+	b.showBroadcastProgress(job)
+	return nil
+}
+
+// showSchedules lists the broadcast schedules, next run first, with a button to open each
+func (b *Bot) showSchedules(chatID int64, messageID ...int) {
+	t := b.tr(chatID)
+	schedules, err := b.storage.GetBroadcastSchedules()
+	if err != nil {
+		b.logger.Errorf("Failed to get broadcast schedules: %v", err)
+		b.sendMessage(chatID, t("schedule.error_list"))
+		return
+	}
+
+	msg := t("schedule.list_empty")
+	var rows [][]telego.InlineKeyboardButton
+	if len(schedules) > 0 {
+		var sb strings.Builder
+		sb.WriteString(t("schedule.list_title"))
+		for _, schedule := range schedules {
+			sb.WriteString(t("schedule.list_item", schedule.ID, schedule.SendAt.Format(sendAtLayout), scheduleRepeatLabel(t, schedule.Cron)))
+			rows = append(rows, tu.InlineKeyboardRow(
+				tu.InlineKeyboardButton(t("button.schedule_item", schedule.ID, schedule.SendAt.Format(sendAtLayout))).
+					WithCallbackData(fmt.Sprintf("%s%d", constants.CbScheduleOpenPrefix, schedule.ID)),
+			))
+		}
+		msg = sb.String()
+	}
+
+	switch {
+	case len(messageID) > 0 && len(rows) > 0:
+		b.editMessage(chatID, messageID[0], msg, tu.InlineKeyboard(rows...))
+	case len(messageID) > 0:
+		b.editMessageText(chatID, messageID[0], msg)
+	case len(rows) > 0:
+		b.sendMessageWithInlineKeyboard(chatID, msg, tu.InlineKeyboard(rows...))
+	default:
+		b.sendMessage(chatID, msg)
+	}
+}
+
+// scheduleRepeatLabel describes how a schedule repeats
+func scheduleRepeatLabel(t i18n.Translator, cron string) string {
+	if cron == "" {
+		return t("schedule.once")
+	}
+	return t("schedule.repeats", html.EscapeString(cron))
+}
+
+// showSchedule shows the card of a schedule with the buttons to edit, preview and cancel it
+func (b *Bot) showSchedule(chatID int64, schedule *storage.BroadcastSchedule, messageID ...int) {
+	t := b.tr(chatID)
+
+	audience := html.EscapeString(schedule.Audience)
+	if aud, ok := parseAudience(schedule.Audience); ok {
+		audience = b.audienceLabel(t, aud)
+	}
+	lastRun := t("schedule.never")
+	if !schedule.LastRunAt.IsZero() {
+		lastRun = schedule.LastRunAt.Format(sendAtLayout)
+	}
+	msg := t("schedule.card", schedule.ID, b.broadcastSummary(t, schedule.BroadcastContent, schedule.Cron), audience, lastRun)
+
+	button := func(key, prefix string) telego.InlineKeyboardButton {
+		return tu.InlineKeyboardButton(t(key)).WithCallbackData(fmt.Sprintf("%s%d", prefix, schedule.ID))
+	}
+	keyboard := tu.InlineKeyboard(
+		tu.InlineKeyboardRow(
+			button("button.broadcast_schedule", constants.CbScheduleTimePrefix),
+			button("button.broadcast_repeat", constants.CbScheduleCronPrefix),
+		),
+		tu.InlineKeyboardRow(
+			button("button.schedule_text", constants.CbScheduleTextPrefix),
+			button("button.broadcast_preview", constants.CbSchedulePreviewPrefix),
+		),
+		tu.InlineKeyboardRow(
+			button("button.schedule_delete", constants.CbScheduleDeletePrefix),
+		),
+		tu.InlineKeyboardRow(
+			tu.InlineKeyboardButton(t("button.back")).WithCallbackData(constants.CbScheduleList),
+		),
+	)
+
+	if len(messageID) > 0 {
+		b.editMessage(chatID, messageID[0], msg, keyboard)
+		return
+	}
+	b.sendMessageWithInlineKeyboard(chatID, msg, keyboard)
+}
+
+// handleScheduleAction handles the buttons of a schedule card and returns the text of the callback answer
+func (b *Bot) handleScheduleAction(chatID int64, messageID int, prefix string, id int64) string {
+	t := b.tr(chatID)
+	schedule, err := b.storage.GetBroadcastSchedule(id)
+	if err != nil {
+		// A one-off schedule is removed once it has run
+		b.showSchedules(chatID, messageID)
+		return t("schedule.not_found", id)
+	}
+
+	switch prefix {
+	case constants.CbScheduleOpenPrefix:
+		b.showSchedule(chatID, schedule, messageID)
+
+	case constants.CbScheduleTimePrefix, constants.CbScheduleCronPrefix, constants.CbScheduleTextPrefix:
+		edit := scheduleEdit{ID: id}
+		prompt := ""
+		switch prefix {
+		case constants.CbScheduleTimePrefix:
+			edit.Field, prompt = scheduleFieldTime, sendAtPrompt(t)
+		case constants.CbScheduleCronPrefix:
+			edit.Field, prompt = scheduleFieldCron, t("broadcast.cron_prompt", time.Now().Format(sendAtLayout))
+		case constants.CbScheduleTextPrefix:
+			edit.Field, prompt = scheduleFieldText, t("schedule.text_prompt")
+		}
+		if err := b.setUserState(chatID, constants.StateAwaitingScheduleEdit); err != nil {
+			return t("common.error_state")
+		}
+		b.scheduleEdits.Store(chatID, edit)
+		b.sendMessage(chatID, prompt)
+
+	case constants.CbSchedulePreviewPrefix:
+		if err := b.broadcastService.Preview(context.Background(), chatID, schedule.BroadcastContent); err != nil {
+			b.logger.Warnf("Failed to send preview of broadcast schedule %d to admin %d: %v", id, chatID, err)
+			b.sendMessage(chatID, t("broadcast.error_preview", html.EscapeString(err.Error())))
+		}
+
+	case constants.CbScheduleDeletePrefix:
+		if err := b.storage.DeleteBroadcastSchedule(id); err != nil {
+			b.logger.Errorf("Failed to delete broadcast schedule %d: %v", id, err)
+			return t("common.error", err)
+		}
+		b.logger.Infof("Broadcast schedule %d cancelled by admin %d", id, chatID)
+		b.showSchedules(chatID, messageID)
+		return t("schedule.deleted_short", id)
+	}
+	return ""
+}
+
+// handleScheduleEditInput applies the new send time, cron spec or text of a schedule. As in the composer
+// "-" sends a schedule right away or makes it a one-off broadcast
+func (b *Bot) handleScheduleEditInput(chatID int64, input string) {
+	t := b.tr(chatID)
+	value, ok := b.scheduleEdits.Load(chatID)
+	if !ok {
+		if err := b.deleteUserState(chatID); err != nil {
+			b.logger.Errorf("Failed to delete user state: %v", err)
+		}
+		b.sendMessage(chatID, t("common.error_no_state"))
+		return
+	}
+	edit := value.(scheduleEdit)
+
+	schedule, err := b.storage.GetBroadcastSchedule(edit.ID)
+	if err != nil {
+		b.scheduleEdits.Delete(chatID)
+		if err := b.deleteUserState(chatID); err != nil {
+			b.logger.Errorf("Failed to delete user state: %v", err)
+		}
+		b.sendMessage(chatID, t("schedule.not_found", edit.ID))
+		return
+	}
+
+	cleared := strings.TrimSpace(input) == "-"
+	var errText string
+	switch edit.Field {
+	case scheduleFieldTime:
+		if cleared {
+			schedule.SendAt = time.Now()
+		} else {
+			schedule.SendAt, errText = parseSendAt(t, input, time.Now())
+		}
+	case scheduleFieldCron:
+		if cleared {
+			schedule.Cron = ""
+		} else {
+			schedule.Cron, errText = parseCronInput(t, input)
+		}
+	case scheduleFieldText:
+		schedule.Message = input
+		if !b.captionFits(schedule.BroadcastContent) {
+			errText = t("broadcast.error_caption", broadcastCaptionLimit)
+		}
+	}
+	if errText != "" {
+		// The admin may send a corrected value
+		b.sendMessage(chatID, errText)
+		return
+	}
+
+	b.scheduleEdits.Delete(chatID)
+	if err := b.deleteUserState(chatID); err != nil {
+		b.logger.Errorf("Failed to delete user state: %v", err)
+	}
+	if err := b.storage.UpdateBroadcastSchedule(schedule); err != nil {
+		// The schedule ran or was edited meanwhile
+		b.logger.Warnf("Broadcast schedule %d not changed by admin %d: %v", edit.ID, chatID, err)
+		b.sendMessage(chatID, t("schedule.error_changed", edit.ID))
+		return
+	}
+	b.broadcastScheduler.Notify()
+	b.logger.Infof("Admin %d changed the %s of broadcast schedule %d", chatID, edit.Field, edit.ID)
+
+	b.sendMessage(chatID, t("schedule.updated", edit.ID))
+	b.showSchedule(chatID, schedule)
+}
@@ -3,13 +3,19 @@ package bot
 import (
 	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
 	"html"
 	"strings"
 	"time"
 	"unicode/utf8"
 
+	"x-ui-bot/internal/bot/constants"
+	kbd "x-ui-bot/internal/bot/keyboard"
+	"x-ui-bot/internal/bot/middleware"
+	"x-ui-bot/internal/storage"
+	"x-ui-bot/internal/templates"
+	"x-ui-bot/pkg/client"
+
 	"github.com/mymmrac/telego"
 	tu "github.com/mymmrac/telego/telegoutil"
 )
@@ -18,45 +24,31 @@ import (
 
 // sendSubscriptionInfo sends subscription details with QR code to user
 func (b *Bot) sendSubscriptionInfo(chatID int64, userID int64, email string, title string) error {
+	t := b.tr(userID)
+
 	// Strip suffix from email for display
 	cleanEmail := stripInboundSuffix(email)
 
-	// Get subscription link - try with the provided email first (which may include suffix)
-	// If that fails, search for any email of this user across inbounds
-	subLink, err := b.apiClient.GetClientLink(context.Background(), email)
+	// Get client info and the panel the subscription lives on
+	apiClient, clientInfo, err := b.findClientByTgID(userID)
 	if err != nil {
-		// If the provided email doesn't work, search for user's email in inbounds
-		clientInfo, clientErr := b.apiClient.GetClientByTgID(context.Background(), userID)
-		if clientErr == nil {
-			// Try to get the link using the email from clientInfo
-			if emailFromClient, ok := clientInfo["email"].(string); ok && emailFromClient != "" {
-				subLink, err = b.apiClient.GetClientLink(context.Background(), emailFromClient)
-			}
-		}
-
-		if err != nil {
-			b.logger.Errorf("Failed to get subscription link: %v", err)
-			return fmt.Errorf("не удалось получить ссылку: %w", err)
-		}
+		return fmt.Errorf("%s: %w", t("subscription.error_client_info"), err)
 	}
 
-	// Get client info for detailed stats
-	clientInfo, err := b.apiClient.GetClientByTgID(context.Background(), userID)
-	if err != nil {
-		return fmt.Errorf("не удалось получить информацию о клиенте: %w", err)
+	// Get subscription link - try with the provided email first (which may include suffix)
+	// If that fails, use the email of the client found by Telegram ID
+	subLink, err := apiClient.GetClientLink(context.Background(), email)
+	if err != nil && clientInfo.Email != "" {
+		subLink, err = apiClient.GetClientLink(context.Background(), clientInfo.Email)
 	}
-
-	// Get expiry time
-	expiryTime := int64(0)
-	if et, ok := clientInfo["expiryTime"].(float64); ok {
-		expiryTime = int64(et)
+	if err != nil {
+		b.logger.Errorf("Failed to get subscription link: %v", err)
+		return fmt.Errorf("%s: %w", t("subscription.error_link"), err)
 	}
 
-	// Get traffic limit
-	totalGB := int64(0)
-	if tgb, ok := clientInfo["totalGB"].(float64); ok {
-		totalGB = int64(tgb)
-	}
+	// Get expiry time and traffic limit
+	expiryTime := clientInfo.ExpiryTime
+	totalGB := clientInfo.TotalGB
 
 	// Collect traffic stats from ALL inbounds where user exists
 	type InboundTraffic struct {
@@ -67,75 +59,31 @@ func (b *Bot) sendSubscriptionInfo(chatID int64, userID int64, email string, tit
 	var inboundTraffics []InboundTraffic
 	var totalTraffic int64
 
-	if inbounds, err := b.apiClient.GetInbounds(context.Background()); err == nil {
+	if inbounds, err := apiClient.GetInbounds(context.Background()); err == nil {
 		for _, inbound := range inbounds {
-			settingsStr := ""
-			if settings, ok := inbound["settings"].(string); ok {
-				settingsStr = settings
-			}
-
-			clients, err := b.clientService.ParseClients(settingsStr)
+			clients, err := inbound.Clients()
 			if err != nil {
-				continue
+				b.logger.Warnf("Skipping invalid clients of inbound %d: %v", inbound.ID, err)
 			}
 
 			// Find client with matching tgId
-			var matchedClient map[string]string
-			for _, client := range clients {
-				if client["tgId"] == fmt.Sprintf("%d", userID) {
-					matchedClient = client
+			var matchedClient *client.Client
+			for i := range clients {
+				if clients[i].TgID == userID {
+					matchedClient = &clients[i]
 					break
 				}
 			}
 
 			// If we found a matching client, get traffic from clientStats
 			if matchedClient != nil {
-				clientEmail := matchedClient["email"]
-
-				inboundIDInt := 0
-				if id, ok := inbound["id"].(float64); ok {
-					inboundIDInt = int(id)
-				}
-
-				// Get traffic from clientStats in the inbound
-				var up, down int64
-				found := false
-
-				if clientStats, ok := inbound["clientStats"].([]interface{}); ok {
-					for _, stat := range clientStats {
-						statMap, ok := stat.(map[string]interface{})
-						if !ok {
-							continue
-						}
-
-						if email, ok := statMap["email"].(string); ok && email == clientEmail {
-							if u, ok := statMap["up"].(float64); ok {
-								up = int64(u)
-							}
-							if d, ok := statMap["down"].(float64); ok {
-								down = int64(d)
-							}
-							found = true
-							break
-						}
-					}
-				}
-
-				if found {
-					inboundTraffic := up + down
+				if stat, found := inbound.StatByEmail(matchedClient.Email); found {
+					inboundTraffic := stat.Used()
 					// Since traffic is synced across inbounds, use the maximum value found
 					if inboundTraffic > totalTraffic {
 						totalTraffic = inboundTraffic
 					}
 
-					// Extract inbound name
-					inboundName := ""
-					if remark, ok := inbound["remark"].(string); ok && remark != "" {
-						inboundName = remark
-					} else {
-						inboundName = fmt.Sprintf("Inbound %d", inboundIDInt)
-					}
-
 					// Calculate percentage for this inbound
 					percentage := 0.0
 					if totalGB > 0 {
@@ -143,7 +91,7 @@ func (b *Bot) sendSubscriptionInfo(chatID int64, userID int64, email string, tit
 					}
 
 					inboundTraffics = append(inboundTraffics, InboundTraffic{
-						Name:       inboundName,
+						Name:       inbound.Name(),
 						Traffic:    inboundTraffic,
 						Percentage: percentage,
 					})
@@ -152,102 +100,74 @@ func (b *Bot) sendSubscriptionInfo(chatID int64, userID int64, email string, tit
 		}
 	}
 
-	// Status icon and text
-	statusIcon := "✅"
-	statusText := "Активна"
-	expiryText := ""
+	info := templates.SubscriptionInfoData{
+		Title:            title,
+		Email:            html.EscapeString(cleanEmail),
+		StatusIcon:       "✅",
+		Status:           t("subscription.status_active"),
+		DeviceLimit:      clientInfo.LimitIP,
+		TrafficUsed:      b.clientService.FormatBytes(totalTraffic),
+		SubscriptionLink: html.EscapeString(subLink),
+	}
 
 	if expiryTime == 0 {
 		// Unlimited subscription
-		statusIcon = "♾️"
-		statusText = "Безлимитная"
-		expiryText = "⏰ Истекает: ∞ (бессрочно)"
+		info.StatusIcon = "♾️"
+		info.Status = t("subscription.status_unlimited")
 	} else {
 		// Calculate days remaining
 		daysRemaining, hoursRemaining := b.calculateTimeRemaining(expiryTime)
 
 		if daysRemaining <= 0 {
-			statusIcon = "⛔"
-			statusText = "Истекла"
+			info.StatusIcon = "⛔"
+			info.Status = t("subscription.status_expired")
 		} else if daysRemaining <= 3 {
-			statusIcon = "🔴"
-			statusText = "Заканчивается"
+			info.StatusIcon = "🔴"
+			info.Status = t("subscription.status_ending")
 		} else if daysRemaining <= 7 {
-			statusIcon = "⚠️"
-			statusText = "Скоро истечёт"
+			info.StatusIcon = "⚠️"
+			info.Status = t("subscription.status_expiring")
 		}
 
-		expiryDate := time.UnixMilli(expiryTime).Format("02.01.2006 15:04")
-		expiryText = fmt.Sprintf("⏰ Истекает: %s\n📅 Осталось: %d дней %d часов", expiryDate, daysRemaining, hoursRemaining)
+		info.Expiry = time.UnixMilli(expiryTime).Format("02.01.2006 15:04")
+		info.DaysLeft, info.HoursLeft = daysRemaining, hoursRemaining
 	}
 
 	// Build traffic info
-	trafficInfo := ""
 	if totalGB > 0 {
-		limitBytes := totalGB
-		percentage := (float64(totalTraffic) / float64(limitBytes)) * 100
-		trafficEmoji := "🟢"
-		if percentage >= 90 {
-			trafficEmoji = "🔴"
-		} else if percentage >= 70 {
-			trafficEmoji = "🟡"
+		info.TrafficLimit = b.clientService.FormatBytes(totalGB)
+		info.TrafficPercent = (float64(totalTraffic) / float64(totalGB)) * 100
+		info.TrafficIcon = "🟢"
+		if info.TrafficPercent >= 90 {
+			info.TrafficIcon = "🔴"
+		} else if info.TrafficPercent >= 70 {
+			info.TrafficIcon = "🟡"
 		}
-
-		trafficInfo = fmt.Sprintf("📊 <b>Трафик:</b> %s / %s %s (%.1f%%)",
-			b.clientService.FormatBytes(totalTraffic),
-			b.clientService.FormatBytes(limitBytes),
-			trafficEmoji,
-			percentage,
-		)
-	} else {
-		trafficInfo = fmt.Sprintf("📊 <b>Трафик:</b> %s (безлимит)", b.clientService.FormatBytes(totalTraffic))
-	}
-
-	// Get device limit
-	limitDevicesText := ""
-	if limitIP, ok := clientInfo["limitIp"].(float64); ok && int(limitIP) > 0 {
-		limitDevicesText = fmt.Sprintf("\n📱 Лимит устройств: %d", int(limitIP))
 	}
 
 	// Get list of inbound names
-	inboundsList := ""
 	if len(inboundTraffics) > 0 {
 		var names []string
 		for _, it := range inboundTraffics {
 			names = append(names, it.Name)
 		}
-		inboundsList = fmt.Sprintf("\n🌐 Серверы: %s", html.EscapeString(strings.Join(names, ", ")))
-	}
-
-	msg := fmt.Sprintf(
-		"%s\n\n"+
-			"👤 Аккаунт: %s\n"+
-			"%s Статус: %s\n"+
-			"%s%s%s\n\n"+
-			"%s\n\n"+
-			"🔗 <b>Ваша VPN конфигурация:</b>\n"+
-			"<blockquote expandable>%s</blockquote>\n\n"+
-			"📲 Отсканируйте QR-код выше в приложении VPN или используйте ссылку",
-		title,
-		html.EscapeString(cleanEmail),
-		statusIcon,
-		statusText,
-		expiryText,
-		limitDevicesText,
-		inboundsList,
-		trafficInfo,
-		html.EscapeString(subLink),
-	)
+		info.Servers = html.EscapeString(strings.Join(names, ", "))
+	}
+
+	msg, err := b.renderer(userID)(templates.SubscriptionInfo, info)
+	if err != nil {
+		return fmt.Errorf("%s: %w", t("subscription.error_client_info"), err)
+	}
 
 	// Create keyboard with Instructions button
 	keyboard := tu.InlineKeyboard(
 		tu.InlineKeyboardRow(
-			tu.InlineKeyboardButton("📖 Инструкции").WithCallbackData("instructions_menu"),
+			tu.InlineKeyboardButton(t("button.instructions_menu")).WithCallbackData("instructions_menu"),
 		),
 	)
 
 	// Generate and send QR code with caption
-	qrCode, err := b.apiClient.GetClientQRCode(context.Background(), email)
+	qrCode, err := apiClient.GetClientQRCode(context.Background(), email)
 	if err != nil {
 		b.logger.Errorf("Failed to generate QR code for user %d: %v", userID, err)
 		// Fallback to text-only message
@@ -275,33 +195,33 @@ func (b *Bot) sendSubscriptionInfo(chatID int64, userID int64, email string, tit
 // handleMySubscription shows detailed subscription information for the user
 func (b *Bot) handleMySubscription(chatID int64, userID int64) {
 	b.logger.Infof("User %d requested subscription info", userID)
+	t := b.tr(userID)
 
 	// Get client info
-	clientInfo, err := b.apiClient.GetClientByTgID(context.Background(), userID)
+	_, clientInfo, err := b.findClientByTgID(userID)
 	if err != nil {
-		b.sendMessage(chatID, "❌ Вы не зарегистрированы.\n\nДля получения VPN необходимо зарегистрироваться.")
+		b.sendMessage(chatID, t("subscription.not_registered_register"))
 		// Start registration process - get user info from Telegram
 		userName, tgUsername := b.getUserInfo(userID)
 		// Remove @ prefix for storage
 		if tgUsername != "" && tgUsername[0] == '@' {
 			tgUsername = tgUsername[1:]
 		}
-		b.handleRegistrationStart(chatID, userID, userName, tgUsername)
+		b.handleRegistrationStart(chatID, userID, userName, tgUsername, b.userLanguage(userID))
 		return
 	}
 
-	email := ""
-	if e, ok := clientInfo["email"].(string); ok {
-		email = e
-	}
-
+	email := clientInfo.Email
 	if email == "" {
-		b.sendMessage(chatID, "❌ Ошибка: не удалось получить информацию о клиенте")
+		b.sendMessage(chatID, t("subscription.error_no_client_info"))
 		return
 	}
 
 	// Send subscription info with QR code
-	if err := b.sendSubscriptionInfo(chatID, userID, email, "📱 <b>Моя подписка</b>"); err != nil {
+	if !b.checkRateLimit(chatID, userID, middleware.ActionQRCode) {
+		return
+	}
+	if err := b.sendSubscriptionInfo(chatID, userID, email, t("subscription.title")); err != nil {
 		b.sendMessage(chatID, fmt.Sprintf("❌ %s", err.Error()))
 		return
 	}
@@ -312,44 +232,35 @@ func (b *Bot) handleMySubscription(chatID int64, userID int64) {
 // handleExtensionMenu shows the extension request menu
 func (b *Bot) handleExtensionMenu(chatID int64, userID int64, messageID int) {
 	b.logger.Infof("User %d opened extension menu", userID)
+	t := b.tr(userID)
 
 	// Get client info to show current subscription
-	clientInfo, err := b.apiClient.GetClientByTgID(context.Background(), userID)
+	_, clientInfo, err := b.findClientByTgID(userID)
 	if err != nil {
-		b.sendMessage(chatID, "❌ Вы не зарегистрированы в системе")
+		b.sendMessage(chatID, t("common.not_registered"))
 		return
 	}
 
-	email := ""
-	if e, ok := clientInfo["email"].(string); ok {
-		email = e
-	}
+	email := clientInfo.Email
 
 	// Get expiry time
-	expiryTime := time.Unix(0, 0)
-	if exp, ok := clientInfo["expiryTime"].(float64); ok {
-		expiryTime = time.UnixMilli(int64(exp))
-	}
+	expiryTime := time.UnixMilli(clientInfo.ExpiryTime)
 
 	cleanEmail := stripInboundSuffix(email)
-	msg := fmt.Sprintf(
-		"⏰ <b>Продление подписки</b>\n\n"+
-			"👤 Аккаунт: %s\n"+
-			"📅 Истекает: %s\n\n"+
-			"Выберите период продления:",
+	msg := t("extension.menu",
 		cleanEmail,
 		expiryTime.Format("02.01.2006 15:04"),
 	)
 
 	keyboard := tu.InlineKeyboard(
 		tu.InlineKeyboardRow(
-			tu.InlineKeyboardButton("📅 30 дней").WithCallbackData(fmt.Sprintf("extend_%d_30", userID)),
+			tu.InlineKeyboardButton(t("extension.menu_days", 30)).WithCallbackData(fmt.Sprintf("extend_%d_30", userID)),
 		),
 		tu.InlineKeyboardRow(
-			tu.InlineKeyboardButton("📅 60 дней").WithCallbackData(fmt.Sprintf("extend_%d_60", userID)),
+			tu.InlineKeyboardButton(t("extension.menu_days", 60)).WithCallbackData(fmt.Sprintf("extend_%d_60", userID)),
 		),
 		tu.InlineKeyboardRow(
-			tu.InlineKeyboardButton("📅 90 дней").WithCallbackData(fmt.Sprintf("extend_%d_90", userID)),
+			tu.InlineKeyboardButton(t("extension.menu_days", 90)).WithCallbackData(fmt.Sprintf("extend_%d_90", userID)),
 		),
 	)
 
@@ -367,71 +278,109 @@ func (b *Bot) handleExtensionMenu(chatID int64, userID int64, messageID int) {
 // handleSettings shows the settings menu for the user
 func (b *Bot) handleSettings(chatID int64, userID int64) {
 	b.logger.Infof("User %d opened settings", userID)
+	t := b.tr(userID)
 
-	msg := "⚙️ <b>Настройки</b>\n\nВыберите действие:"
+	msg := t("settings.menu")
 
-	keyboard := tu.Keyboard(
+	rows := [][]telego.KeyboardButton{
 		tu.KeyboardRow(
-			tu.KeyboardButton("🔄 Обновить username"),
+			tu.KeyboardButton(t(constants.BtnUpdateUsername)),
 		),
 		tu.KeyboardRow(
-			tu.KeyboardButton("◀️ Назад"),
+			tu.KeyboardButton(t(constants.BtnLanguage)),
 		),
-	).WithResizeKeyboard().WithIsPersistent()
+	}
+	if b.cfg().Referral.Enabled() {
+		rows = append(rows, tu.KeyboardRow(
+			tu.KeyboardButton(t(constants.BtnReferral)),
+		))
+	}
+	rows = append(rows, tu.KeyboardRow(
+		tu.KeyboardButton(t(constants.BtnBack)),
+	))
+	keyboard := tu.Keyboard(rows...).WithResizeKeyboard().WithIsPersistent()
 
 	b.sendMessageWithKeyboard(chatID, msg, keyboard)
 }
 
+// handleLanguageMenu shows the language picker
+func (b *Bot) handleLanguageMenu(chatID int64, userID int64) {
+	b.sendMessageWithInlineKeyboard(chatID, b.t(userID, "settings.language_prompt"), kbd.BuildLanguageKeyboard(b.i18n))
+}
+
+// handleLanguageChange switches the user's language and shows the settings menu in it
+func (b *Bot) handleLanguageChange(chatID int64, userID int64, messageID int, lang string) {
+	if !b.i18n.Supports(lang) {
+		b.editMessageText(chatID, messageID, b.t(userID, "settings.language_unknown"))
+		return
+	}
+
+	b.setLanguage(userID, lang)
+	b.logger.Infof("User %d switched language to %s", userID, lang)
+
+	b.editMessageText(chatID, messageID, b.t(userID, "settings.language_changed", b.t(userID, "language.name")))
+	b.handleSettings(chatID, userID)
+}
+
 // handleUpdateUsername initiates the username update process
 func (b *Bot) handleUpdateUsername(chatID int64, userID int64) {
 	b.logger.Infof("User %d requested username update", userID)
+	t := b.tr(userID)
 
 	// Get client info to verify registration
-	clientInfo, err := b.apiClient.GetClientByTgID(context.Background(), userID)
+	_, clientInfo, err := b.findClientByTgID(userID)
 	if err != nil {
-		b.sendMessage(chatID, "❌ Вы не зарегистрированы в системе")
+		b.sendMessage(chatID, t("common.not_registered"))
 		return
 	}
 
-	currentEmail := ""
-	if e, ok := clientInfo["email"].(string); ok {
-		currentEmail = stripInboundSuffix(e)
-	}
+	currentEmail := stripInboundSuffix(clientInfo.Email)
 
 	// Set state and ask for new username
 	if err := b.setUserState(chatID, "awaiting_new_email"); err != nil {
-		b.sendMessage(chatID, "❌ Ошибка сохранения состояния")
+		b.sendMessage(chatID, t("common.error_state"))
 		return
 	}
-	b.sendMessage(chatID, fmt.Sprintf("👤 Текущий username: %s\n\nВведите новый username:", currentEmail))
+	b.sendMessage(chatID, t("settings.username_prompt", currentEmail))
 	b.logger.Infof("User %d entering username update mode", userID)
 }
 
 // handleNewEmailInput processes new username input and updates client
 func (b *Bot) handleNewEmailInput(chatID int64, userID int64, newEmail string) {
 	b.logger.Infof("User %d updating username to: %s", userID, newEmail)
+	t := b.tr(userID)
 
 	// Check for __ suffix - forbidden for user input
 	if strings.Contains(newEmail, "__") {
-		b.sendMessage(chatID, "❌ Username не может содержать символы __\n\nЭто служебные символы системы.\n\nВведите новый username:")
+		b.sendMessage(chatID, t("settings.username_reserved"))
 		return
 	}
 
 	// Validate username length (3-32 characters, count actual characters not bytes)
 	usernameLength := utf8.RuneCountInString(newEmail)
 	if usernameLength < 3 {
-		b.sendMessage(chatID, "❌ Username слишком короткий. Минимум 3 символа.\n\nВведите новый username:")
+		b.sendMessage(chatID, t("settings.username_too_short"))
 		return
 	}
 	if usernameLength > 32 {
-		b.sendMessage(chatID, "❌ Username слишком длинный. Максимум 32 символа.\n\nВведите новый username:")
+		b.sendMessage(chatID, t("settings.username_too_long"))
+		return
+	}
+
+	// Find the panel the user's subscription lives on
+	apiClient, _, err := b.findClientByTgID(userID)
+	if err != nil {
+		b.sendMessage(chatID, t("common.not_registered"))
+		if err := b.deleteUserState(chatID); err != nil {
+			b.logger.Errorf("Failed to delete user state: %v", err)
+		}
 		return
 	}
 
 	// Get all inbounds to update username across all of them
-	inbounds, err := b.apiClient.GetInbounds(context.Background())
+	inbounds, err := apiClient.GetInbounds(context.Background())
 	if err != nil {
-		b.sendMessage(chatID, "❌ Ошибка получения inbounds")
+		b.sendMessage(chatID, t("common.error_inbounds"))
 		if err := b.deleteUserState(chatID); err != nil {
 			b.logger.Errorf("Failed to delete user state: %v", err)
 		}
@@ -443,53 +392,29 @@ func (b *Bot) handleNewEmailInput(chatID int64, userID int64, newEmail string) {
 	oldEmailClean := ""
 
 	for _, inbound := range inbounds {
-		inboundID := int(inbound["id"].(float64))
-		settingsStr := ""
-		if settings, ok := inbound["settings"].(string); ok {
-			settingsStr = settings
-		}
+		inboundID := inbound.ID
 
-		clients, err := b.clientService.ParseClients(settingsStr)
+		clients, err := inbound.Clients()
 		if err != nil {
 			b.logger.Errorf("Failed to parse clients for inbound %d: %v", inboundID, err)
-			continue
 		}
 
 		// Find client with matching tgId
-		for _, client := range clients {
-			if client["tgId"] == fmt.Sprintf("%d", userID) {
-				// Parse raw JSON
-				rawJSON := client["_raw_json"]
-				var clientData map[string]interface{}
-				if err := json.Unmarshal([]byte(rawJSON), &clientData); err != nil {
-					b.logger.Errorf("Failed to parse client JSON: %v", err)
-					continue
-				}
-
+		for _, c := range clients {
+			if c.TgID == userID {
 				// Get old email (with suffix if present)
-				oldEmailWithSuffix := client["email"]
+				oldEmailWithSuffix := c.Email
 				if oldEmailClean == "" {
 					oldEmailClean = stripInboundSuffix(oldEmailWithSuffix)
 				}
 
-				// Extract inbound remark (name) for suffix
-				inboundRemark := ""
-				if remark, ok := inbound["remark"].(string); ok && remark != "" {
-					inboundRemark = remark
-				} else {
-					inboundRemark = fmt.Sprintf("inbound%d", inboundID)
-				}
-
 				// Build new email with appropriate suffix for this inbound
 				// Format: email__remarkName
-				newEmailForInbound := fmt.Sprintf("%s__%s", newEmail, inboundRemark) // Update email field
-				clientData["email"] = newEmailForInbound
-
-				// Fix numeric fields
-				b.clientService.FixNumericFields(clientData)
+				c.Email = fmt.Sprintf("%s__%s", newEmail, inbound.EmailSuffix())
+				newEmailForInbound := c.Email
 
 				// Update client in this inbound
-				err = b.apiClient.UpdateClient(context.Background(), inboundID, oldEmailWithSuffix, clientData)
+				err = apiClient.UpdateClient(context.Background(), inboundID, oldEmailWithSuffix, c.Map())
 				if err != nil {
 					b.logger.Errorf("Failed to update username in inbound %d: %v", inboundID, err)
 				} else {
@@ -501,15 +426,17 @@ func (b *Bot) handleNewEmailInput(chatID int64, userID int64, newEmail string) {
 	}
 
 	if updatedCount == 0 {
-		b.sendMessage(chatID, "❌ Не удалось обновить username ни в одном inbound")
+		b.sendMessage(chatID, t("settings.username_update_failed"))
 		if err := b.deleteUserState(chatID); err != nil {
 			b.logger.Errorf("Failed to delete user state: %v", err)
 		}
 		return
 	}
 
-	b.sendMessage(chatID, fmt.Sprintf("✅ Username успешно обновлен во всех inbounds!\n\n👤 Старый: %s\n👤 Новый: %s\n📊 Обновлено: %d/%d", oldEmailClean, newEmail, updatedCount, len(inbounds)))
+	b.sendMessage(chatID, t("settings.username_updated", oldEmailClean, newEmail, updatedCount, len(inbounds)))
 	b.logger.Infof("Username updated for user %d from %s to %s in %d inbounds", userID, oldEmailClean, newEmail, updatedCount)
+	b.recordAudit(userID, storage.AuditActionRename, newEmail, userID, oldEmailClean, newEmail)
+	b.syncUserRecord(userID, "")
 
 	// Update traffic sync state records to use new email instead of old
 	// This preserves traffic sync history when username changes
@@ -529,20 +456,21 @@ func (b *Bot) handleNewEmailInput(chatID int64, userID int64, newEmail string) {
 
 // handleInstructionsMenu shows the platform selection menu
 func (b *Bot) handleInstructionsMenu(chatID int64, messageID int) {
-	keyboard := b.createInstructionsKeyboard()
+	t := b.tr(chatID)
+	keyboard := b.createInstructionsKeyboard(t)
 
 	// Edit the message to show the instructions menu
 	_, err := b.bot.EditMessageText(context.Background(), &telego.EditMessageTextParams{
 		ChatID:      telego.ChatID{ID: chatID},
 		MessageID:   messageID,
-		Text:        "📖 <b>Инструкции по настройке</b>\n\nВыберите ваше устройство:",
+		Text:        t("instructions.menu"),
 		ParseMode:   telego.ModeHTML,
 		ReplyMarkup: keyboard,
 	})
 	if err != nil {
 		b.logger.Errorf("Failed to edit message to instructions menu: %v", err)
 		// If edit fails (e.g. message too old), send a new one
-		b.sendMessageWithInlineKeyboard(chatID, "📖 <b>Инструкции по настройке</b>\n\nВыберите ваше устройство:", keyboard)
+		b.sendMessageWithInlineKeyboard(chatID, t("instructions.menu"), keyboard)
 	}
 }
 
@@ -562,38 +490,39 @@ func (b *Bot) handleInstructionPlatform(chatID int64, userID int64, messageID in
 		return
 	}
 
+	t := b.tr(userID)
 	var url string
 	var platformName string
 
 	switch platform {
 	case "ios":
-		url = b.config.Instructions.IOS
+		url = b.cfg().Instructions.IOS
 		platformName = "iOS"
 	case "macos":
-		url = b.config.Instructions.MacOS
+		url = b.cfg().Instructions.MacOS
 		platformName = "macOS"
 	case "android":
-		url = b.config.Instructions.Android
+		url = b.cfg().Instructions.Android
 		platformName = "Android"
 	case "windows":
-		url = b.config.Instructions.Windows
+		url = b.cfg().Instructions.Windows
 		platformName = "Windows"
 	}
 
 	if url == "" {
 		// Answer with alert
-		b.sendMessage(chatID, fmt.Sprintf("❌ Инструкция для %s не найдена.", platformName))
+		b.sendMessage(chatID, t("instructions.not_found", platformName))
 		return
 	}
 
-	msg := fmt.Sprintf("📄 <b>Инструкция для %s</b>\n\n<a href=\"%s\">Нажмите здесь, чтобы открыть инструкцию</a>", platformName, url)
+	msg := t("instructions.link", platformName, url)
 
 	keyboard := tu.InlineKeyboard(
 		tu.InlineKeyboardRow(
-			tu.InlineKeyboardButton("🔗 Открыть инструкцию").WithURL(url),
+			tu.InlineKeyboardButton(t("button.open_instructions")).WithURL(url),
 		),
 		tu.InlineKeyboardRow(
-			tu.InlineKeyboardButton("◀️ Назад").WithCallbackData("instructions_menu"),
+			tu.InlineKeyboardButton(t(constants.BtnBack)).WithCallbackData("instructions_menu"),
 		),
 	)
 
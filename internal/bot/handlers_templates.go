@@ -0,0 +1,53 @@
+package bot
+
+import (
+	"html"
+	"slices"
+	"strings"
+
+	"x-ui-bot/internal/templates"
+)
+
+// Message template handlers: listing the templates in use and previewing them with sample data
+
+// handleTemplates lists the notification templates or previews one: /templates [name] [language]
+func (b *Bot) handleTemplates(chatID int64, args []string) {
+	t := b.tr(chatID)
+	lang := b.userLanguage(chatID)
+
+	if len(args) == 0 {
+		var sb strings.Builder
+		sb.WriteString(t("templates.list_title"))
+		for _, name := range templates.Names() {
+			sb.WriteString(t("templates.list_item", name, html.EscapeString(b.templates.Source(lang, name))))
+		}
+		sb.WriteString(t("templates.usage"))
+		b.sendMessage(chatID, sb.String())
+		return
+	}
+
+	name := args[0]
+	if !slices.Contains(templates.Names(), name) {
+		b.sendMessage(chatID, t("templates.unknown", html.EscapeString(name))+t("templates.usage"))
+		return
+	}
+	if len(args) > 1 {
+		lang = args[1]
+		if !slices.Contains(b.i18n.Languages(), lang) {
+			b.sendMessage(chatID, t("templates.unknown_language", html.EscapeString(lang), strings.Join(b.i18n.Languages(), ", ")))
+			return
+		}
+	}
+
+	previews, err := b.templates.Preview(lang, name)
+	if err != nil {
+		b.logger.Errorf("Failed to preview template %s/%s: %v", lang, name, err)
+		b.sendMessage(chatID, t("templates.error_preview", html.EscapeString(err.Error())))
+		return
+	}
+
+	b.sendMessage(chatID, t("templates.preview_title", name, lang, html.EscapeString(b.templates.Source(lang, name)), len(previews)))
+	for i, preview := range previews {
+		b.sendMessage(chatID, t("templates.preview_item", i+1, len(previews), preview))
+	}
+}
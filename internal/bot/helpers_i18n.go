@@ -0,0 +1,76 @@
+package bot
+
+import (
+	"x-ui-bot/internal/i18n"
+	"x-ui-bot/internal/templates"
+
+	"github.com/mymmrac/telego"
+)
+
+// Localization helpers
+// Every message is rendered in the language of the user it is sent to
+
+// tr returns the translator for the user's language
+func (b *Bot) tr(userID int64) i18n.Translator {
+	return b.i18n.Translator(b.userLanguage(userID))
+}
+
+// t renders a catalog message in the user's language
+func (b *Bot) t(userID int64, key string, args ...interface{}) string {
+	return b.i18n.T(b.userLanguage(userID), key, args...)
+}
+
+// renderer returns the template renderer for the user's language
+func (b *Bot) renderer(userID int64) templates.Renderer {
+	return b.templates.Renderer(b.userLanguage(userID))
+}
+
+// userLanguage returns the language of the user: the chosen or stored one, the default language otherwise
+func (b *Bot) userLanguage(userID int64) string {
+	if lang, ok := b.languages.Load(userID); ok {
+		return lang.(string)
+	}
+
+	if user, ok := b.getUser(userID); ok && user.Language != "" {
+		lang := b.i18n.Normalize(user.Language)
+		b.languages.Store(userID, lang)
+		return lang
+	}
+	return i18n.DefaultLanguage
+}
+
+// rememberLanguage picks up the language of the user from Telegram on the first update since start.
+// A language stored for the user (chosen in settings or saved at registration) wins over the Telegram one
+func (b *Bot) rememberLanguage(from *telego.User) {
+	if from == nil {
+		return
+	}
+	if _, ok := b.languages.Load(from.ID); ok {
+		return
+	}
+
+	user, registered := b.getUser(from.ID)
+	if registered && user.Language != "" {
+		b.languages.Store(from.ID, b.i18n.Normalize(user.Language))
+		return
+	}
+
+	b.languages.Store(from.ID, b.i18n.Normalize(from.LanguageCode))
+	if registered && from.LanguageCode != "" {
+		if err := b.storage.SetUserLanguage(from.ID, from.LanguageCode); err != nil {
+			b.logger.Errorf("Failed to save language of user %d: %v", from.ID, err)
+		}
+	}
+}
+
+// setLanguage switches the user to a supported language, registered users keep it across restarts
+func (b *Bot) setLanguage(userID int64, lang string) {
+	b.languages.Store(userID, lang)
+
+	if _, registered := b.getUser(userID); !registered {
+		return
+	}
+	if err := b.storage.SetUserLanguage(userID, lang); err != nil {
+		b.logger.Errorf("Failed to save language of user %d: %v", userID, err)
+	}
+}
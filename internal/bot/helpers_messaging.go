@@ -73,3 +73,15 @@ func (b *Bot) editMessageText(chatID int64, messageID int, text string) {
 		b.logger.Errorf("Failed to edit message %d in chat %d: %v", messageID, chatID, err)
 	}
 }
+
+// editMessageCaption edits the caption of a media message without keyboard
+func (b *Bot) editMessageCaption(chatID int64, messageID int, caption string) {
+	if _, err := b.bot.EditMessageCaption(context.Background(), &telego.EditMessageCaptionParams{
+		ChatID:    tu.ID(chatID),
+		MessageID: messageID,
+		Caption:   caption,
+		ParseMode: "HTML",
+	}); err != nil {
+		b.logger.Errorf("Failed to edit caption of message %d in chat %d: %v", messageID, chatID, err)
+	}
+}
@@ -0,0 +1,187 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+	"time"
+
+	"x-ui-bot/internal/bot/services"
+	"x-ui-bot/internal/config"
+	"x-ui-bot/internal/i18n"
+	"x-ui-bot/pkg/client"
+
+	"github.com/mymmrac/telego"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+// Helpers for working with several 3x-ui panels
+
+// panelAt returns the panel at the given registry index
+func (b *Bot) panelAt(index int) (*client.APIClient, bool) {
+	return b.panels.At(index)
+}
+
+// hasMultiplePanels reports whether admin views need a server picker
+func (b *Bot) hasMultiplePanels() bool {
+	return b.panels.Len() > 1
+}
+
+// panelConfig returns the configuration of the given panel
+func (b *Bot) panelConfig(apiClient *client.APIClient) config.PanelConfig {
+	if p, ok := b.cfg().PanelByName(apiClient.Name()); ok {
+		return p
+	}
+	return b.cfg().Panel
+}
+
+// findClientByTgID searches every panel for the client linked to the Telegram ID
+func (b *Bot) findClientByTgID(userID int64) (*client.APIClient, *client.Client, error) {
+	return b.panels.FindClientByTgID(context.Background(), userID)
+}
+
+// forecastServiceAt returns the forecast service of the panel at the given index
+func (b *Bot) forecastServiceAt(index int) *services.ForecastService {
+	if index < 0 || index >= len(b.forecastServices) {
+		return nil
+	}
+	return b.forecastServices[index]
+}
+
+// panelForRegistration picks the panel a new client is created on:
+// the chosen panel if index is valid, otherwise the least loaded one
+func (b *Bot) panelForRegistration(index int) (*client.APIClient, error) {
+	if api, ok := b.panelAt(index); ok {
+		return api, nil
+	}
+	if !b.hasMultiplePanels() {
+		return b.panels.Default(), nil
+	}
+	return b.panels.LeastLoaded(context.Background())
+}
+
+// panelTitle returns a message header naming the panel when several panels are configured
+func (b *Bot) panelTitle(api *client.APIClient) string {
+	if !b.hasMultiplePanels() {
+		return ""
+	}
+	return fmt.Sprintf("🖥 <b>%s</b>\n\n", html.EscapeString(api.Name()))
+}
+
+// panelLine returns a message line naming the panel when several panels are configured
+func (b *Bot) panelLine(t i18n.Translator, api *client.APIClient) string {
+	if !b.hasMultiplePanels() {
+		return ""
+	}
+	return t("panel.line", html.EscapeString(api.Name()))
+}
+
+// backupFilename names a panel database backup, adding the panel name when several panels are configured
+func (b *Bot) backupFilename(api *client.APIClient) string {
+	date := time.Now().Format("2006-01-02_15-04")
+	if !b.hasMultiplePanels() {
+		return fmt.Sprintf("x-ui_%s.db", date)
+	}
+	return fmt.Sprintf("x-ui_%s_%s.db", api.Name(), date)
+}
+
+// buildPanelPicker creates inline keyboard with one button per panel, callback data is prefix + panel index
+func (b *Bot) buildPanelPicker(prefix string) *telego.InlineKeyboardMarkup {
+	var rows [][]telego.InlineKeyboardButton
+	for i, name := range b.panels.Names() {
+		rows = append(rows, tu.InlineKeyboardRow(
+			tu.InlineKeyboardButton("🖥 "+name).WithCallbackData(fmt.Sprintf("%s%d", prefix, i)),
+		))
+	}
+	return tu.InlineKeyboard(rows...)
+}
+
+// Errors of client ref resolution
+var (
+	errClientGone    = errors.New("client no longer exists")
+	errClientChanged = errors.New("client belongs to another user now")
+)
+
+// clientRef returns the stable ref of a listed client for its callback buttons
+func (b *Bot) clientRef(api *client.APIClient, c client.Client) (int64, error) {
+	ref, err := b.storage.GetOrCreateClientRef(api.Name(), c.Email, c.TgID)
+	if err != nil {
+		return 0, err
+	}
+	return ref.ID, nil
+}
+
+// clientCallback builds "<prefix><ref>" callback data
+func clientCallback(prefix string, refID int64) string {
+	return fmt.Sprintf("%s%d", prefix, refID)
+}
+
+// parseClientCallback parses "<prefix><ref>" callback data
+func parseClientCallback(data, prefix string) (int64, bool) {
+	refID, err := strconv.ParseInt(strings.TrimPrefix(data, prefix), 10, 64)
+	return refID, err == nil && refID > 0
+}
+
+// resolveClient finds the client a ref points to and checks it still belongs to the same user.
+// Destructive actions pass fresh to verify the target against the panel instead of the cached inbound list
+func (b *Bot) resolveClient(refID int64, fresh bool) (int, client.Client, error) {
+	ref, err := b.storage.GetClientRef(refID)
+	if err != nil {
+		return 0, client.Client{}, err
+	}
+
+	panelIndex := b.panels.IndexOf(ref.Panel)
+	apiClient, found := b.panelAt(panelIndex)
+	if !found {
+		return 0, client.Client{}, fmt.Errorf("panel %s not found", ref.Panel)
+	}
+
+	var inbounds []client.Inbound
+	if fresh {
+		inbounds, err = apiClient.RefreshInbounds(context.Background())
+	} else {
+		inbounds, err = apiClient.GetInbounds(context.Background())
+	}
+	if err != nil {
+		return 0, client.Client{}, err
+	}
+
+	for _, inbound := range inbounds {
+		clients, err := inbound.Clients()
+		if err != nil {
+			b.logger.Warnf("Skipping invalid clients of inbound %d: %v", inbound.ID, err)
+		}
+		for _, c := range clients {
+			if c.Email != ref.Email {
+				continue
+			}
+			if c.TgID != ref.TgID {
+				return 0, client.Client{}, errClientChanged
+			}
+			return panelIndex, c, nil
+		}
+	}
+	return 0, client.Client{}, errClientGone
+}
+
+// answerClientRefError answers a client button whose ref no longer resolves
+func (b *Bot) answerClientRefError(queryID string, t i18n.Translator, err error) {
+	if err := b.bot.AnswerCallbackQuery(context.Background(), &telego.AnswerCallbackQueryParams{
+		CallbackQueryID: queryID,
+		Text:            clientRefErrorText(t, err),
+		ShowAlert:       true,
+	}); err != nil {
+		b.logger.Errorf("Failed to answer client callback: %v", err)
+	}
+}
+
+// clientRefErrorText explains to an admin why a client button no longer works
+func clientRefErrorText(t i18n.Translator, err error) string {
+	if errors.Is(err, errClientChanged) {
+		return t("clients.changed")
+	}
+	return t("clients.not_found_refresh")
+}
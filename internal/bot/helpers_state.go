@@ -1,5 +1,13 @@
 package bot
 
+import (
+	"context"
+
+	"x-ui-bot/internal/storage"
+
+	"github.com/mymmrac/telego"
+)
+
 // Helper methods for storage state access
 // These methods provide convenient wrappers around storage operations
 
@@ -97,3 +105,56 @@ func (b *Bot) setBroadcastState(adminID int64, state *BroadcastState) error {
 func (b *Bot) deleteBroadcastState(adminID int64) error {
 	return b.storage.DeleteBroadcastState(adminID)
 }
+
+// getUser retrieves the local record of a registered user
+func (b *Bot) getUser(userID int64) (*storage.User, bool) {
+	user, err := b.storage.GetUser(userID)
+	if err != nil {
+		return nil, false
+	}
+	return user, true
+}
+
+// setUserTrial records whether a user is on the trial plan, trial users are a broadcast audience
+func (b *Bot) setUserTrial(userID int64, trial bool) {
+	if err := b.storage.SetUserTrial(userID, trial); err != nil {
+		b.logger.Errorf("Failed to update trial flag of user %d: %v", userID, err)
+	}
+}
+
+// setUserTgUsername stores the Telegram username of a registered user, admins search clients by it
+func (b *Bot) setUserTgUsername(userID int64, username string) {
+	if err := b.storage.SetUserTgUsername(userID, username); err != nil {
+		b.logger.Errorf("Failed to update Telegram username of user %d: %v", userID, err)
+		return
+	}
+	b.tgUsernames.Store(userID, username)
+}
+
+// rememberUsername keeps the stored Telegram username of a registered user up to date.
+// The record is read once per user since start and written only when the username changed
+func (b *Bot) rememberUsername(from *telego.User) {
+	if from == nil {
+		return
+	}
+	if known, ok := b.tgUsernames.Load(from.ID); ok && known.(string) == from.Username {
+		return
+	}
+
+	user, registered := b.getUser(from.ID)
+	if !registered {
+		return
+	}
+	if user.TgUsername == from.Username {
+		b.tgUsernames.Store(from.ID, from.Username)
+		return
+	}
+	b.setUserTgUsername(from.ID, from.Username)
+}
+
+// syncUserRecord refreshes the local record of a user after their clients changed on a panel
+func (b *Bot) syncUserRecord(userID int64, language string) {
+	if err := b.userRegistry.SyncUser(context.Background(), userID, language); err != nil {
+		b.logger.Errorf("Failed to sync user record %d: %v", userID, err)
+	}
+}
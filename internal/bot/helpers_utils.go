@@ -2,10 +2,16 @@ package bot
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"time"
 
+	"x-ui-bot/internal/bot/constants"
+	"x-ui-bot/internal/bot/middleware"
+	"x-ui-bot/internal/bot/services"
+	"x-ui-bot/internal/i18n"
+	"x-ui-bot/internal/storage"
+	"x-ui-bot/pkg/client"
+
 	"github.com/google/uuid"
 	"github.com/mymmrac/telego"
 	tu "github.com/mymmrac/telego/telegoutil"
@@ -13,6 +19,16 @@ import (
 
 // Utility helper methods for bot operations
 
+// isKeyboardButton reports whether text is the label of a reply keyboard button in any language
+func (b *Bot) isKeyboardButton(text string) bool {
+	for _, key := range constants.ReplyKeyboardButtons {
+		if b.i18n.Matches(text, key) {
+			return true
+		}
+	}
+	return false
+}
+
 // isClientBlocked checks if client is blocked (disabled) in panel
 func (b *Bot) isClientBlocked(userID int64) bool {
 	// Admins are never blocked
@@ -20,22 +36,67 @@ func (b *Bot) isClientBlocked(userID int64) bool {
 		return false
 	}
 
-	// Get client info
-	clientInfo, err := b.apiClient.GetClientByTgID(context.Background(), userID)
-	if err != nil {
-		// If client not found, consider as not blocked (allows registration)
+	// Look up the local user record instead of scanning the panels on every message
+	user, ok := b.getUser(userID)
+	if !ok {
+		// If user not found, consider as not blocked (allows registration)
 		return false
 	}
 
 	// Check enable status
-	if enable, ok := clientInfo["enable"].(bool); ok {
-		return !enable
+	return user.Status == storage.UserStatusDisabled
+}
+
+// checkRateLimit charges an action to the user's rate limit and reports whether it may proceed.
+// A refused user is told how long to wait once per refusal streak
+func (b *Bot) checkRateLimit(chatID, userID int64, action middleware.Action) bool {
+	err := b.rateLimiter.Check(userID, action)
+	if err == nil {
+		return true
+	}
+
+	b.logger.Warnf("Rate limit exceeded for user ID: %d (%s)", userID, action)
+	if limitErr, ok := err.(*middleware.LimitError); ok && limitErr.Notify {
+		t := b.tr(userID)
+		b.sendMessage(chatID, t("common.rate_limited", formatWait(t, limitErr.RetryAfter)))
+	}
+	return false
+}
+
+// checkCallbackRateLimit is checkRateLimit for callback queries, the wait is shown as an alert on the button
+func (b *Bot) checkCallbackRateLimit(queryID string, userID int64) bool {
+	err := b.rateLimiter.Check(userID, middleware.ActionCallback)
+	if err == nil {
+		return true
 	}
 
-	// Default to not blocked if status unclear
+	b.logger.Warnf("Rate limit exceeded for user ID: %d (%s)", userID, middleware.ActionCallback)
+	text := ""
+	if limitErr, ok := err.(*middleware.LimitError); ok {
+		t := b.tr(userID)
+		text = t("common.rate_limited", formatWait(t, limitErr.RetryAfter))
+	}
+	if err := b.bot.AnswerCallbackQuery(context.Background(), &telego.AnswerCallbackQueryParams{
+		CallbackQueryID: queryID,
+		Text:            text,
+		ShowAlert:       true,
+	}); err != nil {
+		b.logger.Errorf("Failed to answer rate limited callback: %v", err)
+	}
 	return false
 }
 
+// formatWait formats a wait time rounded up to seconds, minutes or hours
+func formatWait(t i18n.Translator, wait time.Duration) string {
+	switch {
+	case wait > time.Hour:
+		return t("common.wait_hours", int((wait+time.Hour-1)/time.Hour))
+	case wait > time.Minute:
+		return t("common.wait_minutes", int((wait+time.Minute-1)/time.Minute))
+	}
+	return t("common.wait_seconds", max(1, int((wait+time.Second-1)/time.Second)))
+}
+
 // getUserInfo gets user's name and Telegram username from Telegram API
 func (b *Bot) getUserInfo(userID int64) (name string, username string) {
 	chatInfo, err := b.bot.GetChat(context.Background(), &telego.GetChatParams{ChatID: tu.ID(userID)})
@@ -71,25 +132,21 @@ func (b *Bot) calculateTimeRemaining(expiryTime int64) (days int, hours int) {
 }
 
 // addProtocolFields adds protocol-specific fields to client data
-func (b *Bot) addProtocolFields(clientData map[string]interface{}, protocol string, inbound map[string]interface{}) {
-	switch protocol {
-	case "vmess":
+func (b *Bot) addProtocolFields(clientData map[string]interface{}, inbound client.Inbound) {
+	switch inbound.Protocol {
+	case client.ProtocolVMess:
 		clientData["id"] = uuid.New().String()
 		clientData["security"] = "auto"
-	case "vless":
+	case client.ProtocolVLESS:
 		clientData["id"] = uuid.New().String()
 		clientData["flow"] = ""
-	case "trojan":
+	case client.ProtocolTrojan:
 		clientData["password"] = generateRandomString(10)
-	case "shadowsocks":
+	case client.ProtocolShadowsocks:
 		// Get method from inbound settings
-		settingsStr, _ := inbound["settings"].(string)
-		var settings map[string]interface{}
-		method := "aes-256-gcm" // default
-		if json.Unmarshal([]byte(settingsStr), &settings) == nil {
-			if m, ok := settings["method"].(string); ok {
-				method = m
-			}
+		method := inbound.Method()
+		if method == "" {
+			method = "aes-256-gcm" // default
 		}
 		clientData["method"] = method
 		clientData["password"] = generateRandomString(16)
@@ -101,7 +158,7 @@ func (b *Bot) addProtocolFields(clientData map[string]interface{}, protocol stri
 }
 
 // createInstructionsKeyboard creates inline keyboard with platform options for instructions
-func (b *Bot) createInstructionsKeyboard() *telego.InlineKeyboardMarkup {
+func (b *Bot) createInstructionsKeyboard(t i18n.Translator) *telego.InlineKeyboardMarkup {
 	return tu.InlineKeyboard(
 		tu.InlineKeyboardRow(
 			tu.InlineKeyboardButton("📱 iOS").WithCallbackData("instr_ios"),
@@ -112,7 +169,7 @@ func (b *Bot) createInstructionsKeyboard() *telego.InlineKeyboardMarkup {
 			tu.InlineKeyboardButton("🖥️ Windows").WithCallbackData("instr_windows"),
 		),
 		tu.InlineKeyboardRow(
-			tu.InlineKeyboardButton("◀️ Назад").WithCallbackData("instr_back"),
+			tu.InlineKeyboardButton(t(constants.BtnBack)).WithCallbackData("instr_back"),
 		),
 	)
 }
@@ -120,124 +177,65 @@ func (b *Bot) createInstructionsKeyboard() *telego.InlineKeyboardMarkup {
 // createDurationKeyboard creates inline keyboard with duration options and prices
 // callbackPrefix should be "reg_duration" for registration or "extend_<userID>" for extension
 // isFirstPurchase indicates if trial option should be shown
-func (b *Bot) createDurationKeyboard(callbackPrefix string, isFirstPurchase bool) *telego.InlineKeyboardMarkup {
+// promo is the promo code entered by the user: prices include its discount and the callback data carries the code,
+// without a promo code the keyboard offers to enter one
+func (b *Bot) createDurationKeyboard(t i18n.Translator, callbackPrefix string, isFirstPurchase bool, promo *storage.PromoCode) *telego.InlineKeyboardMarkup {
 	rows := [][]telego.InlineKeyboardButton{}
 
-	// Add trial option only for first purchase if enabled
-	if isFirstPurchase && b.config.Payment.TrialDays > 0 {
-		trialText := b.config.Payment.TrialText
+	// Add trial option only for first purchase if enabled, promo codes do not apply to it
+	if isFirstPurchase && b.cfg().Payment.TrialDays > 0 {
+		trialText := b.cfg().Payment.TrialText
 		if trialText == "" {
-			trialText = fmt.Sprintf("%d дня", b.config.Payment.TrialDays)
+			trialText = t("plan.trial_days", b.cfg().Payment.TrialDays)
 		}
 		rows = append(rows, tu.InlineKeyboardRow(
-			tu.InlineKeyboardButton(fmt.Sprintf("Пробный период %s - Бесплатно", trialText)).WithCallbackData(fmt.Sprintf("%s_%d", callbackPrefix, b.config.Payment.TrialDays)),
+			tu.InlineKeyboardButton(t("plan.trial", trialText)).WithCallbackData(fmt.Sprintf("%s_%d", callbackPrefix, b.cfg().Payment.TrialDays)),
 		))
 	}
 
 	// Add regular plans
-	rows = append(rows,
-		tu.InlineKeyboardRow(
-			tu.InlineKeyboardButton(fmt.Sprintf("30 дней - %d₽", b.config.Payment.Prices.OneMonth)).WithCallbackData(fmt.Sprintf("%s_30", callbackPrefix)),
-		),
-		tu.InlineKeyboardRow(
-			tu.InlineKeyboardButton(fmt.Sprintf("90 дней - %d₽", b.config.Payment.Prices.ThreeMonth)).WithCallbackData(fmt.Sprintf("%s_90", callbackPrefix)),
-		),
-		tu.InlineKeyboardRow(
-			tu.InlineKeyboardButton(fmt.Sprintf("180 дней - %d₽", b.config.Payment.Prices.SixMonth)).WithCallbackData(fmt.Sprintf("%s_180", callbackPrefix)),
-		),
-		tu.InlineKeyboardRow(
-			tu.InlineKeyboardButton(fmt.Sprintf("365 дней - %d₽", b.config.Payment.Prices.OneYear)).WithCallbackData(fmt.Sprintf("%s_365", callbackPrefix)),
-		),
-	)
-
-	return tu.InlineKeyboard(rows...)
-}
-
-// copyClientMap makes a deep copy of client map to avoid concurrent mutation
-func copyClientMap(src map[string]string) map[string]string {
-	if src == nil {
-		return nil
-	}
-	dst := make(map[string]string, len(src))
-	for k, v := range src {
-		dst[k] = v
+	for _, duration := range []int{30, 90, 180, 365} {
+		quote := services.ApplyPromo(promo, duration, b.cfg().Payment.Prices.ForDuration(duration))
+		callbackData := fmt.Sprintf("%s_%d", callbackPrefix, duration)
+		if quote.Code != "" {
+			callbackData += "_" + quote.Code
+		}
+		rows = append(rows, tu.InlineKeyboardRow(
+			tu.InlineKeyboardButton(b.durationButtonText(t, quote)).WithCallbackData(callbackData),
+		))
 	}
-	return dst
-}
-
-// getClientFromCacheCopy returns a copy of client map stored under cacheKey
-func (b *Bot) getClientFromCacheCopy(cacheKey string) (map[string]string, bool) {
-	b.cacheMutex.RLock()
-	defer b.cacheMutex.RUnlock()
 
-	if v, ok := b.clientCache.Load(cacheKey); ok {
-		if client, ok2 := v.(map[string]string); ok2 {
-			return copyClientMap(client), true
+	if promo == nil {
+		promoCallback := constants.CbPromoExtension
+		if callbackPrefix == constants.CbRegDurationBase {
+			promoCallback = constants.CbPromoRegistration
 		}
+		rows = append(rows, tu.InlineKeyboardRow(
+			tu.InlineKeyboardButton(t("button.enter_promo")).WithCallbackData(promoCallback),
+		))
 	}
-	return nil, false
-}
 
-// storeClientToCache stores a copy of the client map in cache
-func (b *Bot) storeClientToCache(cacheKey string, client map[string]string) {
-	if client == nil {
-		return
-	}
-	b.cacheMutex.Lock()
-	defer b.cacheMutex.Unlock()
-	b.clientCache.Store(cacheKey, copyClientMap(client))
+	return tu.InlineKeyboard(rows...)
 }
 
-// updateClientField safely updates a single field in the cached client map
-// returns the new map copy or nil if not found
-func (b *Bot) updateClientField(cacheKey, field, value string) map[string]string {
-	b.cacheMutex.Lock()
-	defer b.cacheMutex.Unlock()
-
-	v, ok := b.clientCache.Load(cacheKey)
-	if !ok {
-		return nil
+// durationButtonText formats a plan button: "30 days - 200 RUB", with the old price and bonus days of a promo code
+func (b *Bot) durationButtonText(t i18n.Translator, quote services.Quote) string {
+	currency := b.cfg().Payment.Currency
+	text := t("common.days", quote.Duration)
+	if quote.BonusDays > 0 {
+		text += t("plan.bonus_days", quote.BonusDays)
 	}
-	client, ok := v.(map[string]string)
-	if !ok {
-		return nil
+	if quote.Discounted() {
+		return t("plan.price_discounted", text, quote.Price, currency, quote.BasePrice, currency)
 	}
-	newClient := copyClientMap(client)
-	newClient[field] = value
-	b.clientCache.Store(cacheKey, newClient)
-	return newClient
+	return t("plan.price", text, quote.Price, currency)
 }
 
-// extractClientFromInbound safely extracts a client map from an inbound's settings
-func (b *Bot) extractClientFromInbound(inbound map[string]interface{}, clientIndex int) (map[string]string, error) {
-	settingsStr, ok := inbound["settings"].(string)
-	if !ok {
-		return nil, fmt.Errorf("settings not found or not a string")
-	}
-
-	var settings map[string]interface{}
-	if err := json.Unmarshal([]byte(settingsStr), &settings); err != nil {
-		return nil, fmt.Errorf("failed to parse settings json: %w", err)
-	}
-
-	clients, ok := settings["clients"].([]interface{})
-	if !ok {
-		return nil, fmt.Errorf("clients array not found")
-	}
-
-	if clientIndex < 0 || clientIndex >= len(clients) {
-		return nil, fmt.Errorf("client index out of range")
-	}
-
-	clientMap, ok := clients[clientIndex].(map[string]interface{})
-	if !ok {
-		return nil, fmt.Errorf("invalid client data format")
-	}
-
-	// Convert to map[string]string
-	result := make(map[string]string)
-	for k, v := range clientMap {
-		result[k] = fmt.Sprintf("%v", v)
+// sameClientOwner reports whether c belongs to the same user as target:
+// matched by Telegram ID, or by base email when the client has no Telegram ID
+func sameClientOwner(c, target client.Client) bool {
+	if target.HasTgID() {
+		return c.TgID == target.TgID
 	}
-	return result, nil
+	return !c.HasTgID() && stripInboundSuffix(c.Email) == stripInboundSuffix(target.Email)
 }
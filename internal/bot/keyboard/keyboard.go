@@ -3,40 +3,41 @@ package keyboard
 import (
 	"fmt"
 	"x-ui-bot/internal/bot/constants"
+	"x-ui-bot/internal/i18n"
 
 	"github.com/mymmrac/telego"
 	tu "github.com/mymmrac/telego/telegoutil"
 )
 
 // BuildAdminKeyboard creates the admin keyboard
-func BuildAdminKeyboard() *telego.ReplyKeyboardMarkup {
+func BuildAdminKeyboard(t i18n.Translator) *telego.ReplyKeyboardMarkup {
 	return tu.Keyboard(
 		tu.KeyboardRow(
-			tu.KeyboardButton(constants.BtnServerStatus),
-			tu.KeyboardButton(constants.BtnTrafficForecast),
+			tu.KeyboardButton(t(constants.BtnServerStatus)),
+			tu.KeyboardButton(t(constants.BtnTrafficForecast)),
 		),
 		tu.KeyboardRow(
-			tu.KeyboardButton(constants.BtnClientList),
-			tu.KeyboardButton(constants.BtnBroadcast),
+			tu.KeyboardButton(t(constants.BtnClientList)),
+			tu.KeyboardButton(t(constants.BtnBroadcast)),
 		),
 		tu.KeyboardRow(
-			tu.KeyboardButton(constants.BtnBackupDB),
+			tu.KeyboardButton(t(constants.BtnBackupDB)),
 		),
 	).WithResizeKeyboard().WithIsPersistent()
 }
 
 // BuildUserKeyboard creates the user keyboard for registered clients
-func BuildUserKeyboard(hasExpiry bool) *telego.ReplyKeyboardMarkup {
+func BuildUserKeyboard(t i18n.Translator, hasExpiry bool) *telego.ReplyKeyboardMarkup {
 	if hasExpiry {
 		// Limited subscription - show extend button
 		return tu.Keyboard(
 			tu.KeyboardRow(
-				tu.KeyboardButton(constants.BtnMySubscription),
-				tu.KeyboardButton(constants.BtnExtendSubscription),
+				tu.KeyboardButton(t(constants.BtnMySubscription)),
+				tu.KeyboardButton(t(constants.BtnExtendSubscription)),
 			),
 			tu.KeyboardRow(
-				tu.KeyboardButton(constants.BtnSettings),
-				tu.KeyboardButton(constants.BtnContactAdmin),
+				tu.KeyboardButton(t(constants.BtnSettings)),
+				tu.KeyboardButton(t(constants.BtnContactAdmin)),
 			),
 		).WithResizeKeyboard().WithIsPersistent()
 	}
@@ -44,61 +45,75 @@ func BuildUserKeyboard(hasExpiry bool) *telego.ReplyKeyboardMarkup {
 	// Unlimited subscription - no extend button
 	return tu.Keyboard(
 		tu.KeyboardRow(
-			tu.KeyboardButton(constants.BtnMySubscription),
-			tu.KeyboardButton(constants.BtnSettings),
+			tu.KeyboardButton(t(constants.BtnMySubscription)),
+			tu.KeyboardButton(t(constants.BtnSettings)),
 		),
 		tu.KeyboardRow(
-			tu.KeyboardButton(constants.BtnContactAdmin),
+			tu.KeyboardButton(t(constants.BtnContactAdmin)),
 		),
 	).WithResizeKeyboard().WithIsPersistent()
 }
 
 // BuildGuestKeyboard creates the keyboard for unregistered users
-func BuildGuestKeyboard() *telego.ReplyKeyboardMarkup {
+func BuildGuestKeyboard(t i18n.Translator) *telego.ReplyKeyboardMarkup {
 	return tu.Keyboard(
 		tu.KeyboardRow(
-			tu.KeyboardButton(constants.BtnTerms),
+			tu.KeyboardButton(t(constants.BtnTerms)),
 		),
 	).WithResizeKeyboard().WithIsPersistent()
 }
 
 // BuildTermsKeyboard creates the keyboard for terms acceptance
-func BuildTermsKeyboard() *telego.InlineKeyboardMarkup {
+func BuildTermsKeyboard(t i18n.Translator) *telego.InlineKeyboardMarkup {
 	return tu.InlineKeyboard(
 		tu.InlineKeyboardRow(
-			tu.InlineKeyboardButton("✅ Принять").WithCallbackData(constants.CbTermsAccept),
-			tu.InlineKeyboardButton("❌ Отклонить").WithCallbackData(constants.CbTermsDecline),
+			tu.InlineKeyboardButton(t("button.accept")).WithCallbackData(constants.CbTermsAccept),
+			tu.InlineKeyboardButton(t("button.decline")).WithCallbackData(constants.CbTermsDecline),
 		),
 	)
 }
 
 // BuildSettingsKeyboard creates the settings keyboard
-func BuildSettingsKeyboard() *telego.ReplyKeyboardMarkup {
+func BuildSettingsKeyboard(t i18n.Translator) *telego.ReplyKeyboardMarkup {
 	return tu.Keyboard(
 		tu.KeyboardRow(
-			tu.KeyboardButton(constants.BtnUpdateUsername),
+			tu.KeyboardButton(t(constants.BtnUpdateUsername)),
 		),
 		tu.KeyboardRow(
-			tu.KeyboardButton(constants.BtnBack),
+			tu.KeyboardButton(t(constants.BtnLanguage)),
+		),
+		tu.KeyboardRow(
+			tu.KeyboardButton(t(constants.BtnBack)),
 		),
 	).WithResizeKeyboard().WithIsPersistent()
 }
 
+// BuildLanguageKeyboard creates an inline keyboard with one button per language, each named in its own language
+func BuildLanguageKeyboard(catalog *i18n.Catalog) *telego.InlineKeyboardMarkup {
+	var rows [][]telego.InlineKeyboardButton
+	for _, lang := range catalog.Languages() {
+		rows = append(rows, tu.InlineKeyboardRow(
+			tu.InlineKeyboardButton(catalog.T(lang, "language.name")).WithCallbackData(constants.CbLanguagePrefix+lang),
+		))
+	}
+	return tu.InlineKeyboard(rows...)
+}
+
 // BuildConfirmDeleteKeyboard builds a confirmation inline keyboard for client deletion
-func BuildConfirmDeleteKeyboard(inboundID int, clientIndex int) *telego.InlineKeyboardMarkup {
+func BuildConfirmDeleteKeyboard(t i18n.Translator, refID int64) *telego.InlineKeyboardMarkup {
 	return tu.InlineKeyboard(
 		tu.InlineKeyboardRow(
-			tu.InlineKeyboardButton("✅ Да, удалить").WithCallbackData(fmt.Sprintf("%s%d_%d", constants.CbConfirmDeletePrefix, inboundID, clientIndex)),
-			tu.InlineKeyboardButton("❌ Отмена").WithCallbackData(fmt.Sprintf("%s%d_%d", constants.CbCancelDeletePrefix, inboundID, clientIndex)),
+			tu.InlineKeyboardButton(t("button.confirm_delete")).WithCallbackData(fmt.Sprintf("%s%d", constants.CbConfirmDeletePrefix, refID)),
+			tu.InlineKeyboardButton(t("button.cancel")).WithCallbackData(fmt.Sprintf("%s%d", constants.CbCancelDeletePrefix, refID)),
 		),
 	)
 }
 
 // BuildReplyInlineKeyboard creates an inline keyboard with a reply button for admins to respond to users
-func BuildReplyInlineKeyboard(userID int64) *telego.InlineKeyboardMarkup {
+func BuildReplyInlineKeyboard(t i18n.Translator, userID int64) *telego.InlineKeyboardMarkup {
 	return tu.InlineKeyboard(
 		tu.InlineKeyboardRow(
-			tu.InlineKeyboardButton("💬 Ответить").WithCallbackData(fmt.Sprintf("%s%d", constants.CbReplyPrefix, userID)),
+			tu.InlineKeyboardButton(t("button.reply")).WithCallbackData(fmt.Sprintf("%s%d", constants.CbReplyPrefix, userID)),
 		),
 	)
 }
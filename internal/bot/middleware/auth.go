@@ -7,17 +7,17 @@ import (
 
 // AuthMiddleware handles authorization checks
 type AuthMiddleware struct {
-	config *config.Config
+	config *config.Store
 }
 
 // NewAuthMiddleware creates a new auth middleware
-func NewAuthMiddleware(cfg *config.Config) *AuthMiddleware {
+func NewAuthMiddleware(cfg *config.Store) *AuthMiddleware {
 	return &AuthMiddleware{config: cfg}
 }
 
 // IsAdmin checks if user is an admin
 func (m *AuthMiddleware) IsAdmin(userID int64) bool {
-	for _, id := range m.config.Telegram.AdminIDs {
+	for _, id := range m.config.Get().Telegram.AdminIDs {
 		if id == userID {
 			return true
 		}
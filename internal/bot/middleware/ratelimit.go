@@ -1,76 +1,278 @@
 package middleware
 
 import (
+	"fmt"
+	"math"
 	"sync"
 	"time"
 
+	"x-ui-bot/internal/config"
 	"x-ui-bot/internal/errors"
+	"x-ui-bot/internal/logger"
+	"x-ui-bot/internal/storage"
 )
 
-// RateLimitEntry represents rate limit tracking for a user
-type RateLimitEntry struct {
-	count     int
-	resetTime time.Time
+// Action is a class of requests with its own cost in tokens
+type Action string
+
+// Rate limited actions
+const (
+	ActionCallback     Action = "callback"
+	ActionMessage      Action = "message"
+	ActionCommand      Action = "command"
+	ActionRegistration Action = "registration" // Starting a registration request
+	ActionQRCode       Action = "qr_code"      // Subscription card with a QR code generated by the panel
+)
+
+// How long a user has to behave before refused requests and strikes are forgotten
+const (
+	violationTTL = time.Hour
+	strikeTTL    = 24 * time.Hour
+)
+
+// PenaltyStore persists abuse counters across restarts
+type PenaltyStore interface {
+	GetRateLimitPenalty(tgID int64) (*storage.RateLimitPenalty, error)
+	SaveRateLimitPenalty(penalty *storage.RateLimitPenalty) error
+}
+
+// LimitError is returned for a refused request
+type LimitError struct {
+	RetryAfter time.Duration
+	Notify     bool // First refusal since the last allowed request or a new strike, the user should be told once
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("rate limit exceeded, retry after %s", e.RetryAfter.Round(time.Second))
+}
+
+func (e *LimitError) Unwrap() error {
+	return errors.ErrRateLimitExceeded
+}
+
+// tokenBucket tracks the requests of one user
+type tokenBucket struct {
+	mu      sync.Mutex // Held for the whole check of a request, including saving the penalty
+	tokens  float64
+	updated time.Time
+	penalty *storage.RateLimitPenalty
+	refused bool // The last request was refused and the user was told to wait
+	removed bool // Dropped by Cleanup, a request holding it looks the bucket up again
+}
+
+// limits are the settings of the limiter, replaced as a whole by Reconfigure
+type limits struct {
+	rate             float64 // Tokens per second
+	burst            float64
+	costs            map[Action]float64
+	penaltyThreshold int
+	penalty          time.Duration
+	maxPenalty       time.Duration
+	limitAdmins      bool
 }
 
-// RateLimiter handles rate limiting
+// RateLimiter is a token bucket limiter: every user has a bucket of burst tokens refilled at
+// max_requests_per_minute per window, and each action takes its cost from it.
+// Users who keep hitting the limit get strikes that block them for a growing time.
+// Penalties are loaded and saved under the lock of the user's bucket only, so storage
+// round-trips never hold up the requests of other users
 type RateLimiter struct {
-	limits               map[int64]*RateLimitEntry
-	mu                   sync.Mutex
-	maxRequestsPerMinute int
-	window               time.Duration
+	buckets map[int64]*tokenBucket
+	limits  limits
+	mu      sync.Mutex // Guards buckets and limits
+	auth    *AuthMiddleware
+	store   PenaltyStore
+	logger  *logger.Logger
+	now     func() time.Time // Clock, replaced by tests
 }
 
 // NewRateLimiter creates a new rate limiter
-func NewRateLimiter(maxRequests int, windowSeconds int) *RateLimiter {
-	// Default values if not configured
-	if maxRequests <= 0 {
-		maxRequests = 10
+func NewRateLimiter(cfg config.RateLimitConfig, auth *AuthMiddleware, store PenaltyStore, log *logger.Logger) *RateLimiter {
+	r := &RateLimiter{
+		buckets: make(map[int64]*tokenBucket),
+		auth:    auth,
+		store:   store,
+		logger:  log,
+		now:     time.Now,
 	}
-	if windowSeconds <= 0 {
-		windowSeconds = 60
+	r.configure(cfg)
+	return r
+}
+
+// Reconfigure applies new limits, buckets and penalties of users are kept
+func (r *RateLimiter) Reconfigure(cfg config.RateLimitConfig) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.configure(cfg)
+}
+
+// configure sets the limits from the config, r.mu must be held once the limiter is in use.
+// Unset settings are defaulted by the config package
+func (r *RateLimiter) configure(cfg config.RateLimitConfig) {
+	costs := make(map[Action]float64, len(cfg.Costs))
+	for action, cost := range cfg.Costs {
+		costs[Action(action)] = float64(cost)
 	}
 
-	return &RateLimiter{
-		limits:               make(map[int64]*RateLimitEntry),
-		maxRequestsPerMinute: maxRequests,
-		window:               time.Duration(windowSeconds) * time.Second,
+	r.limits = limits{
+		rate:             float64(cfg.MaxRequestsPerMinute) / float64(cfg.WindowSeconds),
+		burst:            float64(cfg.Burst),
+		costs:            costs,
+		limitAdmins:      cfg.LimitAdmins,
+		penaltyThreshold: cfg.PenaltyThreshold,
+		penalty:          time.Duration(cfg.PenaltySeconds) * time.Second,
+		maxPenalty:       time.Duration(cfg.MaxPenaltySeconds) * time.Second,
 	}
 }
 
-// Check checks if a user is within rate limits
-func (r *RateLimiter) Check(userID int64) error {
+// Check takes the cost of an action from the user's bucket, a refused request returns a *LimitError.
+// Admins are exempt unless limit_admins is set
+func (r *RateLimiter) Check(userID int64, action Action) error {
 	r.mu.Lock()
-	defer r.mu.Unlock()
+	l := r.limits
+	r.mu.Unlock()
 
-	now := time.Now()
-
-	entry, exists := r.limits[userID]
-	if !exists || now.After(entry.resetTime) {
-		r.limits[userID] = &RateLimitEntry{
-			count:     1,
-			resetTime: now.Add(r.window),
-		}
+	if !l.limitAdmins && r.auth != nil && r.auth.IsAdmin(userID) {
 		return nil
 	}
 
-	if entry.count >= r.maxRequestsPerMinute {
-		return errors.RateLimitExceeded("too many requests")
+	b := r.lockBucket(userID, l)
+	defer b.mu.Unlock()
+
+	now := r.now()
+	if now.Before(b.penalty.BlockedUntil) {
+		return r.refuse(b, b.penalty.BlockedUntil.Sub(now), false)
 	}
 
-	entry.count++
-	return nil
+	b.refill(now, l.rate, l.burst)
+
+	// An action never costs more than a full bucket, otherwise it could not be done at all
+	cost := math.Min(l.cost(action), l.burst)
+	if b.tokens >= cost {
+		b.tokens -= cost
+		b.refused = false
+		return nil
+	}
+
+	wait := time.Duration((cost - b.tokens) / l.rate * float64(time.Second))
+	if r.addViolation(b, l, now) {
+		return r.refuse(b, b.penalty.BlockedUntil.Sub(now), true)
+	}
+	return r.refuse(b, wait, false)
 }
 
-// Cleanup removes expired rate limit entries
+// Cleanup removes buckets of users who are back to a full bucket and not blocked.
+// Buckets in use by a request are left for the next cleanup
 func (r *RateLimiter) Cleanup() {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	now := time.Now()
-	for userID, entry := range r.limits {
-		if now.After(entry.resetTime) {
-			delete(r.limits, userID)
+	now := r.now()
+	for userID, b := range r.buckets {
+		if !b.mu.TryLock() {
+			continue
+		}
+		b.refill(now, r.limits.rate, r.limits.burst)
+		if b.tokens >= r.limits.burst && !now.Before(b.penalty.BlockedUntil) {
+			b.removed = true
+			delete(r.buckets, userID)
 		}
+		b.mu.Unlock()
 	}
 }
+
+// lockBucket returns the locked bucket of a user. The stored penalty of a user not seen since start
+// or cleanup is loaded under the lock of the new bucket, requests of other users go on meanwhile
+func (r *RateLimiter) lockBucket(userID int64, l limits) *tokenBucket {
+	for {
+		r.mu.Lock()
+		b, ok := r.buckets[userID]
+		if !ok {
+			b = &tokenBucket{tokens: l.burst, updated: r.now()}
+			b.mu.Lock() // Other requests of the user wait for the penalty to be loaded
+			r.buckets[userID] = b
+		}
+		r.mu.Unlock()
+
+		if !ok {
+			b.penalty = r.loadPenalty(userID)
+			return b
+		}
+		b.mu.Lock()
+		if !b.removed {
+			return b
+		}
+		b.mu.Unlock()
+	}
+}
+
+// loadPenalty returns the stored penalty of a user, an empty one when there is none or it cannot be read
+func (r *RateLimiter) loadPenalty(userID int64) *storage.RateLimitPenalty {
+	if r.store != nil {
+		stored, err := r.store.GetRateLimitPenalty(userID)
+		if err == nil {
+			return stored
+		}
+		r.logger.Errorf("Failed to load rate limit penalty of user %d: %v", userID, err)
+	}
+	return &storage.RateLimitPenalty{TgID: userID}
+}
+
+// cost returns the token cost of an action, 1 for actions without a configured cost
+func (l limits) cost(action Action) float64 {
+	if cost, ok := l.costs[action]; ok {
+		return cost
+	}
+	return 1
+}
+
+// addViolation counts a refused request and reports whether it added a strike that blocks the user.
+// Refused requests are forgotten after violationTTL and strikes after strikeTTL without new ones
+func (r *RateLimiter) addViolation(b *tokenBucket, l limits, now time.Time) bool {
+	p := b.penalty
+	quiet := now.Sub(p.UpdatedAt)
+	if quiet > violationTTL {
+		p.Violations = 0
+	}
+	if quiet > strikeTTL {
+		p.Strikes = 0
+	}
+
+	p.Violations++
+	struck := p.Violations >= l.penaltyThreshold
+	if struck {
+		p.Violations = 0
+		p.Strikes++
+		p.BlockedUntil = now.Add(l.penaltyFor(p.Strikes))
+	}
+	p.UpdatedAt = now
+
+	if r.store != nil {
+		if err := r.store.SaveRateLimitPenalty(p); err != nil {
+			r.logger.Errorf("Failed to save rate limit penalty of user %d: %v", p.TgID, err)
+		}
+	}
+	return struck
+}
+
+// penaltyFor returns how long a strike blocks the user: the base penalty doubled for every earlier strike
+func (l limits) penaltyFor(strikes int) time.Duration {
+	penalty := l.penalty
+	for i := 1; i < strikes && penalty < l.maxPenalty; i++ {
+		penalty *= 2
+	}
+	return min(penalty, l.maxPenalty)
+}
+
+// refuse builds the error of a refused request, asking to notify the user only once per refusal streak
+func (r *RateLimiter) refuse(b *tokenBucket, wait time.Duration, struck bool) error {
+	notify := !b.refused || struck
+	b.refused = true
+	return &LimitError{RetryAfter: wait, Notify: notify}
+}
+
+// refill adds the tokens earned since the last request
+func (b *tokenBucket) refill(now time.Time, rate, burst float64) {
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.updated).Seconds()*rate)
+	b.updated = now
+}
@@ -0,0 +1,334 @@
+package middleware
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"x-ui-bot/internal/config"
+	"x-ui-bot/internal/logger"
+	"x-ui-bot/internal/storage"
+)
+
+const testUser = 42
+
+// week is a refill window long enough that no token comes back during a test
+const week = 7 * 24 * 60 * 60
+
+// fakeClock is the clock of a test limiter, it only moves when the test advances it
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+// newTestLimiter creates a limiter on a fake clock that keeps penalties in the in-memory storage
+func newTestLimiter(t *testing.T, cfg config.RateLimitConfig) (*RateLimiter, *fakeClock, storage.Storage) {
+	t.Helper()
+
+	store := storage.NewMemoryStorage()
+	t.Cleanup(func() { _ = store.Close() })
+
+	clock := &fakeClock{now: time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)}
+	r := NewRateLimiter(cfg, nil, store, logger.GetLogger())
+	r.now = clock.Now
+	return r, clock, store
+}
+
+// check runs Check and returns the refusal, nil when the request was allowed
+func check(t *testing.T, r *RateLimiter, action Action) *LimitError {
+	t.Helper()
+
+	err := r.Check(testUser, action)
+	if err == nil {
+		return nil
+	}
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) {
+		t.Fatalf("Check error = %v, want a *LimitError", err)
+	}
+	return limitErr
+}
+
+// strike runs refused requests until one adds a strike and returns its block
+func strike(t *testing.T, r *RateLimiter, threshold int) time.Duration {
+	t.Helper()
+
+	for i := 1; i < threshold; i++ {
+		if err := check(t, r, ActionMessage); err == nil || r.penalty(testUser).Violations != i {
+			t.Fatalf("refusal %d: error %v, %d violations", i, err, r.penalty(testUser).Violations)
+		}
+	}
+	err := check(t, r, ActionMessage)
+	if err == nil || !err.Notify {
+		t.Fatalf("strike: error %v, want a refusal to notify about", err)
+	}
+	return err.RetryAfter
+}
+
+// penalty returns the abuse counters of a user held by the limiter
+func (r *RateLimiter) penalty(userID int64) storage.RateLimitPenalty {
+	r.mu.Lock()
+	b := r.buckets[userID]
+	r.mu.Unlock()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return *b.penalty
+}
+
+func TestRateLimiterRefill(t *testing.T) {
+	r, clock, _ := newTestLimiter(t, config.RateLimitConfig{
+		MaxRequestsPerMinute: 60, // One token a second
+		WindowSeconds:        60,
+		Burst:                3,
+		Costs:                map[string]int{"qr_code": 2, "registration": 10},
+		PenaltyThreshold:     100,
+		PenaltySeconds:       60,
+		MaxPenaltySeconds:    3600,
+	})
+
+	steps := []struct {
+		advance   time.Duration
+		action    Action
+		allowed   bool
+		notify    bool
+		wantRetry time.Duration
+	}{
+		{action: ActionMessage, allowed: true},
+		{action: ActionMessage, allowed: true},
+		{action: ActionMessage, allowed: true},
+		{action: ActionMessage, notify: true, wantRetry: time.Second},
+		{action: ActionMessage, wantRetry: time.Second}, // Told once per refusal streak
+		{advance: 500 * time.Millisecond, action: ActionMessage, wantRetry: 500 * time.Millisecond},
+		{advance: 500 * time.Millisecond, action: ActionMessage, allowed: true},
+		{advance: time.Second, action: ActionQRCode, notify: true, wantRetry: time.Second}, // Costs 2
+		{advance: time.Second, action: ActionQRCode, allowed: true},
+		{advance: time.Hour, action: ActionRegistration, allowed: true}, // Refill stops at burst, cost is capped at it
+		{action: ActionMessage, notify: true, wantRetry: time.Second},
+		{advance: 10 * time.Second, action: ActionCommand, allowed: true},
+		{action: ActionCallback, allowed: true},
+		{action: ActionMessage, allowed: true},
+		{action: ActionMessage, notify: true, wantRetry: time.Second},
+	}
+
+	for i, step := range steps {
+		clock.Advance(step.advance)
+		err := check(t, r, step.action)
+		switch {
+		case step.allowed && err != nil:
+			t.Fatalf("step %d (%s): refused, retry after %s", i, step.action, err.RetryAfter)
+		case !step.allowed && err == nil:
+			t.Fatalf("step %d (%s): allowed, want a refusal", i, step.action)
+		case !step.allowed && (err.RetryAfter != step.wantRetry || err.Notify != step.notify):
+			t.Fatalf("step %d (%s): retry after %s, notify %v, want %s, %v",
+				i, step.action, err.RetryAfter, err.Notify, step.wantRetry, step.notify)
+		}
+	}
+}
+
+func TestRateLimiterEscalation(t *testing.T) {
+	const threshold = 3
+	r, clock, store := newTestLimiter(t, config.RateLimitConfig{
+		MaxRequestsPerMinute: 1,
+		WindowSeconds:        week,
+		Burst:                1,
+		PenaltyThreshold:     threshold,
+		PenaltySeconds:       10,
+		MaxPenaltySeconds:    35,
+	})
+
+	if err := check(t, r, ActionMessage); err != nil {
+		t.Fatalf("first request refused: %v", err)
+	}
+
+	// Each strike doubles the block until max_penalty_seconds
+	for i, want := range []time.Duration{10 * time.Second, 20 * time.Second, 35 * time.Second, 35 * time.Second} {
+		if got := strike(t, r, threshold); got != want {
+			t.Fatalf("strike %d blocks for %s, want %s", i+1, got, want)
+		}
+
+		// Requests during the block are refused without a new violation
+		clock.Advance(want - time.Second)
+		err := check(t, r, ActionMessage)
+		if err == nil || err.RetryAfter != time.Second || err.Notify {
+			t.Fatalf("strike %d: request during the block got %+v", i+1, err)
+		}
+		if p := r.penalty(testUser); p.Violations != 0 || p.Strikes != i+1 {
+			t.Fatalf("strike %d: penalty %+v", i+1, p)
+		}
+		clock.Advance(time.Second)
+	}
+
+	stored, err := store.GetRateLimitPenalty(testUser)
+	if err != nil {
+		t.Fatalf("GetRateLimitPenalty: %v", err)
+	}
+	if stored.Strikes != 4 || !stored.BlockedUntil.Equal(clock.Now()) {
+		t.Errorf("stored penalty = %+v, want 4 strikes blocked until %s", stored, clock.Now())
+	}
+}
+
+func TestRateLimiterForgetsOldRefusals(t *testing.T) {
+	const threshold = 2
+	cfg := config.RateLimitConfig{
+		MaxRequestsPerMinute: 1,
+		WindowSeconds:        week,
+		Burst:                1,
+		PenaltyThreshold:     threshold,
+		PenaltySeconds:       10,
+		MaxPenaltySeconds:    3600,
+	}
+
+	tests := []struct {
+		name           string
+		quiet          time.Duration // Time without requests after the first strike
+		wantViolations int           // Refused requests remembered after the quiet time and one more refusal
+		wantBlock      time.Duration // Block of the next strike
+	}{
+		{"refusals and strikes kept", violationTTL - time.Second, 0, 20 * time.Second},
+		{"refusals forgotten after an hour", violationTTL + time.Second, 1, 20 * time.Second},
+		{"strikes forgotten after a day", strikeTTL + time.Second, 1, 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, clock, _ := newTestLimiter(t, cfg)
+			if err := check(t, r, ActionMessage); err != nil {
+				t.Fatalf("first request refused: %v", err)
+			}
+			if got := strike(t, r, threshold); got != 10*time.Second {
+				t.Fatalf("first strike blocks for %s", got)
+			}
+			clock.Advance(10 * time.Second)
+
+			// A refusal left from before the quiet time
+			if err := check(t, r, ActionMessage); err == nil {
+				t.Fatal("request after the block allowed")
+			}
+
+			clock.Advance(tt.quiet)
+			err := check(t, r, ActionMessage)
+			if err == nil {
+				t.Fatal("request after the quiet time allowed")
+			}
+			if got := r.penalty(testUser).Violations; got != tt.wantViolations {
+				t.Fatalf("violations = %d, want %d", got, tt.wantViolations)
+			}
+
+			if tt.wantViolations > 0 {
+				// The refusal started a new count, the next one makes the strike
+				if err = check(t, r, ActionMessage); err == nil {
+					t.Fatal("request allowed")
+				}
+			}
+			if block := err.RetryAfter; block != tt.wantBlock {
+				t.Errorf("next strike blocks for %s, want %s", block, tt.wantBlock)
+			}
+		})
+	}
+}
+
+func TestRateLimiterReconfigure(t *testing.T) {
+	r, clock, _ := newTestLimiter(t, config.RateLimitConfig{
+		MaxRequestsPerMinute: 1,
+		WindowSeconds:        week,
+		Burst:                1,
+		PenaltyThreshold:     2,
+		PenaltySeconds:       60,
+		MaxPenaltySeconds:    3600,
+	})
+
+	if err := check(t, r, ActionMessage); err != nil {
+		t.Fatalf("first request refused: %v", err)
+	}
+	if got := strike(t, r, 2); got != time.Minute {
+		t.Fatalf("strike blocks for %s", got)
+	}
+
+	r.Reconfigure(config.RateLimitConfig{
+		MaxRequestsPerMinute: 60,
+		WindowSeconds:        60,
+		Burst:                2,
+		Costs:                map[string]int{"qr_code": 2},
+		PenaltyThreshold:     5,
+		PenaltySeconds:       5,
+		MaxPenaltySeconds:    60,
+	})
+
+	// The running block and strikes stay
+	clock.Advance(30 * time.Second)
+	if err := check(t, r, ActionMessage); err == nil || err.RetryAfter != 30*time.Second {
+		t.Fatalf("request during the block got %v, want a refusal for 30s", err)
+	}
+	if p := r.penalty(testUser); p.Strikes != 1 {
+		t.Fatalf("strikes = %d after Reconfigure, want 1", p.Strikes)
+	}
+
+	// The bucket refills at the new rate up to the new burst
+	clock.Advance(time.Minute)
+	if err := check(t, r, ActionQRCode); err != nil {
+		t.Fatalf("qr code refused after the block: %v", err)
+	}
+	if err := check(t, r, ActionMessage); err == nil || err.RetryAfter != time.Second {
+		t.Fatalf("request with an empty bucket got %v, want a refusal for 1s", err)
+	}
+	clock.Advance(time.Second)
+	if err := check(t, r, ActionMessage); err != nil {
+		t.Fatalf("request after a second refused: %v", err)
+	}
+
+	// The new threshold and penalty apply to the next strike, doubled for the second strike.
+	// The refusal above was the first of five
+	for i := 0; i < 3; i++ {
+		if err := check(t, r, ActionMessage); err == nil || err.RetryAfter >= 5*time.Second {
+			t.Fatalf("refusal %d got %v, want a plain refusal", i+1, err)
+		}
+	}
+	if err := check(t, r, ActionMessage); err == nil || err.RetryAfter != 10*time.Second {
+		t.Fatalf("fifth refusal got %v, want a 10s block", err)
+	}
+	if p := r.penalty(testUser); p.Strikes != 2 {
+		t.Fatalf("strikes = %d, want 2", p.Strikes)
+	}
+}
+
+func TestRateLimiterCleanupKeepsPenalties(t *testing.T) {
+	r, clock, _ := newTestLimiter(t, config.RateLimitConfig{
+		MaxRequestsPerMinute: 60,
+		WindowSeconds:        60,
+		Burst:                1,
+		PenaltyThreshold:     1,
+		PenaltySeconds:       60,
+		MaxPenaltySeconds:    3600,
+	})
+
+	if err := check(t, r, ActionMessage); err != nil {
+		t.Fatalf("first request refused: %v", err)
+	}
+	if err := check(t, r, ActionMessage); err == nil || err.RetryAfter != time.Minute {
+		t.Fatalf("second request got %v, want a 1m block", err)
+	}
+
+	// A blocked user keeps the bucket
+	clock.Advance(30 * time.Second)
+	r.Cleanup()
+	if _, ok := r.buckets[testUser]; !ok {
+		t.Fatal("Cleanup dropped the bucket of a blocked user")
+	}
+
+	// A full bucket is dropped, the strike is loaded back from storage
+	clock.Advance(30 * time.Second)
+	r.Cleanup()
+	if _, ok := r.buckets[testUser]; ok {
+		t.Fatal("Cleanup kept a full bucket")
+	}
+	if err := check(t, r, ActionMessage); err != nil {
+		t.Fatalf("request after the block refused: %v", err)
+	}
+	if err := check(t, r, ActionMessage); err == nil || err.RetryAfter != 2*time.Minute {
+		t.Fatalf("second strike got %v, want a 2m block", err)
+	}
+}
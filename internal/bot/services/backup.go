@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"x-ui-bot/internal/config"
+	"x-ui-bot/internal/i18n"
 	"x-ui-bot/internal/logger"
 	"x-ui-bot/pkg/client"
 
@@ -18,30 +19,32 @@ import (
 type BackupService struct {
 	apiClient *client.APIClient
 	bot       *telego.Bot
-	config    *config.Config
+	config    *config.Store
 	logger    *logger.Logger
+	localize  i18n.Localizer
 	stopChan  chan struct{}
 }
 
 // NewBackupService creates a new backup service
-func NewBackupService(apiClient *client.APIClient, bot *telego.Bot, cfg *config.Config, log *logger.Logger) *BackupService {
+func NewBackupService(apiClient *client.APIClient, bot *telego.Bot, cfg *config.Store, log *logger.Logger, localize i18n.Localizer) *BackupService {
 	return &BackupService{
 		apiClient: apiClient,
 		bot:       bot,
 		config:    cfg,
 		logger:    log,
+		localize:  localize,
 		stopChan:  make(chan struct{}),
 	}
 }
 
 // StartScheduler starts the backup scheduler
 func (s *BackupService) StartScheduler(ctx context.Context) {
-	if s.config.Panel.BackupDays <= 0 {
+	if s.config.Get().Panel.BackupDays <= 0 {
 		s.logger.Info("Backup scheduler disabled")
 		return
 	}
 
-	interval := time.Duration(s.config.Panel.BackupDays) * 24 * time.Hour
+	interval := time.Duration(s.config.Get().Panel.BackupDays) * 24 * time.Hour
 	s.logger.WithField("interval", interval).Info("Starting backup scheduler")
 
 	ticker := time.NewTicker(interval)
@@ -77,7 +80,7 @@ func (s *BackupService) PerformBackup() error {
 	}
 
 	// Send to all admins
-	for _, adminID := range s.config.Telegram.AdminIDs {
+	for _, adminID := range s.config.Get().Telegram.AdminIDs {
 		if err := s.SendBackupToAdmin(adminID, dbFile); err != nil {
 			s.logger.WithFields(map[string]interface{}{
 				"admin_id": adminID,
@@ -103,7 +106,7 @@ func (s *BackupService) SendBackupToAdmin(adminID int64, dbFile []byte) error {
 		Document: telego.InputFile{
 			File: tu.NameReader(reader, filename),
 		},
-		Caption: fmt.Sprintf("📦 Бэкап базы данных\n🕐 %s", time.Now().Format("2006-01-02 15:04:05")),
+		Caption: s.localize(adminID)("backup.caption", time.Now().Format("2006-01-02 15:04:05")),
 	})
 
 	return err
@@ -2,99 +2,456 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync"
+	"time"
 
+	"x-ui-bot/internal/i18n"
 	"x-ui-bot/internal/logger"
-	"x-ui-bot/pkg/client"
+	"x-ui-bot/internal/storage"
 
 	"github.com/mymmrac/telego"
+	"github.com/mymmrac/telego/telegoapi"
 	tu "github.com/mymmrac/telego/telegoutil"
 )
 
-// BroadcastService handles broadcast messaging
+const (
+	// broadcastRate stays under the ~30 messages per second Telegram allows a bot in total
+	broadcastRate = 25
+	// broadcastBatch is the number of recipients sent between two checks for pause and cancel
+	broadcastBatch = 25
+	// broadcastReportInterval is the minimum time between two edits of the progress message
+	broadcastReportInterval = 3 * time.Second
+	// broadcastPollInterval is how often queued jobs are looked for without being woken up
+	broadcastPollInterval = time.Minute
+	// broadcastRetryDelay is the pause after a storage error before the worker tries again
+	broadcastRetryDelay = 10 * time.Second
+)
+
+// BroadcastService delivers broadcast jobs kept in storage. Jobs are sent one at a time, oldest first
+// once their send time has come, at a rate Telegram accepts, and a job interrupted by a restart is resumed
+// from its pending recipients. A recipient being sent to when the bot stopped may receive the announcement twice
 type BroadcastService struct {
-	apiClient *client.APIClient
-	bot       *telego.Bot
-	logger    *logger.Logger
+	bot      *telego.Bot
+	storage  storage.Storage
+	logger   *logger.Logger
+	localize i18n.Localizer                  // Renders announcements in the language of each recipient
+	report   func(job *storage.BroadcastJob) // Refreshes the progress message of a job
+
+	mu   sync.Mutex    // Serializes job status changes of the worker and the admins
+	wake chan struct{} // Signals the worker that a job was queued
 }
 
 // NewBroadcastService creates a new broadcast service
-func NewBroadcastService(apiClient *client.APIClient, bot *telego.Bot, log *logger.Logger) *BroadcastService {
+func NewBroadcastService(bot *telego.Bot, storage storage.Storage, log *logger.Logger, localize i18n.Localizer, report func(job *storage.BroadcastJob)) *BroadcastService {
 	return &BroadcastService{
-		apiClient: apiClient,
-		bot:       bot,
-		logger:    log,
+		bot:      bot,
+		storage:  storage,
+		logger:   log,
+		localize: localize,
+		report:   report,
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Start runs the worker until ctx is cancelled
+func (s *BroadcastService) Start(ctx context.Context) {
+	s.logger.Info("Starting broadcast worker")
+
+	throttle := time.NewTicker(time.Second / broadcastRate)
+	defer throttle.Stop()
+
+	for ctx.Err() == nil {
+		job, wait, err := s.nextJob()
+		if err == nil && job != nil {
+			err = s.run(ctx, job, throttle.C)
+			if err == nil {
+				continue
+			}
+		}
+		if err != nil {
+			s.logger.Errorf("Broadcast worker failed: %v", err)
+			wait = broadcastRetryDelay
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-s.wake:
+		case <-time.After(wait):
+		}
+	}
+	s.logger.Info("Stopping broadcast worker")
+}
+
+// Enqueue stores a job with its recipients and wakes the worker
+func (s *BroadcastService) Enqueue(job *storage.BroadcastJob, recipients []int64) error {
+	job.Status = storage.BroadcastStatusQueued
+	if err := s.storage.CreateBroadcastJob(job, recipients); err != nil {
+		return err
+	}
+	if job.SendAt.After(time.Now()) {
+		s.logger.Infof("Broadcast job %d scheduled by admin %d for %d recipients at %s", job.ID, job.CreatedBy, len(recipients), job.SendAt.Format(time.RFC3339))
+	} else {
+		s.logger.Infof("Broadcast job %d queued by admin %d for %d recipients", job.ID, job.CreatedBy, len(recipients))
+	}
+	s.notify()
+	return nil
+}
+
+// Preview sends content to chatID the way recipients will see it
+func (s *BroadcastService) Preview(ctx context.Context, chatID int64, content storage.BroadcastContent) error {
+	return s.send(ctx, chatID, content, nil)
+}
+
+// Pause stops sending a queued or running job until it is resumed
+func (s *BroadcastService) Pause(id int64) (*storage.BroadcastJob, error) {
+	return s.transition(id, storage.BroadcastStatusPaused, storage.BroadcastStatusQueued, storage.BroadcastStatusRunning)
+}
+
+// Resume queues a paused job again
+func (s *BroadcastService) Resume(id int64) (*storage.BroadcastJob, error) {
+	job, err := s.transition(id, storage.BroadcastStatusQueued, storage.BroadcastStatusPaused)
+	if err == nil {
+		s.notify()
+	}
+	return job, err
+}
+
+// Cancel stops a job for good, its pending recipients are skipped
+func (s *BroadcastService) Cancel(id int64) (*storage.BroadcastJob, error) {
+	return s.transition(id, storage.BroadcastStatusCancelled,
+		storage.BroadcastStatusQueued, storage.BroadcastStatusRunning, storage.BroadcastStatusPaused)
+}
+
+// RetryFailed queues the failed recipients of a finished job again and returns their number
+func (s *BroadcastService) RetryFailed(id int64) (*storage.BroadcastJob, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, err := s.storage.GetBroadcastJob(id)
+	if err != nil {
+		return nil, 0, err
+	}
+	if job.Status != storage.BroadcastStatusDone {
+		return job, 0, fmt.Errorf("broadcast job %d is %s, not done", id, job.Status)
+	}
+
+	n, err := s.storage.RetryFailedBroadcastRecipients(id)
+	if err != nil || n == 0 {
+		return job, n, err
+	}
+	if err := s.storage.SetBroadcastJobStatus(id, storage.BroadcastStatusQueued); err != nil {
+		return job, n, err
+	}
+	job.Status = storage.BroadcastStatusQueued
+	s.logger.Infof("Broadcast job %d queued again for %d failed recipients", id, n)
+	s.notify()
+	return job, n, nil
+}
+
+// notify wakes the worker without blocking when it is busy
+func (s *BroadcastService) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// transition moves a job to status if it is in one of the from statuses
+func (s *BroadcastService) transition(id int64, status string, from ...string) (*storage.BroadcastJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, err := s.storage.GetBroadcastJob(id)
+	if err != nil {
+		return nil, err
+	}
+
+	allowed := false
+	for _, st := range from {
+		if job.Status == st {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return job, fmt.Errorf("broadcast job %d is %s", id, job.Status)
 	}
+
+	if err := s.storage.SetBroadcastJobStatus(id, status); err != nil {
+		return job, err
+	}
+	job.Status = status
+	return job, nil
 }
 
-// SendBroadcast sends a message to all registered clients
-func (s *BroadcastService) SendBroadcast(message string) (sent int, failed int, err error) {
-	s.logger.Info("Starting broadcast")
+// nextJob returns the oldest job to send, nil when there is none. wait is the time until the
+// earliest scheduled job is due, at most broadcastPollInterval
+func (s *BroadcastService) nextJob() (*storage.BroadcastJob, time.Duration, error) {
+	jobs, err := s.storage.GetActiveBroadcastJobs()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	now := time.Now()
+	wait := broadcastPollInterval
+	for _, job := range jobs {
+		switch {
+		case job.Status == storage.BroadcastStatusRunning:
+			return job, 0, nil
+		case job.Status != storage.BroadcastStatusQueued:
+		case !job.SendAt.After(now):
+			return job, 0, nil
+		case job.SendAt.Sub(now) < wait:
+			wait = job.SendAt.Sub(now)
+		}
+	}
+	return nil, wait, nil
+}
 
-	// Get all inbounds
-	inbounds, err := s.apiClient.GetInbounds(context.Background())
+// run sends a job until it is done, paused, cancelled or the worker stops
+func (s *BroadcastService) run(ctx context.Context, job *storage.BroadcastJob, throttle <-chan time.Time) error {
+	if job.Status == storage.BroadcastStatusRunning {
+		s.logger.Infof("Resuming broadcast job %d", job.ID)
+	}
+	started, err := s.transition(job.ID, storage.BroadcastStatusRunning, storage.BroadcastStatusQueued, storage.BroadcastStatusRunning)
 	if err != nil {
-		return 0, 0, fmt.Errorf("failed to get inbounds: %w", err)
+		return fmt.Errorf("failed to start job %d: %w", job.ID, err)
 	}
+	job = started
+	s.report(job)
+	lastReport := time.Now()
 
-	// Collect all unique telegram IDs
-	tgIDs := make(map[int64]bool)
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
 
-	for _, inbound := range inbounds {
-		settingsStr, ok := inbound["settings"].(string)
-		if !ok {
-			continue
+		// Pause and cancel are made by admins between batches
+		current, err := s.storage.GetBroadcastJob(job.ID)
+		if err != nil {
+			return fmt.Errorf("failed to reload job %d: %w", job.ID, err)
+		}
+		if current.Status != storage.BroadcastStatusRunning {
+			s.logger.Infof("Broadcast job %d is %s", job.ID, current.Status)
+			s.report(current)
+			return nil
 		}
 
-		// Parse clients from settings
-		clientService := NewClientService(s.apiClient, s.logger)
-		clients, err := clientService.ParseClients(settingsStr)
+		recipients, err := s.storage.GetPendingBroadcastRecipients(job.ID, broadcastBatch)
 		if err != nil {
-			s.logger.ErrorErr(err, "Failed to parse clients")
-			continue
+			return fmt.Errorf("failed to load recipients of job %d: %w", job.ID, err)
+		}
+		if len(recipients) == 0 {
+			return s.finish(job)
 		}
 
-		for _, client := range clients {
-			tgIDStr := client["tgId"]
-			if tgIDStr == "" || tgIDStr == "0" {
-				continue
+		for _, tgID := range recipients {
+			if !s.deliver(ctx, job, tgID, throttle) {
+				return nil
 			}
+		}
+
+		if time.Since(lastReport) >= broadcastReportInterval {
+			s.report(current)
+			lastReport = time.Now()
+		}
+	}
+}
+
+// deliver sends the announcement to one recipient and records the result, false is returned
+// when the worker stops before the announcement is sent
+func (s *BroadcastService) deliver(ctx context.Context, job *storage.BroadcastJob, tgID int64, throttle <-chan time.Time) bool {
+	err := s.send(ctx, tgID, job.BroadcastContent, throttle)
+	if ctx.Err() != nil {
+		return false
+	}
 
-			var tgID int64
-			if _, err := fmt.Sscanf(tgIDStr, "%d", &tgID); err == nil && tgID > 0 {
-				tgIDs[tgID] = true
+	status, lastError := storage.RecipientStatusSent, ""
+	if err != nil {
+		s.logger.Warnf("Failed to send broadcast %d to user %d: %v", job.ID, tgID, err)
+		status, lastError = storage.RecipientStatusFailed, err.Error()
+	}
+	if err := s.storage.SetBroadcastRecipientStatus(job.ID, tgID, status, lastError); err != nil {
+		s.logger.Errorf("Failed to record broadcast %d delivery to user %d: %v", job.ID, tgID, err)
+	}
+	return true
+}
+
+// send delivers content to one chat: the text or media with the buttons under it, pinned when asked.
+// A failed pin is only logged as the announcement itself was delivered
+func (s *BroadcastService) send(ctx context.Context, chatID int64, content storage.BroadcastContent, throttle <-chan time.Time) error {
+	t := s.localize(chatID)
+	text := t("broadcast.announcement", content.Message)
+	var keyboard telego.ReplyMarkup // Left nil without buttons, a nil markup pointer would be sent as null
+	if len(content.Buttons) > 0 {
+		rows := make([][]telego.InlineKeyboardButton, 0, len(content.Buttons))
+		for _, button := range content.Buttons {
+			rows = append(rows, tu.InlineKeyboardRow(tu.InlineKeyboardButton(button.Text).WithURL(button.URL)))
+		}
+		keyboard = tu.InlineKeyboard(rows...)
+	}
+
+	var pinID int
+	err := s.call(ctx, throttle, func() error {
+		switch len(content.Media) {
+		case 0:
+			msg, err := s.bot.SendMessage(ctx, &telego.SendMessageParams{
+				ChatID:      tu.ID(chatID),
+				Text:        text,
+				ParseMode:   telego.ModeHTML,
+				ReplyMarkup: keyboard,
+			})
+			if err != nil {
+				return err
+			}
+			pinID = msg.MessageID
+		case 1:
+			msg, err := s.sendMedia(ctx, chatID, content.Media[0], text, keyboard)
+			if err != nil {
+				return err
+			}
+			pinID = msg.MessageID
+		default:
+			msgs, err := s.bot.SendMediaGroup(ctx, tu.MediaGroup(tu.ID(chatID), albumMedia(content.Media, text)...))
+			if err != nil {
+				return err
+			}
+			if len(msgs) > 0 {
+				pinID = msgs[0].MessageID
 			}
 		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
-	// Send message to each user
-	for tgID := range tgIDs {
-		err := s.SendMessage(tgID, message)
-		if err != nil {
-			s.logger.WithFields(map[string]interface{}{
-				"tg_id": tgID,
-				"error": err,
-			}).Error("Failed to send broadcast message")
-			failed++
-		} else {
-			sent++
+	// Albums cannot carry buttons
+	if len(content.Media) > 1 && keyboard != nil {
+		if err := s.call(ctx, throttle, func() error {
+			_, err := s.bot.SendMessage(ctx, &telego.SendMessageParams{
+				ChatID:      tu.ID(chatID),
+				Text:        t("broadcast.album_buttons"),
+				ParseMode:   telego.ModeHTML,
+				ReplyMarkup: keyboard,
+			})
+			return err
+		}); err != nil {
+			return err
 		}
 	}
 
-	s.logger.WithFields(map[string]interface{}{
-		"sent":   sent,
-		"failed": failed,
-	}).Info("Broadcast completed")
+	if content.Pin && pinID != 0 {
+		if err := s.call(ctx, throttle, func() error {
+			return s.bot.PinChatMessage(ctx, &telego.PinChatMessageParams{
+				ChatID:              tu.ID(chatID),
+				MessageID:           pinID,
+				DisableNotification: true,
+			})
+		}); err != nil && ctx.Err() == nil {
+			s.logger.Warnf("Failed to pin broadcast in chat %d: %v", chatID, err)
+		}
+	}
+	return nil
+}
 
-	return sent, failed, nil
+// sendMedia sends a single photo, video or document with the announcement as caption
+func (s *BroadcastService) sendMedia(ctx context.Context, chatID int64, media storage.BroadcastMedia, caption string, keyboard telego.ReplyMarkup) (*telego.Message, error) {
+	file := tu.FileFromID(media.FileID)
+	switch media.Type {
+	case storage.BroadcastMediaPhoto:
+		return s.bot.SendPhoto(ctx, &telego.SendPhotoParams{
+			ChatID: tu.ID(chatID), Photo: file, Caption: caption, ParseMode: telego.ModeHTML, ReplyMarkup: keyboard,
+		})
+	case storage.BroadcastMediaVideo:
+		return s.bot.SendVideo(ctx, &telego.SendVideoParams{
+			ChatID: tu.ID(chatID), Video: file, Caption: caption, ParseMode: telego.ModeHTML, ReplyMarkup: keyboard,
+		})
+	case storage.BroadcastMediaDocument:
+		return s.bot.SendDocument(ctx, &telego.SendDocumentParams{
+			ChatID: tu.ID(chatID), Document: file, Caption: caption, ParseMode: telego.ModeHTML, ReplyMarkup: keyboard,
+		})
+	}
+	return nil, fmt.Errorf("unknown media type %q", media.Type)
 }
 
-// SendMessage sends a message to a specific user
-func (s *BroadcastService) SendMessage(chatID int64, message string) error {
-	_, err := s.bot.SendMessage(context.Background(), &telego.SendMessageParams{
-		ChatID:    tu.ID(chatID),
-		Text:      message,
-		ParseMode: "HTML",
-	})
-	return err
+// albumMedia builds the items of an album, the caption goes on the first one as Telegram shows it under the album
+func albumMedia(media []storage.BroadcastMedia, caption string) []telego.InputMedia {
+	items := make([]telego.InputMedia, 0, len(media))
+	for i, m := range media {
+		file := tu.FileFromID(m.FileID)
+		text := ""
+		if i == 0 {
+			text = caption
+		}
+		switch m.Type {
+		case storage.BroadcastMediaPhoto:
+			items = append(items, tu.MediaPhoto(file).WithCaption(text).WithParseMode(telego.ModeHTML))
+		case storage.BroadcastMediaVideo:
+			items = append(items, tu.MediaVideo(file).WithCaption(text).WithParseMode(telego.ModeHTML))
+		case storage.BroadcastMediaDocument:
+			items = append(items, tu.MediaDocument(file).WithCaption(text).WithParseMode(telego.ModeHTML))
+		}
+	}
+	return items
+}
+
+// call makes one Bot API request after waiting for throttle, when it is not nil. Flood control
+// errors are waited out and the request is made again
+func (s *BroadcastService) call(ctx context.Context, throttle <-chan time.Time, request func() error) error {
+	for {
+		if throttle != nil {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-throttle:
+			}
+		}
+
+		err := request()
+		wait := retryAfter(err)
+		if wait == 0 || ctx.Err() != nil {
+			return err
+		}
+
+		s.logger.Warnf("Broadcast hit flood control, waiting %s", wait)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// finish marks a job without pending recipients done, unless an admin paused or cancelled it meanwhile
+func (s *BroadcastService) finish(job *storage.BroadcastJob) error {
+	done, err := s.transition(job.ID, storage.BroadcastStatusDone, storage.BroadcastStatusRunning)
+	if err != nil {
+		if done != nil {
+			// Paused or cancelled after the last batch
+			s.report(done)
+			return nil
+		}
+		return fmt.Errorf("failed to finish job %d: %w", job.ID, err)
+	}
+
+	if progress, err := s.storage.GetBroadcastProgress(job.ID); err == nil {
+		s.logger.Infof("Broadcast job %d finished: %d sent, %d failed", job.ID, progress.Sent, progress.Failed)
+	}
+	s.report(done)
+	return nil
+}
+
+// retryAfter returns how long Telegram asked to wait after a flood control error, 0 for other errors
+func retryAfter(err error) time.Duration {
+	var apiErr *telegoapi.Error
+	if !errors.As(err, &apiErr) || apiErr.ErrorCode != 429 {
+		return 0
+	}
+	if apiErr.Parameters == nil || apiErr.Parameters.RetryAfter <= 0 {
+		return time.Second
+	}
+	return time.Duration(apiErr.Parameters.RetryAfter) * time.Second
 }
@@ -0,0 +1,153 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"x-ui-bot/internal/cron"
+	"x-ui-bot/internal/logger"
+	"x-ui-bot/internal/storage"
+)
+
+// ErrCronNeverFires is returned by NextRun for a valid spec that matches no date, such as February 30th
+var ErrCronNeverFires = errors.New("cron spec never fires")
+
+// scheduleRetryDelay is the pause before a schedule whose run failed is tried again
+const scheduleRetryDelay = 5 * time.Minute
+
+// BroadcastScheduler starts the broadcast schedules kept in storage when they are due. A one-off schedule
+// is removed once it has run, a recurring one moves to the next time its cron spec fires. A run that fails
+// is tried again after scheduleRetryDelay. Runs missed while the bot was down happen once when it starts again
+type BroadcastScheduler struct {
+	storage storage.Storage
+	logger  *logger.Logger
+	run     func(schedule *storage.BroadcastSchedule) error // Queues the broadcast job of one run
+
+	wake chan struct{} // Signals the scheduler that a schedule was created or changed
+}
+
+// NewBroadcastScheduler creates a new broadcast scheduler
+func NewBroadcastScheduler(storage storage.Storage, log *logger.Logger, run func(schedule *storage.BroadcastSchedule) error) *BroadcastScheduler {
+	return &BroadcastScheduler{
+		storage: storage,
+		logger:  log,
+		run:     run,
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// Start runs the scheduler until ctx is cancelled
+func (s *BroadcastScheduler) Start(ctx context.Context) {
+	s.logger.Info("Starting broadcast scheduler")
+
+	for ctx.Err() == nil {
+		wait, err := s.runDue(time.Now())
+		if err != nil {
+			s.logger.Errorf("Broadcast scheduler failed: %v", err)
+			wait = broadcastRetryDelay
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-s.wake:
+		case <-time.After(wait):
+		}
+	}
+	s.logger.Info("Stopping broadcast scheduler")
+}
+
+// Notify wakes the scheduler without blocking so it sees a new or changed schedule
+func (s *BroadcastScheduler) Notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// NextRun returns the first time after t a cron spec fires at. An error is returned for an
+// invalid spec or one that never fires
+func NextRun(spec string, t time.Time) (time.Time, error) {
+	schedule, err := cron.Parse(spec)
+	if err != nil {
+		return time.Time{}, err
+	}
+	next := schedule.Next(t)
+	if next.IsZero() {
+		return next, ErrCronNeverFires
+	}
+	return next, nil
+}
+
+// runDue starts the schedules due at now and returns the time until the next one is due,
+// at most broadcastPollInterval
+func (s *BroadcastScheduler) runDue(now time.Time) (time.Duration, error) {
+	schedules, err := s.storage.GetBroadcastSchedules()
+	if err != nil {
+		return 0, err
+	}
+
+	wait := broadcastPollInterval
+	for _, schedule := range schedules {
+		if schedule.SendAt.After(now) {
+			if d := schedule.SendAt.Sub(now); d < wait {
+				wait = d
+			}
+			continue
+		}
+
+		// A one-off schedule stays until its run is queued, so a failed run is not lost
+		retry := now.Add(scheduleRetryDelay)
+		next := retry
+		if schedule.Cron != "" {
+			if next, err = NextRun(schedule.Cron, now); err != nil {
+				// The spec was checked when it was saved, drop the schedule rather than retry it forever
+				s.logger.Errorf("Dropping broadcast schedule %d with an unusable cron spec %q: %v", schedule.ID, schedule.Cron, err)
+				if _, err := s.storage.ClaimBroadcastSchedule(schedule, time.Time{}); err != nil {
+					return 0, err
+				}
+				continue
+			}
+		}
+
+		// The claim makes sure a schedule changed or started meanwhile is not run twice
+		ok, err := s.storage.ClaimBroadcastSchedule(schedule, next)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			wait = 0
+			continue
+		}
+
+		if err := s.run(schedule); err != nil {
+			s.logger.Errorf("Failed to run broadcast schedule %d, retrying at %s: %v", schedule.ID, retry.Format(time.RFC3339), err)
+			if next.After(retry) {
+				schedule.SendAt = retry
+				if err := s.storage.UpdateBroadcastSchedule(schedule); err != nil {
+					s.logger.Errorf("Failed to reschedule broadcast schedule %d: %v", schedule.ID, err)
+				}
+			}
+			wait = min(wait, scheduleRetryDelay)
+			continue
+		}
+
+		if schedule.Cron == "" {
+			ok, err := s.storage.ClaimBroadcastSchedule(schedule, time.Time{})
+			switch {
+			case err != nil:
+				s.logger.Errorf("Broadcast schedule %d ran but could not be removed: %v", schedule.ID, err)
+			case !ok:
+				s.logger.Warnf("Broadcast schedule %d was changed while it ran and stays scheduled", schedule.ID)
+			default:
+				s.logger.Infof("Broadcast schedule %d ran and is done", schedule.ID)
+			}
+		} else {
+			s.logger.Infof("Broadcast schedule %d ran, next run at %s", schedule.ID, next.Format(time.RFC3339))
+			if d := next.Sub(now); d < wait {
+				wait = d
+			}
+		}
+	}
+	return wait, nil
+}
@@ -2,7 +2,6 @@ package services
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"strconv"
 
@@ -12,136 +11,42 @@ import (
 
 // ClientService handles client-related business logic
 type ClientService struct {
-	apiClient *client.APIClient
-	logger    *logger.Logger
+	panels *client.Registry
+	logger *logger.Logger
 }
 
 // NewClientService creates a new client service
-func NewClientService(apiClient *client.APIClient, log *logger.Logger) *ClientService {
+func NewClientService(panels *client.Registry, log *logger.Logger) *ClientService {
 	return &ClientService{
-		apiClient: apiClient,
-		logger:    log,
+		panels: panels,
+		logger: log,
 	}
 }
 
-// ParseClients parses clients from inbound settings JSON
-func (s *ClientService) ParseClients(settingsStr string) ([]map[string]string, error) {
-	var clients []map[string]string
-
-	if settingsStr == "" {
-		return clients, nil
-	}
-
-	var settings map[string]interface{}
-	if err := json.Unmarshal([]byte(settingsStr), &settings); err != nil {
-		return nil, fmt.Errorf("failed to parse settings JSON: %w", err)
-	}
-
-	clientsArray, ok := settings["clients"].([]interface{})
-	if !ok {
-		return clients, nil
-	}
-
-	for _, c := range clientsArray {
-		clientMap, ok := c.(map[string]interface{})
-		if !ok {
-			continue
-		}
-
-		client := make(map[string]string)
-		clientJSON, _ := json.Marshal(clientMap)
-		client["_raw_json"] = string(clientJSON)
-
-		if email, ok := clientMap["email"].(string); ok {
-			client["email"] = email
-		}
-		if id, ok := clientMap["id"].(string); ok {
-			client["id"] = id
-		}
-		if totalGB, ok := clientMap["totalGB"].(float64); ok {
-			client["totalGB"] = fmt.Sprintf("%.0f", totalGB)
-		} else {
-			client["totalGB"] = "0"
-		}
-		if expiryTime, ok := clientMap["expiryTime"].(float64); ok {
-			client["expiryTime"] = fmt.Sprintf("%.0f", expiryTime)
-		} else {
-			client["expiryTime"] = "0"
-		}
-		if enable, ok := clientMap["enable"].(bool); ok {
-			client["enable"] = fmt.Sprintf("%t", enable)
-		} else {
-			client["enable"] = "true"
-		}
-		if tgId, ok := clientMap["tgId"].(string); ok {
-			client["tgId"] = tgId
-		} else if tgId, ok := clientMap["tgId"].(float64); ok {
-			client["tgId"] = fmt.Sprintf("%.0f", tgId)
-		} else {
-			client["tgId"] = ""
-		}
-
-		client["up"] = "0"
-		client["down"] = "0"
-		client["total"] = "0"
-
-		clients = append(clients, client)
-	}
-
-	return clients, nil
-}
-
 // EnableClient enables a client
-func (s *ClientService) EnableClient(inboundID int, email string, client map[string]string) error {
-	s.logger.WithFields(map[string]interface{}{
-		"inbound_id": inboundID,
-		"email":      email,
-	}).Info("Enabling client")
-
-	rawJSON := client["_raw_json"]
-	var clientData map[string]interface{}
-	if err := json.Unmarshal([]byte(rawJSON), &clientData); err != nil {
-		return fmt.Errorf("failed to parse client data: %w", err)
-	}
-
-	clientData["enable"] = true
-	s.fixNumericFields(clientData)
-
-	return s.apiClient.UpdateClient(context.Background(), inboundID, email, clientData)
+func (s *ClientService) EnableClient(c client.Client) error {
+	return s.setClientEnable(c, true)
 }
 
 // DisableClient disables a client
-func (s *ClientService) DisableClient(inboundID int, email string, client map[string]string) error {
-	s.logger.WithFields(map[string]interface{}{
-		"inbound_id": inboundID,
-		"email":      email,
-	}).Info("Disabling client")
-
-	rawJSON := client["_raw_json"]
-	var clientData map[string]interface{}
-	if err := json.Unmarshal([]byte(rawJSON), &clientData); err != nil {
-		return fmt.Errorf("failed to parse client data: %w", err)
-	}
-
-	clientData["enable"] = false
-	s.fixNumericFields(clientData)
-
-	return s.apiClient.UpdateClient(context.Background(), inboundID, email, clientData)
+func (s *ClientService) DisableClient(c client.Client) error {
+	return s.setClientEnable(c, false)
 }
 
-// FixNumericFields converts float64 to int64 for specific fields (public for external use)
-func (s *ClientService) FixNumericFields(data map[string]interface{}) {
-	numericFields := []string{"expiryTime", "totalGB", "reset", "limitIp", "tgId", "created_at", "updated_at"}
-	for _, field := range numericFields {
-		if val, ok := data[field].(float64); ok {
-			data[field] = int64(val)
-		}
+// setClientEnable updates the enable flag of a client on the panel
+func (s *ClientService) setClientEnable(c client.Client, enable bool) error {
+	action := "Disabling client"
+	if enable {
+		action = "Enabling client"
 	}
-}
+	s.logger.WithFields(map[string]interface{}{
+		"panel":      c.Panel,
+		"inbound_id": c.InboundID,
+		"email":      c.Email,
+	}).Info(action)
 
-// fixNumericFields is an alias for FixNumericFields for internal use
-func (s *ClientService) fixNumericFields(data map[string]interface{}) {
-	s.FixNumericFields(data)
+	c.Enable = enable
+	return s.panels.ForClient(&c).UpdateClient(context.Background(), c.InboundID, c.Email, c.Map())
 }
 
 // FormatBytes formats bytes to human readable string
@@ -185,13 +90,10 @@ func (s *ClientService) FormatBytes(value interface{}) string {
 
 // IsClientBlocked checks if a client is blocked
 func (s *ClientService) IsClientBlocked(userID int64) bool {
-	clientInfo, err := s.apiClient.GetClientByTgID(context.Background(), userID)
+	_, clientInfo, err := s.panels.FindClientByTgID(context.Background(), userID)
 	if err != nil || clientInfo == nil {
 		return false
 	}
 
-	if enable, ok := clientInfo["enable"].(bool); ok {
-		return !enable
-	}
-	return false
+	return !clientInfo.Enable
 }
@@ -2,13 +2,16 @@ package services
 
 import (
 	"context"
-	"fmt"
+	"html"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"x-ui-bot/internal/i18n"
 	"x-ui-bot/internal/logger"
 	"x-ui-bot/internal/storage"
+	"x-ui-bot/internal/templates"
 
 	"github.com/mymmrac/telego"
 	tu "github.com/mymmrac/telego/telegoutil"
@@ -20,17 +23,22 @@ type ExpiryNotifierService struct {
 	storage          storage.Storage
 	logger           *logger.Logger
 	warningDays      []int
+	warningDaysMu    sync.RWMutex
 	checkIntervalMin int
+	localize         i18n.Localizer      // Renders buttons in the language of each user
+	render           templates.Localizer // Renders warnings in the language of each user
 }
 
 // NewExpiryNotifierService creates a new expiry notifier service
-func NewExpiryNotifierService(bot *telego.Bot, storage storage.Storage, logger *logger.Logger, warningDays []int) *ExpiryNotifierService {
+func NewExpiryNotifierService(bot *telego.Bot, storage storage.Storage, logger *logger.Logger, warningDays []int, localize i18n.Localizer, render templates.Localizer) *ExpiryNotifierService {
 	return &ExpiryNotifierService{
 		bot:              bot,
 		storage:          storage,
 		logger:           logger,
 		warningDays:      warningDays,
 		checkIntervalMin: 60, // Check every hour
+		localize:         localize,
+		render:           render,
 	}
 }
 
@@ -55,11 +63,22 @@ func (s *ExpiryNotifierService) Start(ctx context.Context) {
 	}
 }
 
+// SetWarningDays replaces the days before expiry that warnings are sent at
+func (s *ExpiryNotifierService) SetWarningDays(days []int) {
+	s.warningDaysMu.Lock()
+	s.warningDays = days
+	s.warningDaysMu.Unlock()
+}
+
 // checkAndNotify checks for expiring subscriptions and sends notifications
 func (s *ExpiryNotifierService) checkAndNotify() {
 	s.logger.Debug("Checking for expiring subscriptions")
 
-	for _, days := range s.warningDays {
+	s.warningDaysMu.RLock()
+	warningDays := s.warningDays
+	s.warningDaysMu.RUnlock()
+
+	for _, days := range warningDays {
 		// Get subscriptions expiring in N days
 		expiring, err := s.storage.GetExpiringSubscriptions(days)
 		if err != nil {
@@ -121,57 +140,24 @@ func (s *ExpiryNotifierService) wasAlreadyNotified(notifiedDays string, days int
 
 // sendExpiryWarning sends expiry warning to user
 func (s *ExpiryNotifierService) sendExpiryWarning(tgID int64, email string, daysRemaining int, expiryTime time.Time) error {
-	var message string
-	var emoji string
-
-	if daysRemaining <= 1 {
-		emoji = "🔴"
-		message = fmt.Sprintf(
-			"%s <b>Срочно! Ваша подписка истекает завтра!</b>\n\n"+
-				"👤 Аккаунт: %s\n"+
-				"⏰ Истекает: %s\n"+
-				"📅 Осталось: менее 1 дня\n\n"+
-				"⚠️ Для продления подписки нажмите кнопку ниже.",
-			emoji,
-			email,
-			expiryTime.Format("02.01.2006 15:04"),
-		)
-	} else if daysRemaining <= 3 {
-		emoji = "⚠️"
-		message = fmt.Sprintf(
-			"%s <b>Внимание! Ваша подписка скоро истечёт</b>\n\n"+
-				"👤 Аккаунт: %s\n"+
-				"⏰ Истекает: %s\n"+
-				"📅 Осталось: %d дней\n\n"+
-				"Не забудьте продлить подписку!",
-			emoji,
-			email,
-			expiryTime.Format("02.01.2006 15:04"),
-			daysRemaining,
-		)
-	} else {
-		emoji = "📅"
-		message = fmt.Sprintf(
-			"%s <b>Напоминание о подписке</b>\n\n"+
-				"👤 Аккаунт: %s\n"+
-				"⏰ Истекает: %s\n"+
-				"📅 Осталось: %d дней\n\n"+
-				"Напоминаем, что скоро истечёт срок вашей подписки.",
-			emoji,
-			email,
-			expiryTime.Format("02.01.2006 15:04"),
-			daysRemaining,
-		)
+	t := s.localize(tgID)
+	message, err := s.render(tgID)(templates.ExpiryWarning, templates.ExpiryWarningData{
+		Email:    html.EscapeString(email),
+		Expiry:   expiryTime.Format("02.01.2006 15:04"),
+		DaysLeft: daysRemaining,
+	})
+	if err != nil {
+		return err
 	}
 
 	// Add button to extend subscription
 	keyboard := tu.InlineKeyboard(
 		tu.InlineKeyboardRow(
-			tu.InlineKeyboardButton("⏰ Продлить подписку").WithCallbackData("extend_subscription"),
+			tu.InlineKeyboardButton(t("button.extend_subscription")).WithCallbackData("extend_subscription"),
 		),
 	)
 
-	_, err := s.bot.SendMessage(context.Background(), &telego.SendMessageParams{
+	_, err = s.bot.SendMessage(context.Background(), &telego.SendMessageParams{
 		ChatID:      tu.ID(tgID),
 		Text:        message,
 		ParseMode:   "HTML",
@@ -3,12 +3,15 @@ package services
 import (
 	"context"
 	"fmt"
+	"html"
 	"sync"
 	"time"
 
 	"x-ui-bot/internal/config"
+	"x-ui-bot/internal/i18n"
 	"x-ui-bot/internal/logger"
 	"x-ui-bot/internal/storage"
+	"x-ui-bot/internal/templates"
 	"x-ui-bot/pkg/client"
 
 	"github.com/mymmrac/telego"
@@ -19,9 +22,11 @@ import (
 type ForecastService struct {
 	apiClient             *client.APIClient
 	storage               storage.Storage
-	cfg                   *config.Config
+	cfg                   *config.Store
 	bot                   *telego.Bot
 	log                   *logger.Logger
+	localize              i18n.Localizer      // Renders forecasts in the language of each admin
+	render                templates.Localizer // Renders alerts in the language of each admin
 	ticker                *time.Ticker
 	stopChan              chan struct{}
 	closeOnce             sync.Once
@@ -32,13 +37,15 @@ type ForecastService struct {
 }
 
 // NewForecastService creates a new ForecastService
-func NewForecastService(apiClient *client.APIClient, store storage.Storage, bot *telego.Bot, cfg *config.Config, log *logger.Logger) *ForecastService {
+func NewForecastService(apiClient *client.APIClient, store storage.Storage, bot *telego.Bot, cfg *config.Store, log *logger.Logger, localize i18n.Localizer, render templates.Localizer) *ForecastService {
 	return &ForecastService{
 		apiClient:        apiClient,
 		storage:          store,
 		bot:              bot,
 		cfg:              cfg,
 		log:              log,
+		localize:         localize,
+		render:           render,
 		stopChan:         make(chan struct{}),
 		closeOnce:        sync.Once{},
 		alertedThreshold: make(map[int]bool),
@@ -46,6 +53,11 @@ func NewForecastService(apiClient *client.APIClient, store storage.Storage, bot
 	}
 }
 
+// PanelName returns the name of the panel this service forecasts
+func (s *ForecastService) PanelName() string {
+	return s.apiClient.Name()
+}
+
 // CollectTrafficData pulls inbound traffic and saves snapshots per inbound
 func (s *ForecastService) CollectTrafficData() error {
 	s.log.Infof("Collecting traffic data")
@@ -57,23 +69,12 @@ func (s *ForecastService) CollectTrafficData() error {
 
 	now := time.Now().UTC()
 	for _, inbound := range inbounds {
-		up := int64(0)
-		down := int64(0)
-
-		// Parse up/down from inbound (they're typically at top level)
-		if v, ok := inbound["up"].(float64); ok {
-			up = int64(v)
-		}
-		if v, ok := inbound["down"].(float64); ok {
-			down = int64(v)
-		}
-
-		inboundID := 0
-		if v, ok := inbound["id"].(float64); ok {
-			inboundID = int(v)
-		}
+		up := inbound.Up
+		down := inbound.Down
+		inboundID := inbound.ID
 
 		snapshot := &storage.TrafficSnapshot{
+			Panel:         s.apiClient.Name(),
 			InboundID:     inboundID,
 			Timestamp:     now,
 			UploadBytes:   up,
@@ -86,15 +87,12 @@ func (s *ForecastService) CollectTrafficData() error {
 			return err
 		}
 	}
-	s.log.Infof("Saved traffic snapshots for %d inbounds", len(inbounds))
+	s.log.Infof("Saved traffic snapshots for %d inbounds of panel %s", len(inbounds), s.apiClient.Name())
 
 	// Check alerts for each inbound
 	if s.cfg != nil && s.bot != nil {
 		for _, inbound := range inbounds {
-			inboundID := 0
-			if v, ok := inbound["id"].(float64); ok {
-				inboundID = int(v)
-			}
+			inboundID := inbound.ID
 			forecast, err := s.CalculateForecast(inboundID)
 			if err == nil {
 				s.evaluateAlerts(inboundID, forecast)
@@ -114,18 +112,25 @@ func (s *ForecastService) CollectTrafficData() error {
 	return nil
 }
 
-// notifyAdmins sends message to all configured admin IDs
-func (s *ForecastService) notifyAdmins(message string) {
+// notifyAdmins renders the traffic alert for all configured admin IDs, each in their language, and sends it
+func (s *ForecastService) notifyAdmins(alert templates.TrafficAlertData, forecast *TrafficForecast) {
 	if s.cfg == nil || s.bot == nil {
 		s.log.Warn("notifyAdmins: missing cfg or bot, skipping notifications")
 		return
 	}
 
+	alert.Panel = s.alertPanel()
 	ctx := context.Background()
-	for _, adminID := range s.cfg.Telegram.AdminIDs {
-		_, err := s.bot.SendMessage(ctx, &telego.SendMessageParams{
+	for _, adminID := range s.cfg.Get().Telegram.AdminIDs {
+		alert.Forecast = s.FormatForecastMessage(s.localize(adminID), forecast)
+		text, err := s.render(adminID)(templates.TrafficAlert, alert)
+		if err != nil {
+			s.log.Errorf("Failed to render forecast alert for admin %d: %v", adminID, err)
+			continue
+		}
+		_, err = s.bot.SendMessage(ctx, &telego.SendMessageParams{
 			ChatID:    tu.ID(adminID),
-			Text:      message,
+			Text:      text,
 			ParseMode: "HTML",
 		})
 		if err != nil {
@@ -134,12 +139,30 @@ func (s *ForecastService) notifyAdmins(message string) {
 	}
 }
 
+// panelConfig returns the configuration of the panel this service is bound to
+func (s *ForecastService) panelConfig() config.PanelConfig {
+	cfg := s.cfg.Get()
+	if p, ok := cfg.PanelByName(s.apiClient.Name()); ok {
+		return p
+	}
+	return cfg.Panel
+}
+
+// alertPanel names the panel in alerts when several panels are configured
+func (s *ForecastService) alertPanel() string {
+	if len(s.cfg.Get().Panels) > 1 {
+		return html.EscapeString(s.apiClient.Name())
+	}
+	return ""
+}
+
 // evaluateAlerts checks crossing thresholds and sends notifications only when crossing (per-inbound)
 func (s *ForecastService) evaluateAlerts(inboundID int, forecast *TrafficForecast) {
 	// Determine base threshold in bytes: prefer TrafficAlertThresholdGB; otherwise use TrafficLimitGB
-	thresholdGB := int64(s.cfg.Panel.TrafficAlertThresholdGB)
+	panel := s.panelConfig()
+	thresholdGB := int64(panel.TrafficAlertThresholdGB)
 	if thresholdGB <= 0 {
-		thresholdGB = int64(s.cfg.Panel.TrafficLimitGB)
+		thresholdGB = int64(panel.TrafficLimitGB)
 	}
 	if thresholdGB <= 0 {
 		// nothing to evaluate
@@ -148,17 +171,13 @@ func (s *ForecastService) evaluateAlerts(inboundID int, forecast *TrafficForecas
 	thresholdBytes := thresholdGB * 1024 * 1024 * 1024
 
 	// Check percent threshold
-	percent := s.cfg.Panel.TrafficAlertPercent
-	if percent <= 0 {
-		percent = 90
-	}
+	percent := panel.TrafficAlertPercent
 	percentBytes := thresholdBytes * int64(percent) / 100
 
 	// Crossing percent threshold for this inbound
 	if !s.alertedPercent[inboundID] && forecast.PredictedTotal >= percentBytes {
 		// send percent alert
-		alert := fmt.Sprintf("⚠️ Инбаунд #%d: Прогноз трафика достиг %d%% от порога (%d GB)\n\n%s", inboundID, percent, thresholdGB, s.FormatForecastMessage(forecast))
-		s.notifyAdmins(alert)
+		s.notifyAdmins(templates.TrafficAlertData{InboundID: inboundID, Percent: percent, ThresholdGB: thresholdGB}, forecast)
 		s.alertedPercent[inboundID] = true
 	}
 	if s.alertedPercent[inboundID] && forecast.PredictedTotal < percentBytes {
@@ -168,8 +187,7 @@ func (s *ForecastService) evaluateAlerts(inboundID int, forecast *TrafficForecas
 
 	// Crossing absolute threshold for this inbound
 	if !s.alertedThreshold[inboundID] && forecast.PredictedTotal >= thresholdBytes {
-		alert := fmt.Sprintf("⚠️ Инбаунд #%d: Прогноз трафика превысил порог %d GB\n\n%s", inboundID, thresholdGB, s.FormatForecastMessage(forecast))
-		s.notifyAdmins(alert)
+		s.notifyAdmins(templates.TrafficAlertData{InboundID: inboundID, Exceeded: true, ThresholdGB: thresholdGB}, forecast)
 		s.alertedThreshold[inboundID] = true
 	}
 	if s.alertedThreshold[inboundID] && forecast.PredictedTotal < thresholdBytes {
@@ -180,9 +198,10 @@ func (s *ForecastService) evaluateAlerts(inboundID int, forecast *TrafficForecas
 // evaluateTotalAlerts checks crossing thresholds for total traffic and sends notifications
 func (s *ForecastService) evaluateTotalAlerts(forecast *TrafficForecast) {
 	// Determine base threshold in bytes: prefer TrafficAlertThresholdGB; otherwise use TrafficLimitGB
-	thresholdGB := int64(s.cfg.Panel.TrafficAlertThresholdGB)
+	panel := s.panelConfig()
+	thresholdGB := int64(panel.TrafficAlertThresholdGB)
 	if thresholdGB <= 0 {
-		thresholdGB = int64(s.cfg.Panel.TrafficLimitGB)
+		thresholdGB = int64(panel.TrafficLimitGB)
 	}
 	if thresholdGB <= 0 {
 		// nothing to evaluate
@@ -191,16 +210,12 @@ func (s *ForecastService) evaluateTotalAlerts(forecast *TrafficForecast) {
 	thresholdBytes := thresholdGB * 1024 * 1024 * 1024
 
 	// Check percent threshold
-	percent := s.cfg.Panel.TrafficAlertPercent
-	if percent <= 0 {
-		percent = 90
-	}
+	percent := panel.TrafficAlertPercent
 	percentBytes := thresholdBytes * int64(percent) / 100
 
 	// Crossing percent threshold for total traffic
 	if !s.alertedTotalPercent && forecast.PredictedTotal >= percentBytes {
-		alert := fmt.Sprintf("⚠️ ОБЩИЙ ТРАФИК: Прогноз достиг %d%% от порога (%d GB)\n\n%s", percent, thresholdGB, s.FormatForecastMessage(forecast))
-		s.notifyAdmins(alert)
+		s.notifyAdmins(templates.TrafficAlertData{Percent: percent, ThresholdGB: thresholdGB}, forecast)
 		s.alertedTotalPercent = true
 	}
 	if s.alertedTotalPercent && forecast.PredictedTotal < percentBytes {
@@ -209,8 +224,7 @@ func (s *ForecastService) evaluateTotalAlerts(forecast *TrafficForecast) {
 
 	// Crossing absolute threshold for total traffic
 	if !s.alertedTotalThreshold && forecast.PredictedTotal >= thresholdBytes {
-		alert := fmt.Sprintf("⚠️ ОБЩИЙ ТРАФИК: Прогноз превысил порог %d GB\n\n%s", thresholdGB, s.FormatForecastMessage(forecast))
-		s.notifyAdmins(alert)
+		s.notifyAdmins(templates.TrafficAlertData{Exceeded: true, ThresholdGB: thresholdGB}, forecast)
 		s.alertedTotalThreshold = true
 	}
 	if s.alertedTotalThreshold && forecast.PredictedTotal < thresholdBytes {
@@ -283,7 +297,7 @@ func (s *ForecastService) CalculateForecast(inboundID int) (*TrafficForecast, er
 	loc := time.UTC
 	monthStart := time.Date(year, month, 1, 0, 0, 0, 0, loc)
 
-	snapshots, err := s.storage.GetTrafficSnapshots(inboundID, monthStart, now)
+	snapshots, err := s.storage.GetTrafficSnapshots(s.apiClient.Name(), inboundID, monthStart, now)
 	if err != nil {
 		return nil, err
 	}
@@ -355,12 +369,9 @@ func (s *ForecastService) CalculateTotalForecast() (*TrafficForecast, error) {
 	totalSnapshots := 0
 
 	for _, inbound := range inbounds {
-		inboundID := 0
-		if id, ok := inbound["id"].(float64); ok {
-			inboundID = int(id)
-		}
+		inboundID := inbound.ID
 
-		snapshots, err := s.storage.GetTrafficSnapshots(inboundID, monthStart, now)
+		snapshots, err := s.storage.GetTrafficSnapshots(s.apiClient.Name(), inboundID, monthStart, now)
 		if err != nil {
 			s.log.Debugf("Failed to get snapshots for inbound %d: %v", inboundID, err)
 			continue
@@ -445,9 +456,9 @@ func (s *ForecastService) FormatBytes(bytes int64) string {
 }
 
 // FormatForecastMessage prepares a nice message for admin
-func (s *ForecastService) FormatForecastMessage(forecast *TrafficForecast) string {
-	return fmt.Sprintf(
-		"📊 Прогноз трафика на текущий месяц\n\n📈 Текущий расход: %s\n🔮 Прогноз до конца месяца: %s\n📉 Средний расход в день: %s\n\n⏱ Дней прошло: %d / %d\n⏳ Дней осталось: %d\n🕐 Обновлено: %s",
+func (s *ForecastService) FormatForecastMessage(t i18n.Translator, forecast *TrafficForecast) string {
+	return t(
+		"forecast.message",
 		s.FormatBytes(forecast.CurrentTotal),
 		s.FormatBytes(forecast.PredictedTotal),
 		s.FormatBytes(forecast.AveragePerDay),
@@ -2,7 +2,6 @@ package services
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"strings"
 	"time"
@@ -30,7 +29,7 @@ type UserClientInfo struct {
 	TotalGB    int64
 	LimitIP    int
 	Enable     bool
-	RawData    map[string]interface{} // Store original client data for protocol-specific fields
+	RawData    client.Client // Store original client data for protocol-specific fields
 }
 
 // InboundSyncService handles synchronization of users across all inbounds
@@ -115,7 +114,7 @@ func (s *InboundSyncService) SyncUserInbounds() error {
 
 		// First pass: find all inbounds where user exists
 		for _, inbound := range inbounds {
-			inboundID := int(inbound["id"].(float64))
+			inboundID := inbound.ID
 			if s.hasClientInInbound(userInfo.Email, inbound) {
 				inboundsWithUser[inboundID] = true
 			}
@@ -123,7 +122,7 @@ func (s *InboundSyncService) SyncUserInbounds() error {
 
 		// Second pass: create in missing inbounds with appropriate email suffix
 		for idx, inbound := range inbounds {
-			inboundID := int(inbound["id"].(float64))
+			inboundID := inbound.ID
 
 			// Skip if already exists
 			if inboundsWithUser[inboundID] {
@@ -147,37 +146,30 @@ func (s *InboundSyncService) SyncUserInbounds() error {
 }
 
 // collectAllUsers collects all unique users from all inbounds
-func (s *InboundSyncService) collectAllUsers(inbounds []map[string]interface{}) map[int64]*UserClientInfo {
+func (s *InboundSyncService) collectAllUsers(inbounds []client.Inbound) map[int64]*UserClientInfo {
 	users := make(map[int64]*UserClientInfo)
 
 	for _, inbound := range inbounds {
-		settingsStr := ""
-		if settings, ok := inbound["settings"].(string); ok {
-			settingsStr = settings
+		clients, err := inbound.Clients()
+		if err != nil {
+			s.logger.Errorf("Failed to parse clients of inbound %d: %v", inbound.ID, err)
 		}
 
-		clients := s.parseClients(settingsStr)
-
 		for _, clientData := range clients {
-			tgID := s.extractTgID(clientData)
-			if tgID == 0 {
+			if !clientData.HasTgID() {
 				continue // Skip clients without Telegram ID
 			}
 
 			// Only add if not already present (use first occurrence)
-			if _, exists := users[tgID]; !exists {
-				// Strip __ibN suffix from email
-				email := s.extractString(clientData, "email")
-				cleanEmail := stripInboundSuffix(email)
-
-				users[tgID] = &UserClientInfo{
-					TgID:       tgID,
-					Email:      cleanEmail, // Use clean email without suffix
-					SubID:      s.extractString(clientData, "subId"),
-					ExpiryTime: s.extractInt64(clientData, "expiryTime"),
-					TotalGB:    s.extractInt64(clientData, "totalGB"),
-					LimitIP:    int(s.extractInt64(clientData, "limitIp")),
-					Enable:     s.extractBool(clientData, "enable"),
+			if _, exists := users[clientData.TgID]; !exists {
+				users[clientData.TgID] = &UserClientInfo{
+					TgID:       clientData.TgID,
+					Email:      stripInboundSuffix(clientData.Email), // Use clean email without suffix
+					SubID:      clientData.SubID,
+					ExpiryTime: clientData.ExpiryTime,
+					TotalGB:    clientData.TotalGB,
+					LimitIP:    clientData.LimitIP,
+					Enable:     clientData.Enable,
 					RawData:    clientData,
 				}
 			}
@@ -187,44 +179,15 @@ func (s *InboundSyncService) collectAllUsers(inbounds []map[string]interface{})
 	return users
 }
 
-// parseClients parses clients from inbound settings JSON
-func (s *InboundSyncService) parseClients(settingsStr string) []map[string]interface{} {
-	var clients []map[string]interface{}
-
-	if settingsStr == "" {
-		return clients
-	}
-
-	var settings map[string]interface{}
-	if err := json.Unmarshal([]byte(settingsStr), &settings); err != nil {
-		s.logger.Errorf("Failed to parse settings JSON: %v", err)
-		return clients
-	}
-
-	clientsArray, ok := settings["clients"].([]interface{})
-	if !ok {
-		return clients
-	}
-
-	for _, c := range clientsArray {
-		if clientMap, ok := c.(map[string]interface{}); ok {
-			clients = append(clients, clientMap)
-		}
-	}
-
-	return clients
-}
-
 // hasClientInInbound checks if client exists in inbound
-func (s *InboundSyncService) hasClientInInbound(email string, inbound map[string]interface{}) bool {
-	settingsStr := ""
-	if settings, ok := inbound["settings"].(string); ok {
-		settingsStr = settings
+func (s *InboundSyncService) hasClientInInbound(email string, inbound client.Inbound) bool {
+	clients, err := inbound.Clients()
+	if err != nil {
+		s.logger.Warnf("Skipping invalid clients of inbound %d: %v", inbound.ID, err)
 	}
 
-	clients := s.parseClients(settingsStr)
-	for _, client := range clients {
-		if s.extractString(client, "email") == email {
+	for _, c := range clients {
+		if c.Email == email {
 			return true
 		}
 	}
@@ -233,24 +196,12 @@ func (s *InboundSyncService) hasClientInInbound(email string, inbound map[string
 }
 
 // createClientInInbound creates a client in the specified inbound
-func (s *InboundSyncService) createClientInInbound(userInfo *UserClientInfo, inbound map[string]interface{}, _ int) error {
-	inboundID := int(inbound["id"].(float64))
-	protocol := ""
-	if p, ok := inbound["protocol"].(string); ok {
-		protocol = p
-	}
-
-	// Extract inbound remark (name) for suffix
-	inboundRemark := ""
-	if remark, ok := inbound["remark"].(string); ok && remark != "" {
-		inboundRemark = remark
-	} else {
-		inboundRemark = fmt.Sprintf("inbound%d", inboundID)
-	}
+func (s *InboundSyncService) createClientInInbound(userInfo *UserClientInfo, inbound client.Inbound, _ int) error {
+	inboundID := inbound.ID
 
 	// Add unique suffix to email to avoid duplicate errors across inbounds
 	// Format: email__remarkName
-	emailForInbound := fmt.Sprintf("%s__%s", userInfo.Email, inboundRemark)
+	emailForInbound := fmt.Sprintf("%s__%s", userInfo.Email, inbound.EmailSuffix())
 
 	// Build client data with same parameters
 	clientData := map[string]interface{}{
@@ -265,71 +216,31 @@ func (s *InboundSyncService) createClientInInbound(userInfo *UserClientInfo, inb
 	}
 
 	// Add protocol-specific fields
-	s.addProtocolFields(clientData, protocol, inbound)
+	s.addProtocolFields(clientData, inbound)
 
 	return s.apiClient.AddClient(context.Background(), inboundID, clientData)
 }
 
 // addProtocolFields adds protocol-specific fields to client data
-func (s *InboundSyncService) addProtocolFields(clientData map[string]interface{}, protocol string, inbound map[string]interface{}) {
-	switch protocol {
-	case "vless":
+func (s *InboundSyncService) addProtocolFields(clientData map[string]interface{}, inbound client.Inbound) {
+	switch inbound.Protocol {
+	case client.ProtocolVLESS:
 		clientData["id"] = s.generateUUID()
 		clientData["flow"] = ""
-	case "vmess":
+	case client.ProtocolVMess:
 		clientData["id"] = s.generateUUID()
 		clientData["alterId"] = 0
-	case "trojan":
+	case client.ProtocolTrojan:
 		clientData["password"] = s.generatePassword()
-	case "shadowsocks":
+	case client.ProtocolShadowsocks:
 		// Shadowsocks uses method from inbound settings
-		if settingsStr, ok := inbound["settings"].(string); ok {
-			var settings map[string]interface{}
-			if err := json.Unmarshal([]byte(settingsStr), &settings); err == nil {
-				if method, ok := settings["method"].(string); ok {
-					clientData["method"] = method
-				}
-			}
+		if method := inbound.Method(); method != "" {
+			clientData["method"] = method
 		}
 		clientData["password"] = s.generatePassword()
 	}
 }
 
-// Helper functions for extracting data
-func (s *InboundSyncService) extractTgID(data map[string]interface{}) int64 {
-	if tgID, ok := data["tgId"].(float64); ok {
-		return int64(tgID)
-	}
-	if tgID, ok := data["tgId"].(int64); ok {
-		return tgID
-	}
-	return 0
-}
-
-func (s *InboundSyncService) extractString(data map[string]interface{}, key string) string {
-	if val, ok := data[key].(string); ok {
-		return val
-	}
-	return ""
-}
-
-func (s *InboundSyncService) extractInt64(data map[string]interface{}, key string) int64 {
-	if val, ok := data[key].(float64); ok {
-		return int64(val)
-	}
-	if val, ok := data[key].(int64); ok {
-		return val
-	}
-	return 0
-}
-
-func (s *InboundSyncService) extractBool(data map[string]interface{}, key string) bool {
-	if val, ok := data[key].(bool); ok {
-		return val
-	}
-	return true // Default to enabled
-}
-
 // generateUUID generates a random UUID for vless/vmess
 func (s *InboundSyncService) generateUUID() string {
 	// Simple UUID v4 generation
@@ -0,0 +1,152 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"x-ui-bot/internal/logger"
+	"x-ui-bot/internal/storage"
+)
+
+// Promo code validation errors
+var (
+	ErrPromoNotFound  = errors.New("promo code not found")
+	ErrPromoExpired   = errors.New("promo code expired")
+	ErrPromoUsedUp    = errors.New("promo code used up")
+	ErrPromoUserLimit = errors.New("promo code already used by the user")
+)
+
+// Promo code length limits, codes travel in callback data and invoice payloads
+const (
+	minPromoCodeLength = 3
+	maxPromoCodeLength = 20
+)
+
+// Quote is the price of a subscription period after a promo code is applied
+type Quote struct {
+	Duration  int
+	BasePrice int    // Price before the discount
+	Price     int    // Price to pay
+	BonusDays int    // Extra days granted on top of Duration
+	Code      string // Applied promo code, empty if none
+}
+
+// TotalDays returns the days added to the subscription
+func (q Quote) TotalDays() int {
+	return q.Duration + q.BonusDays
+}
+
+// Discounted reports whether the promo code lowered the price
+func (q Quote) Discounted() bool {
+	return q.Price < q.BasePrice
+}
+
+// PromoService validates promo codes and records their redemptions
+type PromoService struct {
+	storage storage.Storage
+	logger  *logger.Logger
+}
+
+// NewPromoService creates a new promo service
+func NewPromoService(storage storage.Storage, logger *logger.Logger) *PromoService {
+	return &PromoService{
+		storage: storage,
+		logger:  logger,
+	}
+}
+
+// NormalizePromoCode returns a code as stored: trimmed and upper-cased
+func NormalizePromoCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// ValidPromoCode reports whether a normalized code has the allowed format: 3-20 Latin letters and digits
+func ValidPromoCode(code string) bool {
+	if len(code) < minPromoCodeLength || len(code) > maxPromoCodeLength {
+		return false
+	}
+	for _, r := range code {
+		if (r < 'A' || r > 'Z') && (r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// Validate returns the promo code if the user can redeem it now
+func (s *PromoService) Validate(code string, userID int64) (*storage.PromoCode, error) {
+	code = NormalizePromoCode(code)
+	if !ValidPromoCode(code) {
+		return nil, ErrPromoNotFound
+	}
+
+	promo, err := s.storage.GetPromoCode(code)
+	if err != nil {
+		return nil, ErrPromoNotFound
+	}
+
+	if !promo.ExpiresAt.IsZero() && time.Now().After(promo.ExpiresAt) {
+		return nil, ErrPromoExpired
+	}
+	if promo.MaxUses > 0 && promo.Uses >= promo.MaxUses {
+		return nil, ErrPromoUsedUp
+	}
+	if promo.PerUserLimit > 0 {
+		used, err := s.storage.CountPromoRedemptions(code, userID)
+		if err != nil {
+			return nil, err
+		}
+		if used >= promo.PerUserLimit {
+			return nil, ErrPromoUserLimit
+		}
+	}
+
+	return promo, nil
+}
+
+// Redeem records that the user paid for a period with the quoted promo code
+func (s *PromoService) Redeem(userID int64, purpose string, quote Quote) error {
+	if quote.Code == "" {
+		return nil
+	}
+
+	if err := s.storage.RedeemPromoCode(&storage.PromoRedemption{
+		Code:      quote.Code,
+		UserID:    userID,
+		Purpose:   purpose,
+		Price:     quote.Price,
+		BonusDays: quote.BonusDays,
+	}); err != nil {
+		return err
+	}
+
+	s.logger.Infof("Promo code %s redeemed by user %d for %s", quote.Code, userID, purpose)
+	return nil
+}
+
+// ApplyPromo calculates the quote of a period, promo may be nil
+func ApplyPromo(promo *storage.PromoCode, duration, price int) Quote {
+	quote := Quote{
+		Duration:  duration,
+		BasePrice: price,
+		Price:     price,
+	}
+	if promo == nil {
+		return quote
+	}
+
+	quote.Code = promo.Code
+	switch promo.Type {
+	case storage.PromoTypePercent:
+		quote.Price = price - price*promo.Value/100
+	case storage.PromoTypeFixed:
+		quote.Price = price - promo.Value
+	case storage.PromoTypeDays:
+		quote.BonusDays = promo.Value
+	}
+	if quote.Price < 0 {
+		quote.Price = 0
+	}
+	return quote
+}
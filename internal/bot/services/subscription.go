@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"time"
 
+	"x-ui-bot/internal/i18n"
 	"x-ui-bot/internal/logger"
 )
 
@@ -40,22 +41,22 @@ func (s *SubscriptionService) CalculateTimeRemaining(expiryTime int64) (days int
 }
 
 // GetSubscriptionStatus returns status icon and text based on time remaining
-func (s *SubscriptionService) GetSubscriptionStatus(expiryTime int64) (icon string, text string) {
+func (s *SubscriptionService) GetSubscriptionStatus(t i18n.Translator, expiryTime int64) (icon string, text string) {
 	if expiryTime == 0 {
-		return "♾️", "Безлимитная"
+		return "♾️", t("subscription.status_unlimited")
 	}
 
 	days, hours := s.CalculateTimeRemaining(expiryTime)
 
 	if days <= 0 {
-		return "⛔", "Истекла"
+		return "⛔", t("subscription.status_expired")
 	} else if days <= 3 {
-		return "🔴", fmt.Sprintf("%d дн. %d ч. (критично!)", days, hours)
+		return "🔴", t("subscription.status_critical", days, hours)
 	} else if days <= 7 {
-		return "⚠️", fmt.Sprintf("%d дн. %d ч.", days, hours)
+		return "⚠️", t("subscription.status_remaining", days, hours)
 	}
 
-	return "✅", fmt.Sprintf("%d дн. %d ч.", days, hours)
+	return "✅", t("subscription.status_remaining", days, hours)
 }
 
 // GetTrafficStatus returns traffic status with emoji
@@ -76,22 +77,22 @@ func (s *SubscriptionService) GetTrafficStatus(used, limit int64) (percentage fl
 }
 
 // FormatSubscriptionInfo formats subscription information for display
-func (s *SubscriptionService) FormatSubscriptionInfo(email string, expiryTime, totalBytes, usedBytes int64) string {
-	statusIcon, statusText := s.GetSubscriptionStatus(expiryTime)
+func (s *SubscriptionService) FormatSubscriptionInfo(t i18n.Translator, email string, expiryTime, totalBytes, usedBytes int64) string {
+	statusIcon, statusText := s.GetSubscriptionStatus(t, expiryTime)
 
-	msg := fmt.Sprintf("👤 Аккаунт: %s\n", email)
-	msg += fmt.Sprintf("%s Подписка: %s\n", statusIcon, statusText)
+	msg := t("subscription.info_account", email)
+	msg += t("subscription.info_status", statusIcon, statusText)
 
 	if totalBytes > 0 {
 		percentage, emoji := s.GetTrafficStatus(usedBytes, totalBytes)
-		msg += fmt.Sprintf("📊 Трафик: %s / %s %s (%.1f%%)\n",
+		msg += t("subscription.info_traffic",
 			formatBytesHelper(usedBytes),
 			formatBytesHelper(totalBytes),
 			emoji,
 			percentage,
 		)
 	} else {
-		msg += fmt.Sprintf("📊 Трафик: %s (безлимит)\n", formatBytesHelper(usedBytes))
+		msg += t("subscription.info_traffic_unlimited", formatBytesHelper(usedBytes))
 	}
 
 	return msg
@@ -11,7 +11,7 @@ import (
 
 // TrafficSyncService handles synchronization of traffic between inbounds
 type TrafficSyncService struct {
-	apiClient     *client.APIClient
+	panels        *client.Registry
 	clientService *ClientService
 	storage       storage.Storage
 	logger        *logger.Logger
@@ -20,9 +20,9 @@ type TrafficSyncService struct {
 }
 
 // NewTrafficSyncService creates a new traffic sync service
-func NewTrafficSyncService(apiClient *client.APIClient, clientService *ClientService, storage storage.Storage, logger *logger.Logger, syncHours int) *TrafficSyncService {
+func NewTrafficSyncService(panels *client.Registry, clientService *ClientService, storage storage.Storage, logger *logger.Logger, syncHours int) *TrafficSyncService {
 	return &TrafficSyncService{
-		apiClient:     apiClient,
+		panels:        panels,
 		clientService: clientService,
 		storage:       storage,
 		logger:        logger,
@@ -61,45 +61,62 @@ func (ts *TrafficSyncService) StartSync(ctx context.Context) {
 	}
 }
 
-// syncAllTraffic synchronizes traffic for all users across all inbounds
+// syncAllTraffic synchronizes traffic for all users across all inbounds of every panel
 func (ts *TrafficSyncService) syncAllTraffic(ctx context.Context) {
 	ts.logger.Infof("Starting traffic synchronization...")
 
-	// Get all inbounds
-	inbounds, err := ts.apiClient.GetInbounds(ctx)
-	if err != nil {
-		ts.logger.Errorf("Failed to get inbounds for traffic sync: %v", err)
+	activeEmails := make(map[string]bool)
+	reachable := 0
+	for _, api := range ts.panels.All() {
+		if err := ts.syncPanelTraffic(ctx, api, activeEmails); err != nil {
+			ts.logger.Errorf("Failed to sync traffic on panel %s: %v", api.Name(), err)
+			continue
+		}
+		reachable++
+	}
+
+	// Skip cleanup if some panel was unreachable, its records would look orphaned
+	if reachable < ts.panels.Len() {
 		return
 	}
 
+	// Cleanup orphaned traffic sync state records
+	if err := ts.storage.CleanupOrphanedTrafficSyncState(activeEmails); err != nil {
+		ts.logger.Errorf("Failed to cleanup orphaned traffic sync state: %v", err)
+	} else {
+		ts.logger.Debugf("Cleaned up orphaned traffic sync state records")
+	}
+}
+
+// syncPanelTraffic synchronizes traffic for users across the inbounds of one panel
+// and records the emails it saw in activeEmails
+func (ts *TrafficSyncService) syncPanelTraffic(ctx context.Context, api *client.APIClient, activeEmails map[string]bool) error {
+	// Get all inbounds with current traffic, a cached snapshot would roll back recent usage
+	inbounds, err := api.RefreshInbounds(ctx)
+	if err != nil {
+		return err
+	}
+
 	// Build a map of email -> tgId from all inbounds (get tgId from client settings)
-	emailToTgID := make(map[string]string)
+	emailToTgID := make(map[string]int64)
 
 	for _, inbound := range inbounds {
-		inboundID := int(inbound["id"].(float64))
-		settingsStr := ""
-		if settings, ok := inbound["settings"].(string); ok {
-			settingsStr = settings
-		}
+		inboundID := inbound.ID
 
 		ts.logger.Debugf("Parsing clients for inbound %d", inboundID)
 
 		// Parse clients from settings to get tgId
-		clients, err := ts.clientService.ParseClients(settingsStr)
+		clients, err := inbound.Clients()
 		if err != nil {
 			ts.logger.Errorf("Failed to parse clients for inbound %d: %v", inboundID, err)
-			continue
 		}
 
 		ts.logger.Debugf("Found %d clients in inbound %d", len(clients), inboundID)
 
-		for _, client := range clients {
-			email := client["email"]
-			tgID := client["tgId"]
-
-			if email != "" && tgID != "" {
-				emailToTgID[email] = tgID
-				ts.logger.Debugf("Mapped email %s -> tgId %s", email, tgID)
+		for _, c := range clients {
+			if c.HasTgID() {
+				emailToTgID[c.Email] = c.TgID
+				ts.logger.Debugf("Mapped email %s -> tgId %d", c.Email, c.TgID)
 			}
 		}
 	}
@@ -108,37 +125,26 @@ func (ts *TrafficSyncService) syncAllTraffic(ctx context.Context) {
 
 	// Collect all users and their traffic from all inbounds
 	// Map: tgId -> inbound -> traffic data
-	userTraffic := make(map[string]map[int]map[string]interface{})
+	userTraffic := make(map[int64]map[int]client.ClientStat)
 
 	for _, inbound := range inbounds {
-		inboundID := int(inbound["id"].(float64))
-
-		// Get clientStats from inbound
-		clientStats, ok := inbound["clientStats"].([]interface{})
-		if !ok {
-			continue
-		}
+		inboundID := inbound.ID
 
-		for _, stat := range clientStats {
-			statMap, ok := stat.(map[string]interface{})
-			if !ok {
-				continue
-			}
-
-			email, _ := statMap["email"].(string)
+		for _, stat := range inbound.ClientStats {
+			email := stat.Email
 			tgID, hasTgID := emailToTgID[email]
 
-			if !hasTgID || tgID == "" {
+			if !hasTgID {
 				ts.logger.Debugf("No tgId found for email %s", email)
 				continue
 			}
 
 			if _, exists := userTraffic[tgID]; !exists {
-				userTraffic[tgID] = make(map[int]map[string]interface{})
+				userTraffic[tgID] = make(map[int]client.ClientStat)
 			}
 
-			userTraffic[tgID][inboundID] = statMap
-			ts.logger.Debugf("Added traffic for tgId %s, inbound %d, email %s", tgID, inboundID, email)
+			userTraffic[tgID][inboundID] = stat
+			ts.logger.Debugf("Added traffic for tgId %d, inbound %d, email %s", tgID, inboundID, email)
 		}
 	}
 
@@ -147,12 +153,6 @@ func (ts *TrafficSyncService) syncAllTraffic(ctx context.Context) {
 	// Now sync traffic: calculate average or use highest value
 	synced := 0
 	for tgID, inboundMap := range userTraffic {
-		// Skip users without valid tgId
-		if tgID == "" || tgID == "0" {
-			ts.logger.Debugf("Skipping user with empty/zero tgId")
-			continue
-		}
-
 		if len(inboundMap) < 2 {
 			continue // Only sync if user exists in multiple inbounds
 		}
@@ -162,18 +162,10 @@ func (ts *TrafficSyncService) syncAllTraffic(ctx context.Context) {
 		var maxLastUp, maxLastDown int64
 		trafficReset := false
 
-		for inboundID, statMap := range inboundMap {
-			email, _ := statMap["email"].(string)
-
-			currentUp := int64(0)
-			if up, ok := statMap["up"].(float64); ok {
-				currentUp = int64(up)
-			}
-
-			currentDown := int64(0)
-			if down, ok := statMap["down"].(float64); ok {
-				currentDown = int64(down)
-			}
+		for inboundID, stat := range inboundMap {
+			email := stat.Email
+			currentUp := stat.Up
+			currentDown := stat.Down
 
 			// Get last synced state
 			lastUp, lastDown, err := ts.storage.GetTrafficSyncState(email, inboundID)
@@ -213,29 +205,22 @@ func (ts *TrafficSyncService) syncAllTraffic(ctx context.Context) {
 
 		// Safety check: target cannot be negative (shouldn't happen after reset detection)
 		if targetUp < 0 {
-			ts.logger.Warnf("Negative target traffic detected for tgId %s, resetting to 0", tgID)
+			ts.logger.Warnf("Negative target traffic detected for tgId %d, resetting to 0", tgID)
 			targetUp = 0
 		}
 		if targetDown < 0 {
-			ts.logger.Warnf("Negative target traffic detected for tgId %s, resetting to 0", tgID)
+			ts.logger.Warnf("Negative target traffic detected for tgId %d, resetting to 0", tgID)
 			targetDown = 0
 		}
 
-		ts.logger.Debugf("Sync calc for tgId %s: maxLast(up=%d, down=%d) + delta(up=%d, down=%d) = target(up=%d, down=%d)",
+		ts.logger.Debugf("Sync calc for tgId %d: maxLast(up=%d, down=%d) + delta(up=%d, down=%d) = target(up=%d, down=%d)",
 			tgID, maxLastUp, maxLastDown, totalDeltaUp, totalDeltaDown, targetUp, targetDown)
 
 		// Update traffic in all inbounds to match the target
-		for inboundID, statMap := range inboundMap {
-			email, _ := statMap["email"].(string)
-			currentUp := int64(0)
-			currentDown := int64(0)
-
-			if up, ok := statMap["up"].(float64); ok {
-				currentUp = int64(up)
-			}
-			if down, ok := statMap["down"].(float64); ok {
-				currentDown = int64(down)
-			}
+		for inboundID, stat := range inboundMap {
+			email := stat.Email
+			currentUp := stat.Up
+			currentDown := stat.Down
 
 			// Only update if current traffic differs from target
 			if currentUp != targetUp || currentDown != targetDown {
@@ -243,11 +228,11 @@ func (ts *TrafficSyncService) syncAllTraffic(ctx context.Context) {
 					email, currentUp, currentDown, targetUp, targetDown)
 
 				// Send target value - API sets absolute value
-				if err := ts.updateClientTraffic(ctx, email, targetUp, targetDown); err != nil {
-					ts.logger.Errorf("Failed to update traffic for %s (tgId=%s) in inbound %d: %v", email, tgID, inboundID, err)
+				if err := api.UpdateClientTraffic(ctx, email, targetUp, targetDown); err != nil {
+					ts.logger.Errorf("Failed to update traffic for %s (tgId=%d) in inbound %d: %v", email, tgID, inboundID, err)
 				} else {
 					synced++
-					ts.logger.Infof("Synced traffic for %s (tgId=%s): set to up=%d, down=%d",
+					ts.logger.Infof("Synced traffic for %s (tgId=%d): set to up=%d, down=%d",
 						email, tgID, targetUp, targetDown)
 
 					// Update state in DB
@@ -266,22 +251,11 @@ func (ts *TrafficSyncService) syncAllTraffic(ctx context.Context) {
 		}
 	}
 
-	ts.logger.Infof("Traffic sync completed: updated %d clients", synced)
+	ts.logger.Infof("Traffic sync completed on panel %s: updated %d clients", api.Name(), synced)
 
-	// Cleanup orphaned traffic sync state records
-	activeEmails := make(map[string]bool)
 	for email := range emailToTgID {
 		activeEmails[email] = true
 	}
 
-	if err := ts.storage.CleanupOrphanedTrafficSyncState(activeEmails); err != nil {
-		ts.logger.Errorf("Failed to cleanup orphaned traffic sync state: %v", err)
-	} else {
-		ts.logger.Debugf("Cleaned up orphaned traffic sync state records")
-	}
-}
-
-// updateClientTraffic updates traffic for a specific client using the x-ui API
-func (ts *TrafficSyncService) updateClientTraffic(ctx context.Context, email string, up int64, down int64) error {
-	return ts.apiClient.UpdateClientTraffic(ctx, email, up, down)
+	return nil
 }
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"x-ui-bot/internal/logger"
+	"x-ui-bot/internal/storage"
+	"x-ui-bot/pkg/client"
+)
+
+// UserRegistryService keeps the local users table consistent with the panels
+type UserRegistryService struct {
+	panels  *client.Registry
+	storage storage.Storage
+	logger  *logger.Logger
+}
+
+// NewUserRegistryService creates a new user registry service
+func NewUserRegistryService(panels *client.Registry, storage storage.Storage, logger *logger.Logger) *UserRegistryService {
+	return &UserRegistryService{
+		panels:  panels,
+		storage: storage,
+		logger:  logger,
+	}
+}
+
+// Start reconciles the users table on start and then periodically
+func (s *UserRegistryService) Start(ctx context.Context, interval time.Duration) {
+	s.logger.Infof("Starting user registry reconciliation (interval: %s)", interval)
+
+	if err := s.Reconcile(ctx); err != nil {
+		s.logger.Errorf("Initial user reconciliation failed: %v", err)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.logger.Info("Stopping user registry reconciliation")
+			return
+		case <-ticker.C:
+			if err := s.Reconcile(ctx); err != nil {
+				s.logger.Errorf("User reconciliation failed: %v", err)
+			}
+		}
+	}
+}
+
+// Reconcile rebuilds user records from the clients of every panel.
+// Records of users that disappeared are removed only when every panel was reachable.
+func (s *UserRegistryService) Reconcile(ctx context.Context) error {
+	seen := make(map[int64]bool)
+	reachable := 0
+
+	for _, api := range s.panels.All() {
+		inbounds, err := api.GetInbounds(ctx)
+		if err != nil {
+			s.logger.Errorf("Failed to get inbounds of panel %s for user reconciliation: %v", api.Name(), err)
+			continue
+		}
+		reachable++
+
+		// A user found on an earlier panel keeps that panel, matching Registry.FindClientByTgID
+		for tgID, user := range s.usersFromInbounds(api.Name(), inbounds) {
+			if seen[tgID] {
+				continue
+			}
+			seen[tgID] = true
+			if err := s.storage.UpsertUser(user); err != nil {
+				s.logger.Errorf("Failed to save user %d: %v", tgID, err)
+			}
+		}
+	}
+
+	if reachable == 0 {
+		return fmt.Errorf("no panel reachable")
+	}
+	if reachable < s.panels.Len() {
+		s.logger.Warnf("Skipping orphaned user cleanup, %d of %d panels unreachable", s.panels.Len()-reachable, s.panels.Len())
+		return nil
+	}
+
+	if err := s.storage.CleanupOrphanedUsers(seen); err != nil {
+		return fmt.Errorf("failed to clean up orphaned users: %w", err)
+	}
+
+	s.logger.Infof("User reconciliation completed: %d users", len(seen))
+	return nil
+}
+
+// SyncUser refreshes the record of one user from the panels.
+// language is stored when not empty, the record is removed when the user has no clients left.
+func (s *UserRegistryService) SyncUser(ctx context.Context, tgID int64, language string) error {
+	complete := true
+	for _, api := range s.panels.All() {
+		inbounds, err := api.GetInbounds(ctx)
+		if err != nil {
+			s.logger.Errorf("Failed to get inbounds of panel %s for user %d: %v", api.Name(), tgID, err)
+			complete = false
+			continue
+		}
+
+		if user, ok := s.usersFromInbounds(api.Name(), inbounds)[tgID]; ok {
+			user.Language = language
+			return s.storage.UpsertUser(user)
+		}
+	}
+
+	if !complete {
+		return fmt.Errorf("user %d not found on reachable panels", tgID)
+	}
+	return s.storage.DeleteUser(tgID)
+}
+
+// usersFromInbounds groups the clients of a panel by Telegram ID
+func (s *UserRegistryService) usersFromInbounds(panel string, inbounds []client.Inbound) map[int64]*storage.User {
+	users := make(map[int64]*storage.User)
+	for _, inbound := range inbounds {
+		clients, err := inbound.Clients()
+		if err != nil {
+			s.logger.Warnf("Skipping invalid clients of panel %s: %v", panel, err)
+		}
+
+		for _, c := range clients {
+			if !c.HasTgID() {
+				continue
+			}
+
+			user, ok := users[c.TgID]
+			if !ok {
+				user = &storage.User{
+					TgID:   c.TgID,
+					Email:  stripInboundSuffix(c.Email),
+					Panel:  panel,
+					Status: storage.UserStatusDisabled,
+				}
+				users[c.TgID] = user
+			}
+
+			if user.SubID == "" {
+				user.SubID = c.SubID
+			}
+			user.InboundIDs = append(user.InboundIDs, inbound.ID)
+			// The user is active while any of their clients is enabled
+			if c.Enable {
+				user.Status = storage.UserStatusActive
+			}
+		}
+	}
+	return users
+}
@@ -3,18 +3,28 @@ package config
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
+// DefaultPanelName is the name given to the panel configured via the single `panel` section
+const DefaultPanelName = "default"
+
+// defaultInboundCacheTTL is used when a panel does not set inbound_cache_seconds
+const defaultInboundCacheTTL = 30 * time.Second
+
 // Config holds all application configuration
 type Config struct {
-	Panel         PanelConfig         `yaml:"panel"`
+	Panel         PanelConfig         `yaml:"panel"`  // Primary panel (first entry of Panels when a list is configured)
+	Panels        []PanelConfig       `yaml:"panels"` // Named panels for multi-server setups
 	Telegram      TelegramConfig      `yaml:"telegram"`
 	Payment       PaymentConfig       `yaml:"payment"`
 	Instructions  InstructionsConfig  `yaml:"instructions"`
 	RateLimit     RateLimitConfig     `yaml:"rate_limit"`
 	Notifications NotificationsConfig `yaml:"notifications"`
+	Referral      ReferralConfig      `yaml:"referral"`
+	Storage       StorageConfig       `yaml:"storage"`
 }
 
 // InstructionsConfig holds URLs for setup instructions
@@ -25,14 +35,34 @@ type InstructionsConfig struct {
 	Windows string `yaml:"windows"`
 }
 
-// RateLimitConfig holds rate limiting configuration
+// DefaultRateLimitCosts are the token costs of rate limited actions, costs set in the config replace them
+var DefaultRateLimitCosts = map[string]int{
+	"callback":     1,
+	"message":      1,
+	"command":      1,
+	"registration": 5,
+	"qr_code":      3,
+}
+
+// RateLimitConfig holds rate limiting configuration.
+// Every user has a bucket of Burst tokens refilled at MaxRequestsPerMinute per window, actions take their cost from it
 type RateLimitConfig struct {
-	MaxRequestsPerMinute int `yaml:"max_requests_per_minute"`
-	WindowSeconds        int `yaml:"window_seconds"`
+	MaxRequestsPerMinute int            `yaml:"max_requests_per_minute"`
+	WindowSeconds        int            `yaml:"window_seconds"`
+	Burst                int            `yaml:"burst"`        // Requests allowed at once (default max_requests_per_minute)
+	Costs                map[string]int `yaml:"costs"`        // Tokens per action: callback, message, command, registration, qr_code
+	LimitAdmins          bool           `yaml:"limit_admins"` // Apply the limit to admins too, they are exempt by default
+	// Every penalty_threshold refused requests add a strike that blocks the user for penalty_seconds,
+	// doubled with every further strike up to max_penalty_seconds. Refused requests are forgotten after a quiet hour,
+	// strikes after a quiet day
+	PenaltyThreshold  int `yaml:"penalty_threshold"`   // Default 5
+	PenaltySeconds    int `yaml:"penalty_seconds"`     // Default 60
+	MaxPenaltySeconds int `yaml:"max_penalty_seconds"` // Default 86400
 }
 
 // PanelConfig holds 3x-ui panel configuration
 type PanelConfig struct {
+	Name           string `yaml:"name"` // Unique panel name, shown in server pickers
 	URL            string `yaml:"url"`
 	Username       string `yaml:"username"`
 	Password       string `yaml:"password"`
@@ -48,6 +78,16 @@ type PanelConfig struct {
 	MultiInboundSync      bool `yaml:"multi_inbound_sync"`       // Periodically sync existing users to all inbounds
 	MultiInboundSyncHours int  `yaml:"multi_inbound_sync_hours"` // Sync interval in hours (default: 24)
 	TrafficSyncHours      int  `yaml:"traffic_sync_hours"`       // Sync traffic between inbounds interval in hours (0 = disabled)
+	// InboundCacheSeconds - how long the inbound list is reused between panel requests (default 30, negative = disabled)
+	InboundCacheSeconds int `yaml:"inbound_cache_seconds"`
+}
+
+// InboundCacheTTL returns the lifetime of the cached inbound list, 0 when caching is disabled
+func (p PanelConfig) InboundCacheTTL() time.Duration {
+	if p.InboundCacheSeconds < 0 {
+		return 0
+	}
+	return time.Duration(p.InboundCacheSeconds) * time.Second
 }
 
 // TelegramConfig holds Telegram bot configuration
@@ -66,11 +106,72 @@ type PaymentConfig struct {
 	TrialText        string       `yaml:"trial_text"`
 	AutoApproveTrial bool         `yaml:"auto_approve_trial"`
 	Prices           PricesConfig `yaml:"prices"`
+	// Telegram Payments: extensions are paid by invoice when a provider token is set
+	ProviderToken string `yaml:"provider_token"`
+	Currency      string `yaml:"currency"` // ISO 4217 code of the invoice currency, prices are in whole units of it (default: RUB)
+}
+
+// InvoicesEnabled reports whether extensions are paid through Telegram Payments
+func (p PaymentConfig) InvoicesEnabled() bool {
+	return p.ProviderToken != ""
+}
+
+// currencyExponents lists the ISO 4217 currencies without two digits after the decimal point.
+// Telegram Payments takes amounts in the smallest units of the currency
+var currencyExponents = map[string]int{
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0, "KRW": 0, "PYG": 0,
+	"RWF": 0, "UGX": 0, "UYI": 0, "VND": 0, "VUV": 0, "XAF": 0, "XOF": 0, "XPF": 0,
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+}
+
+// CurrencyExponent returns the number of digits after the decimal point of a currency, 2 for most of them
+func CurrencyExponent(currency string) int {
+	if exp, ok := currencyExponents[currency]; ok {
+		return exp
+	}
+	return 2
+}
+
+// CurrencyMinorUnits returns the number of smallest units in one unit of a currency, such as 100 kopecks in a rouble
+func CurrencyMinorUnits(currency string) int {
+	units := 1
+	for range CurrencyExponent(currency) {
+		units *= 10
+	}
+	return units
+}
+
+// MinorUnits returns the number of smallest units in one unit of the invoice currency, prices are in whole units
+func (p PaymentConfig) MinorUnits() int {
+	return CurrencyMinorUnits(p.Currency)
 }
 
 // NotificationsConfig holds notification settings
 type NotificationsConfig struct {
-	ExpiryWarningDays []int `yaml:"expiry_warning_days"` // Days before expiry to send warnings (e.g., [7, 3, 1])
+	ExpiryWarningDays []int  `yaml:"expiry_warning_days"` // Days before expiry to send warnings (e.g., [7, 3, 1])
+	TemplatesDir      string `yaml:"templates_dir"`       // Directory of message templates overriding the built-in ones, empty to use those
+}
+
+// ReferralConfig holds referral program settings
+type ReferralConfig struct {
+	BonusDays int `yaml:"bonus_days"` // Days credited to the referrer on the first paid subscription of a referred user (0 = disabled)
+}
+
+// Enabled reports whether referral links are offered
+func (r ReferralConfig) Enabled() bool {
+	return r.BonusDays > 0
+}
+
+// Storage drivers
+const (
+	StorageSQLite   = "sqlite"   // bot.db in the data directory
+	StoragePostgres = "postgres" // PostgreSQL server, for several replicas or central reporting
+)
+
+// StorageConfig selects the database of the bot
+type StorageConfig struct {
+	Driver string `yaml:"driver"` // sqlite (default) or postgres
+	DSN    string `yaml:"dsn"`    // PostgreSQL connection string, e.g. postgres://bot:secret@db:5432/xuibot?sslmode=disable
 }
 
 // PricesConfig holds prices for different subscription periods
@@ -81,42 +182,132 @@ type PricesConfig struct {
 	OneYear    int `yaml:"one_year"`
 }
 
+// ForDuration returns the price of a subscription period in days, 0 for unknown periods
+func (p PricesConfig) ForDuration(days int) int {
+	switch days {
+	case 30:
+		return p.OneMonth
+	case 90:
+		return p.ThreeMonth
+	case 180:
+		return p.SixMonth
+	case 365:
+		return p.OneYear
+	}
+	return 0
+}
+
 // Load reads configuration from config.yaml file
 func Load() (*Config, error) {
-	data, err := os.ReadFile("config.yaml")
+	return LoadFile(DefaultPath)
+}
+
+// LoadFile reads configuration from a file
+func LoadFile(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read config.yaml: %w", err)
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return Parse(data)
+}
+
+// Parse parses and validates configuration, applying XUIBOT_* environment overrides and filling in defaults.
+// Invalid settings are reported together as a *ValidationError
+func Parse(data []byte) (*Config, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
 	}
 
 	var cfg Config
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
-		return nil, fmt.Errorf("failed to parse config.yaml: %w", err)
+	if len(root.Content) > 0 {
+		if err := root.Decode(&cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse config: %w", err)
+		}
 	}
 
-	// Validate required fields
-	if cfg.Telegram.Token == "" {
-		return nil, fmt.Errorf("telegram.token is required")
+	if err := applyEnv(&cfg); err != nil {
+		return nil, fmt.Errorf("invalid environment override: %w", err)
 	}
 
-	if len(cfg.Telegram.AdminIDs) == 0 {
-		return nil, fmt.Errorf("telegram.admin_ids is required")
+	// Single `panel` section is shorthand for a one-element panels list
+	singlePanel := len(cfg.Panels) == 0
+	if singlePanel {
+		cfg.Panels = []PanelConfig{cfg.Panel}
 	}
 
-	if cfg.Panel.URL == "" {
-		return nil, fmt.Errorf("panel.url is required")
+	applyDefaults(&cfg, singlePanel)
+	if err := validate(&cfg, yamlLines(&root), singlePanel); err != nil {
+		return nil, err
 	}
 
-	if cfg.Panel.Username == "" {
-		return nil, fmt.Errorf("panel.username is required")
+	// Global settings (backups, sync intervals) are read from the primary panel
+	cfg.Panel = cfg.Panels[0]
+
+	return &cfg, nil
+}
+
+// applyDefaults fills in every setting left unset, so the rest of the bot reads final values
+func applyDefaults(cfg *Config, singlePanel bool) {
+	if cfg.Payment.Currency == "" {
+		cfg.Payment.Currency = "RUB"
 	}
 
-	if cfg.Panel.Password == "" {
-		return nil, fmt.Errorf("panel.password is required")
+	for i := range cfg.Panels {
+		p := &cfg.Panels[i]
+		if singlePanel && p.Name == "" {
+			p.Name = DefaultPanelName
+		}
+		if p.MultiInboundSyncHours == 0 {
+			p.MultiInboundSyncHours = 24
+		}
+		if p.TrafficAlertPercent == 0 {
+			p.TrafficAlertPercent = 90
+		}
+		if p.InboundCacheSeconds == 0 {
+			p.InboundCacheSeconds = int(defaultInboundCacheTTL / time.Second)
+		}
 	}
 
-	if cfg.Panel.LimitIP < 0 {
-		cfg.Panel.LimitIP = 0 // Reset to 0 (unlimited) if negative
+	r := &cfg.RateLimit
+	if r.MaxRequestsPerMinute == 0 {
+		r.MaxRequestsPerMinute = 10
 	}
+	if r.WindowSeconds == 0 {
+		r.WindowSeconds = 60
+	}
+	if r.Burst == 0 {
+		r.Burst = r.MaxRequestsPerMinute
+	}
+	if r.PenaltyThreshold == 0 {
+		r.PenaltyThreshold = 5
+	}
+	if r.PenaltySeconds == 0 {
+		r.PenaltySeconds = 60
+	}
+	if r.MaxPenaltySeconds == 0 {
+		r.MaxPenaltySeconds = 24 * 60 * 60
+	}
+	costs := make(map[string]int, len(DefaultRateLimitCosts))
+	for action, cost := range DefaultRateLimitCosts {
+		costs[action] = cost
+	}
+	for action, cost := range r.Costs {
+		costs[action] = cost
+	}
+	r.Costs = costs
 
-	return &cfg, nil
+	if cfg.Storage.Driver == "" {
+		cfg.Storage.Driver = StorageSQLite
+	}
+}
+
+// PanelByName returns the configuration of a named panel
+func (c *Config) PanelByName(name string) (PanelConfig, bool) {
+	for _, p := range c.Panels {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return PanelConfig{}, false
 }
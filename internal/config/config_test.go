@@ -0,0 +1,289 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// baseYAML is a valid config, the problem tests refer to the lines of its settings
+const baseYAML = `telegram:
+  token: "123:abc"
+  admin_ids: [1, 2]
+panel:
+  url: "http://127.0.0.1:2053"
+  username: admin
+  password: admin
+payment:
+  bank: Test Bank
+  prices:
+    one_month: 300
+    three_month: 800
+rate_limit:
+  costs:
+    qr_code: 2
+`
+
+func TestParseValid(t *testing.T) {
+	cfg, err := Parse([]byte(baseYAML))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if cfg.Panel.Name != DefaultPanelName || len(cfg.Panels) != 1 || cfg.Payment.Currency != "RUB" {
+		t.Errorf("defaults not applied: panel %q, %d panels, currency %q", cfg.Panel.Name, len(cfg.Panels), cfg.Payment.Currency)
+	}
+}
+
+func TestParseReportsProblemLines(t *testing.T) {
+	tests := []struct {
+		name    string
+		replace [2]string // Changes baseYAML
+		want    []Problem
+	}{
+		{
+			name:    "invalid url",
+			replace: [2]string{`"http://127.0.0.1:2053"`, `"ftp://panel"`},
+			want:    []Problem{{Path: "panel.url", Line: 5}},
+		},
+		{
+			name:    "invalid admin id in a list",
+			replace: [2]string{"[1, 2]", "[1, -2]"},
+			want:    []Problem{{Path: "telegram.admin_ids[1]", Line: 3}},
+		},
+		{
+			name:    "negative price",
+			replace: [2]string{"three_month: 800", "three_month: -1"},
+			want:    []Problem{{Path: "payment.prices.three_month", Line: 12}},
+		},
+		{
+			name:    "unknown map key",
+			replace: [2]string{"qr_code: 2", "qr: 2"},
+			want:    []Problem{{Path: "rate_limit.costs.qr", Line: 15}},
+		},
+		{
+			name:    "missing setting points at its parent",
+			replace: [2]string{"  password: admin\n", ""},
+			want:    []Problem{{Path: "panel.password", Line: 4}},
+		},
+		{
+			name:    "problems in file order",
+			replace: [2]string{"one_month: 300\n    three_month: 800", "one_month: -1\n    three_month: -2\n  currency: rub"},
+			want: []Problem{
+				{Path: "payment.prices.one_month", Line: 11},
+				{Path: "payment.prices.three_month", Line: 12},
+				{Path: "payment.currency", Line: 13},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := strings.Replace(baseYAML, tt.replace[0], tt.replace[1], 1)
+			if data == baseYAML {
+				t.Fatalf("%q is not in the base config", tt.replace[0])
+			}
+
+			_, err := Parse([]byte(data))
+			var verr *ValidationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("Parse error = %v, want a ValidationError", err)
+			}
+
+			var got []Problem
+			for _, p := range verr.Problems {
+				got = append(got, Problem{Path: p.Path, Line: p.Line})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("problems = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseEnvOverridesYAML(t *testing.T) {
+	t.Setenv("XUIBOT_TELEGRAM_TOKEN", "456:env")
+	t.Setenv("XUIBOT_TELEGRAM_ADMIN_IDS", "7, 8")
+	t.Setenv("XUIBOT_PANEL_PASSWORD", "from-env")
+	t.Setenv("XUIBOT_PAYMENT_PRICES_ONE_MONTH", "450")
+	t.Setenv("XUIBOT_RATE_LIMIT_COSTS_QR_CODE", "5")
+
+	cfg, err := Parse([]byte(baseYAML))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	if cfg.Telegram.Token != "456:env" {
+		t.Errorf("token = %q, want the env value", cfg.Telegram.Token)
+	}
+	if !reflect.DeepEqual(cfg.Telegram.AdminIDs, []int64{7, 8}) {
+		t.Errorf("admin_ids = %v, want [7 8]", cfg.Telegram.AdminIDs)
+	}
+	if cfg.Panel.Password != "from-env" || cfg.Panels[0].Password != "from-env" {
+		t.Errorf("panel password = %q, want the env value", cfg.Panel.Password)
+	}
+	if cfg.Payment.Prices.OneMonth != 450 || cfg.Payment.Prices.ThreeMonth != 800 {
+		t.Errorf("prices = %+v, want one_month from env and three_month from the file", cfg.Payment.Prices)
+	}
+	if cfg.RateLimit.Costs["qr_code"] != 5 {
+		t.Errorf("qr_code cost = %d, want 5", cfg.RateLimit.Costs["qr_code"])
+	}
+}
+
+func TestParseEnvInvalidValue(t *testing.T) {
+	t.Setenv("XUIBOT_PAYMENT_PRICES_ONE_MONTH", "cheap")
+
+	if _, err := Parse([]byte(baseYAML)); err == nil || !strings.Contains(err.Error(), "XUIBOT_PAYMENT_PRICES_ONE_MONTH") {
+		t.Errorf("Parse error = %v, want one naming the variable", err)
+	}
+}
+
+func TestParseEnvFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"trailing newline", "secret\n", "secret"},
+		{"windows line ending", "secret\r\n", "secret"},
+		{"no newline", "secret", "secret"},
+		{"inner spaces kept", " two words \n", " two words "},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "password")
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv("XUIBOT_PANEL_PASSWORD_FILE", path)
+
+			cfg, err := Parse([]byte(baseYAML))
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if cfg.Panel.Password != tt.want {
+				t.Errorf("password = %q, want %q", cfg.Panel.Password, tt.want)
+			}
+		})
+	}
+
+	t.Run("variable wins over the file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "password")
+		if err := os.WriteFile(path, []byte("from-file\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv("XUIBOT_PANEL_PASSWORD_FILE", path)
+		t.Setenv("XUIBOT_PANEL_PASSWORD", "from-env")
+
+		cfg, err := Parse([]byte(baseYAML))
+		if err != nil {
+			t.Fatalf("Parse: %v", err)
+		}
+		if cfg.Panel.Password != "from-env" {
+			t.Errorf("password = %q, want from-env", cfg.Panel.Password)
+		}
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		t.Setenv("XUIBOT_PANEL_PASSWORD_FILE", filepath.Join(t.TempDir(), "missing"))
+
+		if _, err := Parse([]byte(baseYAML)); err == nil || !strings.Contains(err.Error(), "XUIBOT_PANEL_PASSWORD_FILE") {
+			t.Errorf("Parse error = %v, want one naming the variable", err)
+		}
+	})
+}
+
+func TestKeepRestartOnly(t *testing.T) {
+	old, err := Parse([]byte(baseYAML))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	changed := baseYAML + `storage:
+  driver: postgres
+  dsn: "postgres://bot@db/xuibot"
+notifications:
+  templates_dir: /etc/templates
+`
+	for _, r := range [][2]string{
+		{`"123:abc"`, `"456:def"`},
+		{`"http://127.0.0.1:2053"`, `"http://10.0.0.1:2053"`},
+		{"username: admin", "username: root"},
+		{"one_month: 300", "one_month: 350"},
+		{"qr_code: 2", "qr_code: 4"},
+	} {
+		changed = strings.Replace(changed, r[0], r[1], 1)
+	}
+	next, err := Parse([]byte(changed))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	got := keepRestartOnly(old, next)
+	want := []string{
+		"telegram.token",
+		"storage.driver",
+		"storage.dsn",
+		"notifications.templates_dir",
+		"panels[0].url",
+		"panels[0].username",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("restart required = %v, want %v", got, want)
+	}
+
+	// Every restart-only setting keeps its running value
+	for _, path := range restartOnlyFields {
+		o, n := reflect.ValueOf(old).Elem(), reflect.ValueOf(next).Elem()
+		for _, name := range strings.Split(path, ".") {
+			i, _ := fieldByYAML(o.Type(), name)
+			o, n = o.Field(i), n.Field(i)
+		}
+		if !reflect.DeepEqual(o.Interface(), n.Interface()) {
+			t.Errorf("%s = %v, want the running value %v", path, n.Interface(), o.Interface())
+		}
+	}
+	if !reflect.DeepEqual(next.Panels, old.Panels) || !reflect.DeepEqual(next.Panel, old.Panel) {
+		t.Errorf("panel = %+v, want the running panel %+v", next.Panel, old.Panel)
+	}
+
+	// Reloadable settings take the new values
+	if next.Payment.Prices.OneMonth != 350 || next.RateLimit.Costs["qr_code"] != 4 {
+		t.Errorf("reloadable settings were not applied: price %d, qr_code cost %d",
+			next.Payment.Prices.OneMonth, next.RateLimit.Costs["qr_code"])
+	}
+}
+
+func TestKeepRestartOnlyPanelsAddedOrRemoved(t *testing.T) {
+	old, err := Parse([]byte(baseYAML))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	next, err := Parse([]byte(strings.Replace(baseYAML, `panel:
+  url: "http://127.0.0.1:2053"
+  username: admin
+  password: admin
+`, `panels:
+  - name: a
+    url: "http://127.0.0.1:2053"
+    username: admin
+    password: admin
+  - name: b
+    url: "http://127.0.0.2:2053"
+    username: admin
+    password: admin
+`, 1)))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	if got := keepRestartOnly(old, next); !reflect.DeepEqual(got, []string{"panels"}) {
+		t.Errorf("restart required = %v, want [panels]", got)
+	}
+	if !reflect.DeepEqual(next.Panels, old.Panels) || next.Panel.Name != DefaultPanelName {
+		t.Errorf("panels = %+v, want the running panels", next.Panels)
+	}
+}
@@ -0,0 +1,153 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// EnvPrefix starts the names of environment variables that override config fields
+const EnvPrefix = "XUIBOT"
+
+// envFileSuffix marks a variable naming a file that holds the value, as Docker secrets are mounted
+const envFileSuffix = "_FILE"
+
+// applyEnv overrides config fields from environment variables. A variable name is the yaml path of the
+// field in upper case joined by underscores: XUIBOT_TELEGRAM_TOKEN, XUIBOT_PAYMENT_PRICES_ONE_MONTH,
+// XUIBOT_PANELS_0_PASSWORD for panels listed in the file, XUIBOT_RATE_LIMIT_COSTS_QR_CODE for map keys.
+// Lists are comma-separated. XUIBOT_TELEGRAM_TOKEN_FILE=/run/secrets/token reads the value from a file
+func applyEnv(cfg *Config) error {
+	return applyEnvValue(EnvPrefix, reflect.ValueOf(cfg).Elem())
+}
+
+// applyEnvValue overrides v and the fields nested in it from the variables named after prefix
+func applyEnvValue(prefix string, v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			name := yamlName(v.Type().Field(i))
+			if name == "" {
+				continue
+			}
+			if err := applyEnvValue(prefix+"_"+strings.ToUpper(name), v.Field(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Struct {
+			for i := 0; i < v.Len(); i++ {
+				if err := applyEnvValue(fmt.Sprintf("%s_%d", prefix, i), v.Index(i)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	case reflect.Map:
+		return applyEnvMap(prefix, v)
+	}
+
+	value, ok, err := lookupEnv(prefix)
+	if err != nil || !ok {
+		return err
+	}
+	if err := setValue(v, value); err != nil {
+		return fmt.Errorf("%s: %w", prefix, err)
+	}
+	return nil
+}
+
+// applyEnvMap sets map entries from variables named prefix_KEY, the key is lower-cased
+func applyEnvMap(prefix string, v reflect.Value) error {
+	seen := make(map[string]bool)
+	for _, env := range os.Environ() {
+		name, _, _ := strings.Cut(env, "=")
+		if !strings.HasPrefix(name, prefix+"_") {
+			continue
+		}
+		name = strings.TrimSuffix(name, envFileSuffix)
+		key := strings.ToLower(strings.TrimPrefix(name, prefix+"_"))
+		if key == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		value, ok, err := lookupEnv(name)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		elem := reflect.New(v.Type().Elem()).Elem()
+		if err := setValue(elem, value); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		v.SetMapIndex(reflect.ValueOf(key), elem)
+	}
+	return nil
+}
+
+// lookupEnv returns the value of a variable, or the content of the file named by its _FILE variant
+func lookupEnv(name string) (string, bool, error) {
+	if value, ok := os.LookupEnv(name); ok {
+		return value, true, nil
+	}
+
+	path, ok := os.LookupEnv(name + envFileSuffix)
+	if !ok {
+		return "", false, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", false, fmt.Errorf("%s%s: %w", name, envFileSuffix, err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), true, nil
+}
+
+// setValue parses a variable value into a field
+func setValue(v reflect.Value, value string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", value)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(strings.TrimSpace(value), 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		v.SetInt(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(strings.TrimSpace(value), v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		var items []string
+		if strings.TrimSpace(value) != "" {
+			items = strings.Split(value, ",")
+		}
+		slice := reflect.MakeSlice(v.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := setValue(slice.Index(i), strings.TrimSpace(item)); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
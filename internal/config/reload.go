@@ -0,0 +1,272 @@
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// DefaultPath is the config file read from the working directory
+const DefaultPath = "config.yaml"
+
+// DefaultWatchInterval is how often the config file is checked for changes
+const DefaultWatchInterval = 5 * time.Second
+
+// restartOnlyFields are settings read once at start. A reload keeps their running values
+// and reports the change, they apply after a restart
+var restartOnlyFields = []string{
+	"telegram.token",
+	"telegram.proxy",
+	"telegram.api_server",
+	"storage.driver",
+	"storage.dsn",
+	"notifications.templates_dir",
+}
+
+// restartOnlyPanelFields are panel settings that connect the bot to the panel or start its schedulers
+var restartOnlyPanelFields = []string{
+	"name",
+	"url",
+	"username",
+	"password",
+	"inbound_cache_seconds",
+	"multi_inbound_sync",
+	"multi_inbound_sync_hours",
+	"traffic_sync_hours",
+	"backup_days",
+}
+
+// ReloadResult describes an applied reload
+type ReloadResult struct {
+	Changed         []string // Settings that took effect, as yaml paths
+	RestartRequired []string // Settings changed in the file that keep their running values until a restart
+}
+
+// Store holds the configuration in use. A reload replaces it as a whole, so readers always get
+// a consistent snapshot from Get
+type Store struct {
+	path     string
+	current  atomic.Pointer[Config]
+	mu       sync.Mutex // Serializes reloads
+	checksum [sha256.Size]byte
+	onReload []func(cfg *Config) error
+}
+
+// NewStore creates a store serving cfg, later reloads read path
+func NewStore(path string, cfg *Config) *Store {
+	s := &Store{path: path}
+	s.current.Store(cfg)
+	if data, err := os.ReadFile(path); err == nil {
+		s.checksum = sha256.Sum256(data)
+	}
+	return s
+}
+
+// Get returns the current configuration. The returned value must not be modified
+func (s *Store) Get() *Config {
+	return s.current.Load()
+}
+
+// OnReload registers a function applying a new configuration to a component that copied settings at start.
+// An error rolls the whole reload back
+func (s *Store) OnReload(fn func(cfg *Config) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onReload = append(s.onReload, fn)
+}
+
+// Reload reads and validates the config file and switches to it.
+// On any error the current configuration stays in place
+func (s *Store) Reload() (*ReloadResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", s.path, err)
+	}
+	s.checksum = sha256.Sum256(data)
+
+	return s.apply(data)
+}
+
+// apply switches to the configuration in data, s.mu must be held
+func (s *Store) apply(data []byte) (*ReloadResult, error) {
+	next, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	old := s.Get()
+	result := &ReloadResult{RestartRequired: keepRestartOnly(old, next)}
+	result.Changed = diffFields("", reflect.ValueOf(*old), reflect.ValueOf(*next))
+	if len(result.Changed) == 0 {
+		return result, nil
+	}
+
+	s.current.Store(next)
+	for _, fn := range s.onReload {
+		if err := fn(next); err != nil {
+			s.rollback(old)
+			return nil, fmt.Errorf("failed to apply the new config, kept the previous one: %w", err)
+		}
+	}
+	return result, nil
+}
+
+// rollback restores the previous configuration and re-applies it to every component
+func (s *Store) rollback(old *Config) {
+	s.current.Store(old)
+	for _, fn := range s.onReload {
+		_ = fn(old) // The previous config was applied before, it is not expected to fail
+	}
+}
+
+// Watch reloads the config on SIGHUP and when the file content changes, checked every interval.
+// report is called after every reload attempt. Watch returns when ctx is done
+func (s *Store) Watch(ctx context.Context, interval time.Duration, report func(result *ReloadResult, err error)) {
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			report(s.Reload())
+		case <-ticker.C:
+			if result, changed, err := s.reloadIfChanged(); changed {
+				report(result, err)
+			}
+		}
+	}
+}
+
+// reloadIfChanged reloads the file when its content differs from the last one read.
+// A broken file is reported once, not on every check
+func (s *Store) reloadIfChanged() (*ReloadResult, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, false, nil // The file may be in the middle of being replaced
+	}
+	checksum := sha256.Sum256(data)
+	if checksum == s.checksum {
+		return nil, false, nil
+	}
+	s.checksum = checksum
+
+	result, err := s.apply(data)
+	return result, true, err
+}
+
+// keepRestartOnly copies restart-only settings of old into next and returns those that differed
+func keepRestartOnly(old, next *Config) []string {
+	var changed []string
+
+	for _, path := range restartOnlyFields {
+		if keepField(reflect.ValueOf(old).Elem(), reflect.ValueOf(next).Elem(), strings.Split(path, ".")) {
+			changed = append(changed, path)
+		}
+	}
+
+	if len(old.Panels) != len(next.Panels) {
+		next.Panels = append([]PanelConfig(nil), old.Panels...)
+		changed = append(changed, "panels")
+	} else {
+		for i := range next.Panels {
+			for _, field := range restartOnlyPanelFields {
+				if keepField(reflect.ValueOf(&old.Panels[i]).Elem(), reflect.ValueOf(&next.Panels[i]).Elem(), []string{field}) {
+					changed = append(changed, fmt.Sprintf("panels[%d].%s", i, field))
+				}
+			}
+		}
+	}
+	next.Panel = next.Panels[0]
+
+	return changed
+}
+
+// keepField copies the field at the yaml path from old to next, reporting whether they differed
+func keepField(old, next reflect.Value, path []string) bool {
+	for _, name := range path {
+		i, ok := fieldByYAML(old.Type(), name)
+		if !ok {
+			return false
+		}
+		old, next = old.Field(i), next.Field(i)
+	}
+
+	if reflect.DeepEqual(old.Interface(), next.Interface()) {
+		return false
+	}
+	next.Set(old)
+	return true
+}
+
+// fieldByYAML returns the index of the struct field with the yaml name
+func fieldByYAML(t reflect.Type, name string) (int, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		if yamlName(t.Field(i)) == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// diffFields returns the yaml paths of the settings that differ between two values
+func diffFields(prefix string, old, next reflect.Value) []string {
+	switch {
+	case old.Kind() == reflect.Struct:
+		var changed []string
+		for i := 0; i < old.NumField(); i++ {
+			name := yamlName(old.Type().Field(i))
+			if name == "" || (prefix == "" && name == "panel") {
+				continue // The panel section mirrors panels[0]
+			}
+			if prefix != "" {
+				name = prefix + "." + name
+			}
+			changed = append(changed, diffFields(name, old.Field(i), next.Field(i))...)
+		}
+		return changed
+	case old.Kind() == reflect.Slice && old.Type().Elem().Kind() == reflect.Struct && old.Len() == next.Len():
+		var changed []string
+		for i := 0; i < old.Len(); i++ {
+			changed = append(changed, diffFields(fmt.Sprintf("%s[%d]", prefix, i), old.Index(i), next.Index(i))...)
+		}
+		return changed
+	}
+
+	if reflect.DeepEqual(old.Interface(), next.Interface()) {
+		return nil
+	}
+	return []string{prefix}
+}
+
+// yamlName returns the yaml key of a struct field, empty for fields not read from yaml
+func yamlName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+	if name == "-" {
+		return ""
+	}
+	return name
+}
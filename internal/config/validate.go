@@ -0,0 +1,285 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Problem is one invalid setting
+type Problem struct {
+	Path    string // yaml path of the setting, e.g. panels[0].url
+	Line    int    // Line of the setting in the file, 0 when it is missing or set by the environment
+	Message string
+}
+
+func (p Problem) String() string {
+	if p.Line > 0 {
+		return fmt.Sprintf("line %d: %s: %s", p.Line, p.Path, p.Message)
+	}
+	return fmt.Sprintf("%s: %s", p.Path, p.Message)
+}
+
+// ValidationError lists every problem found in a config
+type ValidationError struct {
+	Problems []Problem
+}
+
+func (e *ValidationError) Error() string {
+	lines := make([]string, 0, len(e.Problems)+1)
+	lines = append(lines, fmt.Sprintf("invalid config (%d problems):", len(e.Problems)))
+	for _, p := range e.Problems {
+		lines = append(lines, "  "+p.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// validator collects the problems of a config
+type validator struct {
+	lines    map[string]int
+	problems []Problem
+}
+
+// add records a problem, pointing at the line of the setting or of its closest parent in the file
+func (v *validator) add(path, format string, args ...interface{}) {
+	line := 0
+	for p := path; p != "" && line == 0; p = parentPath(p) {
+		line = v.lines[p]
+	}
+	v.problems = append(v.problems, Problem{Path: path, Line: line, Message: fmt.Sprintf(format, args...)})
+}
+
+// nonNegative reports negative numbers
+func (v *validator) nonNegative(path string, n int) {
+	if n < 0 {
+		v.add(path, "must not be negative, got %d", n)
+	}
+}
+
+// positive reports numbers below 1
+func (v *validator) positive(path string, n int) {
+	if n <= 0 {
+		v.add(path, "must be greater than 0, got %d", n)
+	}
+}
+
+// url reports values that are not absolute URLs with one of the schemes
+func (v *validator) url(path, value string, schemes ...string) {
+	u, err := url.Parse(value)
+	if err != nil {
+		v.add(path, "invalid URL %q: %v", value, err)
+		return
+	}
+	if u.Host == "" || !contains(schemes, u.Scheme) {
+		v.add(path, "invalid URL %q, expected %s://host", value, strings.Join(schemes, "://host or "))
+	}
+}
+
+// validate checks every setting of a config with defaults applied
+func validate(cfg *Config, lines map[string]int, singlePanel bool) error {
+	v := &validator{lines: lines}
+
+	// Telegram
+	if cfg.Telegram.Token == "" {
+		v.add("telegram.token", "is required")
+	}
+	if len(cfg.Telegram.AdminIDs) == 0 {
+		v.add("telegram.admin_ids", "is required")
+	}
+	for i, id := range cfg.Telegram.AdminIDs {
+		if id <= 0 {
+			v.add(fmt.Sprintf("telegram.admin_ids[%d]", i), "invalid Telegram user ID %d", id)
+		}
+	}
+	if cfg.Telegram.Proxy != "" {
+		v.url("telegram.proxy", cfg.Telegram.Proxy, "socks5", "http", "https")
+	}
+	if cfg.Telegram.APIServer != "" {
+		v.url("telegram.api_server", cfg.Telegram.APIServer, "http", "https")
+	}
+
+	// Panels
+	seen := make(map[string]bool)
+	for i, p := range cfg.Panels {
+		key := fmt.Sprintf("panels[%d]", i)
+		if singlePanel {
+			key = "panel"
+		}
+
+		if p.Name == "" {
+			v.add(key+".name", "is required")
+		} else if seen[p.Name] {
+			v.add(key+".name", "%q is duplicated", p.Name)
+		}
+		seen[p.Name] = true
+
+		if p.URL == "" {
+			v.add(key+".url", "is required")
+		} else {
+			v.url(key+".url", p.URL, "http", "https")
+		}
+		if p.Username == "" {
+			v.add(key+".username", "is required")
+		}
+		if p.Password == "" {
+			v.add(key+".password", "is required")
+		}
+
+		v.nonNegative(key+".limit_ip", p.LimitIP)
+		v.nonNegative(key+".traffic_limit_gb", p.TrafficLimitGB)
+		v.nonNegative(key+".traffic_alert_threshold_gb", p.TrafficAlertThresholdGB)
+		if p.TrafficAlertPercent < 1 || p.TrafficAlertPercent > 100 {
+			v.add(key+".traffic_alert_percent", "must be between 1 and 100, got %d", p.TrafficAlertPercent)
+		}
+		v.nonNegative(key+".backup_days", p.BackupDays)
+		v.positive(key+".multi_inbound_sync_hours", p.MultiInboundSyncHours)
+		v.nonNegative(key+".traffic_sync_hours", p.TrafficSyncHours)
+	}
+
+	// Payment
+	v.nonNegative("payment.trial_days", cfg.Payment.TrialDays)
+	v.nonNegative("payment.prices.one_month", cfg.Payment.Prices.OneMonth)
+	v.nonNegative("payment.prices.three_month", cfg.Payment.Prices.ThreeMonth)
+	v.nonNegative("payment.prices.six_month", cfg.Payment.Prices.SixMonth)
+	v.nonNegative("payment.prices.one_year", cfg.Payment.Prices.OneYear)
+	if c := cfg.Payment.Currency; len(c) != 3 || strings.ToUpper(c) != c {
+		v.add("payment.currency", "must be a 3-letter ISO 4217 code such as RUB or USD, got %q", c)
+	}
+
+	// Instructions
+	for path, value := range map[string]string{
+		"instructions.ios":     cfg.Instructions.IOS,
+		"instructions.macos":   cfg.Instructions.MacOS,
+		"instructions.android": cfg.Instructions.Android,
+		"instructions.windows": cfg.Instructions.Windows,
+	} {
+		if value != "" {
+			v.url(path, value, "http", "https")
+		}
+	}
+
+	// Rate limit
+	r := cfg.RateLimit
+	v.positive("rate_limit.max_requests_per_minute", r.MaxRequestsPerMinute)
+	v.positive("rate_limit.window_seconds", r.WindowSeconds)
+	v.positive("rate_limit.burst", r.Burst)
+	v.positive("rate_limit.penalty_threshold", r.PenaltyThreshold)
+	v.positive("rate_limit.penalty_seconds", r.PenaltySeconds)
+	v.positive("rate_limit.max_penalty_seconds", r.MaxPenaltySeconds)
+	if r.MaxPenaltySeconds > 0 && r.PenaltySeconds > r.MaxPenaltySeconds {
+		v.add("rate_limit.penalty_seconds", "must not exceed max_penalty_seconds (%d), got %d", r.MaxPenaltySeconds, r.PenaltySeconds)
+	}
+	for action, cost := range r.Costs {
+		path := "rate_limit.costs." + action
+		if _, ok := DefaultRateLimitCosts[action]; !ok {
+			v.add(path, "unknown action, expected one of %s", strings.Join(sortedKeys(DefaultRateLimitCosts), ", "))
+			continue
+		}
+		v.positive(path, cost)
+	}
+
+	// Notifications
+	warned := make(map[int]bool)
+	for i, days := range cfg.Notifications.ExpiryWarningDays {
+		path := fmt.Sprintf("notifications.expiry_warning_days[%d]", i)
+		if days <= 0 {
+			v.add(path, "must be greater than 0, got %d", days)
+		} else if warned[days] {
+			v.add(path, "%d is duplicated", days)
+		}
+		warned[days] = true
+	}
+
+	// Referral
+	v.nonNegative("referral.bonus_days", cfg.Referral.BonusDays)
+
+	// Storage
+	switch cfg.Storage.Driver {
+	case StorageSQLite:
+	case StoragePostgres:
+		if cfg.Storage.DSN == "" {
+			v.add("storage.dsn", "is required for the postgres driver")
+		}
+	default:
+		v.add("storage.driver", "unknown driver %q, expected %s or %s", cfg.Storage.Driver, StorageSQLite, StoragePostgres)
+	}
+
+	if len(v.problems) == 0 {
+		return nil
+	}
+
+	// Report in file order, settings missing from the file last
+	sort.SliceStable(v.problems, func(i, j int) bool {
+		li, lj := v.problems[i].Line, v.problems[j].Line
+		if li == 0 || lj == 0 {
+			return li != 0 && lj == 0
+		}
+		return li < lj
+	})
+	return &ValidationError{Problems: v.problems}
+}
+
+// yamlLines maps the yaml paths of a document to their lines: telegram.token, panels[0].url, telegram.admin_ids[1]
+func yamlLines(root *yaml.Node) map[string]int {
+	lines := make(map[string]int)
+
+	var walk func(path string, n *yaml.Node)
+	walk = func(path string, n *yaml.Node) {
+		switch n.Kind {
+		case yaml.DocumentNode:
+			for _, c := range n.Content {
+				walk(path, c)
+			}
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(n.Content); i += 2 {
+				key, value := n.Content[i], n.Content[i+1]
+				p := key.Value
+				if path != "" {
+					p = path + "." + key.Value
+				}
+				lines[p] = key.Line
+				walk(p, value)
+			}
+		case yaml.SequenceNode:
+			for i, c := range n.Content {
+				p := fmt.Sprintf("%s[%d]", path, i)
+				lines[p] = c.Line
+				walk(p, c)
+			}
+		}
+	}
+	walk("", root)
+
+	return lines
+}
+
+// parentPath returns the path one level up: panels[0].url -> panels[0] -> panels
+func parentPath(path string) string {
+	if i := strings.LastIndexAny(path, ".["); i > 0 {
+		return path[:i]
+	}
+	return ""
+}
+
+// contains reports whether s is in list
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedKeys returns the keys of a map in order
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
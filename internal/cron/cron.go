@@ -0,0 +1,170 @@
+// Package cron parses five-field cron specs and finds the times they fire at
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// searchLimit bounds the search for the next run, a spec such as "0 0 30 2 *" never fires
+const searchLimit = 5 * 366 * 24 * time.Hour
+
+// aliases are the shorthand specs accepted in place of five fields
+var aliases = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+	"@yearly":  "0 0 1 1 *",
+}
+
+// Schedule is a parsed spec: minute, hour, day of month, month and day of week
+type Schedule struct {
+	minute, hour, dom, month, dow uint64 // Bit n is set when value n matches
+	domAny, dowAny                bool   // The day field matches every day, see dayMatches
+}
+
+// field describes the values allowed in one position of a spec
+type field struct {
+	name     string
+	min, max int
+}
+
+var fields = []field{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7}, // 0 and 7 are Sunday
+}
+
+// Parse reads a spec of five space separated fields, each a *, a value, a range a-b or a comma
+// separated list of them, optionally with a /step. @hourly, @daily, @weekly, @monthly and @yearly
+// are accepted too
+func Parse(spec string) (*Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if alias, ok := aliases[strings.ToLower(spec)]; ok {
+		spec = alias
+	}
+
+	parts := strings.Fields(spec)
+	if len(parts) != len(fields) {
+		return nil, fmt.Errorf("expected %d fields, got %d", len(fields), len(parts))
+	}
+
+	bits := make([]uint64, len(fields))
+	for i, part := range parts {
+		var err error
+		if bits[i], err = parseField(part, fields[i]); err != nil {
+			return nil, fmt.Errorf("%s: %w", fields[i].name, err)
+		}
+	}
+
+	s := &Schedule{
+		minute: bits[0],
+		hour:   bits[1],
+		dom:    bits[2],
+		month:  bits[3],
+		dow:    bits[4],
+	}
+	// Sunday may be given as 7
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	// Checked on the values rather than the text, so */1 or 0-6 do not restrict the day either
+	const week = 1<<7 - 1
+	s.domAny = s.dom == everyValue(fields[2])
+	s.dowAny = s.dow&week == week
+	return s, nil
+}
+
+// everyValue returns the bitset of a field matching all its values
+func everyValue(f field) uint64 {
+	return (1<<(f.max+1) - 1) &^ (1<<f.min - 1)
+}
+
+// parseField returns the values of one field as a bitset
+func parseField(part string, f field) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(part, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepPart)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step %q", stepPart)
+			}
+			step = n
+		}
+
+		lo, hi := f.min, f.max
+		if rangePart != "*" {
+			from, to, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if lo, err = strconv.Atoi(from); err != nil {
+				return 0, fmt.Errorf("invalid value %q", from)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(to); err != nil {
+					return 0, fmt.Errorf("invalid value %q", to)
+				}
+			} else if hasStep {
+				// 5/15 means from 5 to the end in steps of 15
+				hi = f.max
+			}
+		}
+		if lo < f.min || hi > f.max || lo > hi {
+			return 0, fmt.Errorf("%q is outside %d-%d", item, f.min, f.max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+// Next returns the first time after t the schedule fires at, in the location of t.
+// The zero time is returned when it never fires
+func (s *Schedule) Next(t time.Time) time.Time {
+	next := t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(searchLimit)
+
+	for next.Before(limit) {
+		switch {
+		case s.month&(1<<uint(next.Month())) == 0:
+			next = time.Date(next.Year(), next.Month()+1, 1, 0, 0, 0, 0, next.Location())
+		case !s.dayMatches(next):
+			next = time.Date(next.Year(), next.Month(), next.Day()+1, 0, 0, 0, 0, next.Location())
+		case s.hour&(1<<uint(next.Hour())) == 0, s.hour != everyValue(fields[1]) && repeatedHour(next):
+			// When clocks go back, a spec with fixed hours fires in the repeated hour only the first time around.
+			// The step is in elapsed time, time.Date may pick either of two times with the same wall clock
+			next = next.Add(time.Duration(60-next.Minute()) * time.Minute)
+		case s.minute&(1<<uint(next.Minute())) == 0:
+			next = next.Add(time.Minute)
+		default:
+			return next
+		}
+	}
+	return time.Time{}
+}
+
+// repeatedHour reports whether t is in an hour that occurs a second time because clocks went back
+func repeatedHour(t time.Time) bool {
+	earlier := t.Add(-time.Hour)
+	return earlier.Hour() == t.Hour() && earlier.Day() == t.Day()
+}
+
+// dayMatches applies the cron rule for the two day fields: when both are restricted
+// a day matching either of them fires, otherwise the restricted one decides
+func (s *Schedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domAny || s.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
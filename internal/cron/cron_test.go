@@ -0,0 +1,96 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"1-x * * * *",
+		"@every",
+	} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q) returned no error", spec)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("no time zone data: %v", err)
+	}
+	// A Friday
+	from := time.Date(2026, 10, 16, 10, 7, 30, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		{"every minute", "* * * * *", from, time.Date(2026, 10, 16, 10, 8, 0, 0, time.UTC)},
+		{"alias", "@hourly", from, time.Date(2026, 10, 16, 11, 0, 0, 0, time.UTC)},
+		{"alias weekly", "@weekly", from, time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)},
+		{"step", "*/15 * * * *", from, time.Date(2026, 10, 16, 10, 15, 0, 0, time.UTC)},
+		{"step from a value", "5/20 * * * *", from, time.Date(2026, 10, 16, 10, 25, 0, 0, time.UTC)},
+		{"range with step", "0 9-17/2 * * *", from, time.Date(2026, 10, 16, 11, 0, 0, 0, time.UTC)},
+		{"list", "0 0 1,15 * *", from, time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)},
+		{"later today", "0 12 * * 1-5", from, time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)},
+		{"tomorrow", "30 8 * * *", from, time.Date(2026, 10, 17, 8, 30, 0, 0, time.UTC)},
+		{"sunday as 7", "0 12 * * 7", from, time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)},
+		{"exactly on a run", "7 10 * * *", time.Date(2026, 10, 16, 10, 7, 0, 0, time.UTC), time.Date(2026, 10, 17, 10, 7, 0, 0, time.UTC)},
+
+		// Month ends
+		{"31st", "0 0 31 * *", from, time.Date(2026, 10, 31, 0, 0, 0, 0, time.UTC)},
+		{"31st skips short months", "0 0 31 * *", time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, 12, 31, 0, 0, 0, 0, time.UTC)},
+		{"leap day", "0 0 29 2 *", from, time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"never", "0 0 30 2 *", from, time.Time{}},
+		{"year end", "59 23 31 12 *", from, time.Date(2026, 12, 31, 23, 59, 0, 0, time.UTC)},
+
+		// Day of month and day of week
+		{"both restricted fire on either", "0 0 20 * 1", from, time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)},
+		{"day of month only", "0 0 20 * *", from, time.Date(2026, 10, 20, 0, 0, 0, 0, time.UTC)},
+		{"day of week only", "0 0 * * 1", from, time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)},
+		{"*/1 day of month is unrestricted", "0 0 */1 * 1", from, time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)},
+		{"*/1 day of week is unrestricted", "0 0 20 * */1", from, time.Date(2026, 10, 20, 0, 0, 0, 0, time.UTC)},
+		{"full day of month range is unrestricted", "0 0 1-31 * 1", from, time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)},
+		{"full day of week range is unrestricted", "0 0 20 * 0-6", from, time.Date(2026, 10, 20, 0, 0, 0, 0, time.UTC)},
+		{"stepped day of month is restricted", "0 0 */2 * 1", from, time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)},
+
+		// Clocks go forward on 2026-03-29 at 02:00 and back on 2026-10-25 at 03:00 in Berlin
+		{"skipped by clocks going forward", "30 2 * * *", time.Date(2026, 3, 28, 12, 0, 0, 0, berlin), time.Date(2026, 3, 30, 2, 30, 0, 0, berlin)},
+		{"after clocks go forward", "0 3 * * *", time.Date(2026, 3, 29, 0, 0, 0, 0, berlin), time.Date(2026, 3, 29, 3, 0, 0, 0, berlin)},
+		{"first of a repeated time", "30 2 * * *", time.Date(2026, 10, 25, 0, 0, 0, 0, berlin), time.Date(2026, 10, 25, 0, 30, 0, 0, time.UTC)},
+		{"repeated time fires once", "30 2 * * *", time.Date(2026, 10, 25, 0, 30, 0, 0, time.UTC).In(berlin), time.Date(2026, 10, 26, 2, 30, 0, 0, berlin)},
+		{"every hour runs in the repeated hour", "*/30 * * * *", time.Date(2026, 10, 25, 0, 45, 0, 0, time.UTC).In(berlin), time.Date(2026, 10, 25, 1, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Parse(tt.spec)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.spec, err)
+			}
+			got := s.Next(tt.from)
+			if !got.Equal(tt.want) {
+				t.Errorf("Next(%s) of %q = %s, want %s", tt.from, tt.spec, got, tt.want)
+			}
+			if !got.IsZero() && got.Location() != tt.from.Location() {
+				t.Errorf("Next(%s) of %q is in %s, want %s", tt.from, tt.spec, got.Location(), tt.from.Location())
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+// Package i18n renders user-facing messages from per-language catalogs.
+//
+// Catalogs are YAML locale files embedded from locales/, one flat map of message
+// keys to fmt format strings per language.
+package i18n
+
+import (
+	"embed"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultLanguage is used for users whose language has no catalog, every catalog must define its keys
+const DefaultLanguage = "ru"
+
+//go:embed locales/*.yaml
+var localeFiles embed.FS
+
+// Translator renders catalog messages in one language
+type Translator func(key string, args ...interface{}) string
+
+// Localizer returns the translator of a Telegram user
+type Localizer func(userID int64) Translator
+
+// Catalog holds the messages of every supported language
+type Catalog struct {
+	messages map[string]map[string]string // language -> key -> format
+}
+
+// Load reads the embedded locale files and checks them against the default language
+func Load() (*Catalog, error) {
+	entries, err := localeFiles.ReadDir("locales")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read locales: %w", err)
+	}
+
+	c := &Catalog{messages: make(map[string]map[string]string)}
+	for _, entry := range entries {
+		data, err := localeFiles.ReadFile(path.Join("locales", entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read locale %s: %w", entry.Name(), err)
+		}
+
+		messages := make(map[string]string)
+		if err := yaml.Unmarshal(data, &messages); err != nil {
+			return nil, fmt.Errorf("failed to parse locale %s: %w", entry.Name(), err)
+		}
+		c.messages[strings.TrimSuffix(entry.Name(), path.Ext(entry.Name()))] = messages
+	}
+
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// verbPattern matches fmt verbs, "%%" included so that it is not taken for a verb
+var verbPattern = regexp.MustCompile(`%[-+# 0]*[0-9]*(?:\.[0-9]+)?[a-zA-Z%]`)
+
+// validate checks that every language defines the keys of the default language with the same fmt verbs
+func (c *Catalog) validate() error {
+	base, ok := c.messages[DefaultLanguage]
+	if !ok {
+		return fmt.Errorf("locale %s is missing", DefaultLanguage)
+	}
+
+	var problems []string
+	for lang, messages := range c.messages {
+		for key, format := range base {
+			translated, ok := messages[key]
+			if !ok {
+				problems = append(problems, fmt.Sprintf("%s: missing key %s", lang, key))
+				continue
+			}
+			if !sameVerbs(format, translated) {
+				problems = append(problems, fmt.Sprintf("%s: key %s has different format verbs", lang, key))
+			}
+		}
+		for key := range messages {
+			if _, ok := base[key]; !ok {
+				problems = append(problems, fmt.Sprintf("%s: unknown key %s", lang, key))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("invalid locales:\n%s", strings.Join(problems, "\n"))
+	}
+	return nil
+}
+
+// sameVerbs reports whether two formats take the same arguments in the same order
+func sameVerbs(a, b string) bool {
+	verbsA := verbPattern.FindAllString(a, -1)
+	verbsB := verbPattern.FindAllString(b, -1)
+	if len(verbsA) != len(verbsB) {
+		return false
+	}
+	for i := range verbsA {
+		if verbsA[i] != verbsB[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Languages returns the supported languages, sorted
+func (c *Catalog) Languages() []string {
+	languages := make([]string, 0, len(c.messages))
+	for lang := range c.messages {
+		languages = append(languages, lang)
+	}
+	sort.Strings(languages)
+	return languages
+}
+
+// Supports reports whether there is a catalog for the language
+func (c *Catalog) Supports(lang string) bool {
+	_, ok := c.messages[lang]
+	return ok
+}
+
+// Normalize maps a Telegram language_code such as "en-US" to a supported language, DefaultLanguage otherwise
+func (c *Catalog) Normalize(languageCode string) string {
+	lang := strings.ToLower(languageCode)
+	if i := strings.IndexAny(lang, "-_"); i >= 0 {
+		lang = lang[:i]
+	}
+	if c.Supports(lang) {
+		return lang
+	}
+	return DefaultLanguage
+}
+
+// T renders the message of key in the language, falling back to the default language and then to the key itself
+func (c *Catalog) T(lang, key string, args ...interface{}) string {
+	format, ok := c.messages[lang][key]
+	if !ok {
+		if format, ok = c.messages[DefaultLanguage][key]; !ok {
+			return key
+		}
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// Translator returns the translator of a language
+func (c *Catalog) Translator(lang string) Translator {
+	return func(key string, args ...interface{}) string {
+		return c.T(lang, key, args...)
+	}
+}
+
+// Matches reports whether text contains the message of key in any language.
+// Reply keyboard buttons arrive as plain text in the language the keyboard was built in
+func (c *Catalog) Matches(text, key string) bool {
+	for _, messages := range c.messages {
+		if label, ok := messages[key]; ok && label != "" && strings.Contains(text, label) {
+			return true
+		}
+	}
+	return false
+}
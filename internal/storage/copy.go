@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+)
+
+// dataTables are the tables copied between databases, schema_version is maintained by each database itself
+var dataTables = []string{
+	"user_states",
+	"registration_requests",
+	"admin_message_states",
+	"user_message_states",
+	"broadcast_states",
+	"traffic_snapshots",
+	"subscription_expiry",
+	"traffic_sync_state",
+	"users",
+	"payments",
+	"extension_requests",
+	"receipts",
+	"promo_codes",
+	"promo_redemptions",
+	"referrals",
+	"audit_log",
+	"client_refs",
+	"rate_limit_penalties",
+	"broadcast_jobs",
+	"broadcast_recipients",
+	"broadcast_schedules",
+}
+
+// CopyData copies every row of src into dst in one transaction, keeping ids so client refs in sent
+// buttons and receipt links stay valid. Both databases must be at the same schema version and every
+// table of dst must be empty. progress is called after each table with the number of copied rows
+func CopyData(dst, src *SQLStorage, progress func(table string, rows int)) error {
+	if dst.SchemaVersion() != src.SchemaVersion() {
+		return fmt.Errorf("schema versions differ: %s %d, %s %d",
+			src.Driver(), src.SchemaVersion(), dst.Driver(), dst.SchemaVersion())
+	}
+
+	for _, table := range dataTables {
+		var count int
+		if err := dst.db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&count); err != nil {
+			return fmt.Errorf("failed to count rows of %s: %w", table, err)
+		}
+		if count > 0 {
+			return fmt.Errorf("destination table %s is not empty (%d rows)", table, count)
+		}
+	}
+
+	tx, err := dst.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	for _, table := range dataTables {
+		rows, err := src.db.Query("SELECT * FROM " + table)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", table, err)
+		}
+		columns, err := rows.Columns()
+		if err != nil {
+			_ = rows.Close()
+			return err
+		}
+
+		insert := dst.rebind(fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+			table, strings.Join(columns, ", "), strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")))
+
+		copied := 0
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		for rows.Next() {
+			if err := rows.Scan(pointers...); err != nil {
+				_ = rows.Close()
+				return fmt.Errorf("failed to read %s: %w", table, err)
+			}
+			if _, err := tx.Exec(insert, values...); err != nil {
+				_ = rows.Close()
+				return fmt.Errorf("failed to copy row %d of %s: %w", copied+1, table, err)
+			}
+			copied++
+		}
+		if err := rows.Err(); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		_ = rows.Close()
+
+		if dst.dialect.resetSequence != "" && contains(columns, "id") {
+			if _, err := tx.Exec(fmt.Sprintf(dst.dialect.resetSequence, table)); err != nil {
+				return fmt.Errorf("failed to reset the id sequence of %s: %w", table, err)
+			}
+		}
+
+		if progress != nil {
+			progress(table, copied)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// contains reports whether s is in list
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"errors"
 	"time"
 )
 
@@ -12,6 +13,10 @@ type RegistrationRequest struct {
 	Email      string
 	Duration   int
 	Status     string
+	Language   string // Telegram language_code of the user
+	PromoCode  string // Promo code applied to the request, empty if none
+	Price      int    // Price after the promo code discount
+	BonusDays  int    // Extra days granted by the promo code
 	Timestamp  time.Time
 }
 
@@ -34,13 +39,15 @@ type UserMessageState struct {
 
 // BroadcastState represents state for admin creating broadcast
 type BroadcastState struct {
-	Message   string
+	BroadcastContent
+	Cron      string // Repeat the broadcast on this cron spec, empty to send it once
 	Timestamp time.Time
 }
 
 // TrafficSnapshot - snapshot of server traffic at a given time
 type TrafficSnapshot struct {
 	ID            int64
+	Panel         string // Name of the panel the inbound belongs to
 	InboundID     int
 	Timestamp     time.Time
 	UploadBytes   int64
@@ -56,6 +63,282 @@ type ExpiringSubscription struct {
 	NotifiedDays string // Comma-separated list of days already notified
 }
 
+// User statuses mirrored from the panel
+const (
+	UserStatusActive   = "active"
+	UserStatusDisabled = "disabled"
+)
+
+// User is the local record of a registered bot user and their panel clients
+type User struct {
+	TgID         int64
+	Email        string // Base email without the __remark inbound suffix
+	SubID        string
+	Panel        string // Name of the panel the user's clients live on
+	InboundIDs   []int  // Inbounds the user has a client in
+	Language     string
+	Status       string
+	Trial        bool   // Registered with the trial plan and has not paid since, set with SetUserTrial
+	TgUsername   string // Telegram username without @, set with SetUserTgUsername when the user writes to the bot
+	RegisteredAt time.Time
+	UpdatedAt    time.Time
+}
+
+// Payment statuses
+const (
+	PaymentStatusPaid    = "paid"    // Charged, subscription not extended yet
+	PaymentStatusApplied = "applied" // Subscription extended
+	PaymentStatusFailed  = "failed"  // Charged but the extension failed, needs an admin
+)
+
+// ErrPaymentExists is returned by AddPayment for a charge that is already in the ledger
+var ErrPaymentExists = errors.New("payment already recorded")
+
+// Payment is a ledger entry of a Telegram Payments charge
+type Payment struct {
+	ID               int64
+	UserID           int64
+	TelegramChargeID string
+	ProviderChargeID string
+	Payload          string
+	Duration         int
+	Amount           int // In the smallest units of the currency
+	Currency         string
+	Status           string
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+}
+
+// Extension request statuses
+const (
+	ExtensionStatusAwaitingReceipt = "awaiting_receipt" // Waiting for the user to attach a receipt
+	ExtensionStatusPending         = "pending"          // Sent to admins
+	ExtensionStatusApproved        = "approved"
+	ExtensionStatusRejected        = "rejected"
+	ExtensionStatusCancelled       = "cancelled" // Replaced by a newer request of the same user
+)
+
+// ExtensionRequest is a manually paid subscription extension and the admin decision on it
+type ExtensionRequest struct {
+	ID        int64
+	UserID    int64
+	Duration  int
+	Price     int    // Price after the promo code discount
+	PromoCode string // Promo code applied to the request, empty if none
+	BonusDays int    // Extra days granted by the promo code
+	Status    string
+	DecidedBy int64 // Admin who approved or rejected the request, 0 while undecided
+	CreatedAt time.Time
+	DecidedAt time.Time // Zero while undecided
+}
+
+// Receipt types
+const (
+	ReceiptTypePhoto    = "photo"
+	ReceiptTypeDocument = "document"
+)
+
+// Receipt is a payment receipt attached to an extension request
+type Receipt struct {
+	ID        int64
+	RequestID int64
+	UserID    int64
+	FileID    string // Telegram file ID
+	FileType  string
+	FileName  string
+	MimeType  string
+	CreatedAt time.Time
+}
+
+// Promo code types
+const (
+	PromoTypePercent = "percent" // Value is a percentage off the price
+	PromoTypeFixed   = "fixed"   // Value is an amount off the price
+	PromoTypeDays    = "days"    // Value is a number of bonus days
+)
+
+// PromoCode is a discount code created by an admin
+type PromoCode struct {
+	Code         string // Upper-case letters and digits
+	Type         string
+	Value        int
+	MaxUses      int // Total redemptions allowed, 0 for unlimited
+	PerUserLimit int // Redemptions allowed per user, 0 for unlimited
+	Uses         int
+	ExpiresAt    time.Time // Zero for codes that never expire
+	CreatedBy    int64
+	CreatedAt    time.Time
+}
+
+// Promo redemption purposes
+const (
+	PromoPurposeRegistration = "registration"
+	PromoPurposeExtension    = "extension"
+)
+
+// PromoRedemption records a promo code applied to a paid period
+type PromoRedemption struct {
+	ID        int64
+	Code      string
+	UserID    int64
+	Purpose   string
+	Price     int // Price paid after the discount
+	BonusDays int
+	CreatedAt time.Time
+}
+
+// Referral links a user to the user whose referral link they started the bot with
+type Referral struct {
+	ReferredID int64
+	ReferrerID int64
+	BonusDays  int // Days credited to the referrer, 0 until rewarded
+	CreatedAt  time.Time
+	RewardedAt time.Time // Zero until the referred user's first paid subscription
+}
+
+// ReferralStats summarizes the referrals of one user
+type ReferralStats struct {
+	Invited   int // Users who started the bot with the referral link
+	Rewarded  int // Invited users who paid for a subscription
+	BonusDays int // Total days credited to the referrer
+}
+
+// Audit actions
+const (
+	AuditActionBlock               = "block"
+	AuditActionUnblock             = "unblock"
+	AuditActionDelete              = "delete"
+	AuditActionExtend              = "extend"
+	AuditActionRejectExtension     = "reject_extension"
+	AuditActionApproveRegistration = "approve_registration"
+	AuditActionRejectRegistration  = "reject_registration"
+	AuditActionRename              = "rename"
+	AuditActionMessage             = "message"
+	AuditActionPromoAdd            = "promo_add"
+	AuditActionPromoDelete         = "promo_delete"
+)
+
+// ClientRef is a short stable ID of a panel client used in admin callbacks instead of its position in an inbound.
+// A ref is bound to the Telegram ID the client had when it was listed, so a client re-created under the
+// same email for another user gets a new ref and old buttons no longer match it
+type ClientRef struct {
+	ID        int64
+	Panel     string
+	Email     string // Email of the listed client, with the inbound suffix
+	TgID      int64  // 0 for clients without a Telegram ID
+	CreatedAt time.Time
+}
+
+// RateLimitPenalty is the abuse record of a user, kept in storage so a restart does not lift a penalty.
+// Every PenaltyThreshold refused requests add a strike, and each strike blocks the user twice as long as the previous one
+type RateLimitPenalty struct {
+	TgID         int64
+	Violations   int // Refused requests since the last strike
+	Strikes      int
+	BlockedUntil time.Time // Zero when the user is not blocked
+	UpdatedAt    time.Time
+}
+
+// AuditEntry records one action taken on a client. Entries are append-only
+type AuditEntry struct {
+	ID          int64
+	ActorID     int64 // Telegram ID of the admin (or the user renaming themselves)
+	Action      string
+	TargetEmail string // Client email without inbound suffix, empty if unknown
+	TargetTgID  int64  // Client Telegram ID, 0 if unknown
+	Before      string // Value before the action, empty if not applicable
+	After       string // Value after the action, empty if not applicable
+	CreatedAt   time.Time
+}
+
+// AuditFilter selects audit entries, zero fields match everything
+type AuditFilter struct {
+	ActorID int64
+	Action  string
+	Target  string // Substring of the target email, or the exact target Telegram ID
+	Limit   int    // 0 for no limit
+	Offset  int
+}
+
+// Broadcast job statuses
+const (
+	BroadcastStatusQueued    = "queued"  // Waiting for the worker
+	BroadcastStatusRunning   = "running" // Being sent, resumed by the worker after a restart
+	BroadcastStatusPaused    = "paused"
+	BroadcastStatusCancelled = "cancelled"
+	BroadcastStatusDone      = "done" // Every recipient was sent to or failed
+)
+
+// Broadcast recipient statuses
+const (
+	RecipientStatusPending = "pending"
+	RecipientStatusSent    = "sent"
+	RecipientStatusFailed  = "failed"
+)
+
+// Broadcast media types
+const (
+	BroadcastMediaPhoto    = "photo"
+	BroadcastMediaVideo    = "video"
+	BroadcastMediaDocument = "document"
+)
+
+// BroadcastMedia is a file of a broadcast, sent again by the file ID Telegram gave the admin's upload
+type BroadcastMedia struct {
+	Type   string `json:"type"`
+	FileID string `json:"file_id"`
+}
+
+// BroadcastButton is an inline URL button under a broadcast
+type BroadcastButton struct {
+	Text string `json:"text"`
+	URL  string `json:"url"`
+}
+
+// BroadcastContent is what a broadcast sends: an HTML text, or media with the text as caption.
+// Several media are sent as an album, albums cannot carry buttons so they follow in a message of their own
+type BroadcastContent struct {
+	Message string // HTML text of the announcement
+	Media   []BroadcastMedia
+	Buttons []BroadcastButton // One button per row
+	Pin     bool              // Pin the announcement in the chat of each recipient
+	SendAt  time.Time         // Zero sends right away
+}
+
+// BroadcastJob is an announcement queued for delivery to a fixed set of users
+type BroadcastJob struct {
+	ID        int64
+	CreatedBy int64 // Admin who confirmed the broadcast
+	BroadcastContent
+	Status     string
+	ChatID     int64 // Chat of the progress message edited as recipients are processed
+	MessageID  int
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	FinishedAt time.Time // Zero until the job is done or cancelled
+}
+
+// BroadcastSchedule is a broadcast sent later, once or repeatedly on a cron spec. Each run collects the
+// recipients of its audience at that time and queues a broadcast job. SendAt of the content is the next run
+type BroadcastSchedule struct {
+	ID        int64
+	CreatedBy int64 // Admin who scheduled the broadcast, the progress of each run is reported to them
+	BroadcastContent
+	Audience  string    // Audience code chosen in the bot
+	Cron      string    // Five-field cron spec, empty for a one-off broadcast
+	LastRunAt time.Time // Zero until the first run
+	Revision  int       // Increased by every change, a claim made with an older one fails
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// BroadcastProgress counts the recipients of a broadcast job by delivery status
+type BroadcastProgress struct {
+	Pending int
+	Sent    int
+	Failed  int
+}
+
 // Storage defines the interface for state persistence
 type Storage interface {
 	// User states
@@ -86,8 +369,8 @@ type Storage interface {
 
 	// Traffic snapshots
 	SaveTrafficSnapshot(snapshot *TrafficSnapshot) error
-	GetTrafficSnapshots(inboundID int, startTime, endTime time.Time) ([]*TrafficSnapshot, error)
-	GetLatestTrafficSnapshot(inboundID int) (*TrafficSnapshot, error)
+	GetTrafficSnapshots(panel string, inboundID int, startTime, endTime time.Time) ([]*TrafficSnapshot, error)
+	GetLatestTrafficSnapshot(panel string, inboundID int) (*TrafficSnapshot, error)
 	DeleteOldTrafficSnapshots(beforeTime time.Time) error
 
 	// Subscription expiry tracking
@@ -103,6 +386,81 @@ type Storage interface {
 	DeleteTrafficSyncStateForEmail(email string) error
 	UpdateTrafficSyncStateEmail(oldEmail, newEmail string) error
 
+	// Users
+	UpsertUser(user *User) error
+	GetUser(tgID int64) (*User, error)
+	GetAllUsers() ([]*User, error)
+	DeleteUser(tgID int64) error
+	SetUserLanguage(tgID int64, language string) error
+	SetUserTrial(tgID int64, trial bool) error
+	SetUserTgUsername(tgID int64, username string) error
+	CleanupOrphanedUsers(activeTgIDs map[int64]bool) error
+
+	// Payments ledger
+	AddPayment(payment *Payment) error
+	GetPaymentByChargeID(telegramChargeID string) (*Payment, error)
+	SetPaymentStatus(id int64, status string) error
+
+	// Extension requests and receipts
+	CreateExtensionRequest(req *ExtensionRequest) error
+	GetExtensionRequest(id int64) (*ExtensionRequest, error)
+	GetOpenExtensionRequest(userID int64) (*ExtensionRequest, error)
+	SetExtensionRequestStatus(id int64, status string) error
+	DecideExtensionRequest(id int64, status string, decidedBy int64) (bool, error)
+	AddReceipt(receipt *Receipt) error
+	GetReceipts(requestID int64) ([]*Receipt, error)
+
+	// Promo codes
+	CreatePromoCode(promo *PromoCode) error
+	GetPromoCode(code string) (*PromoCode, error)
+	GetAllPromoCodes() ([]*PromoCode, error)
+	DeletePromoCode(code string) error
+	CountPromoRedemptions(code string, userID int64) (int, error)
+	RedeemPromoCode(redemption *PromoRedemption) error
+
+	// Referrals
+	AddReferral(referral *Referral) error
+	GetReferral(referredID int64) (*Referral, error)
+	MarkReferralRewarded(referredID int64, bonusDays int) (bool, error)
+	GetReferralStats(referrerID int64) (*ReferralStats, error)
+
+	// Audit log
+	AddAuditEntry(entry *AuditEntry) error
+	GetAuditEntries(filter AuditFilter) ([]*AuditEntry, error)
+	CountAuditEntries(filter AuditFilter) (int, error)
+
+	// Client refs
+	GetOrCreateClientRef(panel, email string, tgID int64) (*ClientRef, error)
+	GetClientRef(id int64) (*ClientRef, error)
+
+	// Rate limit penalties
+	GetRateLimitPenalty(tgID int64) (*RateLimitPenalty, error) // Empty record for users without one
+	SaveRateLimitPenalty(penalty *RateLimitPenalty) error
+	CleanupRateLimitPenalties(maxAge time.Duration) error
+
+	// Broadcast jobs
+	CreateBroadcastJob(job *BroadcastJob, recipients []int64) error
+	GetBroadcastJob(id int64) (*BroadcastJob, error)
+	GetActiveBroadcastJobs() ([]*BroadcastJob, error) // Queued, running and paused jobs, oldest first
+	SetBroadcastJobStatus(id int64, status string) error
+	GetPendingBroadcastRecipients(jobID int64, limit int) ([]int64, error)
+	SetBroadcastRecipientStatus(jobID, tgID int64, status, lastError string) error
+	GetBroadcastProgress(jobID int64) (*BroadcastProgress, error)
+	RetryFailedBroadcastRecipients(jobID int64) (int, error)
+
+	// Broadcast schedules
+	CreateBroadcastSchedule(schedule *BroadcastSchedule) error
+	GetBroadcastSchedule(id int64) (*BroadcastSchedule, error)
+	GetBroadcastSchedules() ([]*BroadcastSchedule, error) // Next run first
+	// UpdateBroadcastSchedule fails when the schedule is gone or changed since it was read, so an edit
+	// cannot move a schedule back to a run it already made
+	UpdateBroadcastSchedule(schedule *BroadcastSchedule) error
+	// ClaimBroadcastSchedule records a run: the schedule moves to next, or is deleted when next is zero.
+	// The revision of schedule is updated, so it can be claimed or updated again afterwards.
+	// false is returned when the schedule changed since it was read, e.g. claimed by another replica
+	ClaimBroadcastSchedule(schedule *BroadcastSchedule, next time.Time) (bool, error)
+	DeleteBroadcastSchedule(id int64) error
+
 	// Cleanup
 	CleanupExpiredStates(maxAge time.Duration) error
 
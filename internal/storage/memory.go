@@ -0,0 +1,1232 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStorage implements Storage in memory with the same behaviour as the SQL backends.
+// It is meant for tests and keeps nothing across restarts
+type MemoryStorage struct {
+	mu sync.Mutex
+
+	userStates           map[int64]string
+	registrationRequests map[int64]RegistrationRequest
+	adminMessageStates   map[int64]AdminMessageState
+	userMessageStates    map[int64]UserMessageState
+	broadcastStates      map[int64]BroadcastState
+	trafficSnapshots     []TrafficSnapshot
+	subscriptionExpiry   map[string]ExpiringSubscription
+	trafficSyncState     map[trafficSyncKey][2]int64 // up, down
+	users                map[int64]User
+	payments             []Payment
+	extensionRequests    []ExtensionRequest
+	receipts             []Receipt
+	promoCodes           map[string]PromoCode
+	promoRedemptions     []PromoRedemption
+	referrals            map[int64]Referral
+	auditLog             []AuditEntry
+	clientRefs           []ClientRef
+	penalties            map[int64]RateLimitPenalty
+	broadcastJobs        []BroadcastJob
+	broadcastRecipients  map[int64][]broadcastRecipient // Per job, ordered by Telegram ID
+	broadcastSchedules   []BroadcastSchedule
+
+	lastID map[string]int64 // Last id given out per table
+}
+
+// trafficSyncKey identifies a traffic sync record
+type trafficSyncKey struct {
+	email     string
+	inboundID int
+}
+
+// broadcastRecipient is the delivery state of one recipient of a broadcast job
+type broadcastRecipient struct {
+	tgID      int64
+	status    string
+	attempts  int
+	lastError string
+}
+
+// NewMemoryStorage creates an empty in-memory storage
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		userStates:           make(map[int64]string),
+		registrationRequests: make(map[int64]RegistrationRequest),
+		adminMessageStates:   make(map[int64]AdminMessageState),
+		userMessageStates:    make(map[int64]UserMessageState),
+		broadcastStates:      make(map[int64]BroadcastState),
+		subscriptionExpiry:   make(map[string]ExpiringSubscription),
+		trafficSyncState:     make(map[trafficSyncKey][2]int64),
+		users:                make(map[int64]User),
+		promoCodes:           make(map[string]PromoCode),
+		referrals:            make(map[int64]Referral),
+		penalties:            make(map[int64]RateLimitPenalty),
+		broadcastRecipients:  make(map[int64][]broadcastRecipient),
+		lastID:               make(map[string]int64),
+	}
+}
+
+// nextID returns the next id of a table, m.mu must be held
+func (m *MemoryStorage) nextID(table string) int64 {
+	m.lastID[table]++
+	return m.lastID[table]
+}
+
+// User states
+func (m *MemoryStorage) SetUserState(userID int64, state string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.userStates[userID] = state
+	return nil
+}
+
+func (m *MemoryStorage) GetUserState(userID int64) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	state, ok := m.userStates[userID]
+	if !ok {
+		return "", fmt.Errorf("state not found for user %d", userID)
+	}
+	return state, nil
+}
+
+func (m *MemoryStorage) DeleteUserState(userID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.userStates, userID)
+	return nil
+}
+
+// Registration requests
+func (m *MemoryStorage) SetRegistrationRequest(userID int64, req *RegistrationRequest) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored := *req
+	stored.UserID = userID
+	m.registrationRequests[userID] = stored
+	return nil
+}
+
+func (m *MemoryStorage) GetRegistrationRequest(userID int64) (*RegistrationRequest, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	req, ok := m.registrationRequests[userID]
+	if !ok {
+		return nil, fmt.Errorf("registration request not found for user %d", userID)
+	}
+	return &req, nil
+}
+
+func (m *MemoryStorage) DeleteRegistrationRequest(userID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.registrationRequests, userID)
+	return nil
+}
+
+func (m *MemoryStorage) GetAllRegistrationRequests() (map[int64]*RegistrationRequest, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result := make(map[int64]*RegistrationRequest, len(m.registrationRequests))
+	for userID, req := range m.registrationRequests {
+		req := req
+		result[userID] = &req
+	}
+	return result, nil
+}
+
+// Admin message states
+func (m *MemoryStorage) SetAdminMessageState(adminID int64, state *AdminMessageState) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.adminMessageStates[adminID] = *state
+	return nil
+}
+
+func (m *MemoryStorage) GetAdminMessageState(adminID int64) (*AdminMessageState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	state, ok := m.adminMessageStates[adminID]
+	if !ok {
+		return nil, fmt.Errorf("admin message state not found for admin %d", adminID)
+	}
+	return &state, nil
+}
+
+func (m *MemoryStorage) DeleteAdminMessageState(adminID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.adminMessageStates, adminID)
+	return nil
+}
+
+// User message states
+func (m *MemoryStorage) SetUserMessageState(userID int64, state *UserMessageState) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored := *state
+	stored.UserID = userID
+	m.userMessageStates[userID] = stored
+	return nil
+}
+
+func (m *MemoryStorage) GetUserMessageState(userID int64) (*UserMessageState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	state, ok := m.userMessageStates[userID]
+	if !ok {
+		return nil, fmt.Errorf("user message state not found for user %d", userID)
+	}
+	return &state, nil
+}
+
+func (m *MemoryStorage) DeleteUserMessageState(userID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.userMessageStates, userID)
+	return nil
+}
+
+// Broadcast states
+func (m *MemoryStorage) SetBroadcastState(adminID int64, state *BroadcastState) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored := *state
+	stored.BroadcastContent = cloneBroadcastContent(state.BroadcastContent)
+	m.broadcastStates[adminID] = stored
+	return nil
+}
+
+func (m *MemoryStorage) GetBroadcastState(adminID int64) (*BroadcastState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	state, ok := m.broadcastStates[adminID]
+	if !ok {
+		return nil, fmt.Errorf("broadcast state not found for admin %d", adminID)
+	}
+	state.BroadcastContent = cloneBroadcastContent(state.BroadcastContent)
+	return &state, nil
+}
+
+func (m *MemoryStorage) DeleteBroadcastState(adminID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.broadcastStates, adminID)
+	return nil
+}
+
+// Traffic snapshots
+func (m *MemoryStorage) SaveTrafficSnapshot(snapshot *TrafficSnapshot) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored := *snapshot
+	stored.ID = m.nextID("traffic_snapshots")
+	m.trafficSnapshots = append(m.trafficSnapshots, stored)
+	return nil
+}
+
+func (m *MemoryStorage) GetTrafficSnapshots(panel string, inboundID int, startTime, endTime time.Time) ([]*TrafficSnapshot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var results []*TrafficSnapshot
+	for _, ts := range m.trafficSnapshots {
+		if ts.Panel == panel && ts.InboundID == inboundID && !ts.Timestamp.Before(startTime) && !ts.Timestamp.After(endTime) {
+			ts := ts
+			results = append(results, &ts)
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Timestamp.Before(results[j].Timestamp) })
+	return results, nil
+}
+
+func (m *MemoryStorage) GetLatestTrafficSnapshot(panel string, inboundID int) (*TrafficSnapshot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var latest *TrafficSnapshot
+	for _, ts := range m.trafficSnapshots {
+		if ts.Panel == panel && ts.InboundID == inboundID && (latest == nil || ts.Timestamp.After(latest.Timestamp)) {
+			ts := ts
+			latest = &ts
+		}
+	}
+	if latest == nil {
+		return nil, fmt.Errorf("no traffic snapshots found")
+	}
+	return latest, nil
+}
+
+func (m *MemoryStorage) DeleteOldTrafficSnapshots(beforeTime time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deleteSnapshotsBefore(beforeTime)
+	return nil
+}
+
+// deleteSnapshotsBefore removes snapshots taken before a time, m.mu must be held
+func (m *MemoryStorage) deleteSnapshotsBefore(before time.Time) {
+	kept := m.trafficSnapshots[:0]
+	for _, ts := range m.trafficSnapshots {
+		if !ts.Timestamp.Before(before) {
+			kept = append(kept, ts)
+		}
+	}
+	m.trafficSnapshots = kept
+}
+
+// Subscription expiry tracking
+func (m *MemoryStorage) UpsertSubscriptionExpiry(email string, tgID int64, expiryTime int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sub := ExpiringSubscription{Email: email, TgID: tgID, ExpiryTime: expiryTime}
+	if old, ok := m.subscriptionExpiry[email]; ok && old.ExpiryTime == expiryTime {
+		sub.NotifiedDays = old.NotifiedDays
+	}
+	m.subscriptionExpiry[email] = sub
+	return nil
+}
+
+func (m *MemoryStorage) GetExpiringSubscriptions(daysThreshold int) ([]ExpiringSubscription, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	thresholdTime := now.Add(time.Duration(daysThreshold) * 24 * time.Hour).UnixMilli()
+
+	var results []ExpiringSubscription
+	for _, sub := range m.subscriptionExpiry {
+		if sub.ExpiryTime > 0 && sub.ExpiryTime <= thresholdTime && sub.ExpiryTime > now.UnixMilli() {
+			results = append(results, sub)
+		}
+	}
+	return results, nil
+}
+
+func (m *MemoryStorage) MarkSubscriptionNotified(email string, daysNotified string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sub, ok := m.subscriptionExpiry[email]
+	if !ok {
+		return nil
+	}
+	switch {
+	case sub.NotifiedDays == "":
+		sub.NotifiedDays = daysNotified
+	case !strings.Contains(sub.NotifiedDays, daysNotified):
+		sub.NotifiedDays += "," + daysNotified
+	}
+	m.subscriptionExpiry[email] = sub
+	return nil
+}
+
+func (m *MemoryStorage) DeleteExpiredSubscriptions() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().UnixMilli()
+	for email, sub := range m.subscriptionExpiry {
+		if sub.ExpiryTime > 0 && sub.ExpiryTime < now {
+			delete(m.subscriptionExpiry, email)
+		}
+	}
+	return nil
+}
+
+// Traffic sync state
+func (m *MemoryStorage) GetTrafficSyncState(email string, inboundID int) (int64, int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	state := m.trafficSyncState[trafficSyncKey{email, inboundID}]
+	return state[0], state[1], nil
+}
+
+func (m *MemoryStorage) SetTrafficSyncState(email string, inboundID int, up, down int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.trafficSyncState[trafficSyncKey{email, inboundID}] = [2]int64{up, down}
+	return nil
+}
+
+// CleanupExpiredStates removes states older than maxAge
+func (m *MemoryStorage) CleanupExpiredStates(maxAge time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cutoff := time.Now().Add(-maxAge)
+	for id, req := range m.registrationRequests {
+		if req.Timestamp.Before(cutoff) {
+			delete(m.registrationRequests, id)
+		}
+	}
+	for id, state := range m.adminMessageStates {
+		if state.Timestamp.Before(cutoff) {
+			delete(m.adminMessageStates, id)
+		}
+	}
+	for id, state := range m.userMessageStates {
+		if state.Timestamp.Before(cutoff) {
+			delete(m.userMessageStates, id)
+		}
+	}
+	for id, state := range m.broadcastStates {
+		if state.Timestamp.Before(cutoff) {
+			delete(m.broadcastStates, id)
+		}
+	}
+	m.deleteSnapshotsBefore(cutoff)
+	return nil
+}
+
+// CleanupOrphanedTrafficSyncState removes traffic sync records for emails not in active clients
+func (m *MemoryStorage) CleanupOrphanedTrafficSyncState(activeEmails map[string]bool) error {
+	if len(activeEmails) == 0 {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key := range m.trafficSyncState {
+		if !activeEmails[key.email] {
+			delete(m.trafficSyncState, key)
+		}
+	}
+	return nil
+}
+
+// DeleteTrafficSyncStateForEmail removes all traffic sync records for a specific email
+func (m *MemoryStorage) DeleteTrafficSyncStateForEmail(email string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key := range m.trafficSyncState {
+		if key.email == email {
+			delete(m.trafficSyncState, key)
+		}
+	}
+	return nil
+}
+
+// UpdateTrafficSyncStateEmail updates all traffic sync records from old email to new email
+func (m *MemoryStorage) UpdateTrafficSyncStateEmail(oldEmail, newEmail string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Like the SQL update, a rename clashing with an existing record changes nothing
+	for key := range m.trafficSyncState {
+		if key.email != oldEmail {
+			continue
+		}
+		if _, exists := m.trafficSyncState[trafficSyncKey{newEmail, key.inboundID}]; exists && newEmail != oldEmail {
+			return fmt.Errorf("traffic sync state of %s in inbound %d already exists", newEmail, key.inboundID)
+		}
+	}
+
+	for key, state := range m.trafficSyncState {
+		if key.email == oldEmail {
+			delete(m.trafficSyncState, key)
+			m.trafficSyncState[trafficSyncKey{newEmail, key.inboundID}] = state
+		}
+	}
+	return nil
+}
+
+// Users
+
+// UpsertUser inserts or updates a user record.
+// The registration date is kept from the first insert and an empty language does not overwrite a known one.
+func (m *MemoryStorage) UpsertUser(user *User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored := *user
+	stored.InboundIDs = append([]int(nil), user.InboundIDs...)
+	stored.UpdatedAt = time.Now()
+	stored.Trial = false
+	stored.TgUsername = ""
+	if old, ok := m.users[user.TgID]; ok {
+		stored.RegisteredAt = old.RegisteredAt
+		stored.Trial = old.Trial
+		stored.TgUsername = old.TgUsername
+		if stored.Language == "" {
+			stored.Language = old.Language
+		}
+	} else if stored.RegisteredAt.IsZero() {
+		stored.RegisteredAt = time.Now()
+	}
+	m.users[user.TgID] = stored
+	return nil
+}
+
+func (m *MemoryStorage) GetUser(tgID int64) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user, ok := m.users[tgID]
+	if !ok {
+		return nil, fmt.Errorf("user %d not found", tgID)
+	}
+	return copyUser(user), nil
+}
+
+func (m *MemoryStorage) GetAllUsers() ([]*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var users []*User
+	for _, user := range m.users {
+		users = append(users, copyUser(user))
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].RegisteredAt.Before(users[j].RegisteredAt) })
+	return users, nil
+}
+
+func (m *MemoryStorage) DeleteUser(tgID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.users, tgID)
+	return nil
+}
+
+func (m *MemoryStorage) SetUserLanguage(tgID int64, language string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if user, ok := m.users[tgID]; ok {
+		user.Language = language
+		user.UpdatedAt = time.Now()
+		m.users[tgID] = user
+	}
+	return nil
+}
+
+func (m *MemoryStorage) SetUserTrial(tgID int64, trial bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if user, ok := m.users[tgID]; ok {
+		user.Trial = trial
+		user.UpdatedAt = time.Now()
+		m.users[tgID] = user
+	}
+	return nil
+}
+
+func (m *MemoryStorage) SetUserTgUsername(tgID int64, username string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if user, ok := m.users[tgID]; ok {
+		user.TgUsername = username
+		user.UpdatedAt = time.Now()
+		m.users[tgID] = user
+	}
+	return nil
+}
+
+// CleanupOrphanedUsers removes user records whose Telegram ID no longer has a client on any panel
+func (m *MemoryStorage) CleanupOrphanedUsers(activeTgIDs map[int64]bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for tgID := range m.users {
+		if !activeTgIDs[tgID] {
+			delete(m.users, tgID)
+		}
+	}
+	return nil
+}
+
+// copyUser returns a copy of a user that does not share the inbound list
+func copyUser(user User) *User {
+	user.InboundIDs = append([]int(nil), user.InboundIDs...)
+	return &user
+}
+
+// Payments ledger
+
+// AddPayment records a payment and sets its ID, a charge can only be recorded once
+func (m *MemoryStorage) AddPayment(payment *Payment) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, p := range m.payments {
+		if p.TelegramChargeID == payment.TelegramChargeID {
+			return fmt.Errorf("%w: %s", ErrPaymentExists, payment.TelegramChargeID)
+		}
+	}
+
+	now := time.Now()
+	if payment.CreatedAt.IsZero() {
+		payment.CreatedAt = now
+	}
+	payment.UpdatedAt = now
+	payment.ID = m.nextID("payments")
+	m.payments = append(m.payments, *payment)
+	return nil
+}
+
+func (m *MemoryStorage) GetPaymentByChargeID(telegramChargeID string) (*Payment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, p := range m.payments {
+		if p.TelegramChargeID == telegramChargeID {
+			return &p, nil
+		}
+	}
+	return nil, fmt.Errorf("payment %s not found", telegramChargeID)
+}
+
+func (m *MemoryStorage) SetPaymentStatus(id int64, status string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.payments {
+		if m.payments[i].ID == id {
+			m.payments[i].Status = status
+			m.payments[i].UpdatedAt = time.Now()
+		}
+	}
+	return nil
+}
+
+// Extension requests and receipts
+
+// CreateExtensionRequest stores a new extension request and sets its ID
+func (m *MemoryStorage) CreateExtensionRequest(req *ExtensionRequest) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if req.CreatedAt.IsZero() {
+		req.CreatedAt = time.Now()
+	}
+	req.ID = m.nextID("extension_requests")
+
+	stored := *req
+	stored.DecidedBy = 0
+	stored.DecidedAt = time.Time{}
+	m.extensionRequests = append(m.extensionRequests, stored)
+	return nil
+}
+
+func (m *MemoryStorage) GetExtensionRequest(id int64) (*ExtensionRequest, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, req := range m.extensionRequests {
+		if req.ID == id {
+			return &req, nil
+		}
+	}
+	return nil, fmt.Errorf("extension request %d not found", id)
+}
+
+// GetOpenExtensionRequest returns the latest request of the user that still waits for a receipt
+func (m *MemoryStorage) GetOpenExtensionRequest(userID int64) (*ExtensionRequest, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.extensionRequests) - 1; i >= 0; i-- {
+		req := m.extensionRequests[i]
+		if req.UserID == userID && req.Status == ExtensionStatusAwaitingReceipt {
+			return &req, nil
+		}
+	}
+	return nil, fmt.Errorf("no open extension request for user %d", userID)
+}
+
+// SetExtensionRequestStatus moves a request to an undecided status, clearing a decision recorded before
+func (m *MemoryStorage) SetExtensionRequestStatus(id int64, status string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.extensionRequests {
+		req := &m.extensionRequests[i]
+		if req.ID != id {
+			continue
+		}
+		req.Status = status
+		req.DecidedBy = 0
+		req.DecidedAt = time.Time{}
+	}
+	return nil
+}
+
+// DecideExtensionRequest records the approval or rejection of an open request, it reports false
+// when the request was already decided or is no longer open
+func (m *MemoryStorage) DecideExtensionRequest(id int64, status string, decidedBy int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.extensionRequests {
+		req := &m.extensionRequests[i]
+		if req.ID != id || (req.Status != ExtensionStatusPending && req.Status != ExtensionStatusAwaitingReceipt) {
+			continue
+		}
+		req.Status = status
+		req.DecidedBy = decidedBy
+		req.DecidedAt = time.Now()
+		return true, nil
+	}
+	return false, nil
+}
+
+// AddReceipt stores a receipt and sets its ID
+func (m *MemoryStorage) AddReceipt(receipt *Receipt) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if receipt.CreatedAt.IsZero() {
+		receipt.CreatedAt = time.Now()
+	}
+	receipt.ID = m.nextID("receipts")
+	m.receipts = append(m.receipts, *receipt)
+	return nil
+}
+
+func (m *MemoryStorage) GetReceipts(requestID int64) ([]*Receipt, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var receipts []*Receipt
+	for _, r := range m.receipts {
+		if r.RequestID == requestID {
+			r := r
+			receipts = append(receipts, &r)
+		}
+	}
+	return receipts, nil
+}
+
+// Promo codes
+
+// CreatePromoCode stores a new promo code, codes are unique
+func (m *MemoryStorage) CreatePromoCode(promo *PromoCode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.promoCodes[promo.Code]; exists {
+		return fmt.Errorf("promo code %s already exists", promo.Code)
+	}
+	if promo.CreatedAt.IsZero() {
+		promo.CreatedAt = time.Now()
+	}
+	m.promoCodes[promo.Code] = *promo
+	return nil
+}
+
+func (m *MemoryStorage) GetPromoCode(code string) (*PromoCode, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	promo, ok := m.promoCodes[code]
+	if !ok {
+		return nil, fmt.Errorf("promo code %s not found", code)
+	}
+	return &promo, nil
+}
+
+func (m *MemoryStorage) GetAllPromoCodes() ([]*PromoCode, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var promos []*PromoCode
+	for _, promo := range m.promoCodes {
+		promo := promo
+		promos = append(promos, &promo)
+	}
+	sort.Slice(promos, func(i, j int) bool { return promos[i].CreatedAt.After(promos[j].CreatedAt) })
+	return promos, nil
+}
+
+// DeletePromoCode removes a promo code, its redemptions are kept
+func (m *MemoryStorage) DeletePromoCode(code string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.promoCodes[code]; !ok {
+		return fmt.Errorf("promo code %s not found", code)
+	}
+	delete(m.promoCodes, code)
+	return nil
+}
+
+// CountPromoRedemptions returns how many times the user redeemed the code
+func (m *MemoryStorage) CountPromoRedemptions(code string, userID int64) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.countRedemptions(code, userID), nil
+}
+
+// countRedemptions counts the redemptions of a code by a user, m.mu must be held
+func (m *MemoryStorage) countRedemptions(code string, userID int64) int {
+	count := 0
+	for _, r := range m.promoRedemptions {
+		if r.Code == code && r.UserID == userID {
+			count++
+		}
+	}
+	return count
+}
+
+// RedeemPromoCode records a redemption and counts it against the usage cap and the per-user limit
+func (m *MemoryStorage) RedeemPromoCode(redemption *PromoRedemption) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if redemption.CreatedAt.IsZero() {
+		redemption.CreatedAt = time.Now()
+	}
+
+	promo, ok := m.promoCodes[redemption.Code]
+	if !ok {
+		return fmt.Errorf("promo code %s not found", redemption.Code)
+	}
+	if promo.PerUserLimit > 0 && m.countRedemptions(redemption.Code, redemption.UserID) >= promo.PerUserLimit {
+		return fmt.Errorf("promo code %s already used by user %d", redemption.Code, redemption.UserID)
+	}
+	if promo.MaxUses > 0 && promo.Uses >= promo.MaxUses {
+		return fmt.Errorf("promo code %s is used up", redemption.Code)
+	}
+
+	promo.Uses++
+	m.promoCodes[promo.Code] = promo
+	redemption.ID = m.nextID("promo_redemptions")
+	m.promoRedemptions = append(m.promoRedemptions, *redemption)
+	return nil
+}
+
+// Referrals
+
+// AddReferral records who referred a user, the first recorded referrer is kept
+func (m *MemoryStorage) AddReferral(referral *Referral) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if referral.CreatedAt.IsZero() {
+		referral.CreatedAt = time.Now()
+	}
+	if _, exists := m.referrals[referral.ReferredID]; exists {
+		return nil
+	}
+	m.referrals[referral.ReferredID] = Referral{
+		ReferredID: referral.ReferredID,
+		ReferrerID: referral.ReferrerID,
+		CreatedAt:  referral.CreatedAt,
+	}
+	return nil
+}
+
+func (m *MemoryStorage) GetReferral(referredID int64) (*Referral, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.referrals[referredID]
+	if !ok {
+		return nil, fmt.Errorf("referral of user %d not found", referredID)
+	}
+	return &r, nil
+}
+
+// MarkReferralRewarded records the referrer bonus of a referral, it reports false when the bonus was already credited
+func (m *MemoryStorage) MarkReferralRewarded(referredID int64, bonusDays int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.referrals[referredID]
+	if !ok || !r.RewardedAt.IsZero() {
+		return false, nil
+	}
+	r.BonusDays = bonusDays
+	r.RewardedAt = time.Now()
+	m.referrals[referredID] = r
+	return true, nil
+}
+
+func (m *MemoryStorage) GetReferralStats(referrerID int64) (*ReferralStats, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := &ReferralStats{}
+	for _, r := range m.referrals {
+		if r.ReferrerID != referrerID {
+			continue
+		}
+		stats.Invited++
+		if !r.RewardedAt.IsZero() {
+			stats.Rewarded++
+		}
+		stats.BonusDays += r.BonusDays
+	}
+	return stats, nil
+}
+
+// Audit log
+
+// AddAuditEntry appends an entry to the audit log and sets its ID
+func (m *MemoryStorage) AddAuditEntry(entry *AuditEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+	entry.ID = m.nextID("audit_log")
+	m.auditLog = append(m.auditLog, *entry)
+	return nil
+}
+
+// GetAuditEntries returns the entries matching the filter, newest first
+func (m *MemoryStorage) GetAuditEntries(filter AuditFilter) ([]*AuditEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	matched := m.matchAudit(filter)
+	if filter.Limit > 0 {
+		start := min(filter.Offset, len(matched))
+		matched = matched[start:min(start+filter.Limit, len(matched))]
+	}
+	return matched, nil
+}
+
+func (m *MemoryStorage) CountAuditEntries(filter AuditFilter) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.matchAudit(filter)), nil
+}
+
+// matchAudit returns the entries matching the filter newest first, m.mu must be held
+func (m *MemoryStorage) matchAudit(filter AuditFilter) []*AuditEntry {
+	target := strings.ToLower(filter.Target)
+	tgID, tgErr := strconv.ParseInt(filter.Target, 10, 64)
+
+	var entries []*AuditEntry
+	for i := len(m.auditLog) - 1; i >= 0; i-- {
+		e := m.auditLog[i]
+		if filter.ActorID != 0 && e.ActorID != filter.ActorID {
+			continue
+		}
+		if filter.Action != "" && e.Action != filter.Action {
+			continue
+		}
+		if filter.Target != "" {
+			byEmail := strings.Contains(strings.ToLower(e.TargetEmail), target)
+			byTgID := tgErr == nil && e.TargetTgID == tgID
+			if !byEmail && !byTgID {
+				continue
+			}
+		}
+		entries = append(entries, &e)
+	}
+	return entries
+}
+
+// Client refs
+
+// GetOrCreateClientRef returns the ref of a panel client, creating it on first use
+func (m *MemoryStorage) GetOrCreateClientRef(panel, email string, tgID int64) (*ClientRef, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, ref := range m.clientRefs {
+		if ref.Panel == panel && ref.Email == email && ref.TgID == tgID {
+			return &ref, nil
+		}
+	}
+
+	ref := ClientRef{ID: m.nextID("client_refs"), Panel: panel, Email: email, TgID: tgID, CreatedAt: time.Now()}
+	m.clientRefs = append(m.clientRefs, ref)
+	return &ref, nil
+}
+
+func (m *MemoryStorage) GetClientRef(id int64) (*ClientRef, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, ref := range m.clientRefs {
+		if ref.ID == id {
+			return &ref, nil
+		}
+	}
+	return nil, fmt.Errorf("client ref %d not found", id)
+}
+
+// Rate limit penalties
+
+func (m *MemoryStorage) GetRateLimitPenalty(tgID int64) (*RateLimitPenalty, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	penalty, ok := m.penalties[tgID]
+	if !ok {
+		return &RateLimitPenalty{TgID: tgID}, nil
+	}
+	return &penalty, nil
+}
+
+func (m *MemoryStorage) SaveRateLimitPenalty(penalty *RateLimitPenalty) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.penalties[penalty.TgID] = *penalty
+	return nil
+}
+
+// CleanupRateLimitPenalties forgets penalties that ended and were not renewed for maxAge
+func (m *MemoryStorage) CleanupRateLimitPenalties(maxAge time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for tgID, p := range m.penalties {
+		if p.UpdatedAt.Before(now.Add(-maxAge)) && p.BlockedUntil.Before(now) {
+			delete(m.penalties, tgID)
+		}
+	}
+	return nil
+}
+
+// cloneBroadcastContent copies the media and buttons so stored broadcasts share no slices with callers
+func cloneBroadcastContent(content BroadcastContent) BroadcastContent {
+	if content.Media != nil {
+		content.Media = append([]BroadcastMedia(nil), content.Media...)
+	}
+	if content.Buttons != nil {
+		content.Buttons = append([]BroadcastButton(nil), content.Buttons...)
+	}
+	return content
+}
+
+// CreateBroadcastJob stores a job with all its recipients pending and sets its ID
+func (m *MemoryStorage) CreateBroadcastJob(job *BroadcastJob, recipients []int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if job.CreatedAt.IsZero() {
+		job.CreatedAt = time.Now()
+	}
+	job.UpdatedAt = job.CreatedAt
+	job.ID = m.nextID("broadcast_jobs")
+
+	stored := *job
+	stored.BroadcastContent = cloneBroadcastContent(job.BroadcastContent)
+	stored.FinishedAt = time.Time{}
+	m.broadcastJobs = append(m.broadcastJobs, stored)
+
+	seen := make(map[int64]bool, len(recipients))
+	list := make([]broadcastRecipient, 0, len(recipients))
+	for _, tgID := range recipients {
+		if seen[tgID] {
+			continue
+		}
+		seen[tgID] = true
+		list = append(list, broadcastRecipient{tgID: tgID, status: RecipientStatusPending})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].tgID < list[j].tgID })
+	m.broadcastRecipients[job.ID] = list
+	return nil
+}
+
+func (m *MemoryStorage) GetBroadcastJob(id int64) (*BroadcastJob, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, job := range m.broadcastJobs {
+		if job.ID == id {
+			job.BroadcastContent = cloneBroadcastContent(job.BroadcastContent)
+			return &job, nil
+		}
+	}
+	return nil, fmt.Errorf("broadcast job %d not found", id)
+}
+
+func (m *MemoryStorage) GetActiveBroadcastJobs() ([]*BroadcastJob, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var jobs []*BroadcastJob
+	for _, job := range m.broadcastJobs {
+		switch job.Status {
+		case BroadcastStatusQueued, BroadcastStatusRunning, BroadcastStatusPaused:
+			job := job
+			job.BroadcastContent = cloneBroadcastContent(job.BroadcastContent)
+			jobs = append(jobs, &job)
+		}
+	}
+	return jobs, nil
+}
+
+// SetBroadcastJobStatus updates the status, done and cancelled jobs also record when they finished
+func (m *MemoryStorage) SetBroadcastJobStatus(id int64, status string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.broadcastJobs {
+		job := &m.broadcastJobs[i]
+		if job.ID != id {
+			continue
+		}
+		job.Status = status
+		job.UpdatedAt = time.Now()
+		job.FinishedAt = time.Time{}
+		if status == BroadcastStatusDone || status == BroadcastStatusCancelled {
+			job.FinishedAt = job.UpdatedAt
+		}
+	}
+	return nil
+}
+
+// GetPendingBroadcastRecipients returns up to limit recipients of the job that were not sent to yet
+func (m *MemoryStorage) GetPendingBroadcastRecipients(jobID int64, limit int) ([]int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var recipients []int64
+	for _, r := range m.broadcastRecipients[jobID] {
+		if len(recipients) == limit {
+			break
+		}
+		if r.status == RecipientStatusPending {
+			recipients = append(recipients, r.tgID)
+		}
+	}
+	return recipients, nil
+}
+
+// SetBroadcastRecipientStatus records a delivery attempt, lastError is empty for successful ones
+func (m *MemoryStorage) SetBroadcastRecipientStatus(jobID, tgID int64, status, lastError string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := m.broadcastRecipients[jobID]
+	for i := range list {
+		if list[i].tgID == tgID {
+			list[i].status = status
+			list[i].attempts++
+			list[i].lastError = lastError
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStorage) GetBroadcastProgress(jobID int64) (*BroadcastProgress, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	progress := &BroadcastProgress{}
+	for _, r := range m.broadcastRecipients[jobID] {
+		switch r.status {
+		case RecipientStatusPending:
+			progress.Pending++
+		case RecipientStatusSent:
+			progress.Sent++
+		case RecipientStatusFailed:
+			progress.Failed++
+		}
+	}
+	return progress, nil
+}
+
+// RetryFailedBroadcastRecipients makes the failed recipients of the job pending again and returns their number
+func (m *MemoryStorage) RetryFailedBroadcastRecipients(jobID int64) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	list := m.broadcastRecipients[jobID]
+	for i := range list {
+		if list[i].status == RecipientStatusFailed {
+			list[i].status = RecipientStatusPending
+			n++
+		}
+	}
+	return n, nil
+}
+
+// CreateBroadcastSchedule stores a schedule and sets its ID
+func (m *MemoryStorage) CreateBroadcastSchedule(schedule *BroadcastSchedule) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if schedule.CreatedAt.IsZero() {
+		schedule.CreatedAt = now
+	}
+	schedule.UpdatedAt = now
+	schedule.ID = m.nextID("broadcast_schedules")
+
+	stored := *schedule
+	stored.BroadcastContent = cloneBroadcastContent(schedule.BroadcastContent)
+	m.broadcastSchedules = append(m.broadcastSchedules, stored)
+	return nil
+}
+
+func (m *MemoryStorage) GetBroadcastSchedule(id int64) (*BroadcastSchedule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, schedule := range m.broadcastSchedules {
+		if schedule.ID == id {
+			schedule.BroadcastContent = cloneBroadcastContent(schedule.BroadcastContent)
+			return &schedule, nil
+		}
+	}
+	return nil, fmt.Errorf("broadcast schedule %d not found", id)
+}
+
+func (m *MemoryStorage) GetBroadcastSchedules() ([]*BroadcastSchedule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	schedules := make([]*BroadcastSchedule, 0, len(m.broadcastSchedules))
+	for _, schedule := range m.broadcastSchedules {
+		schedule := schedule
+		schedule.BroadcastContent = cloneBroadcastContent(schedule.BroadcastContent)
+		schedules = append(schedules, &schedule)
+	}
+	sort.SliceStable(schedules, func(i, j int) bool { return schedules[i].SendAt.Before(schedules[j].SendAt) })
+	return schedules, nil
+}
+
+// UpdateBroadcastSchedule saves the content, audience, cron spec and next run of a schedule.
+// An error is returned when it is gone or changed since it was read
+func (m *MemoryStorage) UpdateBroadcastSchedule(schedule *BroadcastSchedule) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.broadcastSchedules {
+		stored := &m.broadcastSchedules[i]
+		if stored.ID != schedule.ID || stored.Revision != schedule.Revision {
+			continue
+		}
+		schedule.UpdatedAt = time.Now()
+		schedule.Revision++
+		stored.BroadcastContent = cloneBroadcastContent(schedule.BroadcastContent)
+		stored.Audience = schedule.Audience
+		stored.Cron = schedule.Cron
+		stored.Revision = schedule.Revision
+		stored.UpdatedAt = schedule.UpdatedAt
+		return nil
+	}
+	return fmt.Errorf("broadcast schedule %d not found or changed since it was read", schedule.ID)
+}
+
+// ClaimBroadcastSchedule records a run: the schedule moves to next, or is deleted when next is zero.
+// false is returned when the schedule changed since it was read
+func (m *MemoryStorage) ClaimBroadcastSchedule(schedule *BroadcastSchedule, next time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.broadcastSchedules {
+		stored := &m.broadcastSchedules[i]
+		if stored.ID != schedule.ID || stored.Revision != schedule.Revision {
+			continue
+		}
+		if next.IsZero() {
+			m.broadcastSchedules = append(m.broadcastSchedules[:i], m.broadcastSchedules[i+1:]...)
+			return true, nil
+		}
+		now := time.Now()
+		stored.SendAt = next
+		stored.LastRunAt = now
+		stored.Revision++
+		stored.UpdatedAt = now
+		schedule.Revision = stored.Revision
+		return true, nil
+	}
+	return false, nil
+}
+
+func (m *MemoryStorage) DeleteBroadcastSchedule(id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, schedule := range m.broadcastSchedules {
+		if schedule.ID == id {
+			m.broadcastSchedules = append(m.broadcastSchedules[:i], m.broadcastSchedules[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("broadcast schedule %d not found", id)
+}
+
+// Close releases nothing, the data stays readable until the storage is garbage collected
+func (m *MemoryStorage) Close() error {
+	return nil
+}
@@ -0,0 +1,14 @@
+package storage_test
+
+import (
+	"testing"
+
+	"x-ui-bot/internal/storage"
+	"x-ui-bot/internal/storage/storagetest"
+)
+
+func TestMemoryStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) (storage.Storage, error) {
+		return storage.NewMemoryStorage(), nil
+	})
+}
@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"x-ui-bot/internal/logger"
+)
+
+// ErrSchemaTooNew is returned for a database migrated by a newer version of the bot
+var ErrSchemaTooNew = errors.New("database schema is too new")
+
+// migration is one numbered schema change. A released migration is never edited,
+// schema changes are new migrations appended to the list of every dialect
+type migration struct {
+	version     int
+	description string
+	up          func(tx *sql.Tx) error
+}
+
+// execSchema returns the step of a migration that only runs the statements of schema
+func execSchema(schema string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		_, err := tx.Exec(schema)
+		return err
+	}
+}
+
+// SchemaVersion returns the schema version this build migrates the database to
+func (s *SQLStorage) SchemaVersion() int {
+	return s.dialect.migrations[len(s.dialect.migrations)-1].version
+}
+
+// migrate brings the database to the latest schema version. The database is backed up before
+// the first pending migration when the dialect supports it, and a database with a newer
+// schema is refused so an older build never writes to it
+func (s *SQLStorage) migrate() error {
+	if _, err := s.db.Exec(fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS schema_version (
+		version INTEGER PRIMARY KEY,
+		description TEXT NOT NULL,
+		applied_at %s NOT NULL
+	)`, s.dialect.timestampType)); err != nil {
+		return err
+	}
+
+	current, err := s.schemaVersion(s.db)
+	if err != nil {
+		return err
+	}
+	latest := s.SchemaVersion()
+	if current > latest {
+		return fmt.Errorf("%w: database is at version %d, this build supports up to %d, upgrade the bot or restore the backup taken before the upgrade",
+			ErrSchemaTooNew, current, latest)
+	}
+	if current == latest {
+		return nil
+	}
+
+	if s.dialect.backup != nil {
+		backup, err := s.dialect.backup(s, current)
+		if err != nil {
+			return fmt.Errorf("failed to back up the database before migrating: %w", err)
+		}
+		if backup != "" {
+			logger.Infof("Database backed up to %s before migrating from schema version %d to %d", backup, current, latest)
+		}
+	}
+
+	for _, m := range s.dialect.migrations {
+		if m.version <= current {
+			continue
+		}
+		applied, err := s.applyMigration(m)
+		if err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", m.version, m.description, err)
+		}
+		if applied {
+			logger.Infof("Applied %s migration %d: %s", s.dialect.name, m.version, m.description)
+		}
+	}
+	return nil
+}
+
+// queryRower is implemented by *sql.DB and *sql.Tx
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// schemaVersion returns the version of the last applied migration, 0 for a new database
+// or one created before schema versioning
+func (s *SQLStorage) schemaVersion(q queryRower) (int, error) {
+	var version int
+	err := q.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&version)
+	return version, err
+}
+
+// applyMigration runs a migration and records its version in one transaction. It reports false
+// when another replica applied the migration first
+func (s *SQLStorage) applyMigration(m migration) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if s.dialect.lock != "" {
+		if _, err := tx.Exec(s.dialect.lock); err != nil {
+			return false, err
+		}
+		current, err := s.schemaVersion(tx)
+		if err != nil {
+			return false, err
+		}
+		if current >= m.version {
+			return false, nil
+		}
+	}
+
+	if err := m.up(tx); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(
+		s.rebind("INSERT INTO schema_version (version, description, applied_at) VALUES (?, ?, ?)"),
+		m.version, m.description, time.Now(),
+	); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
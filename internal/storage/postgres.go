@@ -0,0 +1,322 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// postgresDialect is a PostgreSQL server shared by several bot replicas. Migrations run in transactions,
+// so a failed one leaves the schema untouched, and backups are left to pg_dump
+var postgresDialect = &dialect{
+	name: "postgres",
+	migrations: []migration{
+		{version: 1, description: "initial schema", up: migratePostgresInitialSchema},
+		{version: 2, description: "broadcast jobs", up: execSchema(postgresBroadcastJobsSchema)},
+		{version: 3, description: "user trial flag", up: execSchema("ALTER TABLE users ADD COLUMN trial BOOLEAN NOT NULL DEFAULT FALSE")},
+		{version: 4, description: "rich broadcasts", up: execSchema(postgresRichBroadcastsSchema)},
+		{version: 5, description: "broadcast schedules", up: execSchema(postgresBroadcastSchedulesSchema)},
+		{version: 6, description: "user telegram usernames", up: execSchema("ALTER TABLE users ADD COLUMN tg_username TEXT NOT NULL DEFAULT ''")},
+	},
+	timestampType: "TIMESTAMPTZ",
+	numbered:      true,
+	lock:          "SELECT pg_advisory_xact_lock(hashtext('x-ui-bot schema_version'))",
+	resetSequence: "SELECT setval(pg_get_serial_sequence('%[1]s', 'id'), COALESCE(MAX(id), 0) + 1, false) FROM %[1]s",
+}
+
+// NewPostgresStorage creates a new PostgreSQL storage, dsn is a connection string such as
+// postgres://bot:secret@db:5432/xuibot?sslmode=disable
+func NewPostgresStorage(dsn string) (*SQLStorage, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	db.SetMaxOpenConns(10)
+	db.SetMaxIdleConns(2)
+	db.SetConnMaxIdleTime(5 * time.Minute)
+
+	if err := db.Ping(); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	storage := &SQLStorage{db: db, dialect: postgresDialect}
+	if err := storage.migrate(); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
+	}
+
+	return storage, nil
+}
+
+// migratePostgresInitialSchema creates the schema matching SQLite migration 1
+func migratePostgresInitialSchema(tx *sql.Tx) error {
+	_, err := tx.Exec(postgresInitialSchema)
+	return err
+}
+
+// postgresInitialSchema is the schema of migration 1
+const postgresInitialSchema = `
+	CREATE TABLE IF NOT EXISTS user_states (
+		user_id BIGINT PRIMARY KEY,
+		state TEXT NOT NULL,
+		updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS registration_requests (
+		user_id BIGINT PRIMARY KEY,
+		username TEXT NOT NULL,
+		tg_username TEXT,
+		email TEXT NOT NULL,
+		duration BIGINT NOT NULL,
+		status TEXT NOT NULL,
+		language TEXT NOT NULL DEFAULT '',
+		promo_code TEXT NOT NULL DEFAULT '',
+		price BIGINT NOT NULL DEFAULT 0,
+		bonus_days BIGINT NOT NULL DEFAULT 0,
+		timestamp TIMESTAMPTZ NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS admin_message_states (
+		admin_id BIGINT PRIMARY KEY,
+		client_email TEXT NOT NULL,
+		client_tg_id TEXT,
+		inbound_id BIGINT NOT NULL,
+		client_index BIGINT NOT NULL,
+		timestamp TIMESTAMPTZ NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS user_message_states (
+		user_id BIGINT PRIMARY KEY,
+		username TEXT NOT NULL,
+		tg_username TEXT,
+		timestamp TIMESTAMPTZ NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS broadcast_states (
+		admin_id BIGINT PRIMARY KEY,
+		message TEXT NOT NULL,
+		timestamp TIMESTAMPTZ NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS traffic_snapshots (
+		id BIGSERIAL PRIMARY KEY,
+		panel TEXT NOT NULL DEFAULT 'default',
+		inbound_id BIGINT NOT NULL,
+		timestamp TIMESTAMPTZ NOT NULL,
+		upload_bytes BIGINT NOT NULL,
+		download_bytes BIGINT NOT NULL,
+		total_bytes BIGINT NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS subscription_expiry (
+		email TEXT PRIMARY KEY,
+		tg_id BIGINT NOT NULL,
+		expiry_time BIGINT NOT NULL,
+		last_updated TIMESTAMPTZ NOT NULL,
+		notified_days TEXT DEFAULT ''
+	);
+	CREATE INDEX IF NOT EXISTS idx_expiry_time ON subscription_expiry(expiry_time);
+
+	CREATE TABLE IF NOT EXISTS traffic_sync_state (
+		email TEXT NOT NULL,
+		inbound_id BIGINT NOT NULL,
+		up BIGINT NOT NULL,
+		down BIGINT NOT NULL,
+		updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (email, inbound_id)
+	);
+
+	CREATE TABLE IF NOT EXISTS users (
+		tg_id BIGINT PRIMARY KEY,
+		email TEXT NOT NULL,
+		sub_id TEXT NOT NULL DEFAULT '',
+		panel TEXT NOT NULL DEFAULT 'default',
+		inbound_ids TEXT NOT NULL DEFAULT '',
+		language TEXT NOT NULL DEFAULT '',
+		status TEXT NOT NULL,
+		registered_at TIMESTAMPTZ NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_users_email ON users(email);
+
+	CREATE TABLE IF NOT EXISTS payments (
+		id BIGSERIAL PRIMARY KEY,
+		user_id BIGINT NOT NULL,
+		telegram_charge_id TEXT NOT NULL UNIQUE,
+		provider_charge_id TEXT NOT NULL DEFAULT '',
+		payload TEXT NOT NULL,
+		duration BIGINT NOT NULL,
+		amount BIGINT NOT NULL,
+		currency TEXT NOT NULL,
+		status TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_payments_user ON payments(user_id);
+
+	CREATE TABLE IF NOT EXISTS extension_requests (
+		id BIGSERIAL PRIMARY KEY,
+		user_id BIGINT NOT NULL,
+		duration BIGINT NOT NULL,
+		price BIGINT NOT NULL,
+		promo_code TEXT NOT NULL DEFAULT '',
+		bonus_days BIGINT NOT NULL DEFAULT 0,
+		status TEXT NOT NULL,
+		decided_by BIGINT NOT NULL DEFAULT 0,
+		created_at TIMESTAMPTZ NOT NULL,
+		decided_at TIMESTAMPTZ
+	);
+	CREATE INDEX IF NOT EXISTS idx_extension_requests_user ON extension_requests(user_id, status);
+
+	CREATE TABLE IF NOT EXISTS receipts (
+		id BIGSERIAL PRIMARY KEY,
+		request_id BIGINT NOT NULL,
+		user_id BIGINT NOT NULL,
+		file_id TEXT NOT NULL,
+		file_type TEXT NOT NULL,
+		file_name TEXT NOT NULL DEFAULT '',
+		mime_type TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_receipts_request ON receipts(request_id);
+
+	CREATE TABLE IF NOT EXISTS promo_codes (
+		code TEXT PRIMARY KEY,
+		type TEXT NOT NULL,
+		value BIGINT NOT NULL,
+		max_uses BIGINT NOT NULL DEFAULT 0,
+		per_user_limit BIGINT NOT NULL DEFAULT 0,
+		uses BIGINT NOT NULL DEFAULT 0,
+		expires_at TIMESTAMPTZ,
+		created_by BIGINT NOT NULL DEFAULT 0,
+		created_at TIMESTAMPTZ NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS promo_redemptions (
+		id BIGSERIAL PRIMARY KEY,
+		code TEXT NOT NULL,
+		user_id BIGINT NOT NULL,
+		purpose TEXT NOT NULL,
+		price BIGINT NOT NULL,
+		bonus_days BIGINT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_promo_redemptions_code_user ON promo_redemptions(code, user_id);
+
+	CREATE TABLE IF NOT EXISTS referrals (
+		referred_id BIGINT PRIMARY KEY,
+		referrer_id BIGINT NOT NULL,
+		bonus_days BIGINT NOT NULL DEFAULT 0,
+		created_at TIMESTAMPTZ NOT NULL,
+		rewarded_at TIMESTAMPTZ
+	);
+	CREATE INDEX IF NOT EXISTS idx_referrals_referrer ON referrals(referrer_id);
+
+	CREATE TABLE IF NOT EXISTS audit_log (
+		id BIGSERIAL PRIMARY KEY,
+		actor_id BIGINT NOT NULL,
+		action TEXT NOT NULL,
+		target_email TEXT NOT NULL DEFAULT '',
+		target_tg_id BIGINT NOT NULL DEFAULT 0,
+		before_value TEXT NOT NULL DEFAULT '',
+		after_value TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_audit_log_actor ON audit_log(actor_id);
+	CREATE INDEX IF NOT EXISTS idx_audit_log_action ON audit_log(action);
+	CREATE INDEX IF NOT EXISTS idx_audit_log_target ON audit_log(target_tg_id);
+
+	CREATE TABLE IF NOT EXISTS client_refs (
+		id BIGSERIAL PRIMARY KEY,
+		panel TEXT NOT NULL,
+		email TEXT NOT NULL,
+		tg_id BIGINT NOT NULL DEFAULT 0,
+		created_at TIMESTAMPTZ NOT NULL,
+		UNIQUE(panel, email, tg_id)
+	);
+
+	CREATE TABLE IF NOT EXISTS rate_limit_penalties (
+		tg_id BIGINT PRIMARY KEY,
+		violations BIGINT NOT NULL DEFAULT 0,
+		strikes BIGINT NOT NULL DEFAULT 0,
+		blocked_until TIMESTAMPTZ,
+		updated_at TIMESTAMPTZ NOT NULL
+	);
+
+	-- The audit log is append-only
+	CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+	BEGIN
+		RAISE EXCEPTION 'audit_log is append-only';
+	END;
+	$$ LANGUAGE plpgsql;
+	DROP TRIGGER IF EXISTS audit_log_no_change ON audit_log;
+	CREATE TRIGGER audit_log_no_change BEFORE UPDATE OR DELETE ON audit_log
+		FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
+
+	CREATE INDEX IF NOT EXISTS idx_traffic_panel_inbound_timestamp ON traffic_snapshots(panel, inbound_id, timestamp);
+`
+
+// postgresBroadcastJobsSchema is the schema of migration 2
+const postgresBroadcastJobsSchema = `
+	CREATE TABLE broadcast_jobs (
+		id BIGSERIAL PRIMARY KEY,
+		created_by BIGINT NOT NULL,
+		message TEXT NOT NULL,
+		status TEXT NOT NULL,
+		chat_id BIGINT NOT NULL DEFAULT 0,
+		message_id BIGINT NOT NULL DEFAULT 0,
+		created_at TIMESTAMPTZ NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL,
+		finished_at TIMESTAMPTZ
+	);
+	CREATE INDEX idx_broadcast_jobs_status ON broadcast_jobs(status);
+
+	CREATE TABLE broadcast_recipients (
+		job_id BIGINT NOT NULL,
+		tg_id BIGINT NOT NULL,
+		status TEXT NOT NULL,
+		attempts BIGINT NOT NULL DEFAULT 0,
+		last_error TEXT NOT NULL DEFAULT '',
+		updated_at TIMESTAMPTZ,
+		PRIMARY KEY (job_id, tg_id)
+	);
+	CREATE INDEX idx_broadcast_recipients_status ON broadcast_recipients(job_id, status);
+`
+
+// postgresRichBroadcastsSchema is the schema of migration 4
+const postgresRichBroadcastsSchema = `
+	ALTER TABLE broadcast_states ADD COLUMN media TEXT NOT NULL DEFAULT '';
+	ALTER TABLE broadcast_states ADD COLUMN buttons TEXT NOT NULL DEFAULT '';
+	ALTER TABLE broadcast_states ADD COLUMN pin BOOLEAN NOT NULL DEFAULT FALSE;
+	ALTER TABLE broadcast_states ADD COLUMN send_at TIMESTAMPTZ;
+	ALTER TABLE broadcast_jobs ADD COLUMN media TEXT NOT NULL DEFAULT '';
+	ALTER TABLE broadcast_jobs ADD COLUMN buttons TEXT NOT NULL DEFAULT '';
+	ALTER TABLE broadcast_jobs ADD COLUMN pin BOOLEAN NOT NULL DEFAULT FALSE;
+	ALTER TABLE broadcast_jobs ADD COLUMN send_at TIMESTAMPTZ;
+`
+
+// postgresBroadcastSchedulesSchema is the schema of migration 5
+const postgresBroadcastSchedulesSchema = `
+	CREATE TABLE broadcast_schedules (
+		id BIGSERIAL PRIMARY KEY,
+		created_by BIGINT NOT NULL,
+		message TEXT NOT NULL,
+		media TEXT NOT NULL DEFAULT '',
+		buttons TEXT NOT NULL DEFAULT '',
+		pin BOOLEAN NOT NULL DEFAULT FALSE,
+		audience TEXT NOT NULL,
+		cron TEXT NOT NULL DEFAULT '',
+		send_at TIMESTAMPTZ NOT NULL,
+		last_run_at TIMESTAMPTZ,
+		revision BIGINT NOT NULL DEFAULT 0,
+		created_at TIMESTAMPTZ NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL
+	);
+	CREATE INDEX idx_broadcast_schedules_send_at ON broadcast_schedules(send_at);
+
+	ALTER TABLE broadcast_states ADD COLUMN cron TEXT NOT NULL DEFAULT '';
+`
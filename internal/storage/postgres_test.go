@@ -0,0 +1,59 @@
+package storage_test
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"x-ui-bot/internal/storage"
+	"x-ui-bot/internal/storage/storagetest"
+)
+
+// postgresDSNEnv names the database the PostgreSQL storage is tested against, the test is skipped without it
+const postgresDSNEnv = "XUIBOT_TEST_POSTGRES_DSN"
+
+// TestPostgresStorage runs each check in a temporary schema that is dropped afterwards
+func TestPostgresStorage(t *testing.T) {
+	dsn := os.Getenv(postgresDSNEnv)
+	if dsn == "" {
+		t.Skipf("%s is not set", postgresDSNEnv)
+	}
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = admin.Close() })
+
+	n := 0
+	storagetest.Run(t, func(t *testing.T) (storage.Storage, error) {
+		n++
+		schema := fmt.Sprintf("storagetest_%d_%d", os.Getpid(), n)
+		if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+			return nil, fmt.Errorf("failed to create schema %s: %w", schema, err)
+		}
+		// Registered before the storage is, so it runs after the storage is closed
+		t.Cleanup(func() {
+			if _, err := admin.Exec("DROP SCHEMA " + schema + " CASCADE"); err != nil {
+				t.Errorf("failed to drop schema %s: %v", schema, err)
+			}
+		})
+		return storage.NewPostgresStorage(withSearchPath(dsn, schema))
+	})
+}
+
+// withSearchPath points a URL or key=value DSN at schema
+func withSearchPath(dsn, schema string) string {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		if u, err := url.Parse(dsn); err == nil {
+			q := u.Query()
+			q.Set("search_path", schema)
+			u.RawQuery = q.Encode()
+			return u.String()
+		}
+	}
+	return dsn + " search_path=" + schema
+}
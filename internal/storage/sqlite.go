@@ -2,19 +2,31 @@ package storage
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"os"
 	"time"
 
 	_ "modernc.org/sqlite"
 )
 
-// SQLiteStorage implements Storage interface with SQLite persistence
-type SQLiteStorage struct {
-	db *sql.DB
+// sqliteDialect is a local database file
+var sqliteDialect = &dialect{
+	name: "sqlite",
+	migrations: []migration{
+		{version: 1, description: "initial schema", up: migrateSQLiteInitialSchema},
+		{version: 2, description: "broadcast jobs", up: execSchema(sqliteBroadcastJobsSchema)},
+		{version: 3, description: "user trial flag", up: execSchema("ALTER TABLE users ADD COLUMN trial BOOLEAN NOT NULL DEFAULT FALSE")},
+		{version: 4, description: "rich broadcasts", up: execSchema(sqliteRichBroadcastsSchema)},
+		{version: 5, description: "broadcast schedules", up: execSchema(sqliteBroadcastSchedulesSchema)},
+		{version: 6, description: "user telegram usernames", up: execSchema("ALTER TABLE users ADD COLUMN tg_username TEXT NOT NULL DEFAULT ''")},
+	},
+	timestampType: "DATETIME",
+	backup:        backupSQLite,
 }
 
 // NewSQLiteStorage creates a new SQLite storage
-func NewSQLiteStorage(dbPath string) (*SQLiteStorage, error) {
+func NewSQLiteStorage(dbPath string) (*SQLStorage, error) {
 	// Add pragma parameters for better concurrency
 	// WAL mode allows concurrent reads and writes
 	// busy_timeout makes SQLite wait instead of returning SQLITE_BUSY
@@ -28,17 +40,139 @@ func NewSQLiteStorage(dbPath string) (*SQLiteStorage, error) {
 	db.SetMaxIdleConns(1)    // Keep connection alive
 	db.SetConnMaxLifetime(0) // Reuse connections indefinitely
 
-	storage := &SQLiteStorage{db: db}
-	if err := storage.initialize(); err != nil {
-		return nil, fmt.Errorf("failed to initialize database: %w", err)
+	storage := &SQLStorage{db: db, dialect: sqliteDialect, path: dbPath}
+	if err := storage.migrate(); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
 	}
 
 	return storage, nil
 }
 
-// initialize creates the necessary tables
-func (s *SQLiteStorage) initialize() error {
-	schema := `
+// OpenSQLiteCopy opens a migrated copy of the SQLite database at srcPath without writing to the source:
+// it is opened read-only and copied to copyPath with VACUUM INTO, and only the copy is migrated
+func OpenSQLiteCopy(srcPath, copyPath string) (*SQLStorage, error) {
+	// Without a -wal file all data is in the main file, so it is opened immutable and SQLite does not
+	// create -wal and -shm files next to it
+	params := "mode=ro&_pragma=busy_timeout(5000)"
+	if _, err := os.Stat(srcPath + "-wal"); errors.Is(err, os.ErrNotExist) {
+		params += "&immutable=1"
+	}
+	src, err := sql.Open("sqlite", "file:"+srcPath+"?"+params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	defer func() { _ = src.Close() }()
+
+	if _, err := src.Exec("VACUUM INTO ?", copyPath); err != nil {
+		return nil, fmt.Errorf("failed to copy database: %w", err)
+	}
+	return NewSQLiteStorage(copyPath)
+}
+
+// backupSQLite copies a database holding data to <db>.v<version>-<time>.bak with VACUUM INTO
+func backupSQLite(s *SQLStorage, version int) (string, error) {
+	if s.path == "" || s.path == ":memory:" {
+		return "", nil
+	}
+
+	var tables int
+	if err := s.db.QueryRow(`
+		SELECT COUNT(*) FROM sqlite_master
+		WHERE type = 'table' AND name != 'schema_version' AND name NOT LIKE 'sqlite_%'
+	`).Scan(&tables); err != nil {
+		return "", err
+	}
+	if tables == 0 {
+		return "", nil
+	}
+
+	path := fmt.Sprintf("%s.v%d-%s.bak", s.path, version, time.Now().Format("20060102-150405"))
+	if _, err := s.db.Exec("VACUUM INTO ?", path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// migrateSQLiteInitialSchema creates the schema as of the introduction of versioning. Databases created
+// before that already have some of the tables and get the columns added since they were created
+func migrateSQLiteInitialSchema(tx *sql.Tx) error {
+	if _, err := tx.Exec(sqliteInitialSchema); err != nil {
+		return err
+	}
+
+	// Snapshots recorded before multi-panel support belong to the default panel
+	if err := addColumnIfMissing(tx, "traffic_snapshots", "panel", "TEXT NOT NULL DEFAULT 'default'"); err != nil {
+		return err
+	}
+
+	// Requests saved before users were tracked carry no language
+	if err := addColumnIfMissing(tx, "registration_requests", "language", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+
+	// Requests saved before promo codes carry no discount
+	promoColumns := []struct{ table, column, definition string }{
+		{"registration_requests", "promo_code", "TEXT NOT NULL DEFAULT ''"},
+		{"registration_requests", "price", "INTEGER NOT NULL DEFAULT 0"},
+		{"registration_requests", "bonus_days", "INTEGER NOT NULL DEFAULT 0"},
+		{"extension_requests", "promo_code", "TEXT NOT NULL DEFAULT ''"},
+		{"extension_requests", "bonus_days", "INTEGER NOT NULL DEFAULT 0"},
+	}
+	for _, c := range promoColumns {
+		if err := addColumnIfMissing(tx, c.table, c.column, c.definition); err != nil {
+			return err
+		}
+	}
+
+	_, err := tx.Exec(`
+	DROP INDEX IF EXISTS idx_traffic_inbound_timestamp;
+	CREATE INDEX IF NOT EXISTS idx_traffic_panel_inbound_timestamp ON traffic_snapshots(panel, inbound_id, timestamp);
+	`)
+	return err
+}
+
+// addColumnIfMissing adds a column to an existing table created by an older version
+func addColumnIfMissing(tx *sql.Tx, table, column, definition string) error {
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		if name == column {
+			found = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		_ = rows.Close()
+		return err
+	}
+	_ = rows.Close()
+
+	if found {
+		return nil
+	}
+
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+// sqliteInitialSchema is the schema of migration 1
+const sqliteInitialSchema = `
 	CREATE TABLE IF NOT EXISTS user_states (
 		user_id INTEGER PRIMARY KEY,
 		state TEXT NOT NULL,
@@ -52,6 +186,10 @@ func (s *SQLiteStorage) initialize() error {
 		email TEXT NOT NULL,
 		duration INTEGER NOT NULL,
 		status TEXT NOT NULL,
+		language TEXT NOT NULL DEFAULT '',
+		promo_code TEXT NOT NULL DEFAULT '',
+		price INTEGER NOT NULL DEFAULT 0,
+		bonus_days INTEGER NOT NULL DEFAULT 0,
 		timestamp DATETIME NOT NULL
 	);
 
@@ -79,13 +217,13 @@ func (s *SQLiteStorage) initialize() error {
 
 	CREATE TABLE IF NOT EXISTS traffic_snapshots (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		panel TEXT NOT NULL DEFAULT 'default',
 		inbound_id INTEGER NOT NULL,
 		timestamp DATETIME NOT NULL,
 		upload_bytes INTEGER NOT NULL,
 		download_bytes INTEGER NOT NULL,
 		total_bytes INTEGER NOT NULL
 	);
-	CREATE INDEX IF NOT EXISTS idx_traffic_inbound_timestamp ON traffic_snapshots(inbound_id, timestamp);
 
 	CREATE TABLE IF NOT EXISTS subscription_expiry (
 		email TEXT PRIMARY KEY,
@@ -104,386 +242,192 @@ func (s *SQLiteStorage) initialize() error {
 		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 		PRIMARY KEY (email, inbound_id)
 	);
-	`
-
-	_, err := s.db.Exec(schema)
-	return err
-}
-
-// User states
-func (s *SQLiteStorage) SetUserState(userID int64, state string) error {
-	_, err := s.db.Exec(
-		"INSERT OR REPLACE INTO user_states (user_id, state) VALUES (?, ?)",
-		userID, state,
-	)
-	return err
-}
-
-func (s *SQLiteStorage) GetUserState(userID int64) (string, error) {
-	var state string
-	err := s.db.QueryRow(
-		"SELECT state FROM user_states WHERE user_id = ?",
-		userID,
-	).Scan(&state)
-
-	if err == sql.ErrNoRows {
-		return "", fmt.Errorf("state not found for user %d", userID)
-	}
-	return state, err
-}
-
-func (s *SQLiteStorage) DeleteUserState(userID int64) error {
-	_, err := s.db.Exec("DELETE FROM user_states WHERE user_id = ?", userID)
-	return err
-}
-
-// Registration requests
-func (s *SQLiteStorage) SetRegistrationRequest(userID int64, req *RegistrationRequest) error {
-	_, err := s.db.Exec(`
-		INSERT OR REPLACE INTO registration_requests 
-		(user_id, username, tg_username, email, duration, status, timestamp)
-		VALUES (?, ?, ?, ?, ?, ?, ?)`,
-		userID, req.Username, req.TgUsername, req.Email, req.Duration, req.Status, req.Timestamp,
-	)
-	return err
-}
-
-func (s *SQLiteStorage) GetRegistrationRequest(userID int64) (*RegistrationRequest, error) {
-	req := &RegistrationRequest{}
-	err := s.db.QueryRow(`
-		SELECT user_id, username, tg_username, email, duration, status, timestamp
-		FROM registration_requests WHERE user_id = ?`,
-		userID,
-	).Scan(&req.UserID, &req.Username, &req.TgUsername, &req.Email, &req.Duration, &req.Status, &req.Timestamp)
-
-	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("registration request not found for user %d", userID)
-	}
-	return req, err
-}
-
-func (s *SQLiteStorage) DeleteRegistrationRequest(userID int64) error {
-	_, err := s.db.Exec("DELETE FROM registration_requests WHERE user_id = ?", userID)
-	return err
-}
-
-func (s *SQLiteStorage) GetAllRegistrationRequests() (map[int64]*RegistrationRequest, error) {
-	rows, err := s.db.Query(`
-		SELECT user_id, username, tg_username, email, duration, status, timestamp
-		FROM registration_requests
-	`)
-	if err != nil {
-		return nil, err
-	}
-	defer func() { _ = rows.Close() }()
-
-	result := make(map[int64]*RegistrationRequest)
-	for rows.Next() {
-		req := &RegistrationRequest{}
-		if err := rows.Scan(&req.UserID, &req.Username, &req.TgUsername, &req.Email, &req.Duration, &req.Status, &req.Timestamp); err != nil {
-			return nil, err
-		}
-		result[req.UserID] = req
-	}
-	return result, rows.Err()
-}
-
-// Admin message states
-func (s *SQLiteStorage) SetAdminMessageState(adminID int64, state *AdminMessageState) error {
-	_, err := s.db.Exec(`
-		INSERT OR REPLACE INTO admin_message_states
-		(admin_id, client_email, client_tg_id, inbound_id, client_index, timestamp)
-		VALUES (?, ?, ?, ?, ?, ?)`,
-		adminID, state.ClientEmail, state.ClientTgID, state.InboundID, state.ClientIndex, state.Timestamp,
-	)
-	return err
-}
-
-func (s *SQLiteStorage) GetAdminMessageState(adminID int64) (*AdminMessageState, error) {
-	state := &AdminMessageState{}
-	err := s.db.QueryRow(`
-		SELECT client_email, client_tg_id, inbound_id, client_index, timestamp
-		FROM admin_message_states WHERE admin_id = ?`,
-		adminID,
-	).Scan(&state.ClientEmail, &state.ClientTgID, &state.InboundID, &state.ClientIndex, &state.Timestamp)
-
-	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("admin message state not found for admin %d", adminID)
-	}
-	return state, err
-}
-
-func (s *SQLiteStorage) DeleteAdminMessageState(adminID int64) error {
-	_, err := s.db.Exec("DELETE FROM admin_message_states WHERE admin_id = ?", adminID)
-	return err
-}
-
-// User message states
-func (s *SQLiteStorage) SetUserMessageState(userID int64, state *UserMessageState) error {
-	_, err := s.db.Exec(`
-		INSERT OR REPLACE INTO user_message_states
-		(user_id, username, tg_username, timestamp)
-		VALUES (?, ?, ?, ?)`,
-		userID, state.Username, state.TgUsername, state.Timestamp,
-	)
-	return err
-}
-
-func (s *SQLiteStorage) GetUserMessageState(userID int64) (*UserMessageState, error) {
-	state := &UserMessageState{}
-	err := s.db.QueryRow(`
-		SELECT user_id, username, tg_username, timestamp
-		FROM user_message_states WHERE user_id = ?`,
-		userID,
-	).Scan(&state.UserID, &state.Username, &state.TgUsername, &state.Timestamp)
-
-	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("user message state not found for user %d", userID)
-	}
-	return state, err
-}
-
-func (s *SQLiteStorage) DeleteUserMessageState(userID int64) error {
-	_, err := s.db.Exec("DELETE FROM user_message_states WHERE user_id = ?", userID)
-	return err
-}
 
-// Broadcast states
-func (s *SQLiteStorage) SetBroadcastState(adminID int64, state *BroadcastState) error {
-	_, err := s.db.Exec(`
-		INSERT OR REPLACE INTO broadcast_states (admin_id, message, timestamp)
-		VALUES (?, ?, ?)`,
-		adminID, state.Message, state.Timestamp,
-	)
-	return err
-}
-
-func (s *SQLiteStorage) GetBroadcastState(adminID int64) (*BroadcastState, error) {
-	state := &BroadcastState{}
-	err := s.db.QueryRow(`
-		SELECT message, timestamp FROM broadcast_states WHERE admin_id = ?`,
-		adminID,
-	).Scan(&state.Message, &state.Timestamp)
-
-	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("broadcast state not found for admin %d", adminID)
-	}
-	return state, err
-}
-
-func (s *SQLiteStorage) DeleteBroadcastState(adminID int64) error {
-	_, err := s.db.Exec("DELETE FROM broadcast_states WHERE admin_id = ?", adminID)
-	return err
-}
-
-// Traffic snapshots
-func (s *SQLiteStorage) SaveTrafficSnapshot(snapshot *TrafficSnapshot) error {
-	_, err := s.db.Exec(
-		"INSERT INTO traffic_snapshots (inbound_id, timestamp, upload_bytes, download_bytes, total_bytes) VALUES (?, ?, ?, ?, ?)",
-		snapshot.InboundID, snapshot.Timestamp, snapshot.UploadBytes, snapshot.DownloadBytes, snapshot.TotalBytes,
-	)
-	return err
-}
-
-func (s *SQLiteStorage) GetTrafficSnapshots(inboundID int, startTime, endTime time.Time) ([]*TrafficSnapshot, error) {
-	rows, err := s.db.Query(
-		"SELECT id, inbound_id, timestamp, upload_bytes, download_bytes, total_bytes FROM traffic_snapshots WHERE inbound_id = ? AND timestamp >= ? AND timestamp <= ? ORDER BY timestamp ASC",
-		inboundID, startTime, endTime,
-	)
-	if err != nil {
-		return nil, err
-	}
-	defer func() { _ = rows.Close() }()
-
-	var results []*TrafficSnapshot
-	for rows.Next() {
-		ts := &TrafficSnapshot{}
-		if err := rows.Scan(&ts.ID, &ts.InboundID, &ts.Timestamp, &ts.UploadBytes, &ts.DownloadBytes, &ts.TotalBytes); err != nil {
-			return nil, err
-		}
-		results = append(results, ts)
-	}
-	return results, rows.Err()
-}
-
-func (s *SQLiteStorage) GetLatestTrafficSnapshot(inboundID int) (*TrafficSnapshot, error) {
-	ts := &TrafficSnapshot{}
-	err := s.db.QueryRow(
-		"SELECT id, inbound_id, timestamp, upload_bytes, download_bytes, total_bytes FROM traffic_snapshots WHERE inbound_id = ? ORDER BY timestamp DESC LIMIT 1",
-		inboundID,
-	).Scan(&ts.ID, &ts.InboundID, &ts.Timestamp, &ts.UploadBytes, &ts.DownloadBytes, &ts.TotalBytes)
-
-	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("no traffic snapshots found")
-	}
-	return ts, err
-}
-
-func (s *SQLiteStorage) DeleteOldTrafficSnapshots(beforeTime time.Time) error {
-	_, err := s.db.Exec("DELETE FROM traffic_snapshots WHERE timestamp < ?", beforeTime)
-	return err
-}
-
-// Subscription expiry tracking
-func (s *SQLiteStorage) UpsertSubscriptionExpiry(email string, tgID int64, expiryTime int64) error {
-	_, err := s.db.Exec(`
-		INSERT INTO subscription_expiry (email, tg_id, expiry_time, last_updated, notified_days)
-		VALUES (?, ?, ?, ?, '')
-		ON CONFLICT(email) DO UPDATE SET
-			tg_id = excluded.tg_id,
-			expiry_time = excluded.expiry_time,
-			last_updated = excluded.last_updated,
-			notified_days = CASE 
-				WHEN excluded.expiry_time != subscription_expiry.expiry_time THEN ''
-				ELSE subscription_expiry.notified_days
-			END
-	`, email, tgID, expiryTime, time.Now())
-	return err
-}
-
-func (s *SQLiteStorage) GetExpiringSubscriptions(daysThreshold int) ([]ExpiringSubscription, error) {
-	// Calculate threshold time
-	thresholdTime := time.Now().Add(time.Duration(daysThreshold) * 24 * time.Hour).UnixMilli()
-
-	rows, err := s.db.Query(`
-		SELECT email, tg_id, expiry_time, notified_days
-		FROM subscription_expiry
-		WHERE expiry_time > 0 
-		  AND expiry_time <= ?
-		  AND expiry_time > ?
-	`, thresholdTime, time.Now().UnixMilli())
-
-	if err != nil {
-		return nil, err
-	}
-	defer func() {
-		_ = rows.Close() // Ignore error in defer
-	}()
-
-	var results []ExpiringSubscription
-	for rows.Next() {
-		var sub ExpiringSubscription
-		if err := rows.Scan(&sub.Email, &sub.TgID, &sub.ExpiryTime, &sub.NotifiedDays); err != nil {
-			return nil, err
-		}
-		results = append(results, sub)
-	}
-	return results, rows.Err()
-}
-
-func (s *SQLiteStorage) MarkSubscriptionNotified(email string, daysNotified string) error {
-	_, err := s.db.Exec(`
-		UPDATE subscription_expiry 
-		SET notified_days = CASE
-			WHEN notified_days = '' THEN ?
-			WHEN instr(notified_days, ?) = 0 THEN notified_days || ',' || ?
-			ELSE notified_days
-		END
-		WHERE email = ?
-	`, daysNotified, daysNotified, daysNotified, email)
-	return err
-}
-
-func (s *SQLiteStorage) DeleteExpiredSubscriptions() error {
-	_, err := s.db.Exec("DELETE FROM subscription_expiry WHERE expiry_time > 0 AND expiry_time < ?", time.Now().UnixMilli())
-	return err
-}
-
-// Traffic sync state
-func (s *SQLiteStorage) GetTrafficSyncState(email string, inboundID int) (int64, int64, error) {
-	var up, down int64
-	err := s.db.QueryRow("SELECT up, down FROM traffic_sync_state WHERE email = ? AND inbound_id = ?", email, inboundID).Scan(&up, &down)
-	if err == sql.ErrNoRows {
-		return 0, 0, nil
-	}
-	return up, down, err
-}
-
-func (s *SQLiteStorage) SetTrafficSyncState(email string, inboundID int, up, down int64) error {
-	_, err := s.db.Exec(`
-		INSERT OR REPLACE INTO traffic_sync_state (email, inbound_id, up, down, updated_at)
-		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
-	`, email, inboundID, up, down)
-	return err
-}
+	CREATE TABLE IF NOT EXISTS users (
+		tg_id INTEGER PRIMARY KEY,
+		email TEXT NOT NULL,
+		sub_id TEXT NOT NULL DEFAULT '',
+		panel TEXT NOT NULL DEFAULT 'default',
+		inbound_ids TEXT NOT NULL DEFAULT '',
+		language TEXT NOT NULL DEFAULT '',
+		status TEXT NOT NULL,
+		registered_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_users_email ON users(email);
 
-// CleanupExpiredStates removes states older than maxAge
-func (s *SQLiteStorage) CleanupExpiredStates(maxAge time.Duration) error {
-	cutoff := time.Now().Add(-maxAge)
+	CREATE TABLE IF NOT EXISTS payments (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		telegram_charge_id TEXT NOT NULL UNIQUE,
+		provider_charge_id TEXT NOT NULL DEFAULT '',
+		payload TEXT NOT NULL,
+		duration INTEGER NOT NULL,
+		amount INTEGER NOT NULL,
+		currency TEXT NOT NULL,
+		status TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_payments_user ON payments(user_id);
 
-	tables := []string{
-		"registration_requests",
-		"admin_message_states",
-		"user_message_states",
-		"broadcast_states",
-		"traffic_snapshots",
-	}
+	CREATE TABLE IF NOT EXISTS extension_requests (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		duration INTEGER NOT NULL,
+		price INTEGER NOT NULL,
+		promo_code TEXT NOT NULL DEFAULT '',
+		bonus_days INTEGER NOT NULL DEFAULT 0,
+		status TEXT NOT NULL,
+		decided_by INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME NOT NULL,
+		decided_at DATETIME
+	);
+	CREATE INDEX IF NOT EXISTS idx_extension_requests_user ON extension_requests(user_id, status);
 
-	for _, table := range tables {
-		_, err := s.db.Exec(
-			fmt.Sprintf("DELETE FROM %s WHERE timestamp < ?", table),
-			cutoff,
-		)
-		if err != nil {
-			return err
-		}
-	}
+	CREATE TABLE IF NOT EXISTS receipts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		request_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		file_id TEXT NOT NULL,
+		file_type TEXT NOT NULL,
+		file_name TEXT NOT NULL DEFAULT '',
+		mime_type TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_receipts_request ON receipts(request_id);
+
+	CREATE TABLE IF NOT EXISTS promo_codes (
+		code TEXT PRIMARY KEY,
+		type TEXT NOT NULL,
+		value INTEGER NOT NULL,
+		max_uses INTEGER NOT NULL DEFAULT 0,
+		per_user_limit INTEGER NOT NULL DEFAULT 0,
+		uses INTEGER NOT NULL DEFAULT 0,
+		expires_at DATETIME,
+		created_by INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME NOT NULL
+	);
 
-	return nil
-}
+	CREATE TABLE IF NOT EXISTS promo_redemptions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		code TEXT NOT NULL,
+		user_id INTEGER NOT NULL,
+		purpose TEXT NOT NULL,
+		price INTEGER NOT NULL,
+		bonus_days INTEGER NOT NULL,
+		created_at DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_promo_redemptions_code_user ON promo_redemptions(code, user_id);
+
+	CREATE TABLE IF NOT EXISTS referrals (
+		referred_id INTEGER PRIMARY KEY,
+		referrer_id INTEGER NOT NULL,
+		bonus_days INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME NOT NULL,
+		rewarded_at DATETIME
+	);
+	CREATE INDEX IF NOT EXISTS idx_referrals_referrer ON referrals(referrer_id);
 
-// CleanupOrphanedTrafficSyncState removes traffic sync records for emails not in active clients
-func (s *SQLiteStorage) CleanupOrphanedTrafficSyncState(activeEmails map[string]bool) error {
-	if len(activeEmails) == 0 {
-		return nil
-	}
+	CREATE TABLE IF NOT EXISTS audit_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		actor_id INTEGER NOT NULL,
+		action TEXT NOT NULL,
+		target_email TEXT NOT NULL DEFAULT '',
+		target_tg_id INTEGER NOT NULL DEFAULT 0,
+		before_value TEXT NOT NULL DEFAULT '',
+		after_value TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_audit_log_actor ON audit_log(actor_id);
+	CREATE INDEX IF NOT EXISTS idx_audit_log_action ON audit_log(action);
+	CREATE INDEX IF NOT EXISTS idx_audit_log_target ON audit_log(target_tg_id);
 
-	// Get all emails in traffic_sync_state
-	rows, err := s.db.Query("SELECT DISTINCT email FROM traffic_sync_state")
-	if err != nil {
-		return err
-	}
-	defer func() { _ = rows.Close() }()
+	CREATE TABLE IF NOT EXISTS client_refs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		panel TEXT NOT NULL,
+		email TEXT NOT NULL,
+		tg_id INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME NOT NULL,
+		UNIQUE(panel, email, tg_id)
+	);
 
-	var orphanedEmails []string
-	for rows.Next() {
-		var email string
-		if err := rows.Scan(&email); err != nil {
-			return err
-		}
-		if !activeEmails[email] {
-			orphanedEmails = append(orphanedEmails, email)
-		}
-	}
+	CREATE TABLE IF NOT EXISTS rate_limit_penalties (
+		tg_id INTEGER PRIMARY KEY,
+		violations INTEGER NOT NULL DEFAULT 0,
+		strikes INTEGER NOT NULL DEFAULT 0,
+		blocked_until DATETIME,
+		updated_at DATETIME NOT NULL
+	);
 
-	if err := rows.Err(); err != nil {
-		return err
-	}
+	-- The audit log is append-only
+	CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+	BEGIN
+		SELECT RAISE(ABORT, 'audit_log is append-only');
+	END;
+	CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+	BEGIN
+		SELECT RAISE(ABORT, 'audit_log is append-only');
+	END;
+	`
 
-	// Delete orphaned records
-	for _, email := range orphanedEmails {
-		_, err := s.db.Exec("DELETE FROM traffic_sync_state WHERE email = ?", email)
-		if err != nil {
-			return err
-		}
-	}
+// sqliteBroadcastJobsSchema is the schema of migration 2
+const sqliteBroadcastJobsSchema = `
+	CREATE TABLE broadcast_jobs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		created_by INTEGER NOT NULL,
+		message TEXT NOT NULL,
+		status TEXT NOT NULL,
+		chat_id INTEGER NOT NULL DEFAULT 0,
+		message_id INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL,
+		finished_at DATETIME
+	);
+	CREATE INDEX idx_broadcast_jobs_status ON broadcast_jobs(status);
 
-	return nil
-}
+	CREATE TABLE broadcast_recipients (
+		job_id INTEGER NOT NULL,
+		tg_id INTEGER NOT NULL,
+		status TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		last_error TEXT NOT NULL DEFAULT '',
+		updated_at DATETIME,
+		PRIMARY KEY (job_id, tg_id)
+	);
+	CREATE INDEX idx_broadcast_recipients_status ON broadcast_recipients(job_id, status);
+	`
 
-// DeleteTrafficSyncStateForEmail removes all traffic sync records for a specific email
-func (s *SQLiteStorage) DeleteTrafficSyncStateForEmail(email string) error {
-	_, err := s.db.Exec("DELETE FROM traffic_sync_state WHERE email = ?", email)
-	return err
-}
+// sqliteRichBroadcastsSchema is the schema of migration 4
+const sqliteRichBroadcastsSchema = `
+	ALTER TABLE broadcast_states ADD COLUMN media TEXT NOT NULL DEFAULT '';
+	ALTER TABLE broadcast_states ADD COLUMN buttons TEXT NOT NULL DEFAULT '';
+	ALTER TABLE broadcast_states ADD COLUMN pin BOOLEAN NOT NULL DEFAULT FALSE;
+	ALTER TABLE broadcast_states ADD COLUMN send_at DATETIME;
+	ALTER TABLE broadcast_jobs ADD COLUMN media TEXT NOT NULL DEFAULT '';
+	ALTER TABLE broadcast_jobs ADD COLUMN buttons TEXT NOT NULL DEFAULT '';
+	ALTER TABLE broadcast_jobs ADD COLUMN pin BOOLEAN NOT NULL DEFAULT FALSE;
+	ALTER TABLE broadcast_jobs ADD COLUMN send_at DATETIME;
+	`
 
-// UpdateTrafficSyncStateEmail updates all traffic sync records from old email to new email
-func (s *SQLiteStorage) UpdateTrafficSyncStateEmail(oldEmail, newEmail string) error {
-	_, err := s.db.Exec("UPDATE traffic_sync_state SET email = ? WHERE email = ?", newEmail, oldEmail)
-	return err
-}
+// sqliteBroadcastSchedulesSchema is the schema of migration 5
+const sqliteBroadcastSchedulesSchema = `
+	CREATE TABLE broadcast_schedules (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		created_by INTEGER NOT NULL,
+		message TEXT NOT NULL,
+		media TEXT NOT NULL DEFAULT '',
+		buttons TEXT NOT NULL DEFAULT '',
+		pin BOOLEAN NOT NULL DEFAULT FALSE,
+		audience TEXT NOT NULL,
+		cron TEXT NOT NULL DEFAULT '',
+		send_at DATETIME NOT NULL,
+		last_run_at DATETIME,
+		revision INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL
+	);
+	CREATE INDEX idx_broadcast_schedules_send_at ON broadcast_schedules(send_at);
 
-// Close closes the database connection
-func (s *SQLiteStorage) Close() error {
-	return s.db.Close()
-} // Helper function to marshal/unmarshal complex types if needed
+	ALTER TABLE broadcast_states ADD COLUMN cron TEXT NOT NULL DEFAULT '';
+	`
@@ -0,0 +1,100 @@
+package storage_test
+
+import (
+	"bytes"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"x-ui-bot/internal/storage"
+	"x-ui-bot/internal/storage/storagetest"
+)
+
+func TestSQLiteStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) (storage.Storage, error) {
+		return storage.NewSQLiteStorage(filepath.Join(t.TempDir(), "bot.db"))
+	})
+}
+
+func TestOpenSQLiteCopyLeavesSourceUntouched(t *testing.T) {
+	srcDir := t.TempDir()
+	srcPath := filepath.Join(srcDir, "bot.db")
+
+	src, err := storage.NewSQLiteStorage(srcPath)
+	if err != nil {
+		t.Fatalf("NewSQLiteStorage: %v", err)
+	}
+	if err := src.UpsertUser(&storage.User{TgID: 42, Email: "alice", Status: storage.UserStatusActive}); err != nil {
+		t.Fatalf("UpsertUser: %v", err)
+	}
+	if err := src.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// Roll the source back one version so opening it would migrate it
+	db, err := sql.Open("sqlite", srcPath)
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	_, err = db.Exec(`
+		ALTER TABLE users DROP COLUMN tg_username;
+		DELETE FROM schema_version WHERE version = (SELECT MAX(version) FROM schema_version);
+	`)
+	_ = db.Close()
+	if err != nil {
+		t.Fatalf("roll back the schema: %v", err)
+	}
+
+	before, err := os.ReadFile(srcPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filesBefore := dirNames(t, srcDir)
+
+	copyDir := t.TempDir()
+	imported, err := storage.OpenSQLiteCopy(srcPath, filepath.Join(copyDir, "bot.db"))
+	if err != nil {
+		t.Fatalf("OpenSQLiteCopy: %v", err)
+	}
+	dst, err := storage.NewSQLiteStorage(filepath.Join(t.TempDir(), "bot.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteStorage: %v", err)
+	}
+	if err := storage.CopyData(dst, imported, nil); err != nil {
+		t.Fatalf("CopyData: %v", err)
+	}
+	_ = imported.Close()
+	defer func() { _ = dst.Close() }()
+
+	if user, err := dst.GetUser(42); err != nil || user == nil || user.Email != "alice" {
+		t.Errorf("imported user = %+v, %v, want alice", user, err)
+	}
+
+	after, err := os.ReadFile(srcPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Error("source database changed during the import")
+	}
+	if filesAfter := dirNames(t, srcDir); !reflect.DeepEqual(filesBefore, filesAfter) {
+		t.Errorf("source directory = %v, want %v", filesAfter, filesBefore)
+	}
+}
+
+// dirNames returns the names of the files in dir
+func dirNames(t *testing.T, dir string) []string {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
@@ -0,0 +1,1352 @@
+// Package storagetest checks that a storage.Storage implementation behaves like the others.
+// Every backend must pass it, so services can be run against the in-memory storage and rely on
+// the same results from SQLite and PostgreSQL
+package storagetest
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"x-ui-bot/internal/storage"
+)
+
+// Open returns a new empty storage for a test, it is closed when the test ends
+type Open func(t *testing.T) (storage.Storage, error)
+
+// check exercises one group of interface methods
+type check struct {
+	name string
+	run  func(s storage.Storage) error
+}
+
+var checks = []check{
+	{"user states", checkUserStates},
+	{"registration requests", checkRegistrationRequests},
+	{"admin message states", checkAdminMessageStates},
+	{"user message states", checkUserMessageStates},
+	{"broadcast states", checkBroadcastStates},
+	{"traffic snapshots", checkTrafficSnapshots},
+	{"expiring subscriptions", checkExpiringSubscriptions},
+	{"traffic sync state", checkTrafficSyncState},
+	{"traffic sync orphans and renames", checkTrafficSyncCleanup},
+	{"expired states cleanup", checkCleanupExpiredStates},
+	{"users", checkUsers},
+	{"payments", checkPayments},
+	{"extension requests and receipts", checkExtensionRequests},
+	{"promo codes", checkPromoCodes},
+	{"referrals", checkReferrals},
+	{"audit log", checkAuditLog},
+	{"client refs", checkClientRefs},
+	{"rate limit penalties", checkRateLimitPenalties},
+	{"broadcast jobs", checkBroadcastJobs},
+	{"broadcast schedules", checkBroadcastSchedules},
+}
+
+// Run runs every check as a subtest of t, each on a fresh storage from open
+func Run(t *testing.T, open Open) {
+	for _, c := range checks {
+		t.Run(c.name, func(t *testing.T) {
+			s, err := open(t)
+			if err != nil {
+				t.Fatalf("failed to open storage: %v", err)
+			}
+			t.Cleanup(func() {
+				if err := s.Close(); err != nil {
+					t.Errorf("close: %v", err)
+				}
+			})
+			if err := c.run(s); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// now is a timestamp every backend stores without losing precision
+func now() time.Time {
+	return time.Now().Truncate(time.Millisecond)
+}
+
+// sameTime reports whether two timestamps are equal within the precision of the backends
+func sameTime(a, b time.Time) bool {
+	d := a.Sub(b)
+	return d > -time.Millisecond && d < time.Millisecond
+}
+
+func checkUserStates(s storage.Storage) error {
+	if _, err := s.GetUserState(1); err == nil {
+		return fmt.Errorf("GetUserState of a missing user returned no error")
+	}
+	if err := s.SetUserState(1, "awaiting_email"); err != nil {
+		return err
+	}
+	if err := s.SetUserState(1, "awaiting_duration"); err != nil {
+		return err
+	}
+	state, err := s.GetUserState(1)
+	if err != nil {
+		return err
+	}
+	if state != "awaiting_duration" {
+		return fmt.Errorf("GetUserState = %q after overwrite, want awaiting_duration", state)
+	}
+	if err := s.DeleteUserState(1); err != nil {
+		return err
+	}
+	if _, err := s.GetUserState(1); err == nil {
+		return fmt.Errorf("GetUserState after delete returned no error")
+	}
+	return nil
+}
+
+func checkRegistrationRequests(s storage.Storage) error {
+	ts := now()
+	if err := s.SetRegistrationRequest(1, &storage.RegistrationRequest{Username: "first", Email: "a", Duration: 30, Status: "pending", Timestamp: ts}); err != nil {
+		return err
+	}
+	want := &storage.RegistrationRequest{
+		Username: "alice", TgUsername: "alice_tg", Email: "alice", Duration: 90, Status: "pending",
+		Language: "en", PromoCode: "SPRING", Price: 700, BonusDays: 3, Timestamp: ts,
+	}
+	if err := s.SetRegistrationRequest(1, want); err != nil {
+		return err
+	}
+	if err := s.SetRegistrationRequest(2, &storage.RegistrationRequest{Username: "bob", Email: "bob", Duration: 30, Status: "pending", Timestamp: ts}); err != nil {
+		return err
+	}
+
+	got, err := s.GetRegistrationRequest(1)
+	if err != nil {
+		return err
+	}
+	if got.UserID != 1 || got.Username != want.Username || got.TgUsername != want.TgUsername || got.Email != want.Email ||
+		got.Duration != want.Duration || got.Status != want.Status || got.Language != want.Language ||
+		got.PromoCode != want.PromoCode || got.Price != want.Price || got.BonusDays != want.BonusDays || !sameTime(got.Timestamp, ts) {
+		return fmt.Errorf("GetRegistrationRequest = %+v, want %+v", got, want)
+	}
+
+	all, err := s.GetAllRegistrationRequests()
+	if err != nil {
+		return err
+	}
+	if len(all) != 2 || all[1] == nil || all[2] == nil || all[2].UserID != 2 {
+		return fmt.Errorf("GetAllRegistrationRequests returned %d requests, want users 1 and 2", len(all))
+	}
+
+	if err := s.DeleteRegistrationRequest(1); err != nil {
+		return err
+	}
+	if _, err := s.GetRegistrationRequest(1); err == nil {
+		return fmt.Errorf("GetRegistrationRequest after delete returned no error")
+	}
+	return nil
+}
+
+func checkAdminMessageStates(s storage.Storage) error {
+	ts := now()
+	if err := s.SetAdminMessageState(10, &storage.AdminMessageState{ClientEmail: "old", Timestamp: ts}); err != nil {
+		return err
+	}
+	want := &storage.AdminMessageState{ClientEmail: "alice", ClientTgID: "42", InboundID: 3, ClientIndex: 5, Timestamp: ts}
+	if err := s.SetAdminMessageState(10, want); err != nil {
+		return err
+	}
+	got, err := s.GetAdminMessageState(10)
+	if err != nil {
+		return err
+	}
+	if got.ClientEmail != want.ClientEmail || got.ClientTgID != want.ClientTgID || got.InboundID != want.InboundID ||
+		got.ClientIndex != want.ClientIndex || !sameTime(got.Timestamp, ts) {
+		return fmt.Errorf("GetAdminMessageState = %+v, want %+v", got, want)
+	}
+	if err := s.DeleteAdminMessageState(10); err != nil {
+		return err
+	}
+	if _, err := s.GetAdminMessageState(10); err == nil {
+		return fmt.Errorf("GetAdminMessageState after delete returned no error")
+	}
+	return nil
+}
+
+func checkUserMessageStates(s storage.Storage) error {
+	ts := now()
+	want := &storage.UserMessageState{Username: "alice", TgUsername: "alice_tg", Timestamp: ts}
+	if err := s.SetUserMessageState(7, want); err != nil {
+		return err
+	}
+	got, err := s.GetUserMessageState(7)
+	if err != nil {
+		return err
+	}
+	if got.UserID != 7 || got.Username != want.Username || got.TgUsername != want.TgUsername || !sameTime(got.Timestamp, ts) {
+		return fmt.Errorf("GetUserMessageState = %+v, want user 7 %+v", got, want)
+	}
+	if err := s.DeleteUserMessageState(7); err != nil {
+		return err
+	}
+	if _, err := s.GetUserMessageState(7); err == nil {
+		return fmt.Errorf("GetUserMessageState after delete returned no error")
+	}
+	return nil
+}
+
+func checkBroadcastStates(s storage.Storage) error {
+	ts := now()
+	draft := storage.BroadcastContent{
+		Message: "draft",
+		Media:   []storage.BroadcastMedia{{Type: storage.BroadcastMediaPhoto, FileID: "photo-1"}},
+		Buttons: []storage.BroadcastButton{{Text: "Site", URL: "https://example.com"}},
+		Pin:     true,
+		SendAt:  ts.Add(time.Hour),
+	}
+	if err := s.SetBroadcastState(10, &storage.BroadcastState{BroadcastContent: draft, Cron: "0 9 * * 1", Timestamp: ts}); err != nil {
+		return err
+	}
+	got, err := s.GetBroadcastState(10)
+	if err != nil {
+		return err
+	}
+	if got.Message != "draft" || len(got.Media) != 1 || got.Media[0] != draft.Media[0] ||
+		len(got.Buttons) != 1 || got.Buttons[0] != draft.Buttons[0] || !got.Pin || !sameTime(got.SendAt, draft.SendAt) ||
+		got.Cron != "0 9 * * 1" {
+		return fmt.Errorf("GetBroadcastState = %+v, want the media, buttons, pin, send time and cron spec kept", got)
+	}
+
+	final := storage.BroadcastContent{Message: "<b>final</b>"}
+	if err := s.SetBroadcastState(10, &storage.BroadcastState{BroadcastContent: final, Timestamp: ts}); err != nil {
+		return err
+	}
+	if got, err = s.GetBroadcastState(10); err != nil {
+		return err
+	}
+	if got.Message != "<b>final</b>" || !sameTime(got.Timestamp, ts) {
+		return fmt.Errorf("GetBroadcastState = %+v, want the overwritten message", got)
+	}
+	if len(got.Media) != 0 || len(got.Buttons) != 0 || got.Pin || !got.SendAt.IsZero() || got.Cron != "" {
+		return fmt.Errorf("GetBroadcastState = %+v, want the media, buttons, pin, send time and cron spec cleared", got)
+	}
+	if err := s.DeleteBroadcastState(10); err != nil {
+		return err
+	}
+	if _, err := s.GetBroadcastState(10); err == nil {
+		return fmt.Errorf("GetBroadcastState after delete returned no error")
+	}
+	return nil
+}
+
+func checkTrafficSnapshots(s storage.Storage) error {
+	if _, err := s.GetLatestTrafficSnapshot("nl", 1); err == nil {
+		return fmt.Errorf("GetLatestTrafficSnapshot without snapshots returned no error")
+	}
+
+	base := now().Add(-10 * time.Hour)
+	snapshots := []*storage.TrafficSnapshot{
+		{Panel: "nl", InboundID: 1, Timestamp: base.Add(4 * time.Hour), UploadBytes: 1, DownloadBytes: 2, TotalBytes: 30},
+		{Panel: "nl", InboundID: 1, Timestamp: base, UploadBytes: 1, DownloadBytes: 2, TotalBytes: 10},
+		{Panel: "nl", InboundID: 1, Timestamp: base.Add(8 * time.Hour), UploadBytes: 1, DownloadBytes: 2, TotalBytes: 50},
+		{Panel: "nl", InboundID: 2, Timestamp: base.Add(9 * time.Hour), TotalBytes: 99},
+		{Panel: "de", InboundID: 1, Timestamp: base.Add(9 * time.Hour), TotalBytes: 99},
+	}
+	for _, ts := range snapshots {
+		if err := s.SaveTrafficSnapshot(ts); err != nil {
+			return err
+		}
+	}
+
+	got, err := s.GetTrafficSnapshots("nl", 1, base, base.Add(4*time.Hour))
+	if err != nil {
+		return err
+	}
+	if len(got) != 2 || got[0].TotalBytes != 10 || got[1].TotalBytes != 30 || got[0].Panel != "nl" || got[0].UploadBytes != 1 || got[0].DownloadBytes != 2 {
+		return fmt.Errorf("GetTrafficSnapshots returned %d snapshots, want the 2 of the range oldest first", len(got))
+	}
+	if got[0].ID == 0 || got[0].ID == got[1].ID {
+		return fmt.Errorf("GetTrafficSnapshots returned snapshots without distinct ids")
+	}
+
+	latest, err := s.GetLatestTrafficSnapshot("nl", 1)
+	if err != nil {
+		return err
+	}
+	if latest.TotalBytes != 50 || !sameTime(latest.Timestamp, base.Add(8*time.Hour)) {
+		return fmt.Errorf("GetLatestTrafficSnapshot = %d bytes, want the newest snapshot with 50", latest.TotalBytes)
+	}
+
+	if err := s.DeleteOldTrafficSnapshots(base.Add(5 * time.Hour)); err != nil {
+		return err
+	}
+	got, err = s.GetTrafficSnapshots("nl", 1, base, base.Add(10*time.Hour))
+	if err != nil {
+		return err
+	}
+	if len(got) != 1 || got[0].TotalBytes != 50 {
+		return fmt.Errorf("after DeleteOldTrafficSnapshots %d snapshots are left, want the newest one", len(got))
+	}
+	return nil
+}
+
+// expiringEmails returns the emails returned by GetExpiringSubscriptions
+func expiringEmails(s storage.Storage, days int) (map[string]storage.ExpiringSubscription, error) {
+	subs, err := s.GetExpiringSubscriptions(days)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]storage.ExpiringSubscription, len(subs))
+	for _, sub := range subs {
+		result[sub.Email] = sub
+	}
+	return result, nil
+}
+
+func checkExpiringSubscriptions(s storage.Storage) error {
+	day := 24 * time.Hour
+	in := func(d time.Duration) int64 { return time.Now().Add(d).UnixMilli() }
+
+	subs := []struct {
+		email  string
+		tgID   int64
+		expiry int64
+	}{
+		{"in-12h", 1, in(12 * time.Hour)},
+		{"in-2d", 2, in(2*day + time.Hour)},
+		{"in-6d", 3, in(6*day + time.Hour)},
+		{"expired", 4, in(-day)},
+		{"unlimited", 5, 0},
+	}
+	for _, sub := range subs {
+		if err := s.UpsertSubscriptionExpiry(sub.email, sub.tgID, sub.expiry); err != nil {
+			return err
+		}
+	}
+
+	thresholds := []struct {
+		days int
+		want []string
+	}{
+		{1, []string{"in-12h"}},
+		{3, []string{"in-12h", "in-2d"}},
+		{7, []string{"in-12h", "in-2d", "in-6d"}},
+	}
+	for _, th := range thresholds {
+		got, err := expiringEmails(s, th.days)
+		if err != nil {
+			return err
+		}
+		if len(got) != len(th.want) {
+			return fmt.Errorf("GetExpiringSubscriptions(%d) returned %d subscriptions, want %v", th.days, len(got), th.want)
+		}
+		for _, email := range th.want {
+			if _, ok := got[email]; !ok {
+				return fmt.Errorf("GetExpiringSubscriptions(%d) misses %s", th.days, email)
+			}
+		}
+	}
+
+	// Notified days accumulate once each, and reset when the expiry moves
+	for _, days := range []string{"7", "3", "7"} {
+		if err := s.MarkSubscriptionNotified("in-2d", days); err != nil {
+			return err
+		}
+	}
+	got, err := expiringEmails(s, 3)
+	if err != nil {
+		return err
+	}
+	if sub := got["in-2d"]; sub.NotifiedDays != "7,3" || sub.TgID != 2 {
+		return fmt.Errorf("notified days = %q, want \"7,3\"", sub.NotifiedDays)
+	}
+
+	if err := s.UpsertSubscriptionExpiry("in-2d", 2, got["in-2d"].ExpiryTime); err != nil {
+		return err
+	}
+	if got, err = expiringEmails(s, 3); err != nil {
+		return err
+	}
+	if sub := got["in-2d"]; sub.NotifiedDays != "7,3" {
+		return fmt.Errorf("notified days = %q after an upsert with the same expiry, want them kept", sub.NotifiedDays)
+	}
+
+	if err := s.UpsertSubscriptionExpiry("in-2d", 2, in(2*day+2*time.Hour)); err != nil {
+		return err
+	}
+	if got, err = expiringEmails(s, 3); err != nil {
+		return err
+	}
+	if sub := got["in-2d"]; sub.NotifiedDays != "" {
+		return fmt.Errorf("notified days = %q after the expiry moved, want them reset", sub.NotifiedDays)
+	}
+
+	// Deleting expired subscriptions keeps the running and unlimited ones
+	if err := s.DeleteExpiredSubscriptions(); err != nil {
+		return err
+	}
+	if got, err = expiringEmails(s, 7); err != nil {
+		return err
+	}
+	if len(got) != 3 {
+		return fmt.Errorf("DeleteExpiredSubscriptions removed running subscriptions, %d are left", len(got))
+	}
+	if err := s.UpsertSubscriptionExpiry("expired", 4, in(time.Hour)); err != nil {
+		return err
+	}
+	if got, err = expiringEmails(s, 1); err != nil {
+		return err
+	}
+	if sub, ok := got["expired"]; !ok || sub.NotifiedDays != "" {
+		return fmt.Errorf("a deleted subscription renewed by an upsert is missing")
+	}
+	return nil
+}
+
+func checkTrafficSyncState(s storage.Storage) error {
+	up, down, err := s.GetTrafficSyncState("alice", 1)
+	if err != nil {
+		return fmt.Errorf("GetTrafficSyncState of a missing record: %w", err)
+	}
+	if up != 0 || down != 0 {
+		return fmt.Errorf("GetTrafficSyncState of a missing record = %d/%d, want 0/0", up, down)
+	}
+
+	if err := s.SetTrafficSyncState("alice", 1, 10, 20); err != nil {
+		return err
+	}
+	if err := s.SetTrafficSyncState("alice", 1, 15, 25); err != nil {
+		return err
+	}
+	if err := s.SetTrafficSyncState("alice", 2, 1, 2); err != nil {
+		return err
+	}
+	if up, down, err = s.GetTrafficSyncState("alice", 1); err != nil {
+		return err
+	}
+	if up != 15 || down != 25 {
+		return fmt.Errorf("GetTrafficSyncState = %d/%d after overwrite, want 15/25", up, down)
+	}
+	if up, down, err = s.GetTrafficSyncState("alice", 2); err != nil {
+		return err
+	}
+	if up != 1 || down != 2 {
+		return fmt.Errorf("GetTrafficSyncState of inbound 2 = %d/%d, want 1/2", up, down)
+	}
+	return nil
+}
+
+func checkTrafficSyncCleanup(s storage.Storage) error {
+	records := []struct {
+		email   string
+		inbound int
+		up      int64
+	}{
+		{"alice", 1, 1}, {"alice", 2, 2}, {"bob", 1, 3}, {"carol", 1, 4}, {"dave", 1, 5},
+	}
+	for _, r := range records {
+		if err := s.SetTrafficSyncState(r.email, r.inbound, r.up, 0); err != nil {
+			return err
+		}
+	}
+	has := func(email string, inbound int) (bool, error) {
+		up, _, err := s.GetTrafficSyncState(email, inbound)
+		return up != 0, err
+	}
+	expect := func(what, email string, inbound int, want bool) error {
+		got, err := has(email, inbound)
+		if err != nil {
+			return err
+		}
+		if got != want {
+			return fmt.Errorf("%s: record of %s in inbound %d present = %v, want %v", what, email, inbound, got, want)
+		}
+		return nil
+	}
+
+	// No active clients means the panel list was not loaded, nothing is removed
+	if err := s.CleanupOrphanedTrafficSyncState(map[string]bool{}); err != nil {
+		return err
+	}
+	if err := expect("cleanup with no active clients", "dave", 1, true); err != nil {
+		return err
+	}
+
+	if err := s.CleanupOrphanedTrafficSyncState(map[string]bool{"alice": true, "bob": true, "carol": true}); err != nil {
+		return err
+	}
+	if err := expect("orphan cleanup", "dave", 1, false); err != nil {
+		return err
+	}
+	if err := expect("orphan cleanup", "alice", 2, true); err != nil {
+		return err
+	}
+
+	// A rename moves every inbound of the client and nothing else
+	if err := s.UpdateTrafficSyncStateEmail("alice", "alice2"); err != nil {
+		return err
+	}
+	for _, inbound := range []int{1, 2} {
+		if err := expect("rename", "alice", inbound, false); err != nil {
+			return err
+		}
+		if err := expect("rename", "alice2", inbound, true); err != nil {
+			return err
+		}
+	}
+	if up, _, err := s.GetTrafficSyncState("alice2", 2); err != nil || up != 2 {
+		return fmt.Errorf("rename: inbound 2 of alice2 has up %d, want the counters of alice (err %v)", up, err)
+	}
+	if err := expect("rename", "bob", 1, true); err != nil {
+		return err
+	}
+
+	if err := s.DeleteTrafficSyncStateForEmail("alice2"); err != nil {
+		return err
+	}
+	for _, inbound := range []int{1, 2} {
+		if err := expect("delete", "alice2", inbound, false); err != nil {
+			return err
+		}
+	}
+	return expect("delete", "carol", 1, true)
+}
+
+func checkCleanupExpiredStates(s storage.Storage) error {
+	old := now().Add(-48 * time.Hour)
+	fresh := now()
+
+	if err := s.SetRegistrationRequest(1, &storage.RegistrationRequest{Username: "old", Email: "old", Status: "pending", Timestamp: old}); err != nil {
+		return err
+	}
+	if err := s.SetRegistrationRequest(2, &storage.RegistrationRequest{Username: "new", Email: "new", Status: "pending", Timestamp: fresh}); err != nil {
+		return err
+	}
+	if err := s.SetAdminMessageState(10, &storage.AdminMessageState{ClientEmail: "old", Timestamp: old}); err != nil {
+		return err
+	}
+	if err := s.SetUserMessageState(3, &storage.UserMessageState{Username: "old", Timestamp: old}); err != nil {
+		return err
+	}
+	if err := s.SetBroadcastState(10, &storage.BroadcastState{BroadcastContent: storage.BroadcastContent{Message: "new"}, Timestamp: fresh}); err != nil {
+		return err
+	}
+	if err := s.SaveTrafficSnapshot(&storage.TrafficSnapshot{Panel: "nl", InboundID: 1, Timestamp: old}); err != nil {
+		return err
+	}
+
+	if err := s.CleanupExpiredStates(24 * time.Hour); err != nil {
+		return err
+	}
+
+	if _, err := s.GetRegistrationRequest(1); err == nil {
+		return fmt.Errorf("an old registration request was kept")
+	}
+	if _, err := s.GetRegistrationRequest(2); err != nil {
+		return fmt.Errorf("a fresh registration request was removed: %w", err)
+	}
+	if _, err := s.GetAdminMessageState(10); err == nil {
+		return fmt.Errorf("an old admin message state was kept")
+	}
+	if _, err := s.GetUserMessageState(3); err == nil {
+		return fmt.Errorf("an old user message state was kept")
+	}
+	if _, err := s.GetBroadcastState(10); err != nil {
+		return fmt.Errorf("a fresh broadcast state was removed: %w", err)
+	}
+	if _, err := s.GetLatestTrafficSnapshot("nl", 1); err == nil {
+		return fmt.Errorf("an old traffic snapshot was kept")
+	}
+	return nil
+}
+
+func checkUsers(s storage.Storage) error {
+	if _, err := s.GetUser(1); err == nil {
+		return fmt.Errorf("GetUser of a missing user returned no error")
+	}
+
+	registered := now().Add(-time.Hour)
+	if err := s.UpsertUser(&storage.User{TgID: 1, Email: "alice", SubID: "sub1", Panel: "nl", InboundIDs: []int{1, 3},
+		Language: "en", Status: storage.UserStatusActive, RegisteredAt: registered}); err != nil {
+		return err
+	}
+	// An update keeps the registration date and a known language
+	if err := s.UpsertUser(&storage.User{TgID: 1, Email: "alice2", SubID: "sub1", Panel: "de", InboundIDs: []int{2},
+		Status: storage.UserStatusDisabled, RegisteredAt: now()}); err != nil {
+		return err
+	}
+	got, err := s.GetUser(1)
+	if err != nil {
+		return err
+	}
+	if got.Email != "alice2" || got.Panel != "de" || got.Status != storage.UserStatusDisabled || got.Language != "en" ||
+		len(got.InboundIDs) != 1 || got.InboundIDs[0] != 2 || !sameTime(got.RegisteredAt, registered) || got.UpdatedAt.IsZero() {
+		return fmt.Errorf("GetUser after update = %+v", got)
+	}
+
+	if err := s.UpsertUser(&storage.User{TgID: 2, Email: "bob", Status: storage.UserStatusActive, RegisteredAt: registered.Add(-time.Hour)}); err != nil {
+		return err
+	}
+	if err := s.UpsertUser(&storage.User{TgID: 3, Email: "carol", Status: storage.UserStatusActive}); err != nil {
+		return err
+	}
+	all, err := s.GetAllUsers()
+	if err != nil {
+		return err
+	}
+	if len(all) != 3 || all[0].TgID != 2 || all[1].TgID != 1 || all[2].TgID != 3 {
+		return fmt.Errorf("GetAllUsers returned %d users, want 3 ordered by registration", len(all))
+	}
+	if all[2].RegisteredAt.IsZero() {
+		return fmt.Errorf("a user inserted without a registration date got none")
+	}
+
+	if err := s.SetUserLanguage(1, "ru"); err != nil {
+		return err
+	}
+	if got, err = s.GetUser(1); err != nil {
+		return err
+	}
+	if got.Language != "ru" {
+		return fmt.Errorf("language = %q after SetUserLanguage, want ru", got.Language)
+	}
+
+	// The trial flag survives updates from the panels until it is cleared
+	if got.Trial {
+		return fmt.Errorf("a new user is marked as trial")
+	}
+	if err := s.SetUserTrial(1, true); err != nil {
+		return err
+	}
+	if err := s.UpsertUser(&storage.User{TgID: 1, Email: "alice2", Status: storage.UserStatusActive}); err != nil {
+		return err
+	}
+	if got, err = s.GetUser(1); err != nil {
+		return err
+	}
+	if !got.Trial {
+		return fmt.Errorf("an update from the panel cleared the trial flag")
+	}
+	if err := s.SetUserTrial(1, false); err != nil {
+		return err
+	}
+	if got, err = s.GetUser(1); err != nil {
+		return err
+	}
+	if got.Trial {
+		return fmt.Errorf("SetUserTrial(false) kept the trial flag")
+	}
+
+	// The Telegram username survives updates from the panels too
+	if err := s.SetUserTgUsername(1, "alice_tg"); err != nil {
+		return err
+	}
+	if err := s.UpsertUser(&storage.User{TgID: 1, Email: "alice3", Status: storage.UserStatusActive}); err != nil {
+		return err
+	}
+	if got, err = s.GetUser(1); err != nil {
+		return err
+	}
+	if got.TgUsername != "alice_tg" {
+		return fmt.Errorf("Telegram username = %q after an update from the panel, want alice_tg", got.TgUsername)
+	}
+
+	if err := s.DeleteUser(3); err != nil {
+		return err
+	}
+	if _, err := s.GetUser(3); err == nil {
+		return fmt.Errorf("GetUser after delete returned no error")
+	}
+
+	if err := s.CleanupOrphanedUsers(map[int64]bool{1: true}); err != nil {
+		return err
+	}
+	if all, err = s.GetAllUsers(); err != nil {
+		return err
+	}
+	if len(all) != 1 || all[0].TgID != 1 {
+		return fmt.Errorf("CleanupOrphanedUsers left %d users, want only the active one", len(all))
+	}
+	return nil
+}
+
+func checkPayments(s storage.Storage) error {
+	p := &storage.Payment{UserID: 1, TelegramChargeID: "tg-1", ProviderChargeID: "pr-1", Payload: "extend_30",
+		Duration: 30, Amount: 30000, Currency: "RUB", Status: storage.PaymentStatusPaid}
+	if err := s.AddPayment(p); err != nil {
+		return err
+	}
+	if p.ID == 0 || p.CreatedAt.IsZero() || p.UpdatedAt.IsZero() {
+		return fmt.Errorf("AddPayment did not set the id and timestamps: %+v", p)
+	}
+	if err := s.AddPayment(&storage.Payment{UserID: 1, TelegramChargeID: "tg-1", Payload: "x", Currency: "RUB", Status: storage.PaymentStatusPaid}); !errors.Is(err, storage.ErrPaymentExists) {
+		return fmt.Errorf("AddPayment of a recorded charge = %v, want ErrPaymentExists", err)
+	}
+	second := &storage.Payment{UserID: 2, TelegramChargeID: "tg-2", Payload: "extend_90", Currency: "RUB", Status: storage.PaymentStatusPaid}
+	if err := s.AddPayment(second); err != nil {
+		return err
+	}
+	if second.ID == p.ID {
+		return fmt.Errorf("two payments got id %d", p.ID)
+	}
+
+	if err := s.SetPaymentStatus(p.ID, storage.PaymentStatusApplied); err != nil {
+		return err
+	}
+	got, err := s.GetPaymentByChargeID("tg-1")
+	if err != nil {
+		return err
+	}
+	if got.ID != p.ID || got.Status != storage.PaymentStatusApplied || got.Amount != 30000 || got.ProviderChargeID != "pr-1" ||
+		got.Duration != 30 || got.Payload != "extend_30" || got.Currency != "RUB" {
+		return fmt.Errorf("GetPaymentByChargeID = %+v", got)
+	}
+	if _, err := s.GetPaymentByChargeID("missing"); err == nil {
+		return fmt.Errorf("GetPaymentByChargeID of a missing charge returned no error")
+	}
+	return nil
+}
+
+func checkExtensionRequests(s storage.Storage) error {
+	first := &storage.ExtensionRequest{UserID: 1, Duration: 30, Price: 300, PromoCode: "X", BonusDays: 2, Status: storage.ExtensionStatusAwaitingReceipt}
+	second := &storage.ExtensionRequest{UserID: 1, Duration: 90, Price: 800, Status: storage.ExtensionStatusAwaitingReceipt}
+	for _, req := range []*storage.ExtensionRequest{first, second} {
+		if err := s.CreateExtensionRequest(req); err != nil {
+			return err
+		}
+	}
+	if first.ID == 0 || second.ID <= first.ID || first.CreatedAt.IsZero() {
+		return fmt.Errorf("CreateExtensionRequest set ids %d and %d, want increasing ids", first.ID, second.ID)
+	}
+
+	open, err := s.GetOpenExtensionRequest(1)
+	if err != nil {
+		return err
+	}
+	if open.ID != second.ID {
+		return fmt.Errorf("GetOpenExtensionRequest = %d, want the latest request %d", open.ID, second.ID)
+	}
+
+	if err := s.SetExtensionRequestStatus(second.ID, storage.ExtensionStatusPending); err != nil {
+		return err
+	}
+	if open, err = s.GetOpenExtensionRequest(1); err != nil {
+		return err
+	}
+	if open.ID != first.ID {
+		return fmt.Errorf("GetOpenExtensionRequest = %d after the latest was sent, want %d", open.ID, first.ID)
+	}
+
+	if ok, err := s.DecideExtensionRequest(second.ID, storage.ExtensionStatusApproved, 99); err != nil || !ok {
+		return fmt.Errorf("DecideExtensionRequest = %v, want true (err %v)", ok, err)
+	}
+	// A second admin deciding at the same time loses
+	if ok, err := s.DecideExtensionRequest(second.ID, storage.ExtensionStatusRejected, 98); err != nil || ok {
+		return fmt.Errorf("DecideExtensionRequest of a decided request = %v, want false (err %v)", ok, err)
+	}
+	got, err := s.GetExtensionRequest(second.ID)
+	if err != nil {
+		return err
+	}
+	if got.Status != storage.ExtensionStatusApproved || got.DecidedBy != 99 || got.DecidedAt.IsZero() || got.Price != 800 || got.Duration != 90 {
+		return fmt.Errorf("GetExtensionRequest after approval = %+v", got)
+	}
+	if got, err = s.GetExtensionRequest(first.ID); err != nil {
+		return err
+	}
+	if got.PromoCode != "X" || got.BonusDays != 2 || got.DecidedBy != 0 || !got.DecidedAt.IsZero() {
+		return fmt.Errorf("GetExtensionRequest of an undecided request = %+v", got)
+	}
+	if err := s.SetExtensionRequestStatus(first.ID, storage.ExtensionStatusCancelled); err != nil {
+		return err
+	}
+	if ok, err := s.DecideExtensionRequest(first.ID, storage.ExtensionStatusApproved, 99); err != nil || ok {
+		return fmt.Errorf("DecideExtensionRequest of a cancelled request = %v, want false (err %v)", ok, err)
+	}
+
+	// Undoing a decision reopens the request
+	if err := s.SetExtensionRequestStatus(second.ID, storage.ExtensionStatusPending); err != nil {
+		return err
+	}
+	if got, err = s.GetExtensionRequest(second.ID); err != nil {
+		return err
+	}
+	if got.Status != storage.ExtensionStatusPending || got.DecidedBy != 0 || !got.DecidedAt.IsZero() {
+		return fmt.Errorf("GetExtensionRequest after the decision was undone = %+v", got)
+	}
+	if _, err := s.GetOpenExtensionRequest(1); err == nil {
+		return fmt.Errorf("GetOpenExtensionRequest without open requests returned no error")
+	}
+	if _, err := s.GetExtensionRequest(9999); err == nil {
+		return fmt.Errorf("GetExtensionRequest of a missing request returned no error")
+	}
+
+	receipts := []*storage.Receipt{
+		{RequestID: second.ID, UserID: 1, FileID: "photo-1", FileType: storage.ReceiptTypePhoto},
+		{RequestID: second.ID, UserID: 1, FileID: "doc-1", FileType: storage.ReceiptTypeDocument, FileName: "receipt.pdf", MimeType: "application/pdf"},
+		{RequestID: first.ID, UserID: 1, FileID: "photo-2", FileType: storage.ReceiptTypePhoto},
+	}
+	for _, r := range receipts {
+		if err := s.AddReceipt(r); err != nil {
+			return err
+		}
+		if r.ID == 0 || r.CreatedAt.IsZero() {
+			return fmt.Errorf("AddReceipt did not set the id and creation time")
+		}
+	}
+	gotReceipts, err := s.GetReceipts(second.ID)
+	if err != nil {
+		return err
+	}
+	if len(gotReceipts) != 2 || gotReceipts[0].FileID != "photo-1" || gotReceipts[1].FileName != "receipt.pdf" || gotReceipts[1].MimeType != "application/pdf" {
+		return fmt.Errorf("GetReceipts returned %d receipts, want the 2 of the request in order", len(gotReceipts))
+	}
+	return nil
+}
+
+func checkPromoCodes(s storage.Storage) error {
+	expires := now().Add(72 * time.Hour)
+	promo := &storage.PromoCode{Code: "SPRING", Type: storage.PromoTypePercent, Value: 20, MaxUses: 2, PerUserLimit: 1, ExpiresAt: expires, CreatedBy: 10}
+	if err := s.CreatePromoCode(promo); err != nil {
+		return err
+	}
+	if promo.CreatedAt.IsZero() {
+		return fmt.Errorf("CreatePromoCode did not set the creation time")
+	}
+	if err := s.CreatePromoCode(&storage.PromoCode{Code: "SPRING", Type: storage.PromoTypeFixed, Value: 1}); err == nil {
+		return fmt.Errorf("a promo code was created twice")
+	}
+	if err := s.CreatePromoCode(&storage.PromoCode{Code: "DAYS", Type: storage.PromoTypeDays, Value: 7, CreatedAt: now().Add(time.Hour)}); err != nil {
+		return err
+	}
+
+	got, err := s.GetPromoCode("SPRING")
+	if err != nil {
+		return err
+	}
+	if got.Type != storage.PromoTypePercent || got.Value != 20 || got.MaxUses != 2 || got.PerUserLimit != 1 || got.CreatedBy != 10 || !sameTime(got.ExpiresAt, expires) {
+		return fmt.Errorf("GetPromoCode = %+v", got)
+	}
+	if got, err = s.GetPromoCode("DAYS"); err != nil {
+		return err
+	}
+	if !got.ExpiresAt.IsZero() {
+		return fmt.Errorf("a code without expiry got %v", got.ExpiresAt)
+	}
+	all, err := s.GetAllPromoCodes()
+	if err != nil {
+		return err
+	}
+	if len(all) != 2 || all[0].Code != "DAYS" {
+		return fmt.Errorf("GetAllPromoCodes returned %d codes, want 2 newest first", len(all))
+	}
+
+	// The per-user limit and then the usage cap refuse further redemptions
+	r := &storage.PromoRedemption{Code: "SPRING", UserID: 1, Purpose: storage.PromoPurposeRegistration, Price: 240, BonusDays: 0}
+	if err := s.RedeemPromoCode(r); err != nil {
+		return err
+	}
+	if r.ID == 0 || r.CreatedAt.IsZero() {
+		return fmt.Errorf("RedeemPromoCode did not set the id and creation time")
+	}
+	if err := s.RedeemPromoCode(&storage.PromoRedemption{Code: "SPRING", UserID: 1, Purpose: storage.PromoPurposeExtension}); err == nil {
+		return fmt.Errorf("the per-user limit allowed a second redemption")
+	}
+	if err := s.RedeemPromoCode(&storage.PromoRedemption{Code: "SPRING", UserID: 2, Purpose: storage.PromoPurposeExtension}); err != nil {
+		return err
+	}
+	if err := s.RedeemPromoCode(&storage.PromoRedemption{Code: "SPRING", UserID: 3, Purpose: storage.PromoPurposeExtension}); err == nil {
+		return fmt.Errorf("the usage cap allowed a third redemption")
+	}
+	if got, err = s.GetPromoCode("SPRING"); err != nil {
+		return err
+	}
+	if got.Uses != 2 {
+		return fmt.Errorf("uses = %d, want 2", got.Uses)
+	}
+	if n, err := s.CountPromoRedemptions("SPRING", 1); err != nil || n != 1 {
+		return fmt.Errorf("CountPromoRedemptions = %d, want 1 (err %v)", n, err)
+	}
+	if n, err := s.CountPromoRedemptions("SPRING", 3); err != nil || n != 0 {
+		return fmt.Errorf("CountPromoRedemptions of a refused user = %d, want 0 (err %v)", n, err)
+	}
+	if err := s.RedeemPromoCode(&storage.PromoRedemption{Code: "MISSING", UserID: 1}); err == nil {
+		return fmt.Errorf("a missing code was redeemed")
+	}
+
+	if err := s.DeletePromoCode("SPRING"); err != nil {
+		return err
+	}
+	if _, err := s.GetPromoCode("SPRING"); err == nil {
+		return fmt.Errorf("GetPromoCode after delete returned no error")
+	}
+	if err := s.DeletePromoCode("SPRING"); err == nil {
+		return fmt.Errorf("deleting a missing code returned no error")
+	}
+	if n, err := s.CountPromoRedemptions("SPRING", 1); err != nil || n != 1 {
+		return fmt.Errorf("redemptions of a deleted code = %d, want them kept (err %v)", n, err)
+	}
+	return nil
+}
+
+func checkReferrals(s storage.Storage) error {
+	if _, err := s.GetReferral(2); err == nil {
+		return fmt.Errorf("GetReferral of a missing referral returned no error")
+	}
+	for _, r := range []*storage.Referral{
+		{ReferredID: 2, ReferrerID: 1},
+		{ReferredID: 2, ReferrerID: 5}, // The first referrer is kept
+		{ReferredID: 3, ReferrerID: 1},
+	} {
+		if err := s.AddReferral(r); err != nil {
+			return err
+		}
+	}
+
+	got, err := s.GetReferral(2)
+	if err != nil {
+		return err
+	}
+	if got.ReferrerID != 1 || got.BonusDays != 0 || !got.RewardedAt.IsZero() || got.CreatedAt.IsZero() {
+		return fmt.Errorf("GetReferral = %+v, want the first referrer unrewarded", got)
+	}
+
+	if ok, err := s.MarkReferralRewarded(2, 7); err != nil || !ok {
+		return fmt.Errorf("MarkReferralRewarded = %v, want true (err %v)", ok, err)
+	}
+	if ok, err := s.MarkReferralRewarded(2, 7); err != nil || ok {
+		return fmt.Errorf("MarkReferralRewarded of a rewarded referral = %v, want false (err %v)", ok, err)
+	}
+	if got, err = s.GetReferral(2); err != nil {
+		return err
+	}
+	if got.BonusDays != 7 || got.RewardedAt.IsZero() {
+		return fmt.Errorf("GetReferral after reward = %+v", got)
+	}
+
+	stats, err := s.GetReferralStats(1)
+	if err != nil {
+		return err
+	}
+	if stats.Invited != 2 || stats.Rewarded != 1 || stats.BonusDays != 7 {
+		return fmt.Errorf("GetReferralStats = %+v, want 2 invited, 1 rewarded, 7 days", stats)
+	}
+	if stats, err = s.GetReferralStats(5); err != nil {
+		return err
+	}
+	if stats.Invited != 0 || stats.BonusDays != 0 {
+		return fmt.Errorf("GetReferralStats of a user without referrals = %+v", stats)
+	}
+	return nil
+}
+
+func checkAuditLog(s storage.Storage) error {
+	entries := []*storage.AuditEntry{
+		{ActorID: 10, Action: storage.AuditActionBlock, TargetEmail: "Alice", TargetTgID: 1, Before: "enabled", After: "disabled"},
+		{ActorID: 10, Action: storage.AuditActionExtend, TargetEmail: "bob", TargetTgID: 2},
+		{ActorID: 11, Action: storage.AuditActionBlock, TargetEmail: "carol", TargetTgID: 1234},
+		{ActorID: 11, Action: storage.AuditActionDelete, TargetEmail: "user1234", TargetTgID: 3},
+	}
+	for _, e := range entries {
+		if err := s.AddAuditEntry(e); err != nil {
+			return err
+		}
+		if e.ID == 0 || e.CreatedAt.IsZero() {
+			return fmt.Errorf("AddAuditEntry did not set the id and creation time")
+		}
+	}
+
+	cases := []struct {
+		filter storage.AuditFilter
+		want   []int // Indexes of the expected entries, newest first
+	}{
+		{storage.AuditFilter{}, []int{3, 2, 1, 0}},
+		{storage.AuditFilter{ActorID: 10}, []int{1, 0}},
+		{storage.AuditFilter{Action: storage.AuditActionBlock}, []int{2, 0}},
+		{storage.AuditFilter{Target: "ALI"}, []int{0}},          // Case-insensitive email substring
+		{storage.AuditFilter{Target: "1234"}, []int{3, 2}},      // Telegram ID or email substring
+		{storage.AuditFilter{Limit: 2, Offset: 1}, []int{2, 1}}, // A page
+		{storage.AuditFilter{ActorID: 11, Limit: 1}, []int{3}},  // Filter and page
+		{storage.AuditFilter{Action: "missing"}, nil},
+	}
+	for _, c := range cases {
+		got, err := s.GetAuditEntries(c.filter)
+		if err != nil {
+			return err
+		}
+		if len(got) != len(c.want) {
+			return fmt.Errorf("GetAuditEntries(%+v) returned %d entries, want %d", c.filter, len(got), len(c.want))
+		}
+		for i, idx := range c.want {
+			if got[i].ID != entries[idx].ID {
+				return fmt.Errorf("GetAuditEntries(%+v)[%d] = entry %d, want %d", c.filter, i, got[i].ID, entries[idx].ID)
+			}
+		}
+
+		count, err := s.CountAuditEntries(storage.AuditFilter{ActorID: c.filter.ActorID, Action: c.filter.Action, Target: c.filter.Target})
+		if err != nil {
+			return err
+		}
+		if c.filter.Limit == 0 && count != len(c.want) {
+			return fmt.Errorf("CountAuditEntries(%+v) = %d, want %d", c.filter, count, len(c.want))
+		}
+	}
+
+	got, err := s.GetAuditEntries(storage.AuditFilter{Action: storage.AuditActionBlock, Target: "alice"})
+	if err != nil {
+		return err
+	}
+	if len(got) != 1 || got[0].Before != "enabled" || got[0].After != "disabled" || got[0].ActorID != 10 || got[0].TargetTgID != 1 {
+		return fmt.Errorf("audit entry fields were not kept: %+v", got)
+	}
+	return nil
+}
+
+func checkClientRefs(s storage.Storage) error {
+	first, err := s.GetOrCreateClientRef("nl", "alice__1", 1)
+	if err != nil {
+		return err
+	}
+	again, err := s.GetOrCreateClientRef("nl", "alice__1", 1)
+	if err != nil {
+		return err
+	}
+	if first.ID == 0 || again.ID != first.ID {
+		return fmt.Errorf("GetOrCreateClientRef returned ids %d and %d for the same client", first.ID, again.ID)
+	}
+
+	// The same email under another Telegram ID or panel is another client
+	for _, c := range []struct {
+		panel string
+		tgID  int64
+	}{{"nl", 2}, {"de", 1}} {
+		other, err := s.GetOrCreateClientRef(c.panel, "alice__1", c.tgID)
+		if err != nil {
+			return err
+		}
+		if other.ID == first.ID {
+			return fmt.Errorf("client %s/%d got the ref of nl/1", c.panel, c.tgID)
+		}
+	}
+
+	got, err := s.GetClientRef(first.ID)
+	if err != nil {
+		return err
+	}
+	if got.Panel != "nl" || got.Email != "alice__1" || got.TgID != 1 || got.CreatedAt.IsZero() {
+		return fmt.Errorf("GetClientRef = %+v", got)
+	}
+	if _, err := s.GetClientRef(9999); err == nil {
+		return fmt.Errorf("GetClientRef of a missing ref returned no error")
+	}
+	return nil
+}
+
+func checkRateLimitPenalties(s storage.Storage) error {
+	empty, err := s.GetRateLimitPenalty(1)
+	if err != nil {
+		return err
+	}
+	if empty.TgID != 1 || empty.Strikes != 0 || empty.Violations != 0 || !empty.BlockedUntil.IsZero() {
+		return fmt.Errorf("GetRateLimitPenalty of a user without one = %+v, want an empty record", empty)
+	}
+
+	ts := now()
+	blocked := ts.Add(time.Hour)
+	if err := s.SaveRateLimitPenalty(&storage.RateLimitPenalty{TgID: 1, Violations: 3, UpdatedAt: ts}); err != nil {
+		return err
+	}
+	if err := s.SaveRateLimitPenalty(&storage.RateLimitPenalty{TgID: 1, Violations: 0, Strikes: 2, BlockedUntil: blocked, UpdatedAt: ts}); err != nil {
+		return err
+	}
+	got, err := s.GetRateLimitPenalty(1)
+	if err != nil {
+		return err
+	}
+	if got.Violations != 0 || got.Strikes != 2 || !sameTime(got.BlockedUntil, blocked) || !sameTime(got.UpdatedAt, ts) {
+		return fmt.Errorf("GetRateLimitPenalty = %+v after overwrite", got)
+	}
+
+	// Cleanup forgets stale penalties that no longer block
+	stale := ts.Add(-48 * time.Hour)
+	penalties := []*storage.RateLimitPenalty{
+		{TgID: 2, Strikes: 1, BlockedUntil: stale.Add(time.Minute), UpdatedAt: stale},
+		{TgID: 3, Violations: 2, UpdatedAt: stale},
+		{TgID: 4, Strikes: 5, BlockedUntil: ts.Add(24 * time.Hour), UpdatedAt: stale},
+	}
+	for _, p := range penalties {
+		if err := s.SaveRateLimitPenalty(p); err != nil {
+			return err
+		}
+	}
+	if err := s.CleanupRateLimitPenalties(24 * time.Hour); err != nil {
+		return err
+	}
+	for _, c := range []struct {
+		tgID    int64
+		strikes int
+	}{{1, 2}, {2, 0}, {3, 0}, {4, 5}} {
+		got, err := s.GetRateLimitPenalty(c.tgID)
+		if err != nil {
+			return err
+		}
+		if got.Strikes != c.strikes {
+			return fmt.Errorf("user %d has %d strikes after cleanup, want %d", c.tgID, got.Strikes, c.strikes)
+		}
+	}
+	if got, err = s.GetRateLimitPenalty(3); err != nil {
+		return err
+	}
+	if got.Violations != 0 {
+		return fmt.Errorf("a stale penalty without a block was kept")
+	}
+	return nil
+}
+
+func checkBroadcastJobs(s storage.Storage) error {
+	if _, err := s.GetBroadcastJob(1); err == nil {
+		return fmt.Errorf("GetBroadcastJob of a missing job returned no error")
+	}
+
+	content := storage.BroadcastContent{
+		Message: "<b>news</b>",
+		Media: []storage.BroadcastMedia{
+			{Type: storage.BroadcastMediaPhoto, FileID: "photo-1"},
+			{Type: storage.BroadcastMediaVideo, FileID: "video-1"},
+		},
+		Buttons: []storage.BroadcastButton{{Text: "Site", URL: "https://example.com"}},
+		Pin:     true,
+		SendAt:  now().Add(time.Hour),
+	}
+	job := &storage.BroadcastJob{CreatedBy: 10, BroadcastContent: content, Status: storage.BroadcastStatusQueued, ChatID: 10, MessageID: 55}
+	if err := s.CreateBroadcastJob(job, []int64{3, 1, 2, 1}); err != nil {
+		return err
+	}
+	other := &storage.BroadcastJob{CreatedBy: 11, BroadcastContent: storage.BroadcastContent{Message: "other"}, Status: storage.BroadcastStatusQueued}
+	if err := s.CreateBroadcastJob(other, []int64{1}); err != nil {
+		return err
+	}
+	if job.ID == 0 || other.ID <= job.ID || job.CreatedAt.IsZero() {
+		return fmt.Errorf("CreateBroadcastJob set ids %d and %d, want increasing ids", job.ID, other.ID)
+	}
+
+	got, err := s.GetBroadcastJob(job.ID)
+	if err != nil {
+		return err
+	}
+	if got.CreatedBy != 10 || got.Message != job.Message || got.Status != storage.BroadcastStatusQueued ||
+		got.ChatID != 10 || got.MessageID != 55 || !got.FinishedAt.IsZero() || got.UpdatedAt.IsZero() {
+		return fmt.Errorf("GetBroadcastJob = %+v", got)
+	}
+	if len(got.Media) != 2 || got.Media[1] != content.Media[1] || len(got.Buttons) != 1 || got.Buttons[0] != content.Buttons[0] ||
+		!got.Pin || !sameTime(got.SendAt, content.SendAt) {
+		return fmt.Errorf("GetBroadcastJob = %+v, want the media, buttons, pin and send time kept", got)
+	}
+	if got, err = s.GetBroadcastJob(other.ID); err != nil {
+		return err
+	}
+	if len(got.Media) != 0 || len(got.Buttons) != 0 || got.Pin || !got.SendAt.IsZero() {
+		return fmt.Errorf("GetBroadcastJob = %+v, want a plain text job", got)
+	}
+
+	// Duplicate recipients are stored once and come out in a stable order
+	pending, err := s.GetPendingBroadcastRecipients(job.ID, 2)
+	if err != nil {
+		return err
+	}
+	if len(pending) != 2 || pending[0] != 1 || pending[1] != 2 {
+		return fmt.Errorf("GetPendingBroadcastRecipients = %v, want [1 2]", pending)
+	}
+	if err := s.SetBroadcastRecipientStatus(job.ID, 1, storage.RecipientStatusSent, ""); err != nil {
+		return err
+	}
+	if err := s.SetBroadcastRecipientStatus(job.ID, 2, storage.RecipientStatusFailed, "403 blocked"); err != nil {
+		return err
+	}
+	if pending, err = s.GetPendingBroadcastRecipients(job.ID, 10); err != nil {
+		return err
+	}
+	if len(pending) != 1 || pending[0] != 3 {
+		return fmt.Errorf("GetPendingBroadcastRecipients after two deliveries = %v, want [3]", pending)
+	}
+
+	progress, err := s.GetBroadcastProgress(job.ID)
+	if err != nil {
+		return err
+	}
+	if progress.Pending != 1 || progress.Sent != 1 || progress.Failed != 1 {
+		return fmt.Errorf("GetBroadcastProgress = %+v, want 1 of each", progress)
+	}
+	if progress, err = s.GetBroadcastProgress(other.ID); err != nil {
+		return err
+	}
+	if progress.Pending != 1 || progress.Sent != 0 {
+		return fmt.Errorf("the deliveries of one job changed another: %+v", progress)
+	}
+
+	if n, err := s.RetryFailedBroadcastRecipients(job.ID); err != nil || n != 1 {
+		return fmt.Errorf("RetryFailedBroadcastRecipients = %d, want 1 (err %v)", n, err)
+	}
+	if pending, err = s.GetPendingBroadcastRecipients(job.ID, 10); err != nil {
+		return err
+	}
+	if len(pending) != 2 || pending[0] != 2 || pending[1] != 3 {
+		return fmt.Errorf("GetPendingBroadcastRecipients after a retry = %v, want [2 3]", pending)
+	}
+
+	// Finished jobs leave the active list and record when they finished
+	if err := s.SetBroadcastJobStatus(job.ID, storage.BroadcastStatusPaused); err != nil {
+		return err
+	}
+	if err := s.SetBroadcastJobStatus(other.ID, storage.BroadcastStatusDone); err != nil {
+		return err
+	}
+	active, err := s.GetActiveBroadcastJobs()
+	if err != nil {
+		return err
+	}
+	if len(active) != 1 || active[0].ID != job.ID || active[0].Status != storage.BroadcastStatusPaused {
+		return fmt.Errorf("GetActiveBroadcastJobs returned %d jobs, want the paused one", len(active))
+	}
+	if got, err = s.GetBroadcastJob(other.ID); err != nil {
+		return err
+	}
+	if got.FinishedAt.IsZero() {
+		return fmt.Errorf("a done job has no finish time")
+	}
+	if err := s.SetBroadcastJobStatus(other.ID, storage.BroadcastStatusQueued); err != nil {
+		return err
+	}
+	if got, err = s.GetBroadcastJob(other.ID); err != nil {
+		return err
+	}
+	if !got.FinishedAt.IsZero() {
+		return fmt.Errorf("a requeued job kept its finish time")
+	}
+	return nil
+}
+
+func checkBroadcastSchedules(s storage.Storage) error {
+	if _, err := s.GetBroadcastSchedule(1); err == nil {
+		return fmt.Errorf("GetBroadcastSchedule of a missing schedule returned no error")
+	}
+
+	ts := now()
+	weekly := &storage.BroadcastSchedule{
+		CreatedBy: 10,
+		BroadcastContent: storage.BroadcastContent{
+			Message: "weekly",
+			Media:   []storage.BroadcastMedia{{Type: storage.BroadcastMediaPhoto, FileID: "photo-1"}},
+			Buttons: []storage.BroadcastButton{{Text: "Site", URL: "https://example.com"}},
+			Pin:     true,
+			SendAt:  ts.Add(2 * time.Hour),
+		},
+		Audience: "all",
+		Cron:     "0 9 * * 1",
+	}
+	once := &storage.BroadcastSchedule{
+		CreatedBy:        11,
+		BroadcastContent: storage.BroadcastContent{Message: "once", SendAt: ts.Add(time.Hour)},
+		Audience:         "active",
+	}
+	for _, schedule := range []*storage.BroadcastSchedule{weekly, once} {
+		if err := s.CreateBroadcastSchedule(schedule); err != nil {
+			return err
+		}
+	}
+	if weekly.ID == 0 || once.ID <= weekly.ID || weekly.CreatedAt.IsZero() {
+		return fmt.Errorf("CreateBroadcastSchedule set ids %d and %d, want increasing ids", weekly.ID, once.ID)
+	}
+
+	got, err := s.GetBroadcastSchedule(weekly.ID)
+	if err != nil {
+		return err
+	}
+	if got.CreatedBy != 10 || got.Message != "weekly" || len(got.Media) != 1 || got.Media[0] != weekly.Media[0] ||
+		len(got.Buttons) != 1 || got.Buttons[0] != weekly.Buttons[0] || !got.Pin || !sameTime(got.SendAt, weekly.SendAt) ||
+		got.Audience != "all" || got.Cron != "0 9 * * 1" || !got.LastRunAt.IsZero() {
+		return fmt.Errorf("GetBroadcastSchedule = %+v", got)
+	}
+
+	// The next run comes first
+	schedules, err := s.GetBroadcastSchedules()
+	if err != nil {
+		return err
+	}
+	if len(schedules) != 2 || schedules[0].ID != once.ID || schedules[1].ID != weekly.ID {
+		return fmt.Errorf("GetBroadcastSchedules returned %d schedules, want the one-off one first", len(schedules))
+	}
+
+	// An edit makes a claim with the old revision fail
+	stale := *got
+	got.Message = "edited"
+	got.Cron = "30 18 * * *"
+	got.SendAt = ts.Add(3 * time.Hour)
+	if err := s.UpdateBroadcastSchedule(got); err != nil {
+		return err
+	}
+	if got.Revision != stale.Revision+1 {
+		return fmt.Errorf("UpdateBroadcastSchedule set revision %d, want %d", got.Revision, stale.Revision+1)
+	}
+	if err := s.UpdateBroadcastSchedule(&stale); err == nil {
+		return fmt.Errorf("UpdateBroadcastSchedule with a stale revision returned no error")
+	}
+	if ok, err := s.ClaimBroadcastSchedule(&stale, ts.Add(time.Hour)); err != nil || ok {
+		return fmt.Errorf("ClaimBroadcastSchedule with a stale revision = %v, want false (err %v)", ok, err)
+	}
+	if got, err = s.GetBroadcastSchedule(weekly.ID); err != nil {
+		return err
+	}
+	if got.Message != "edited" || got.Cron != "30 18 * * *" || !sameTime(got.SendAt, ts.Add(3*time.Hour)) {
+		return fmt.Errorf("GetBroadcastSchedule after an edit = %+v", got)
+	}
+
+	// A claim moves a recurring schedule to its next run, and only succeeds once
+	next := ts.Add(24 * time.Hour)
+	claimed := *got
+	if ok, err := s.ClaimBroadcastSchedule(&claimed, next); err != nil || !ok {
+		return fmt.Errorf("ClaimBroadcastSchedule = %v, want true (err %v)", ok, err)
+	}
+	if ok, err := s.ClaimBroadcastSchedule(got, next); err != nil || ok {
+		return fmt.Errorf("a second ClaimBroadcastSchedule with the same revision = %v, want false (err %v)", ok, err)
+	}
+	if got, err = s.GetBroadcastSchedule(weekly.ID); err != nil {
+		return err
+	}
+	if !sameTime(got.SendAt, next) || got.LastRunAt.IsZero() {
+		return fmt.Errorf("GetBroadcastSchedule after a claim = %+v, want the next run and a last run time", got)
+	}
+	// The claimer holds the new revision, so it can update the schedule after the run
+	if claimed.Revision != got.Revision {
+		return fmt.Errorf("ClaimBroadcastSchedule left revision %d, stored %d", claimed.Revision, got.Revision)
+	}
+	claimed.SendAt = ts.Add(time.Hour)
+	if err := s.UpdateBroadcastSchedule(&claimed); err != nil {
+		return fmt.Errorf("UpdateBroadcastSchedule after a claim: %w", err)
+	}
+	if got, err = s.GetBroadcastSchedule(weekly.ID); err != nil {
+		return err
+	}
+
+	// Claiming a one-off schedule removes it
+	if ok, err := s.ClaimBroadcastSchedule(once, time.Time{}); err != nil || !ok {
+		return fmt.Errorf("ClaimBroadcastSchedule of a one-off schedule = %v, want true (err %v)", ok, err)
+	}
+	if _, err := s.GetBroadcastSchedule(once.ID); err == nil {
+		return fmt.Errorf("a claimed one-off schedule is still stored")
+	}
+
+	if err := s.DeleteBroadcastSchedule(weekly.ID); err != nil {
+		return err
+	}
+	if err := s.DeleteBroadcastSchedule(weekly.ID); err == nil {
+		return fmt.Errorf("DeleteBroadcastSchedule of a missing schedule returned no error")
+	}
+	if err := s.UpdateBroadcastSchedule(got); err == nil {
+		return fmt.Errorf("UpdateBroadcastSchedule of a missing schedule returned no error")
+	}
+	if schedules, err = s.GetBroadcastSchedules(); err != nil {
+		return err
+	}
+	if len(schedules) != 0 {
+		return fmt.Errorf("GetBroadcastSchedules after delete returned %d schedules", len(schedules))
+	}
+	return nil
+}
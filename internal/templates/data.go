@@ -0,0 +1,100 @@
+package templates
+
+import "sort"
+
+// Template names, the file of a template is <name>.tmpl
+const (
+	ExpiryWarning     = "expiry_warning"
+	TrafficAlert      = "traffic_alert"
+	ExtensionApproved = "extension_approved"
+	SubscriptionInfo  = "subscription_info"
+)
+
+// The data of each template. Text values are HTML-escaped by the caller, messages are sent with HTML
+// formatting. Dates are formatted as 02.01.2006 15:04
+
+// ExpiryWarningData is sent to a user whose subscription expires soon
+type ExpiryWarningData struct {
+	Email    string // Account of the subscription
+	Expiry   string // When it expires
+	DaysLeft int    // Whole days until then, 1 or less on the last day
+}
+
+// TrafficAlertData is sent to admins when the traffic forecast crosses a threshold
+type TrafficAlertData struct {
+	Panel       string // Name of the panel when several are configured, empty otherwise
+	InboundID   int    // 0 for the total traffic of the panel
+	Exceeded    bool   // The forecast exceeded the threshold, otherwise it reached Percent of it
+	Percent     int
+	ThresholdGB int64
+	Forecast    string // The forecast report, as /forecast shows it
+}
+
+// ExtensionApprovedData replaces the request card when an admin approves an extension
+type ExtensionApprovedData struct {
+	User      string // Telegram name
+	Username  string // @username, empty when the user has none
+	Email     string
+	OldExpiry string
+	Days      int    // Days added, promo code bonus included
+	Promo     string // Promo code lines of the quote starting with a line break, empty without a code
+	NewExpiry string
+}
+
+// SubscriptionInfoData is the caption of the subscription QR code
+type SubscriptionInfoData struct {
+	Title            string // Heading, differs between registration and the subscription menu
+	Email            string
+	StatusIcon       string
+	Status           string
+	Expiry           string // Empty for a subscription that never expires
+	DaysLeft         int
+	HoursLeft        int
+	DeviceLimit      int    // 0 when unlimited
+	Servers          string // Comma separated inbound names, empty when unknown
+	TrafficUsed      string
+	TrafficLimit     string // Empty when unlimited
+	TrafficPercent   float64
+	TrafficIcon      string // 🟢, 🟡 or 🔴 by the share of the limit used
+	SubscriptionLink string
+}
+
+// samples are the data every template is checked with at load and shown with in previews.
+// They cover the branches the built-in templates take
+var samples = map[string][]any{
+	ExpiryWarning: {
+		ExpiryWarningData{Email: "alice", Expiry: "21.10.2026 18:00", DaysLeft: 1},
+		ExpiryWarningData{Email: "alice", Expiry: "23.10.2026 18:00", DaysLeft: 3},
+		ExpiryWarningData{Email: "alice", Expiry: "27.10.2026 18:00", DaysLeft: 7},
+	},
+	TrafficAlert: {
+		TrafficAlertData{InboundID: 3, Percent: 80, ThresholdGB: 500, Forecast: "📊 …"},
+		TrafficAlertData{Panel: "de-1", InboundID: 3, Exceeded: true, ThresholdGB: 500, Forecast: "📊 …"},
+		TrafficAlertData{Percent: 80, ThresholdGB: 500, Forecast: "📊 …"},
+		TrafficAlertData{Panel: "de-1", Exceeded: true, ThresholdGB: 500, Forecast: "📊 …"},
+	},
+	ExtensionApproved: {
+		ExtensionApprovedData{User: "Alice", Username: "@alice", Email: "alice", OldExpiry: "21.10.2026 18:00", Days: 30, NewExpiry: "20.11.2026 18:00"},
+		ExtensionApprovedData{User: "Bob", Email: "bob", OldExpiry: "21.10.2026 18:00", Days: 37, Promo: "\n🎟 SPRING", NewExpiry: "27.11.2026 18:00"},
+	},
+	SubscriptionInfo: {
+		SubscriptionInfoData{
+			Title: "📱 <b>…</b>", Email: "alice", StatusIcon: "✅", Status: "…", Expiry: "20.11.2026 18:00", DaysLeft: 35, HoursLeft: 4,
+			DeviceLimit: 3, Servers: "vless-reality, trojan", TrafficUsed: "12.50 GB", TrafficLimit: "100.00 GB", TrafficPercent: 12.5,
+			TrafficIcon: "🟢", SubscriptionLink: "vless://…",
+		},
+		SubscriptionInfoData{
+			Title: "📱 <b>…</b>", Email: "bob", StatusIcon: "♾️", Status: "…", TrafficUsed: "3.20 GB", SubscriptionLink: "vless://…",
+		},
+	},
+}
+
+// Names returns the names of all templates, sorted
+func Names() []string {
+	names := make([]string, 0, len(samples))
+	for name := range samples {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
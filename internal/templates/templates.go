@@ -0,0 +1,198 @@
+// Package templates renders notifications from text/template files with named variables.
+//
+// The built-in templates are embedded from defaults/<language>/<name>.tmpl. A templates directory
+// overrides them without a rebuild: <dir>/<name>.tmpl replaces a template in every language and
+// <dir>/<language>/<name>.tmpl in one language. Every template is parsed and run with sample data
+// when the set is loaded, so a broken template stops the bot at start instead of a notification.
+package templates
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"text/template"
+
+	"x-ui-bot/internal/i18n"
+)
+
+// Extension is the file extension of templates
+const Extension = ".tmpl"
+
+//go:embed defaults
+var defaultFiles embed.FS
+
+// Renderer renders templates in one language
+type Renderer func(name string, data any) (string, error)
+
+// Localizer returns the renderer of a Telegram user
+type Localizer func(userID int64) Renderer
+
+// Set holds the templates of every supported language
+type Set struct {
+	templates map[string]map[string]*template.Template // language -> name -> template
+	sources   map[string]map[string]string             // language -> name -> file the template was read from
+}
+
+// Load reads the built-in templates and the overrides in dir, an empty dir uses the built-in ones only.
+// languages are the languages messages are rendered in, the built-in templates of the default
+// language are used for a language without its own
+func Load(dir string, languages []string) (*Set, error) {
+	s := &Set{
+		templates: make(map[string]map[string]*template.Template),
+		sources:   make(map[string]map[string]string),
+	}
+
+	var problems []string
+	for _, lang := range languages {
+		s.templates[lang] = make(map[string]*template.Template)
+		s.sources[lang] = make(map[string]string)
+		for _, name := range Names() {
+			src, source, err := find(dir, lang, name)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s/%s: %v", lang, name, err))
+				continue
+			}
+			tmpl, err := template.New(name).Option("missingkey=error").Parse(src)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", source, err))
+				continue
+			}
+			// Executing with samples catches unknown variables and wrong argument types
+			for _, sample := range samples[name] {
+				if err := tmpl.Execute(&bytes.Buffer{}, sample); err != nil {
+					problems = append(problems, fmt.Sprintf("%s: %v", source, err))
+					break
+				}
+			}
+			s.templates[lang][name] = tmpl
+			s.sources[lang][name] = source
+		}
+	}
+	problems = append(problems, checkDir(dir, languages)...)
+
+	if len(problems) > 0 {
+		// A file for every language is reported once per language
+		sort.Strings(problems)
+		problems = slices.Compact(problems)
+		return nil, fmt.Errorf("invalid templates:\n%s", strings.Join(problems, "\n"))
+	}
+	return s, nil
+}
+
+// find returns the source of a template in a language and the file it was read from: the override of the
+// language, the override for every language, the built-in template of the language or of the default language
+func find(dir, lang, name string) (string, string, error) {
+	file := name + Extension
+	if dir != "" {
+		for _, p := range []string{filepath.Join(dir, lang, file), filepath.Join(dir, file)} {
+			data, err := os.ReadFile(p)
+			if err == nil {
+				return string(data), p, nil
+			}
+			if !os.IsNotExist(err) {
+				return "", p, err
+			}
+		}
+	}
+	for _, l := range []string{lang, i18n.DefaultLanguage} {
+		p := path.Join("defaults", l, file)
+		if data, err := defaultFiles.ReadFile(p); err == nil {
+			return string(data), "built-in " + p, nil
+		}
+	}
+	return "", "", fmt.Errorf("no built-in template")
+}
+
+// checkDir reports files in the templates directory that would be silently ignored:
+// unknown template names and directories of unsupported languages
+func checkDir(dir string, languages []string) []string {
+	if dir == "" {
+		return nil
+	}
+
+	known := make(map[string]bool)
+	for _, name := range Names() {
+		known[name+Extension] = true
+	}
+	supported := make(map[string]bool)
+	for _, lang := range languages {
+		supported[lang] = true
+	}
+
+	var problems []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, p)
+		depth := len(strings.Split(rel, string(filepath.Separator)))
+		switch {
+		case rel == ".":
+		case d.IsDir() && (depth > 1 || !supported[d.Name()]):
+			problems = append(problems, fmt.Sprintf("%s: not a supported language", p))
+			return filepath.SkipDir
+		case !d.IsDir() && strings.HasSuffix(d.Name(), Extension) && !known[d.Name()]:
+			problems = append(problems, fmt.Sprintf("%s: unknown template", p))
+		}
+		return nil
+	})
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("%s: %v", dir, err))
+	}
+	return problems
+}
+
+// Render renders a template in a language, the default language is used for an unsupported one
+func (s *Set) Render(lang, name string, data any) (string, error) {
+	tmpl, ok := s.templates[lang][name]
+	if !ok {
+		if tmpl, ok = s.templates[i18n.DefaultLanguage][name]; !ok {
+			return "", fmt.Errorf("unknown template %q", name)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("template %s: %w", name, err)
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// Renderer returns the renderer of a language
+func (s *Set) Renderer(lang string) Renderer {
+	return func(name string, data any) (string, error) {
+		return s.Render(lang, name, data)
+	}
+}
+
+// Source returns the file a template of a language was read from
+func (s *Set) Source(lang, name string) string {
+	if source, ok := s.sources[lang][name]; ok {
+		return source
+	}
+	return s.sources[i18n.DefaultLanguage][name]
+}
+
+// Preview renders a template in a language with each of its samples
+func (s *Set) Preview(lang, name string) ([]string, error) {
+	list, ok := samples[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown template %q", name)
+	}
+	previews := make([]string, 0, len(list))
+	for _, sample := range list {
+		text, err := s.Render(lang, name, sample)
+		if err != nil {
+			return nil, err
+		}
+		previews = append(previews, text)
+	}
+	return previews, nil
+}
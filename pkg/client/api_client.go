@@ -4,25 +4,45 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
-	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/skip2/go-qrcode"
 )
 
+// Retry policy for panel requests
+const (
+	maxRequestAttempts    = 4
+	initialRetryDelay     = 500 * time.Millisecond
+	maxRetryDelay         = 8 * time.Second
+	defaultRequestTimeout = 2 * time.Minute
+)
+
 // APIClient handles communication with 3x-ui panel API
 type APIClient struct {
+	name       string
 	baseURL    string
 	username   string
 	password   string
 	httpClient *http.Client
-	sessionID  string
+
+	sessionMu sync.RWMutex
+	sessionID string
+
+	// loginMu serialises logins so concurrent requests that hit an expired
+	// session trigger a single re-login instead of one per request
+	loginMu sync.Mutex
+
+	// inbounds caches the inbound list, every write to clients invalidates it
+	inbounds *inboundCache
 }
 
 // NewAPIClient creates a new API client
@@ -39,17 +59,69 @@ func NewAPIClient(baseURL, username, password string) *APIClient {
 				IdleConnTimeout:     30 * time.Second,
 			},
 		},
+		inbounds: newInboundCache(DefaultInboundCacheTTL),
 	}
 }
 
+// SetInboundCacheTTL sets how long the inbound list is cached, 0 disables the cache
+func (c *APIClient) SetInboundCacheTTL(ttl time.Duration) {
+	c.inbounds.setTTL(ttl)
+}
+
+// InvalidateInbounds drops the cached inbound list, for changes made outside this client
+func (c *APIClient) InvalidateInbounds() {
+	c.inbounds.invalidate()
+}
+
+// Name returns the panel name the client was registered under
+func (c *APIClient) Name() string {
+	return c.name
+}
+
+// session returns the current session cookie value
+func (c *APIClient) session() string {
+	c.sessionMu.RLock()
+	defer c.sessionMu.RUnlock()
+	return c.sessionID
+}
+
+// setSession stores a new session cookie value
+func (c *APIClient) setSession(id string) {
+	c.sessionMu.Lock()
+	c.sessionID = id
+	c.sessionMu.Unlock()
+}
+
 // Login authenticates with the 3x-ui panel
 func (c *APIClient) Login(ctx context.Context) error {
+	c.loginMu.Lock()
+	defer c.loginMu.Unlock()
+	return c.login(ctx)
+}
+
+// relogin refreshes the session after a 401 response. If another request
+// already replaced the stale session while we waited for the lock, the new
+// session is reused and no extra login is made.
+func (c *APIClient) relogin(ctx context.Context, staleSession string) error {
+	c.loginMu.Lock()
+	defer c.loginMu.Unlock()
+
+	if current := c.session(); current != "" && current != staleSession {
+		return nil
+	}
+
+	log.Printf("[INFO] Session for panel %q expired, logging in again", c.name)
+	return c.login(ctx)
+}
+
+// login performs the login request, callers must hold loginMu
+func (c *APIClient) login(ctx context.Context) error {
 	loginData := map[string]string{
 		"username": c.username,
 		"password": c.password,
 	}
 
-	resp, err := c.doRequest(ctx, "POST", "/login", loginData, false)
+	resp, err := c.send(ctx, "POST", "/login", loginData, "application/json", "")
 	if err != nil {
 		return fmt.Errorf("login request failed: %w", err)
 	}
@@ -63,7 +135,7 @@ func (c *APIClient) Login(ctx context.Context) error {
 	// Extract session cookie
 	for _, cookie := range resp.Cookies() {
 		if cookie.Name == "session" || cookie.Name == "3x-ui" {
-			c.sessionID = cookie.Value
+			c.setSession(cookie.Value)
 			return nil
 		}
 	}
@@ -71,131 +143,250 @@ func (c *APIClient) Login(ctx context.Context) error {
 	return fmt.Errorf("no session cookie found in %d cookies", len(resp.Cookies()))
 }
 
-// doRequest performs an HTTP request
+// doRequest performs a JSON API request.
+// On 401 the session is refreshed and the request is retried once.
 func (c *APIClient) doRequest(ctx context.Context, method, path string, data interface{}, needAuth bool) (*http.Response, error) {
-	var body io.Reader
+	return c.doRequestAccept(ctx, method, path, data, needAuth, "application/json")
+}
+
+// doRequestAccept is doRequest with a custom Accept header
+func (c *APIClient) doRequestAccept(ctx context.Context, method, path string, data interface{}, needAuth bool, accept string) (*http.Response, error) {
+	if !needAuth {
+		return c.send(ctx, method, path, data, accept, "")
+	}
+
+	session := c.session()
+	resp, err := c.send(ctx, method, path, data, accept, session)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	_ = resp.Body.Close()
+
+	if err := c.relogin(ctx, session); err != nil {
+		return nil, fmt.Errorf("re-login failed: %w", err)
+	}
+
+	resp, err = c.send(ctx, method, path, data, accept, c.session())
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("unauthorized after re-login")
+	}
+	return resp, nil
+}
+
+// send performs an HTTP request, retrying with exponential backoff until the attempts or the context deadline run out.
+// Reads and logins are retried on network errors and 5xx responses. Writes are retried only when they never
+// reached the panel, as a write may have been applied before its response was lost; their callers decide.
+// Contexts without a deadline are bounded by defaultRequestTimeout.
+func (c *APIClient) send(ctx context.Context, method, path string, data interface{}, accept, session string) (*http.Response, error) {
+	var payload []byte
 	if data != nil {
 		jsonData, err := json.Marshal(data)
 		if err != nil {
 			return nil, fmt.Errorf("failed to marshal data: %w", err)
 		}
-		body = bytes.NewBuffer(jsonData)
+		payload = jsonData
 	}
 
-	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultRequestTimeout)
+		// The response body may still be read by the caller, so the timeout
+		// is released once the body is closed
+		resp, err := c.sendWithRetry(ctx, method, path, payload, data != nil, accept, session)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+		return resp, nil
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "application/json")
+	return c.sendWithRetry(ctx, method, path, payload, data != nil, accept, session)
+}
+
+func (c *APIClient) sendWithRetry(ctx context.Context, method, path string, payload []byte, hasBody bool, accept, session string) (*http.Response, error) {
+	delay := initialRetryDelay
+	for attempt := 1; ; attempt++ {
+		var body io.Reader
+		if hasBody {
+			body = bytes.NewReader(payload)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", accept)
+
+		if session != "" {
+			req.AddCookie(&http.Cookie{
+				Name:  "3x-ui",
+				Value: session,
+			})
+		}
 
-	if needAuth && c.sessionID != "" {
-		req.AddCookie(&http.Cookie{
-			Name:  "3x-ui",
-			Value: c.sessionID,
-		})
+		resp, err := c.httpClient.Do(req)
+		var retryable bool
+		if err != nil {
+			retryable = repeatable(method, path) || notSent(err)
+		} else {
+			retryable = repeatable(method, path) && resp.StatusCode >= http.StatusInternalServerError
+		}
+		if !retryable || attempt >= maxRequestAttempts || ctx.Err() != nil || !fitsDeadline(ctx, delay) {
+			return resp, err
+		}
+
+		if err != nil {
+			log.Printf("[WARN] %s %s failed (attempt %d/%d): %v, retrying in %s", method, path, attempt, maxRequestAttempts, err, delay)
+		} else {
+			log.Printf("[WARN] %s %s returned status %d (attempt %d/%d), retrying in %s", method, path, resp.StatusCode, attempt, maxRequestAttempts, delay)
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
 	}
+}
 
-	return c.httpClient.Do(req)
+// readOnlyPosts are the POST requests that do not change the panel
+var readOnlyPosts = map[string]bool{
+	"/login":             true,
+	"/panel/setting/all": true,
+}
+
+// repeatable reports whether a request can be sent again after it may have reached the panel
+func repeatable(method, path string) bool {
+	return method == http.MethodGet || readOnlyPosts[path]
+}
+
+// notSent reports whether a request failed before it reached the panel, such as on a refused connection
+func notSent(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && (opErr.Op == "dial" || opErr.Op == "proxyconnect")
+}
+
+// fitsDeadline reports whether waiting for delay still leaves time before the context deadline
+func fitsDeadline(ctx context.Context, delay time.Duration) bool {
+	deadline, ok := ctx.Deadline()
+	return !ok || time.Until(deadline) > delay
+}
+
+// cancelOnClose releases a request context when the response body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
 }
 
 // GetStatus gets server status
-func (c *APIClient) GetStatus(ctx context.Context) (map[string]interface{}, error) {
+func (c *APIClient) GetStatus(ctx context.Context) (*ServerStatus, error) {
 	resp, err := c.doRequest(ctx, "GET", "/panel/api/server/status", nil, true)
 	if err != nil {
 		return nil, fmt.Errorf("status request failed: %w", err)
 	}
 	defer func() { _ = resp.Body.Close() }()
 
-	if resp.StatusCode == http.StatusUnauthorized {
-		if err := c.Login(ctx); err != nil {
-			return nil, fmt.Errorf("re-login failed: %w", err)
-		}
-		return c.GetStatus(ctx)
+	var result apiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
 
-	var result map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+	status := &ServerStatus{}
+	if err := result.decodeObj(status); err != nil {
 		return nil, err
 	}
 
-	return result, nil
+	return status, nil
+}
+
+// GetInbounds gets list of inbounds.
+// The list is served from a short-lived snapshot shared by all callers, use RefreshInbounds for current traffic
+func (c *APIClient) GetInbounds(ctx context.Context) ([]Inbound, error) {
+	return c.inbounds.get(ctx, c.fetchInbounds)
+}
+
+// RefreshInbounds loads the inbound list from the panel and replaces the cached snapshot
+func (c *APIClient) RefreshInbounds(ctx context.Context) ([]Inbound, error) {
+	c.inbounds.invalidate()
+	return c.inbounds.get(ctx, c.fetchInbounds)
 }
 
-// GetInbounds gets list of inbounds
-func (c *APIClient) GetInbounds(ctx context.Context) ([]map[string]interface{}, error) {
+// fetchInbounds requests the inbound list from the panel
+func (c *APIClient) fetchInbounds(ctx context.Context) ([]Inbound, error) {
 	resp, err := c.doRequest(ctx, "GET", "/panel/api/inbounds/list", nil, true)
 	if err != nil {
 		return nil, fmt.Errorf("inbounds request failed: %w", err)
 	}
 	defer func() { _ = resp.Body.Close() }()
 
-	if resp.StatusCode == http.StatusUnauthorized {
-		if err := c.Login(ctx); err != nil {
-			return nil, fmt.Errorf("re-login failed: %w", err)
-		}
-		return c.GetInbounds(ctx)
-	}
-
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
 		return nil, fmt.Errorf("inbounds request failed with status: %d, body: %s", resp.StatusCode, string(body))
 	}
 
-	var result map[string]interface{}
+	var result apiResponse
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
 		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
 
-	if success, ok := result["success"].(bool); ok && success {
-		if obj, ok := result["obj"].([]interface{}); ok {
-			var inbounds []map[string]interface{}
-			for _, item := range obj {
-				if inbound, ok := item.(map[string]interface{}); ok {
-					inbounds = append(inbounds, inbound)
-				}
-			}
-			return inbounds, nil
-		}
+	var inbounds []Inbound
+	if err := result.decodeObj(&inbounds); err != nil {
+		return nil, fmt.Errorf("invalid inbounds response: %w", err)
+	}
+
+	for i := range inbounds {
+		inbounds[i].Panel = c.name
 	}
 
-	return nil, fmt.Errorf("invalid response format")
+	return inbounds, nil
 }
 
 // GetInbound gets inbound by ID
-func (c *APIClient) GetInbound(ctx context.Context, id int) (map[string]interface{}, error) {
+func (c *APIClient) GetInbound(ctx context.Context, id int) (*Inbound, error) {
 	resp, err := c.doRequest(ctx, "GET", fmt.Sprintf("/panel/api/inbounds/get/%d", id), nil, true)
 	if err != nil {
 		return nil, fmt.Errorf("inbound request failed: %w", err)
 	}
 	defer func() { _ = resp.Body.Close() }()
 
-	if resp.StatusCode == http.StatusUnauthorized {
-		if err := c.Login(ctx); err != nil {
-			return nil, fmt.Errorf("re-login failed: %w", err)
-		}
-		return c.GetInbound(ctx, id)
-	}
-
-	var result struct {
-		Success bool                   `json:"success"`
-		Obj     map[string]interface{} `json:"obj"`
-	}
-
+	var result apiResponse
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
 
-	if !result.Success {
-		return nil, fmt.Errorf("API returned success=false")
+	inbound := &Inbound{}
+	if err := result.decodeObj(inbound); err != nil {
+		return nil, err
 	}
+	inbound.Panel = c.name
 
-	return result.Obj, nil
+	return inbound, nil
 }
 
 // ResetClientTraffic resets traffic for a client by email
 func (c *APIClient) ResetClientTraffic(ctx context.Context, email string) error {
+	defer c.InvalidateInbounds()
+
 	data := map[string]string{"email": email}
 	resp, err := c.doRequest(ctx, "POST", "/panel/api/inbounds/resetClientTraffic", data, true)
 	if err != nil {
@@ -203,13 +394,6 @@ func (c *APIClient) ResetClientTraffic(ctx context.Context, email string) error
 	}
 	defer func() { _ = resp.Body.Close() }()
 
-	if resp.StatusCode == http.StatusUnauthorized {
-		if err := c.Login(ctx); err != nil {
-			return fmt.Errorf("re-login failed: %w", err)
-		}
-		return c.ResetClientTraffic(ctx, email)
-	}
-
 	var result struct {
 		Success bool `json:"success"`
 	}
@@ -226,38 +410,28 @@ func (c *APIClient) ResetClientTraffic(ctx context.Context, email string) error
 }
 
 // GetClientTraffics gets client traffic statistics by email
-func (c *APIClient) GetClientTraffics(ctx context.Context, email string) (map[string]interface{}, error) {
+func (c *APIClient) GetClientTraffics(ctx context.Context, email string) (*ClientStat, error) {
 	resp, err := c.doRequest(ctx, "GET", fmt.Sprintf("/panel/api/inbounds/getClientTraffics/%s", email), nil, true)
 	if err != nil {
 		return nil, err
 	}
 	defer func() { _ = resp.Body.Close() }()
 
-	if resp.StatusCode == http.StatusUnauthorized {
-		if err := c.Login(ctx); err != nil {
-			return nil, err
-		}
-		return c.GetClientTraffics(ctx, email)
-	}
-
-	var result struct {
-		Success bool                   `json:"success"`
-		Obj     map[string]interface{} `json:"obj"`
-	}
-
+	var result apiResponse
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
 
-	if !result.Success {
-		return nil, fmt.Errorf("API returned success=false")
+	stat := &ClientStat{}
+	if err := result.decodeObj(stat); err != nil {
+		return nil, err
 	}
 
-	return result.Obj, nil
+	return stat, nil
 }
 
 // GetClientTrafficsById gets all client traffic statistics for an inbound by ID
-func (c *APIClient) GetClientTrafficsById(ctx context.Context, inboundID int) ([]map[string]interface{}, error) {
+func (c *APIClient) GetClientTrafficsById(ctx context.Context, inboundID int) ([]ClientStat, error) {
 	path := fmt.Sprintf("/panel/api/inbounds/getClientTrafficsById/%d", inboundID)
 
 	resp, err := c.doRequest(ctx, "GET", path, nil, true)
@@ -266,49 +440,39 @@ func (c *APIClient) GetClientTrafficsById(ctx context.Context, inboundID int) ([
 	}
 	defer func() { _ = resp.Body.Close() }()
 
-	if resp.StatusCode == http.StatusUnauthorized {
-		if err := c.Login(ctx); err != nil {
-			return nil, err
-		}
-		return c.GetClientTrafficsById(ctx, inboundID)
-	}
-
 	// Read body for debugging
 	bodyBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
 	}
 
-	var result struct {
-		Success bool                     `json:"success"`
-		Obj     []map[string]interface{} `json:"obj"`
-	}
-
+	var result apiResponse
 	if err := json.Unmarshal(bodyBytes, &result); err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
 
-	if !result.Success {
-		log.Printf("[ERROR] GetClientTrafficsById: API returned success=false for inbound %d, response: %s", inboundID, string(bodyBytes))
-		return nil, fmt.Errorf("API returned success=false")
+	var stats []ClientStat
+	if err := result.decodeObj(&stats); err != nil {
+		log.Printf("[ERROR] GetClientTrafficsById: invalid response for inbound %d: %s", inboundID, string(bodyBytes))
+		return nil, err
 	}
 
-	log.Printf("[DEBUG] GetClientTrafficsById: inbound %d returned %d traffic entries", inboundID, len(result.Obj))
-	if len(result.Obj) > 0 {
+	log.Printf("[DEBUG] GetClientTrafficsById: inbound %d returned %d traffic entries", inboundID, len(stats))
+	if len(stats) > 0 {
 		var emails []string
-		for _, item := range result.Obj {
-			if email, ok := item["email"].(string); ok {
-				emails = append(emails, email)
-			}
+		for _, item := range stats {
+			emails = append(emails, item.Email)
 		}
 		log.Printf("[DEBUG] Traffic emails for inbound %d: %v", inboundID, emails)
 	}
 
-	return result.Obj, nil
+	return stats, nil
 }
 
 // UpdateClientTraffic updates traffic statistics for a specific client
 func (c *APIClient) UpdateClientTraffic(ctx context.Context, email string, up int64, down int64) error {
+	defer c.InvalidateInbounds()
+
 	// URL encode the email to handle special characters
 	encodedEmail := url.QueryEscape(email)
 	path := fmt.Sprintf("/panel/api/inbounds/updateClientTraffic/%s", encodedEmail)
@@ -327,13 +491,6 @@ func (c *APIClient) UpdateClientTraffic(ctx context.Context, email string, up in
 	}
 	defer func() { _ = resp.Body.Close() }()
 
-	if resp.StatusCode == http.StatusUnauthorized {
-		if err := c.Login(ctx); err != nil {
-			return err
-		}
-		return c.UpdateClientTraffic(ctx, email, up, down)
-	}
-
 	// Read body for debugging
 	bodyBytes, _ := io.ReadAll(resp.Body)
 	log.Printf("[DEBUG] UpdateClientTraffic response for %s (status=%d): %s", email, resp.StatusCode, string(bodyBytes))
@@ -357,19 +514,20 @@ func (c *APIClient) UpdateClientTraffic(ctx context.Context, email string, up in
 // UpdateClient updates an existing client in an inbound
 func (c *APIClient) UpdateClient(ctx context.Context, inboundID int, clientID string, clientData map[string]interface{}) error {
 	log.Printf("[INFO] UpdateClient called for inbound=%d, client=%s", inboundID, clientID)
+	defer c.InvalidateInbounds()
 
-	// Get current inbound data
-	inbounds, err := c.GetInbounds(ctx)
+	// Get current inbound data, bypassing the cache so that the merge does not restore stale fields
+	inbounds, err := c.fetchInbounds(ctx)
 	if err != nil {
 		log.Printf("[ERROR] Failed to get inbounds: %v", err)
 		return fmt.Errorf("failed to get inbounds: %w", err)
 	}
 	log.Printf("[INFO] Got %d inbounds", len(inbounds))
 
-	var targetInbound map[string]interface{}
-	for _, inbound := range inbounds {
-		if int(inbound["id"].(float64)) == inboundID {
-			targetInbound = inbound
+	var targetInbound *Inbound
+	for i := range inbounds {
+		if inbounds[i].ID == inboundID {
+			targetInbound = &inbounds[i]
 			break
 		}
 	}
@@ -378,49 +536,32 @@ func (c *APIClient) UpdateClient(ctx context.Context, inboundID int, clientID st
 		return fmt.Errorf("inbound %d not found", inboundID)
 	}
 
-	// Parse current settings
-	settingsStr, ok := targetInbound["settings"].(string)
-	if !ok {
-		return fmt.Errorf("invalid settings format")
-	}
-
-	var settings map[string]interface{}
-	if err := json.Unmarshal([]byte(settingsStr), &settings); err != nil {
-		return fmt.Errorf("failed to parse settings: %w", err)
-	}
-
-	clientsArray, ok := settings["clients"].([]interface{})
-	if !ok {
-		return fmt.Errorf("clients array not found")
+	clients, clientsErr := targetInbound.Clients()
+	if clientsErr != nil {
+		log.Printf("[WARN] UpdateClient: %v", clientsErr)
 	}
 
 	// Find and update the target client
-	found := false
 	var clientUUID string
 	var updatedClient map[string]interface{}
-	for i, cl := range clientsArray {
-		clientMap, ok := cl.(map[string]interface{})
-		if !ok {
+	for i := range clients {
+		if clients[i].Email != clientID {
 			continue
 		}
-
-		if email, ok := clientMap["email"].(string); ok && email == clientID {
-			// Get UUID for API call
-			if id, ok := clientMap["id"].(string); ok {
-				clientUUID = id
-			}
-			// Merge new data with existing client data (preserve other fields)
-			for key, value := range clientData {
-				clientMap[key] = value
-			}
-			clientsArray[i] = clientMap
-			updatedClient = clientMap // Save reference to updated client
-			found = true
-			break
+		// Get panel key (UUID/password) for API call
+		clientUUID = clients[i].Key()
+		// Merge new data with existing client data (preserve other fields)
+		updatedClient = clients[i].Map()
+		for key, value := range clientData {
+			updatedClient[key] = value
 		}
+		break
 	}
 
-	if !found {
+	if updatedClient == nil {
+		if clientsErr != nil {
+			return fmt.Errorf("client %s not found in inbound: %w", clientID, clientsErr)
+		}
 		return fmt.Errorf("client %s not found in inbound", clientID)
 	}
 
@@ -449,13 +590,6 @@ func (c *APIClient) UpdateClient(ctx context.Context, inboundID int, clientID st
 	defer func() { _ = resp.Body.Close() }()
 	log.Printf("[INFO] Got response with status: %d", resp.StatusCode)
 
-	if resp.StatusCode == http.StatusUnauthorized {
-		if err := c.Login(ctx); err != nil {
-			return fmt.Errorf("re-login failed: %w", err)
-		}
-		return c.UpdateClient(ctx, inboundID, clientID, clientData)
-	}
-
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		log.Printf("[ERROR] Failed to read response body: %v", err)
@@ -489,6 +623,7 @@ func (c *APIClient) UpdateClient(ctx context.Context, inboundID int, clientID st
 // DeleteClient deletes a client from an inbound
 func (c *APIClient) DeleteClient(ctx context.Context, inboundID int, clientID string) error {
 	log.Printf("[INFO] DeleteClient called for inbound=%d, clientID=%s", inboundID, clientID)
+	defer c.InvalidateInbounds()
 
 	// According to 3x-ui API, delClient endpoint expects clientId (UUID for VMESS/VLESS)
 	resp, err := c.doRequest(ctx, "POST", fmt.Sprintf("/panel/api/inbounds/%d/delClient/%s", inboundID, clientID), map[string]interface{}{
@@ -501,13 +636,6 @@ func (c *APIClient) DeleteClient(ctx context.Context, inboundID int, clientID st
 	defer func() { _ = resp.Body.Close() }()
 	log.Printf("[INFO] DeleteClient got response with status: %d", resp.StatusCode)
 
-	if resp.StatusCode == http.StatusUnauthorized {
-		if err := c.Login(ctx); err != nil {
-			return fmt.Errorf("re-login failed: %w", err)
-		}
-		return c.DeleteClient(ctx, inboundID, clientID)
-	}
-
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		log.Printf("[ERROR] DeleteClient failed to read response: %v", err)
@@ -542,6 +670,7 @@ func (c *APIClient) DeleteClient(ctx context.Context, inboundID int, clientID st
 
 // AddClient adds a new client to an inbound
 func (c *APIClient) AddClient(ctx context.Context, inboundID int, clientData map[string]interface{}) error {
+	defer c.InvalidateInbounds()
 
 	clientJSON, _ := json.Marshal(clientData)
 	data := map[string]interface{}{
@@ -555,13 +684,6 @@ func (c *APIClient) AddClient(ctx context.Context, inboundID int, clientData map
 	}
 	defer func() { _ = resp.Body.Close() }()
 
-	if resp.StatusCode == http.StatusUnauthorized {
-		if err := c.Login(ctx); err != nil {
-			return fmt.Errorf("re-login failed: %w", err)
-		}
-		return c.AddClient(ctx, inboundID, clientData)
-	}
-
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return fmt.Errorf("failed to read response: %w", err)
@@ -587,8 +709,8 @@ func (c *APIClient) AddClient(ctx context.Context, inboundID int, clientData map
 	return nil
 }
 
-// GetClientByTgID returns client information by Telegram ID
-func (c *APIClient) GetClientByTgID(ctx context.Context, tgID int64) (map[string]interface{}, error) {
+// GetClientByTgID returns the first client linked to the Telegram ID
+func (c *APIClient) GetClientByTgID(ctx context.Context, tgID int64) (*Client, error) {
 	// Get all inbounds
 	inbounds, err := c.GetInbounds(ctx)
 	if err != nil {
@@ -596,41 +718,15 @@ func (c *APIClient) GetClientByTgID(ctx context.Context, tgID int64) (map[string
 	}
 
 	// Search through all inbounds for client with matching tgId
-	for _, inbound := range inbounds {
-		settingsStr, ok := inbound["settings"].(string)
-		if !ok {
-			continue
-		}
-
-		var settings map[string]interface{}
-		if err := json.Unmarshal([]byte(settingsStr), &settings); err != nil {
-			continue
-		}
-
-		clientsArray, ok := settings["clients"].([]interface{})
-		if !ok {
-			continue
+	for i := range inbounds {
+		clients, err := inbounds[i].Clients()
+		if err != nil {
+			log.Printf("[WARN] GetClientByTgID: %v", err)
 		}
 
-		for _, c := range clientsArray {
-			clientMap, ok := c.(map[string]interface{})
-			if !ok {
-				continue
-			}
-
-			// Check tgId
-			if clientTgID, ok := clientMap["tgId"].(string); ok {
-				tgIDInt, _ := strconv.ParseInt(clientTgID, 10, 64)
-				if tgIDInt == tgID {
-					// Add inbound info
-					clientMap["_inboundID"] = inbound["id"]
-					return clientMap, nil
-				}
-			} else if clientTgID, ok := clientMap["tgId"].(float64); ok {
-				if int64(clientTgID) == tgID {
-					clientMap["_inboundID"] = inbound["id"]
-					return clientMap, nil
-				}
+		for j := range clients {
+			if clients[j].TgID == tgID {
+				return &clients[j], nil
 			}
 		}
 	}
@@ -639,7 +735,7 @@ func (c *APIClient) GetClientByTgID(ctx context.Context, tgID int64) (map[string
 }
 
 // GetPanelSettings returns the panel settings including subscription configuration
-func (c *APIClient) GetPanelSettings(ctx context.Context) (map[string]interface{}, error) {
+func (c *APIClient) GetPanelSettings(ctx context.Context) (*PanelSettings, error) {
 	resp, err := c.doRequest(ctx, "POST", "/panel/setting/all", nil, true)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get panel settings: %w", err)
@@ -655,20 +751,17 @@ func (c *APIClient) GetPanelSettings(ctx context.Context) (map[string]interface{
 		return nil, fmt.Errorf("failed to read response: %w", err)
 	}
 
-	var result struct {
-		Success bool                   `json:"success"`
-		Obj     map[string]interface{} `json:"obj"`
-	}
-
+	var result apiResponse
 	if err := json.Unmarshal(body, &result); err != nil {
 		return nil, fmt.Errorf("failed to parse response: %w", err)
 	}
 
-	if !result.Success {
-		return nil, fmt.Errorf("API returned success=false")
+	settings := &PanelSettings{}
+	if err := result.decodeObj(settings); err != nil {
+		return nil, err
 	}
 
-	return result.Obj, nil
+	return settings, nil
 }
 
 // GetClientLink returns the subscription link for a specific client email
@@ -705,36 +798,16 @@ func (c *APIClient) GetClientLink(ctx context.Context, email string) (string, er
 	}
 
 	var clientSubID string
-	// Find the client in inbounds
-	for _, inbound := range inbounds {
-		settingsStr, ok := inbound["settings"].(string)
-		if !ok {
-			continue
-		}
-
-		var inboundSettings map[string]interface{}
-		if err := json.Unmarshal([]byte(settingsStr), &inboundSettings); err != nil {
-			continue
-		}
-
-		clientsArray, ok := inboundSettings["clients"].([]interface{})
-		if !ok {
-			continue
+	// Find the client by email in inbounds
+	for i := range inbounds {
+		clients, err := inbounds[i].Clients()
+		if err != nil {
+			log.Printf("[WARN] GetClientLink: %v", err)
 		}
-
-		// Find the client by email
-		for _, client := range clientsArray {
-			clientMap, ok := client.(map[string]interface{})
-			if !ok {
-				continue
-			}
-
-			if clientEmail, ok := clientMap["email"].(string); ok && clientEmail == email {
-				// Found the client! Get their subId
-				if subId, ok := clientMap["subId"].(string); ok && subId != "" {
-					clientSubID = subId
-					break
-				}
+		for j := range clients {
+			if clients[j].Email == email && clients[j].SubID != "" {
+				clientSubID = clients[j].SubID
+				break
 			}
 		}
 		if clientSubID != "" {
@@ -747,9 +820,8 @@ func (c *APIClient) GetClientLink(ctx context.Context, email string) (string, er
 	}
 
 	// Get subURI from panel settings (this is the subscription server URL)
-	subURI := ""
-	if uri, ok := panelSettings["subURI"].(string); ok && uri != "" {
-		subURI = strings.TrimSuffix(uri, "/")
+	if panelSettings.SubURI != "" {
+		subURI := strings.TrimSuffix(panelSettings.SubURI, "/")
 
 		// If subURI is complete (includes path), just append clientSubID
 		// subURI format: https://subscribe.domain.com:port/path or https://subscribe.domain.com:port
@@ -758,30 +830,15 @@ func (c *APIClient) GetClientLink(ctx context.Context, email string) (string, er
 	}
 
 	// If no subURI configured, build from subDomain + subPort + subPath
-	subDomain := ""
-	if domain, ok := panelSettings["subDomain"].(string); ok && domain != "" {
-		subDomain = domain
-	}
-
-	subPort := 0
-	if port, ok := panelSettings["subPort"].(float64); ok {
-		subPort = int(port)
-	}
-
-	subKeyFile := ""
-	if keyFile, ok := panelSettings["subKeyFile"].(string); ok {
-		subKeyFile = keyFile
-	}
-
-	subCertFile := ""
-	if certFile, ok := panelSettings["subCertFile"].(string); ok {
-		subCertFile = certFile
-	}
+	subDomain := panelSettings.SubDomain
+	subPort := panelSettings.SubPort
+	subKeyFile := panelSettings.SubKeyFile
+	subCertFile := panelSettings.SubCertFile
 
 	// Get subPath from panel settings or use default
 	subPath := "/sub/"
-	if path, ok := panelSettings["subPath"].(string); ok && path != "" {
-		subPath = path
+	if panelSettings.SubPath != "" {
+		subPath = panelSettings.SubPath
 		// Ensure path format
 		if !strings.HasPrefix(subPath, "/") {
 			subPath = "/" + subPath
@@ -836,22 +893,7 @@ func (c *APIClient) GetClientQRCode(ctx context.Context, email string) ([]byte,
 
 // GetDatabaseBackup downloads x-ui database backup
 func (c *APIClient) GetDatabaseBackup(ctx context.Context) ([]byte, error) {
-	url := c.baseURL + "/panel/api/server/getDb"
-
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	req.Header.Set("Accept", "application/octet-stream")
-	if c.sessionID != "" {
-		req.AddCookie(&http.Cookie{
-			Name:  "3x-ui",
-			Value: c.sessionID,
-		})
-	}
-
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.doRequestAccept(ctx, "GET", "/panel/api/server/getDb", nil, true, "application/octet-stream")
 	if err != nil {
 		return nil, err
 	}
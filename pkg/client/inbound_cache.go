@@ -0,0 +1,106 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultInboundCacheTTL is how long an inbound list is served from memory before the panel is asked again
+const DefaultInboundCacheTTL = 30 * time.Second
+
+// inboundCache holds the last inbound list of a panel.
+// Concurrent misses share a single panel request, writes through the API client invalidate the snapshot.
+type inboundCache struct {
+	mu         sync.Mutex
+	ttl        time.Duration // 0 disables caching, every call loads the list
+	inbounds   []Inbound
+	loadedAt   time.Time
+	generation uint64       // Bumped on invalidation so that loads started earlier are not stored
+	loading    *inboundLoad // In-flight load shared by concurrent callers
+}
+
+// inboundLoad is a panel request that callers wait on
+type inboundLoad struct {
+	done     chan struct{}
+	inbounds []Inbound
+	err      error
+}
+
+func newInboundCache(ttl time.Duration) *inboundCache {
+	return &inboundCache{ttl: ttl}
+}
+
+// setTTL changes the snapshot lifetime and drops the current snapshot
+func (c *inboundCache) setTTL(ttl time.Duration) {
+	c.mu.Lock()
+	c.ttl = ttl
+	c.mu.Unlock()
+	c.invalidate()
+}
+
+// get returns a copy of the cached inbounds, loading them when the snapshot is missing or stale.
+// The load is detached from the context of the caller that started it, so one cancelled
+// caller does not fail the others; each caller still stops waiting when its own context ends.
+func (c *inboundCache) get(ctx context.Context, load func(ctx context.Context) ([]Inbound, error)) ([]Inbound, error) {
+	c.mu.Lock()
+	if c.inbounds != nil && time.Since(c.loadedAt) < c.ttl {
+		inbounds := copyInbounds(c.inbounds)
+		c.mu.Unlock()
+		return inbounds, nil
+	}
+
+	l := c.loading
+	if l == nil {
+		l = &inboundLoad{done: make(chan struct{})}
+		c.loading = l
+		go c.run(context.WithoutCancel(ctx), l, c.generation, load)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-l.done:
+		if l.err != nil {
+			return nil, l.err
+		}
+		return copyInbounds(l.inbounds), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// run performs a shared load and stores the result unless the cache was invalidated meanwhile
+func (c *inboundCache) run(ctx context.Context, l *inboundLoad, generation uint64, load func(ctx context.Context) ([]Inbound, error)) {
+	l.inbounds, l.err = load(ctx)
+
+	c.mu.Lock()
+	if c.loading == l {
+		c.loading = nil
+	}
+	if l.err == nil && c.generation == generation && c.ttl > 0 {
+		c.inbounds = l.inbounds
+		c.loadedAt = time.Now()
+	}
+	c.mu.Unlock()
+
+	close(l.done)
+}
+
+// invalidate drops the snapshot, the next call loads the list from the panel
+func (c *inboundCache) invalidate() {
+	c.mu.Lock()
+	c.inbounds = nil
+	c.generation++
+	c.loading = nil // Callers arriving after a write must not join a load that started before it
+	c.mu.Unlock()
+}
+
+// copyInbounds copies the list and the client stats so callers cannot modify the shared snapshot
+func copyInbounds(src []Inbound) []Inbound {
+	inbounds := make([]Inbound, len(src))
+	copy(inbounds, src)
+	for i := range inbounds {
+		inbounds[i].ClientStats = append([]ClientStat(nil), src[i].ClientStats...)
+	}
+	return inbounds
+}
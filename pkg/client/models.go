@@ -0,0 +1,382 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Supported inbound protocols
+const (
+	ProtocolVLESS       = "vless"
+	ProtocolVMess       = "vmess"
+	ProtocolTrojan      = "trojan"
+	ProtocolShadowsocks = "shadowsocks"
+)
+
+// FlexInt64 is an integer that the panel may encode either as a JSON number or as a string
+type FlexInt64 int64
+
+// UnmarshalJSON accepts numbers, numeric strings, empty strings and null
+func (f *FlexInt64) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || string(data) == "null" {
+		*f = 0
+		return nil
+	}
+
+	if data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		s = strings.TrimSpace(s)
+		if s == "" {
+			*f = 0
+			return nil
+		}
+		data = []byte(s)
+	}
+
+	// Numbers may arrive in float notation (e.g. 1.7e+12)
+	if v, err := strconv.ParseInt(string(data), 10, 64); err == nil {
+		*f = FlexInt64(v)
+		return nil
+	}
+	v, err := strconv.ParseFloat(string(data), 64)
+	if err != nil {
+		return fmt.Errorf("invalid integer value %q", string(data))
+	}
+	*f = FlexInt64(v)
+	return nil
+}
+
+// Int64 returns the value as int64
+func (f FlexInt64) Int64() int64 {
+	return int64(f)
+}
+
+// Inbound represents a 3x-ui inbound
+type Inbound struct {
+	ID             int          `json:"id"`
+	Up             int64        `json:"up"`
+	Down           int64        `json:"down"`
+	Total          int64        `json:"total"`
+	Remark         string       `json:"remark"`
+	Enable         bool         `json:"enable"`
+	ExpiryTime     int64        `json:"expiryTime"`
+	Port           int          `json:"port"`
+	Protocol       string       `json:"protocol"`
+	Settings       string       `json:"settings"`
+	StreamSettings string       `json:"streamSettings"`
+	Tag            string       `json:"tag"`
+	ClientStats    []ClientStat `json:"clientStats"`
+
+	// Panel is the name of the panel the inbound was loaded from
+	Panel string `json:"-"`
+}
+
+// inboundSettings is the decoded form of Inbound.Settings
+type inboundSettings struct {
+	Clients []Client `json:"clients"`
+	Method  string   `json:"method"`
+}
+
+// Name returns the inbound remark or a generated name if remark is empty
+func (i *Inbound) Name() string {
+	if i.Remark != "" {
+		return i.Remark
+	}
+	return fmt.Sprintf("Inbound %d", i.ID)
+}
+
+// EmailSuffix returns the suffix used for client emails in multi-inbound mode
+func (i *Inbound) EmailSuffix() string {
+	if i.Remark != "" {
+		return i.Remark
+	}
+	return fmt.Sprintf("inbound%d", i.ID)
+}
+
+// Method returns the shadowsocks cipher configured on the inbound
+func (i *Inbound) Method() string {
+	settings, err := i.decodeSettings()
+	if err != nil {
+		return ""
+	}
+	return settings.Method
+}
+
+// Clients decodes and validates the clients stored in inbound settings.
+// Invalid clients are skipped: the valid ones are returned together with an error
+// describing the skipped ones, so one broken entry does not hide the whole inbound
+func (i *Inbound) Clients() ([]Client, error) {
+	settings, err := i.decodeSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	clients := make([]Client, 0, len(settings.Clients))
+	var errs []error
+	for idx, c := range settings.Clients {
+		if err := c.validate(i.Protocol); err != nil {
+			errs = append(errs, fmt.Errorf("inbound %d client %d: %w", i.ID, idx, err))
+			continue
+		}
+		c.InboundID = i.ID
+		c.Protocol = i.Protocol
+		c.Panel = i.Panel
+		clients = append(clients, c)
+	}
+
+	return clients, errors.Join(errs...)
+}
+
+// StatByEmail returns traffic stats for a client email
+func (i *Inbound) StatByEmail(email string) (*ClientStat, bool) {
+	for idx := range i.ClientStats {
+		if i.ClientStats[idx].Email == email {
+			return &i.ClientStats[idx], true
+		}
+	}
+	return nil, false
+}
+
+func (i *Inbound) decodeSettings() (*inboundSettings, error) {
+	settings := &inboundSettings{}
+	if strings.TrimSpace(i.Settings) == "" {
+		return settings, nil
+	}
+	if err := json.Unmarshal([]byte(i.Settings), settings); err != nil {
+		return nil, fmt.Errorf("failed to parse settings of inbound %d: %w", i.ID, err)
+	}
+	return settings, nil
+}
+
+// Client represents a client entry inside inbound settings
+type Client struct {
+	ID         string
+	Password   string
+	Method     string
+	Flow       string
+	Email      string
+	Enable     bool
+	ExpiryTime int64
+	TotalGB    int64
+	LimitIP    int
+	TgID       int64
+	SubID      string
+	Comment    string
+	Reset      int
+
+	// InboundID, Protocol and Panel are filled in when the client is decoded from an inbound
+	InboundID int
+	Protocol  string
+	Panel     string
+
+	// raw keeps every field sent by the panel so updates don't drop unknown keys
+	raw map[string]interface{}
+}
+
+// clientJSON mirrors Client for decoding
+type clientJSON struct {
+	ID         string    `json:"id"`
+	Password   string    `json:"password"`
+	Method     string    `json:"method"`
+	Flow       string    `json:"flow"`
+	Email      string    `json:"email"`
+	Enable     *bool     `json:"enable"`
+	ExpiryTime FlexInt64 `json:"expiryTime"`
+	TotalGB    FlexInt64 `json:"totalGB"`
+	LimitIP    FlexInt64 `json:"limitIp"`
+	TgID       FlexInt64 `json:"tgId"`
+	SubID      string    `json:"subId"`
+	Comment    string    `json:"comment"`
+	Reset      FlexInt64 `json:"reset"`
+}
+
+// UnmarshalJSON decodes a client and keeps the raw fields
+func (c *Client) UnmarshalJSON(data []byte) error {
+	var decoded clientJSON
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return fmt.Errorf("invalid client: %w", err)
+	}
+
+	raw := make(map[string]interface{})
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&raw); err != nil {
+		return fmt.Errorf("invalid client: %w", err)
+	}
+
+	*c = Client{
+		ID:         decoded.ID,
+		Password:   decoded.Password,
+		Method:     decoded.Method,
+		Flow:       decoded.Flow,
+		Email:      decoded.Email,
+		Enable:     decoded.Enable == nil || *decoded.Enable, // panel treats missing flag as enabled
+		ExpiryTime: decoded.ExpiryTime.Int64(),
+		TotalGB:    decoded.TotalGB.Int64(),
+		LimitIP:    int(decoded.LimitIP),
+		TgID:       decoded.TgID.Int64(),
+		SubID:      decoded.SubID,
+		Comment:    decoded.Comment,
+		Reset:      int(decoded.Reset),
+		raw:        raw,
+	}
+	return nil
+}
+
+// validate checks that the client has the credentials required by the protocol
+func (c *Client) validate(protocol string) error {
+	if c.Email == "" {
+		return fmt.Errorf("client has no email")
+	}
+	switch protocol {
+	case ProtocolVLESS, ProtocolVMess:
+		if c.ID == "" {
+			return fmt.Errorf("%s client %s has no id", protocol, c.Email)
+		}
+	case ProtocolTrojan:
+		if c.Password == "" {
+			return fmt.Errorf("trojan client %s has no password", c.Email)
+		}
+	}
+	return nil
+}
+
+// Key returns the identifier used by the panel to address this client (delClient, updateClient)
+func (c *Client) Key() string {
+	switch c.Protocol {
+	case ProtocolTrojan:
+		return c.Password
+	case ProtocolShadowsocks:
+		return c.Email
+	default:
+		return c.ID
+	}
+}
+
+// HasTgID reports whether the client is linked to a Telegram account
+func (c *Client) HasTgID() bool {
+	return c.TgID > 0
+}
+
+// Map returns the client as a map suitable for AddClient/UpdateClient,
+// preserving fields unknown to this package
+func (c *Client) Map() map[string]interface{} {
+	data := make(map[string]interface{}, len(c.raw)+12)
+	for k, v := range c.raw {
+		if n, ok := v.(json.Number); ok {
+			if i, err := n.Int64(); err == nil {
+				data[k] = i
+				continue
+			}
+			if f, err := n.Float64(); err == nil {
+				data[k] = int64(f)
+				continue
+			}
+		}
+		data[k] = v
+	}
+
+	data["email"] = c.Email
+	data["enable"] = c.Enable
+	data["expiryTime"] = c.ExpiryTime
+	data["totalGB"] = c.TotalGB
+	data["limitIp"] = c.LimitIP
+	data["tgId"] = c.TgID
+	data["subId"] = c.SubID
+	data["comment"] = c.Comment
+	data["reset"] = c.Reset
+	if c.ID != "" {
+		data["id"] = c.ID
+	}
+	if c.Password != "" {
+		data["password"] = c.Password
+	}
+	if c.Method != "" {
+		data["method"] = c.Method
+	}
+	if c.Flow != "" {
+		data["flow"] = c.Flow
+	}
+	return data
+}
+
+// ClientStat holds traffic statistics for a single client email
+type ClientStat struct {
+	ID         int    `json:"id"`
+	InboundID  int    `json:"inboundId"`
+	Enable     bool   `json:"enable"`
+	Email      string `json:"email"`
+	Up         int64  `json:"up"`
+	Down       int64  `json:"down"`
+	ExpiryTime int64  `json:"expiryTime"`
+	Total      int64  `json:"total"`
+	Reset      int    `json:"reset"`
+}
+
+// Used returns total consumed traffic in bytes
+func (s *ClientStat) Used() int64 {
+	return s.Up + s.Down
+}
+
+// PanelSettings holds the subset of panel settings used by the bot
+type PanelSettings struct {
+	SubEnable   bool   `json:"subEnable"`
+	SubURI      string `json:"subURI"`
+	SubDomain   string `json:"subDomain"`
+	SubPort     int    `json:"subPort"`
+	SubPath     string `json:"subPath"`
+	SubKeyFile  string `json:"subKeyFile"`
+	SubCertFile string `json:"subCertFile"`
+}
+
+// ServerStatus holds panel host metrics
+type ServerStatus struct {
+	CPU      float64 `json:"cpu"`
+	CPUCores int     `json:"cpuCores"`
+	Mem      struct {
+		Current int64 `json:"current"`
+		Total   int64 `json:"total"`
+	} `json:"mem"`
+	Disk struct {
+		Current int64 `json:"current"`
+		Total   int64 `json:"total"`
+	} `json:"disk"`
+	Xray struct {
+		State   string `json:"state"`
+		Version string `json:"version"`
+	} `json:"xray"`
+	Uptime int64     `json:"uptime"`
+	Loads  []float64 `json:"loads"`
+}
+
+// apiResponse is the common envelope of 3x-ui API responses
+type apiResponse struct {
+	Success bool            `json:"success"`
+	Msg     string          `json:"msg"`
+	Obj     json.RawMessage `json:"obj"`
+}
+
+// decodeObj unmarshals the response payload into out
+func (r *apiResponse) decodeObj(out interface{}) error {
+	if !r.Success {
+		if r.Msg != "" {
+			return fmt.Errorf("API returned success=false: %s", r.Msg)
+		}
+		return fmt.Errorf("API returned success=false")
+	}
+	if len(r.Obj) == 0 || string(r.Obj) == "null" {
+		return fmt.Errorf("API response has no payload")
+	}
+	if err := json.Unmarshal(r.Obj, out); err != nil {
+		return fmt.Errorf("failed to decode response payload: %w", err)
+	}
+	return nil
+}
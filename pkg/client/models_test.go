@@ -0,0 +1,200 @@
+package client
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestInboundClients(t *testing.T) {
+	tests := []struct {
+		name       string
+		protocol   string
+		settings   string
+		wantEmails []string
+		wantErrs   []string // Substrings of the error, none when it must be nil
+	}{
+		{
+			name:       "all valid",
+			protocol:   ProtocolVLESS,
+			settings:   `{"clients":[{"id":"a","email":"alice"},{"id":"b","email":"bob"}]}`,
+			wantEmails: []string{"alice", "bob"},
+		},
+		{
+			name:       "vless client without id",
+			protocol:   ProtocolVLESS,
+			settings:   `{"clients":[{"id":"a","email":"alice"},{"email":"bob"},{"id":"c","email":"carol"}]}`,
+			wantEmails: []string{"alice", "carol"},
+			wantErrs:   []string{"inbound 7 client 1: vless client bob has no id"},
+		},
+		{
+			name:       "trojan client without password",
+			protocol:   ProtocolTrojan,
+			settings:   `{"clients":[{"id":"a","email":"alice"},{"password":"p","email":"bob"}]}`,
+			wantEmails: []string{"bob"},
+			wantErrs:   []string{"inbound 7 client 0: trojan client alice has no password"},
+		},
+		{
+			name:       "client without email",
+			protocol:   ProtocolVMess,
+			settings:   `{"clients":[{"id":"a"},{"id":"b","email":"bob"},{"email":"carol"}]}`,
+			wantEmails: []string{"bob"},
+			wantErrs:   []string{"client 0: client has no email", "client 2: vmess client carol has no id"},
+		},
+		{
+			name:       "shadowsocks needs only an email",
+			protocol:   ProtocolShadowsocks,
+			settings:   `{"method":"aes-256-gcm","clients":[{"email":"alice"}]}`,
+			wantEmails: []string{"alice"},
+		},
+		{
+			name:     "empty settings",
+			protocol: ProtocolVLESS,
+			settings: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inbound := &Inbound{ID: 7, Protocol: tt.protocol, Settings: tt.settings, Panel: "main"}
+			clients, err := inbound.Clients()
+
+			var emails []string
+			for _, c := range clients {
+				emails = append(emails, c.Email)
+				if c.InboundID != 7 || c.Protocol != tt.protocol || c.Panel != "main" {
+					t.Errorf("client %s has inbound %d, protocol %q, panel %q", c.Email, c.InboundID, c.Protocol, c.Panel)
+				}
+			}
+			if !reflect.DeepEqual(emails, tt.wantEmails) {
+				t.Errorf("clients = %v, want %v", emails, tt.wantEmails)
+			}
+
+			if len(tt.wantErrs) == 0 {
+				if err != nil {
+					t.Errorf("error = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("error = nil, want %q", tt.wantErrs)
+			}
+			for _, want := range tt.wantErrs {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestInboundClientsMalformedSettings(t *testing.T) {
+	inbound := &Inbound{ID: 7, Protocol: ProtocolVLESS, Settings: `{"clients":[`}
+	if clients, err := inbound.Clients(); err == nil || clients != nil {
+		t.Errorf("Clients() = %v, %v, want an error", clients, err)
+	}
+}
+
+func TestFlexInt64(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    int64
+		wantErr bool
+	}{
+		{input: `1700000000000`, want: 1700000000000},
+		{input: `-1`, want: -1},
+		{input: `"1700000000000"`, want: 1700000000000},
+		{input: `" 42 "`, want: 42},
+		{input: `1.7e+12`, want: 1700000000000},
+		{input: `"1.7e+12"`, want: 1700000000000},
+		{input: `12.9`, want: 12},
+		{input: `""`, want: 0},
+		{input: `null`, want: 0},
+		{input: `"abc"`, wantErr: true},
+		{input: `true`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			v := FlexInt64(99)
+			err := json.Unmarshal([]byte(tt.input), &v)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("got %d, want an error", v)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unmarshal: %v", err)
+			}
+			if v.Int64() != tt.want {
+				t.Errorf("got %d, want %d", v, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientMapKeepsUnknownFields(t *testing.T) {
+	const data = `{
+		"id": "uuid-1",
+		"email": "alice",
+		"enable": false,
+		"expiryTime": "1700000000000",
+		"totalGB": 1.5e+10,
+		"limitIp": 2,
+		"tgId": "42",
+		"subId": "sub",
+		"reset": 0,
+		"flow": "xtls-rprx-vision",
+		"created_at": 1712345678901,
+		"note": "keep me",
+		"tags": ["a", "b"],
+		"extra": {"nested": true}
+	}`
+
+	var c Client
+	if err := json.Unmarshal([]byte(data), &c); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if c.TgID != 42 || c.ExpiryTime != 1700000000000 || c.TotalGB != 15000000000 || c.Enable {
+		t.Fatalf("decoded client = %+v", c)
+	}
+
+	c.ExpiryTime = 1800000000000
+	got := c.Map()
+
+	want := map[string]interface{}{
+		"id":         "uuid-1",
+		"email":      "alice",
+		"enable":     false,
+		"expiryTime": int64(1800000000000),
+		"totalGB":    int64(15000000000),
+		"limitIp":    2,
+		"tgId":       int64(42),
+		"subId":      "sub",
+		"comment":    "",
+		"reset":      0,
+		"flow":       "xtls-rprx-vision",
+		"created_at": int64(1712345678901),
+		"note":       "keep me",
+		"tags":       []interface{}{"a", "b"},
+		"extra":      map[string]interface{}{"nested": true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Map() = %#v\nwant %#v", got, want)
+	}
+
+	// The map is what the panel gets back, decoding it again gives the same client
+	encoded, err := json.Marshal(got)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	var again Client
+	if err := json.Unmarshal(encoded, &again); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if !reflect.DeepEqual(again.Map(), got) {
+		t.Errorf("second round trip = %#v\nwant %#v", again.Map(), got)
+	}
+}
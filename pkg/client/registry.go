@@ -0,0 +1,144 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+// Registry holds API clients for several named 3x-ui panels
+type Registry struct {
+	order   []string
+	clients map[string]*APIClient
+}
+
+// NewRegistry creates an empty panel registry
+func NewRegistry() *Registry {
+	return &Registry{
+		clients: make(map[string]*APIClient),
+	}
+}
+
+// Add registers an API client under a unique panel name
+func (r *Registry) Add(name string, c *APIClient) error {
+	if name == "" {
+		return fmt.Errorf("panel name is required")
+	}
+	if _, exists := r.clients[name]; exists {
+		return fmt.Errorf("panel %q is already registered", name)
+	}
+	c.name = name
+	r.clients[name] = c
+	r.order = append(r.order, name)
+	return nil
+}
+
+// Get returns the API client for a panel name
+func (r *Registry) Get(name string) (*APIClient, bool) {
+	c, ok := r.clients[name]
+	return c, ok
+}
+
+// Default returns the first registered panel
+func (r *Registry) Default() *APIClient {
+	if len(r.order) == 0 {
+		return nil
+	}
+	return r.clients[r.order[0]]
+}
+
+// ForClient returns the API client of the panel a client was loaded from
+func (r *Registry) ForClient(c *Client) *APIClient {
+	if api, ok := r.clients[c.Panel]; ok {
+		return api
+	}
+	return r.Default()
+}
+
+// Names returns panel names in registration order
+func (r *Registry) Names() []string {
+	names := make([]string, len(r.order))
+	copy(names, r.order)
+	return names
+}
+
+// All returns API clients in registration order
+func (r *Registry) All() []*APIClient {
+	all := make([]*APIClient, 0, len(r.order))
+	for _, name := range r.order {
+		all = append(all, r.clients[name])
+	}
+	return all
+}
+
+// Len returns the number of registered panels
+func (r *Registry) Len() int {
+	return len(r.order)
+}
+
+// At returns the panel at the given registration index
+func (r *Registry) At(index int) (*APIClient, bool) {
+	if index < 0 || index >= len(r.order) {
+		return nil, false
+	}
+	return r.clients[r.order[index]], true
+}
+
+// IndexOf returns the registration index of a panel name or -1
+func (r *Registry) IndexOf(name string) int {
+	for i, n := range r.order {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// FindClientByTgID searches all panels for the client linked to the Telegram ID
+func (r *Registry) FindClientByTgID(ctx context.Context, tgID int64) (*APIClient, *Client, error) {
+	for _, name := range r.order {
+		api := r.clients[name]
+		c, err := api.GetClientByTgID(ctx, tgID)
+		if err == nil && c != nil {
+			return api, c, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("client not found")
+}
+
+// LeastLoaded returns the reachable panel with the fewest clients
+func (r *Registry) LeastLoaded(ctx context.Context) (*APIClient, error) {
+	var best *APIClient
+	bestCount := -1
+
+	for _, name := range r.order {
+		api := r.clients[name]
+		inbounds, err := api.GetInbounds(ctx)
+		if err != nil {
+			log.Printf("[WARN] LeastLoaded: panel %s unavailable: %v", name, err)
+			continue
+		}
+		if len(inbounds) == 0 {
+			continue
+		}
+
+		count := 0
+		for i := range inbounds {
+			clients, err := inbounds[i].Clients()
+			if err != nil {
+				log.Printf("[WARN] LeastLoaded: panel %s: %v", name, err)
+			}
+			count += len(clients)
+		}
+
+		if bestCount < 0 || count < bestCount {
+			best = api
+			bestCount = count
+		}
+	}
+
+	if best == nil {
+		return nil, fmt.Errorf("no panel with inbounds available")
+	}
+	return best, nil
+}